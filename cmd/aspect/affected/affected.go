@@ -0,0 +1,103 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package affected
+
+import (
+	"context"
+
+	"github.com/spf13/cobra"
+
+	"aspect.build/cli/pkg/aspect/affected"
+	"aspect.build/cli/pkg/aspect/root/config"
+	"aspect.build/cli/pkg/aspect/test"
+	"aspect.build/cli/pkg/bazel"
+	"aspect.build/cli/pkg/interceptors"
+	"aspect.build/cli/pkg/ioutils"
+	"aspect.build/cli/pkg/plugin/system"
+	"aspect.build/cli/pkg/plugin/system/bep"
+)
+
+// NewDefaultAffectedCmd creates a new affected cobra command with the default
+// dependencies.
+func NewDefaultAffectedCmd(cfg *config.Config, pluginSystem system.PluginSystem) *cobra.Command {
+	return NewAffectedCmd(ioutils.DefaultStreams, cfg, pluginSystem, bazel.New())
+}
+
+// NewAffectedCmd creates a new affected cobra command.
+func NewAffectedCmd(
+	streams ioutils.Streams,
+	cfg *config.Config,
+	pluginSystem system.PluginSystem,
+	bzl bazel.Bazel,
+) *cobra.Command {
+	var base string
+	var stdin bool
+	var universe string
+	var runTests bool
+
+	cmd := &cobra.Command{
+		Use:   "affected",
+		Short: "Lists the tests affected by the changed files.",
+		Long: `Lists the tests affected by the changed files, one label per line.
+
+The changed files are the ones that differ from the merge-base of the --base
+git revision and HEAD, i.e. the changes of the branch, including the
+uncommitted and untracked ones, or the ones read from stdin
+with --stdin. Each file is mapped to the targets that own it:
+
+  - a source file to its label
+  - a BUILD file or a deleted file to every target of its package
+  - a .bzl file to every target of the packages that load it
+  - the WORKSPACE, MODULE.bazel, .bazelrc or .bazelversion to every target
+
+The affected tests are the ones that depend on these targets. With --test,
+they run with 'aspect test' and the flags given after '--', e.g.
+
+  aspect affected --base=origin/main --test -- --config=ci`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if !runTests {
+				return cobra.NoArgs(cmd, args)
+			}
+			return nil
+		},
+		RunE: interceptors.Run(
+			[]interceptors.Interceptor{
+				interceptors.WorkspaceRootInterceptor(),
+				interceptors.StartupOptionsInterceptor(cfg, bzl),
+			},
+			func(ctx context.Context, cmd *cobra.Command, args []string) (exitErr error) {
+				workspaceRoot := ctx.Value(interceptors.WorkspaceRootKey).(string)
+				bzl.SetWorkspaceRoot(workspaceRoot)
+				a := affected.New(streams, bzl, workspaceRoot)
+				a.Base = base
+				a.Stdin = stdin
+				a.Universe = universe
+				if runTests {
+					// The tests run like 'aspect test' does, with the plugins
+					// subscribed to the build events and their test hooks.
+					a.RunTests = interceptors.Run(
+						[]interceptors.Interceptor{
+							pluginSystem.BESBackendInterceptor(),
+							pluginSystem.TestHooksInterceptor(streams),
+						},
+						func(ctx context.Context, cmd *cobra.Command, args []string) error {
+							besBackend := ctx.Value(system.BESBackendInterceptorKey).(bep.BESBackend)
+							return test.New(streams, bzl).Run(args, besBackend)
+						},
+					)
+				}
+				return a.Run(cmd, args)
+			},
+		),
+	}
+
+	cmd.Flags().StringVarP(&base, "base", "", "HEAD", "The git revision that the changes are made against.")
+	cmd.Flags().BoolVarP(&stdin, "stdin", "", false, "Read the changed files from stdin, one per line and relative to the workspace root.")
+	cmd.Flags().StringVarP(&universe, "universe", "", "//...", "The target pattern of the targets that may be affected.")
+	cmd.Flags().BoolVarP(&runTests, "test", "", false, "Run the affected tests with 'aspect test'.")
+	return cmd
+}
@@ -8,36 +8,88 @@ package aquery
 
 import (
 	"context"
+	"os"
 
 	"github.com/spf13/cobra"
 
 	"aspect.build/cli/pkg/aspect/aquery"
+	"aspect.build/cli/pkg/aspect/query/shared"
+	"aspect.build/cli/pkg/aspect/root/config"
 	"aspect.build/cli/pkg/bazel"
 	"aspect.build/cli/pkg/interceptors"
 	"aspect.build/cli/pkg/ioutils"
 )
 
-func NewDefaultAQueryCmd() *cobra.Command {
-	return NewAQueryCommand(ioutils.DefaultStreams, bazel.New())
+func NewDefaultAQueryCmd(cfg *config.Config) *cobra.Command {
+	return NewAQueryCommand(ioutils.DefaultStreams, cfg, bazel.New())
 }
 
-func NewAQueryCommand(streams ioutils.Streams, bzl bazel.Bazel) *cobra.Command {
+func NewAQueryCommand(streams ioutils.Streams, cfg *config.Config, bzl bazel.Bazel) *cobra.Command {
+	var save string
+	var set []string
+	var output shared.Output
+	var inspect bool
+	var diffFlags []string
+
 	cmd := &cobra.Command{
 		Use:   "aquery",
 		Short: "Executes an aquery.",
-		Long:  "Executes a query language expression over a specified subgraph of the build dependency graph using aquery.",
+		Long: `Executes a query language expression over a specified subgraph of the build dependency graph using aquery.
+
+Without --output, --diff compares the action graph of the query with the
+one at a git revision, and explains the actions that would miss the cache
+with the arguments, environment variables and inputs that differ.`,
 		RunE: interceptors.Run(
 			[]interceptors.Interceptor{
 				interceptors.WorkspaceRootInterceptor(),
+				interceptors.StartupOptionsInterceptor(cfg, bzl),
 			},
 			func(ctx context.Context, cmd *cobra.Command, args []string) (exitErr error) {
 				workspaceRoot := ctx.Value(interceptors.WorkspaceRootKey).(string)
 				bzl.SetWorkspaceRoot(workspaceRoot)
-				q := aquery.New(streams, bzl, true)
+				q := aquery.New(streams, cfg, bzl, true)
+				q.Save = save
+				q.WorkspaceRoot = workspaceRoot
+				wd, err := os.Getwd()
+				if err != nil {
+					return err
+				}
+				if !output.NoCache {
+					cacheDir, err := shared.QueryCacheDir(workspaceRoot)
+					if err != nil {
+						return err
+					}
+					startupOptions, _ := ctx.Value(interceptors.StartupOptionsKey).([]string)
+					output.Cache = shared.NewQueryCache(cacheDir, workspaceRoot, startupOptions)
+				}
+				q.Output = output
+				q.Inspect = inspect
+				q.DiffFlags = diffFlags
+				if q.Set, err = shared.ParseSet(set); err != nil {
+					return err
+				}
+				q.PromptLabel = shared.LabelPrompt(workspaceRoot, wd)
+				historyFile, err := shared.HistoryFile(workspaceRoot)
+				if err != nil {
+					return err
+				}
+				q.History = shared.LoadHistory(historyFile, streams.Stderr)
 				return q.Run(cmd, args)
 			},
 		),
 	}
 
+	cmd.Flags().StringVarP(&save, "save", "", "", `Save the query as a preset of the workspace config with this name.
+Its ?placeholders, optionally typed as ?name:label, ?name:int or
+?name:enum(a,b), are prompted for when the preset is selected.`)
+	cmd.Flags().StringArrayVarP(&set, "set", "", nil, `Set a placeholder of the query by name, e.g. --set target=//foo.
+The other placeholders take the arguments after the preset name in
+order, and are prompted for when missing.`)
+	cmd.Flags().BoolVarP(&inspect, "inspect", "", false, `Print the mnemonic, command line, environment, inputs and outputs of
+each action of the result.`)
+	cmd.Flags().StringArrayVarP(&diffFlags, "diff-flags", "", nil, `Compare the action graph of the query with the one when this Bazel
+flag is set, e.g. --diff-flags=--compilation_mode=opt, and explain the
+actions that would miss the cache. Can be repeated.`)
+	shared.AddOutputFlags(cmd, &output)
 	return cmd
 }
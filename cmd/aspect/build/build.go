@@ -12,7 +12,9 @@ import (
 	"github.com/spf13/cobra"
 
 	"aspect.build/cli/pkg/aspect/build"
+	"aspect.build/cli/pkg/aspect/root/config"
 	"aspect.build/cli/pkg/bazel"
+	"aspect.build/cli/pkg/completion"
 	"aspect.build/cli/pkg/interceptors"
 	"aspect.build/cli/pkg/ioutils"
 	"aspect.build/cli/pkg/plugin/system"
@@ -21,9 +23,10 @@ import (
 
 // NewDefaultBuildCmd creates a new build cobra command with the default
 // dependencies.
-func NewDefaultBuildCmd(pluginSystem system.PluginSystem) *cobra.Command {
+func NewDefaultBuildCmd(cfg *config.Config, pluginSystem system.PluginSystem) *cobra.Command {
 	return NewBuildCmd(
 		ioutils.DefaultStreams,
+		cfg,
 		pluginSystem,
 		bazel.New(),
 	)
@@ -32,6 +35,7 @@ func NewDefaultBuildCmd(pluginSystem system.PluginSystem) *cobra.Command {
 // NewBuildCmd creates a new build cobra command.
 func NewBuildCmd(
 	streams ioutils.Streams,
+	cfg *config.Config,
 	pluginSystem system.PluginSystem,
 	bzl bazel.Bazel,
 ) *cobra.Command {
@@ -40,9 +44,14 @@ func NewBuildCmd(
 		Short: "Builds the specified targets, using the options.",
 		Long: "Invokes bazel build on the specified targets. " +
 			"See 'bazel help target-syntax' for details and examples on how to specify targets to build.",
+		// Bazel flags are validated by the ParseArgsInterceptor instead.
+		DisableFlagParsing: true,
+		ValidArgsFunction:  completion.ValidArgsFunction(bzl, "build"),
 		RunE: interceptors.Run(
 			[]interceptors.Interceptor{
 				interceptors.WorkspaceRootInterceptor(),
+				interceptors.StartupOptionsInterceptor(cfg, bzl),
+				interceptors.ParseArgsInterceptor(bzl, "build"),
 				pluginSystem.BESBackendInterceptor(),
 				pluginSystem.BuildHooksInterceptor(streams),
 			},
@@ -8,26 +8,102 @@ package clean
 
 import (
 	"context"
+	"fmt"
 	"os"
 
 	"github.com/mattn/go-isatty"
 	"github.com/spf13/cobra"
 
 	"aspect.build/cli/pkg/aspect/clean"
+	"aspect.build/cli/pkg/aspect/du"
+	"aspect.build/cli/pkg/aspect/root/config"
 	"aspect.build/cli/pkg/bazel"
 	"aspect.build/cli/pkg/interceptors"
 	"aspect.build/cli/pkg/ioutils"
+	"aspect.build/cli/pkg/outputbase"
 )
 
 // NewDefaultCleanCmd creates a new default clean cobra command.
-func NewDefaultCleanCmd() *cobra.Command {
-	return NewCleanCmd(ioutils.DefaultStreams, bazel.New())
+func NewDefaultCleanCmd(cfg *config.Config) *cobra.Command {
+	return NewCleanCmd(ioutils.DefaultStreams, cfg, bazel.New())
 }
 
 // NewCleanCmd creates a new clean cobra command.
-func NewCleanCmd(streams ioutils.Streams, bzl bazel.Bazel) *cobra.Command {
+func NewCleanCmd(streams ioutils.Streams, cfg *config.Config, bzl bazel.Bazel) *cobra.Command {
 	var expunge bool
 	var expungeAsync bool
+	var all bool
+	var olderThan string
+	var dryRun bool
+	var only string
+
+	newClean := func(ctx context.Context) (*clean.Clean, error) {
+		isInteractive := isatty.IsTerminal(os.Stdout.Fd()) || isatty.IsCygwinTerminal(os.Stdout.Fd())
+		c := clean.NewDefault(cfg, bzl, isInteractive)
+		c.Expunge = expunge
+		c.ExpungeAsync = expungeAsync
+		c.All = all
+		c.DryRun = dryRun
+		if only != "" {
+			if all || expunge || expungeAsync {
+				return nil, fmt.Errorf("--only can't be combined with --all, --expunge or --expunge_async")
+			}
+			category, err := du.ParseCategory(only)
+			if err != nil {
+				return nil, fmt.Errorf("invalid --only: %w", err)
+			}
+			c.Only = category
+		}
+		if olderThan != "" {
+			d, err := outputbase.ParseAge(olderThan)
+			if err != nil {
+				return nil, fmt.Errorf("invalid --older-than: %w", err)
+			}
+			c.OlderThan = d
+		}
+		startupOptions, _ := ctx.Value(interceptors.StartupOptionsKey).([]string)
+		c.StartupOptions = startupOptions
+		outputUserRoot, err := outputbase.OutputUserRoot(startupOptions)
+		if err != nil {
+			return nil, err
+		}
+		c.OutputUserRoot = outputUserRoot
+		return c, nil
+	}
+
+	// Reclaiming the disk space of all workspaces doesn't need to run in one,
+	// e.g. from a cron job.
+	reclaimAll := interceptors.Run(
+		[]interceptors.Interceptor{
+			interceptors.StartupOptionsInterceptor(cfg, bzl),
+		},
+		func(ctx context.Context, cmd *cobra.Command, args []string) (exitErr error) {
+			c, err := newClean(ctx)
+			if err != nil {
+				return err
+			}
+			return c.Run(cmd, args)
+		},
+	)
+	cleanWorkspace := interceptors.Run(
+		[]interceptors.Interceptor{
+			interceptors.WorkspaceRootInterceptor(),
+			interceptors.StartupOptionsInterceptor(cfg, bzl),
+		},
+		func(ctx context.Context, cmd *cobra.Command, args []string) (exitErr error) {
+			workspaceRoot := ctx.Value(interceptors.WorkspaceRootKey).(string)
+			bzl.SetWorkspaceRoot(workspaceRoot)
+			c, err := newClean(ctx)
+			if err != nil {
+				return err
+			}
+			startupOptions, _ := ctx.Value(interceptors.StartupOptionsKey).([]string)
+			if c.Locations, err = du.Locate(workspaceRoot, startupOptions); err != nil {
+				return err
+			}
+			return c.Run(cmd, args)
+		},
+	)
 
 	cmd := &cobra.Command{
 		Use:   "clean",
@@ -45,13 +121,22 @@ Recall that each Bazel instance is associated with a single workspace,
 thus the clean command will delete all outputs from all builds you've
 done with that Bazel instance in that workspace.
 
+To reclaim the disk space of all the workspaces that Bazel hasn't used
+recently, e.g. from a cron job:
+	aspect clean --all --older-than=30d
+
+To see what's taking space before cleaning, and only remove one kind of data,
+e.g. the Bazel binaries downloaded by Bazelisk:
+	aspect clean --dry-run
+	aspect clean --only=bazelisk
+
 NOTE: clean is primarily intended for reclaiming disk space for workspaces
 that are no longer needed.
 It causes all subsequent builds to be non-incremental.
 If this is not your intent, consider these alternatives:
 
 Do a one-off non-incremental build:
-	bazel --output_base=$(mktemp -d) ...
+	aspect --output_base=$(mktemp -d) build ...
 
 Force repository rules to re-execute:
 	bazel sync --configure
@@ -61,21 +146,16 @@ Workaround inconistent state:
 	should never be required due to inconsistencies in the build.
 	Such problems are fixable and these bugs are a high priority.
 	If you ever find an incorrect incremental build, please file a bug report,
-	and only use clean as a temporary workaround.`,
-		RunE: interceptors.Run(
-			[]interceptors.Interceptor{
-				interceptors.WorkspaceRootInterceptor(),
-			},
-			func(ctx context.Context, cmd *cobra.Command, args []string) (exitErr error) {
-				workspaceRoot := ctx.Value(interceptors.WorkspaceRootKey).(string)
-				bzl.SetWorkspaceRoot(workspaceRoot)
-				isInteractive := isatty.IsTerminal(os.Stdout.Fd()) || isatty.IsCygwinTerminal(os.Stdout.Fd())
-				c := clean.NewDefault(bzl, isInteractive)
-				c.Expunge = expunge
-				c.ExpungeAsync = expungeAsync
-				return c.Run(cmd, args)
-			},
-		),
+	and only use clean as a temporary workaround.
+	The interactive clean offers to delete only the outputs of the
+	inconsistent targets, found with the flags they were built with,
+	and collects a bug report bundle to attach.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if all {
+				return reclaimAll(cmd, args)
+			}
+			return cleanWorkspace(cmd, args)
+		},
 	}
 
 	cmd.PersistentFlags().BoolVarP(&expunge, "expunge", "", false, `Remove the entire output_base tree.
@@ -88,5 +168,20 @@ equivalent to the shutdown command.`)
 It is safe to invoke a Bazel command in the same
 workspace while the asynchronous expunge continues to run.
 Note, however, that this may introduce IO contention.`)
+
+	cmd.PersistentFlags().BoolVarP(&all, "all", "", false, `Reclaim the disk space of all Bazel workspaces on this
+machine without prompting, by removing their output bases.
+The output bases of running Bazel servers are skipped.`)
+
+	cmd.PersistentFlags().StringVarP(&olderThan, "older-than", "", "", `Only reclaim the disk space of the workspaces that
+Bazel hasn't used for this long, e.g. 30d or 12h.`)
+
+	cmd.PersistentFlags().BoolVarP(&dryRun, "dry-run", "", false, `Report the disk space that the clean would reclaim,
+broken down like 'aspect du', without removing anything.`)
+
+	cmd.PersistentFlags().StringVarP(&only, "only", "", "", `Only remove one kind of data instead of running bazel clean:
+execroot, external, action_cache, bazelisk,
+repository_cache or disk_cache.
+The Bazel server is shut down first for the first three.`)
 	return cmd
 }
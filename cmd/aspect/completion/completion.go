@@ -0,0 +1,83 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package completion
+
+import (
+	"github.com/spf13/cobra"
+
+	"aspect.build/cli/pkg/ioutils"
+)
+
+// NewDefaultCompletionCmd creates a new completion cobra command with the
+// default dependencies.
+func NewDefaultCompletionCmd() *cobra.Command {
+	return NewCompletionCmd(ioutils.DefaultStreams)
+}
+
+// NewCompletionCmd creates a new completion cobra command. It replaces the
+// default cobra completion command so that it's documented along with the
+// other commands.
+func NewCompletionCmd(streams ioutils.Streams) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "completion",
+		Short: "Generates the shell completion script.",
+		Long: `Generates the completion script for the given shell.
+
+Besides the aspect commands, the script completes the Bazel flags of the
+commands that wrap Bazel (e.g. 'aspect build --compilation_mode=<TAB>') and
+the target labels in the workspace (e.g. 'aspect build //foo:<TAB>').
+Completing labels only reads the BUILD files, and flags are completed once
+they were cached by running any Bazel command through aspect, so completion
+never starts the Bazel server.`,
+		Args:              cobra.NoArgs,
+		ValidArgsFunction: cobra.NoFileCompletions,
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "bash",
+		Short: "Generates the bash completion script.",
+		Long: `Generates the bash completion script. It requires the bash-completion package.
+
+To load completions in your current shell session:
+	source <(aspect completion bash)`,
+		Args:              cobra.NoArgs,
+		ValidArgsFunction: cobra.NoFileCompletions,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return cmd.Root().GenBashCompletionV2(streams.Stdout, true)
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "zsh",
+		Short: "Generates the zsh completion script.",
+		Long: `Generates the zsh completion script.
+
+To load completions for every new session, execute once:
+	aspect completion zsh > "${fpath[1]}/_aspect"`,
+		Args:              cobra.NoArgs,
+		ValidArgsFunction: cobra.NoFileCompletions,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return cmd.Root().GenZshCompletion(streams.Stdout)
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "fish",
+		Short: "Generates the fish completion script.",
+		Long: `Generates the fish completion script.
+
+To load completions in your current shell session:
+	aspect completion fish | source`,
+		Args:              cobra.NoArgs,
+		ValidArgsFunction: cobra.NoFileCompletions,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return cmd.Root().GenFishCompletion(streams.Stdout, true)
+		},
+	})
+
+	return cmd
+}
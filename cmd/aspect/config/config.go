@@ -0,0 +1,104 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package config
+
+import (
+	"github.com/spf13/cobra"
+
+	"aspect.build/cli/pkg/aspect/config"
+	rootConfig "aspect.build/cli/pkg/aspect/root/config"
+	"aspect.build/cli/pkg/ioutils"
+)
+
+// NewDefaultConfigCmd creates a new config cobra command with the default
+// dependencies.
+func NewDefaultConfigCmd(cfg *rootConfig.Config) *cobra.Command {
+	return NewConfigCmd(ioutils.DefaultStreams, cfg)
+}
+
+// NewConfigCmd creates a new config cobra command.
+func NewConfigCmd(streams ioutils.Streams, cfg *rootConfig.Config) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Inspects and modifies the aspect config.",
+		Long: `Inspects and modifies the aspect config, which is read from these layers,
+each one taking precedence over the previous ones:
+
+user:       $HOME/.aspect.yaml
+workspace:  .aspect.yaml at the root of the Bazel workspace
+env:        environment variables named after the keys, e.g.
+            ASPECT_CLEAN_SKIP_PROMPT for clean.skip_prompt
+
+Keys that no layer sets have a default. Unknown keys are rejected, so a typo
+in a config file is an error.`,
+	}
+
+	var showOrigin bool
+	getCmd := &cobra.Command{
+		Use:   "get <key>",
+		Short: "Prints the value of a config key.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c := config.New(streams, cfg)
+			c.ShowOrigin = showOrigin
+			return c.Get(args[0])
+		},
+	}
+	getCmd.Flags().BoolVar(&showOrigin, "show-origin", false, "print the layer and the file or environment variable of the value")
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "Prints every config key that is set.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c := config.New(streams, cfg)
+			c.ShowOrigin = showOrigin
+			return c.List()
+		},
+	}
+	listCmd.Flags().BoolVar(&showOrigin, "show-origin", false, "print the layer and the file or environment variable of each value")
+
+	var workspace bool
+	layer := func() rootConfig.Layer {
+		if workspace {
+			return rootConfig.WorkspaceLayer
+		}
+		return rootConfig.UserLayer
+	}
+
+	setCmd := &cobra.Command{
+		Use:   "set <key> <value>...",
+		Short: "Sets a config key in the user or workspace config.",
+		Long: `Sets a config key in the user config, or in the workspace config with
+--workspace. A list takes one value per element, placed after '--' when they
+look like flags, e.g.:
+
+aspect config set startup_options -- --host_jvm_args=-Xmx4g`,
+		Args: cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c := config.New(streams, cfg)
+			c.Layer = layer()
+			return c.Set(args[0], args[1:])
+		},
+	}
+	setCmd.Flags().BoolVar(&workspace, "workspace", false, "modify the workspace config instead of the user config")
+
+	unsetCmd := &cobra.Command{
+		Use:   "unset <key>",
+		Short: "Removes a config key from the user or workspace config.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c := config.New(streams, cfg)
+			c.Layer = layer()
+			return c.Unset(args[0])
+		},
+	}
+	unsetCmd.Flags().BoolVar(&workspace, "workspace", false, "modify the workspace config instead of the user config")
+
+	cmd.AddCommand(getCmd, listCmd, setCmd, unsetCmd)
+	return cmd
+}
@@ -0,0 +1,90 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package coverage
+
+import (
+	"context"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"aspect.build/cli/pkg/aspect/coverage"
+	"aspect.build/cli/pkg/aspect/root/config"
+	"aspect.build/cli/pkg/bazel"
+	"aspect.build/cli/pkg/completion"
+	"aspect.build/cli/pkg/interceptors"
+	"aspect.build/cli/pkg/ioutils"
+	"aspect.build/cli/pkg/plugin/system"
+	"aspect.build/cli/pkg/plugin/system/bep"
+)
+
+// NewDefaultCoverageCmd creates a new coverage cobra command with the default
+// dependencies.
+func NewDefaultCoverageCmd(cfg *config.Config, pluginSystem system.PluginSystem) *cobra.Command {
+	return NewCoverageCmd(
+		ioutils.DefaultStreams,
+		cfg,
+		pluginSystem,
+		bazel.New(),
+	)
+}
+
+// NewCoverageCmd creates a new coverage cobra command.
+func NewCoverageCmd(
+	streams ioutils.Streams,
+	cfg *config.Config,
+	pluginSystem system.PluginSystem,
+	bzl bazel.Bazel,
+) *cobra.Command {
+	var threshold float64
+	var lcovOutput string
+
+	cmd := &cobra.Command{
+		Use:   "coverage",
+		Short: "Generates code coverage report for specified test targets.",
+		Long: `Runs the specified test targets with code coverage instrumentation and
+merges the coverage of every test into a single LCOV report.
+
+This command accepts all valid options to 'test' and 'build'.
+
+The line coverage of each package is printed once the tests are done. With
+--threshold, the command fails when the total line coverage is below the
+given percentage.
+
+See 'bazel help target-syntax' for details and examples on how to
+specify targets.
+`,
+		// Bazel flags are validated by the ParseArgsInterceptor instead.
+		DisableFlagParsing: true,
+		ValidArgsFunction:  completion.ValidArgsFunction(bzl, "coverage"),
+		RunE: interceptors.Run(
+			[]interceptors.Interceptor{
+				interceptors.WorkspaceRootInterceptor(),
+				interceptors.StartupOptionsInterceptor(cfg, bzl),
+				interceptors.ParseArgsInterceptor(bzl, "coverage"),
+				pluginSystem.BESBackendInterceptor(),
+				pluginSystem.TestHooksInterceptor(streams),
+			},
+			func(ctx context.Context, cmd *cobra.Command, args []string) (exitErr error) {
+				workspaceRoot := ctx.Value(interceptors.WorkspaceRootKey).(string)
+				bzl.SetWorkspaceRoot(workspaceRoot)
+				c := coverage.New(streams, bzl)
+				c.Threshold = threshold
+				c.LCOVOutput = lcovOutput
+				if c.LCOVOutput == "" {
+					c.LCOVOutput = filepath.Join(workspaceRoot, "bazel-out", "_coverage", "aspect_coverage_report.dat")
+				}
+				besBackend := ctx.Value(system.BESBackendInterceptorKey).(bep.BESBackend)
+				return c.Run(args, besBackend)
+			},
+		),
+	}
+
+	cmd.Flags().Float64Var(&threshold, "threshold", 0, "fail when the total line coverage is below the given percentage")
+	cmd.Flags().StringVar(&lcovOutput, "lcov_output", "", "path of the merged LCOV report (default is bazel-out/_coverage/aspect_coverage_report.dat)")
+	return cmd
+}
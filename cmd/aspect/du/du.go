@@ -0,0 +1,64 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package du
+
+import (
+	"context"
+
+	"github.com/spf13/cobra"
+
+	"aspect.build/cli/pkg/aspect/du"
+	"aspect.build/cli/pkg/aspect/root/config"
+	"aspect.build/cli/pkg/bazel"
+	"aspect.build/cli/pkg/interceptors"
+	"aspect.build/cli/pkg/ioutils"
+)
+
+// NewDefaultDUCmd creates a new du cobra command with the default
+// dependencies.
+func NewDefaultDUCmd(cfg *config.Config) *cobra.Command {
+	return NewDUCmd(ioutils.DefaultStreams, cfg, bazel.New())
+}
+
+// NewDUCmd creates a new du cobra command.
+func NewDUCmd(streams ioutils.Streams, cfg *config.Config, bzl bazel.Bazel) *cobra.Command {
+	return &cobra.Command{
+		Use:   "du",
+		Short: "Reports the disk space used by Bazel for the workspace.",
+		Long: `Reports the disk space used by Bazel for the workspace, broken down by:
+
+execroot:          the build outputs
+external:          the external repositories, with the largest ones
+action_cache:      the results of the actions that ran locally
+bazelisk:          the Bazel binaries downloaded by Bazelisk
+repository_cache:  the files downloaded by repository rules
+disk_cache:        the action outputs cached with --disk_cache
+other:             the rest of the output base, e.g. the server state
+
+The bazelisk downloads and the repository and disk caches are shared with the
+other workspaces. The directories are located the way Bazel does, without
+starting a Bazel server.
+
+Then 'aspect clean --only=<category>' removes a single category.`,
+		Args: cobra.NoArgs,
+		RunE: interceptors.Run(
+			[]interceptors.Interceptor{
+				interceptors.WorkspaceRootInterceptor(),
+				interceptors.StartupOptionsInterceptor(cfg, bzl),
+			},
+			func(ctx context.Context, cmd *cobra.Command, args []string) (exitErr error) {
+				workspaceRoot := ctx.Value(interceptors.WorkspaceRootKey).(string)
+				startupOptions, _ := ctx.Value(interceptors.StartupOptionsKey).([]string)
+				locations, err := du.Locate(workspaceRoot, startupOptions)
+				if err != nil {
+					return err
+				}
+				return du.New(streams, locations).Run(cmd, args)
+			},
+		),
+	}
+}
@@ -0,0 +1,76 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package explaincache
+
+import (
+	"context"
+
+	"github.com/spf13/cobra"
+
+	"aspect.build/cli/pkg/aspect/explaincache"
+	"aspect.build/cli/pkg/aspect/root/config"
+	"aspect.build/cli/pkg/bazel"
+	"aspect.build/cli/pkg/completion"
+	"aspect.build/cli/pkg/interceptors"
+	"aspect.build/cli/pkg/ioutils"
+	"aspect.build/cli/pkg/plugin/system"
+	"aspect.build/cli/pkg/plugin/system/bep"
+)
+
+// NewDefaultExplainCacheCmd creates a new explain-cache cobra command with the
+// default dependencies.
+func NewDefaultExplainCacheCmd(cfg *config.Config, pluginSystem system.PluginSystem) *cobra.Command {
+	return NewExplainCacheCmd(
+		ioutils.DefaultStreams,
+		cfg,
+		pluginSystem,
+		bazel.New(),
+	)
+}
+
+// NewExplainCacheCmd creates a new explain-cache cobra command.
+func NewExplainCacheCmd(
+	streams ioutils.Streams,
+	cfg *config.Config,
+	pluginSystem system.PluginSystem,
+	bzl bazel.Bazel,
+) *cobra.Command {
+	return &cobra.Command{
+		Use:   "explain-cache",
+		Short: "Explains why the actions of a build missed the cache.",
+		Long: `Builds the specified targets twice, using the options, and explains why
+the actions of the second build missed the cache.
+
+The second build runs in a new, temporary output base, so that it can only
+reuse the results of the first one from the disk or remote cache, which must be
+set, e.g. with --disk_cache. The output base of the workspace isn't cleaned, but
+the second build fetches the external repositories again. Both builds write an
+execution log, and each action that ran again is explained with the first
+argument, environment variable or input digest that differs from the first
+build. The options are the ones of build, e.g.
+
+  aspect explain-cache --disk_cache=/tmp/cache //...`,
+		// Bazel flags are validated by the ParseArgsInterceptor instead.
+		DisableFlagParsing: true,
+		ValidArgsFunction:  completion.ValidArgsFunction(bzl, "build"),
+		RunE: interceptors.Run(
+			[]interceptors.Interceptor{
+				interceptors.WorkspaceRootInterceptor(),
+				interceptors.StartupOptionsInterceptor(cfg, bzl),
+				interceptors.ParseArgsInterceptor(bzl, "build"),
+				pluginSystem.BESBackendInterceptor(),
+			},
+			func(ctx context.Context, cmd *cobra.Command, args []string) (exitErr error) {
+				workspaceRoot := ctx.Value(interceptors.WorkspaceRootKey).(string)
+				bzl.SetWorkspaceRoot(workspaceRoot)
+				e := explaincache.New(streams, bzl)
+				besBackend := ctx.Value(system.BESBackendInterceptorKey).(bep.BESBackend)
+				return e.Run(args, besBackend)
+			},
+		),
+	}
+}
@@ -10,16 +10,18 @@ import (
 	"github.com/spf13/cobra"
 
 	"aspect.build/cli/pkg/aspect/info"
+	"aspect.build/cli/pkg/aspect/root/config"
+	"aspect.build/cli/pkg/bazel"
 	"aspect.build/cli/pkg/interceptors"
 	"aspect.build/cli/pkg/ioutils"
 )
 
-func NewDefaultInfoCmd() *cobra.Command {
-	return NewInfoCmd(ioutils.DefaultStreams)
+func NewDefaultInfoCmd(cfg *config.Config) *cobra.Command {
+	return NewInfoCmd(ioutils.DefaultStreams, cfg, bazel.New())
 }
 
-func NewInfoCmd(streams ioutils.Streams) *cobra.Command {
-	v := info.New(streams)
+func NewInfoCmd(streams ioutils.Streams, cfg *config.Config, bzl bazel.Bazel) *cobra.Command {
+	v := info.New(streams, bzl)
 
 	cmd := &cobra.Command{
 		Use:   "info",
@@ -40,12 +42,18 @@ The full list of keys and the meaning of their values is documented in
 the bazel User Manual, and can be programmatically obtained with
 'bazel help info-keys'.
 
+With --output=json, the keys and their values are printed as a JSON
+object, e.g. for scripts:
+
+  aspect info --output=json | jq -r .output_base
+
 See also 'bazel version' for more detailed bazel version
 information.`,
 		Args: cobra.MaximumNArgs(1),
 		RunE: interceptors.Run(
 			[]interceptors.Interceptor{
 				interceptors.WorkspaceRootInterceptor(),
+				interceptors.StartupOptionsInterceptor(cfg, bzl),
 			},
 			v.Run,
 		),
@@ -53,5 +61,7 @@ information.`,
 
 	cmd.PersistentFlags().BoolVarP(&v.ShowMakeEnv, "show_make_env", "", false, `include the set of key/value pairs in the "Make" environment,
 accessible within BUILD files`)
+	cmd.PersistentFlags().StringVarP(&v.Output, "output", "", "text", `The output format: text prints "key: value" lines like bazel info,
+json prints a JSON object.`)
 	return cmd
 }
@@ -11,10 +11,18 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
 
 	"aspect.build/cli/cmd/aspect/root"
+	"aspect.build/cli/pkg/aspect/root/config"
+	"aspect.build/cli/pkg/aspect/root/flags"
 	"aspect.build/cli/pkg/aspecterrors"
+	"aspect.build/cli/pkg/bazel"
+	"aspect.build/cli/pkg/interceptors"
 	"aspect.build/cli/pkg/ioutils"
+	"aspect.build/cli/pkg/pathutils"
 	"aspect.build/cli/pkg/plugin/system"
 )
 
@@ -37,24 +45,72 @@ func main() {
 		_ = os.Chdir(wd)
 	}
 
+	// Ctrl-C cancels the context of the command, which stops Bazel and the BES
+	// backend. A second Ctrl-C kills the CLI right away.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
 	pluginSystem := system.NewPluginSystem()
+	// os.Exit doesn't run the deferred functions, so the plugins are torn down
+	// before it explicitly.
+	exit := func(code int) {
+		pluginSystem.TearDown()
+		os.Exit(code)
+	}
 	if err := pluginSystem.Configure(ioutils.DefaultStreams); err != nil {
 		fmt.Fprintln(os.Stderr, "Error:", err)
-		os.Exit(1)
+		exit(1)
 	}
-	defer pluginSystem.TearDown()
 
-	cmd := root.NewDefaultRootCmd(pluginSystem)
-	if err := cmd.ExecuteContext(context.Background()); err != nil {
+	cfg := &config.Config{}
+	cmd := root.NewDefaultRootCmd(cfg, pluginSystem)
+	// Bazel startup options are placed before the command, so they are taken
+	// out before cobra looks for the command to run.
+	startupOptions, args := flags.SplitStartupOptions(cmd, os.Args[1:], workspaceFlags)
+	// The root flags are set before cobra runs, since it doesn't parse them
+	// for the commands that hand their flags over to Bazel.
+	args, err := flags.ParseRootFlags(cmd, args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		exit(1)
+	}
+	cmd.SetArgs(args)
+	root.AddPassthroughCmd(cmd, cfg, pluginSystem, args)
+	ctx = context.WithValue(ctx, interceptors.StartupOptionsKey, startupOptions)
+	if err := cmd.ExecuteContext(ctx); err != nil {
 		var exitErr *aspecterrors.ExitError
 		if errors.As(err, &exitErr) {
 			if exitErr.Err != nil {
 				fmt.Fprintln(os.Stderr, "Error:", err)
 			}
-			os.Exit(exitErr.ExitCode)
+			exit(exitErr.ExitCode)
 		}
 
 		fmt.Fprintln(os.Stderr, "Error:", err)
-		os.Exit(1)
+		exit(1)
+	}
+	exit(0)
+}
+
+// workspaceFlags returns the Bazel flags of the workspace in the working
+// directory, or nil outside of a workspace or when Bazel can't report them.
+func workspaceFlags() map[string]*bazel.FlagInfo {
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil
+	}
+	workspacePath, err := pathutils.DefaultWorkspaceFinder.Find(wd)
+	if err != nil || workspacePath == "" {
+		return nil
+	}
+	bzl := bazel.New()
+	bzl.SetWorkspaceRoot(filepath.Dir(workspacePath))
+	bazelFlags, err := bzl.Flags()
+	if err != nil {
+		return nil
 	}
+	return bazelFlags
 }
@@ -0,0 +1,74 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package passthrough
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"aspect.build/cli/pkg/aspect/passthrough"
+	"aspect.build/cli/pkg/aspect/root/config"
+	"aspect.build/cli/pkg/bazel"
+	"aspect.build/cli/pkg/completion"
+	"aspect.build/cli/pkg/interceptors"
+	"aspect.build/cli/pkg/ioutils"
+	"aspect.build/cli/pkg/plugin/system"
+	"aspect.build/cli/pkg/plugin/system/bep"
+)
+
+// NewDefaultPassthroughCmd creates a new cobra command that forwards the given
+// Bazel command to Bazel, with the default dependencies.
+func NewDefaultPassthroughCmd(cfg *config.Config, pluginSystem system.PluginSystem, command string) *cobra.Command {
+	return NewPassthroughCmd(
+		ioutils.DefaultStreams,
+		cfg,
+		pluginSystem,
+		bazel.New(),
+		command,
+	)
+}
+
+// NewPassthroughCmd creates a new cobra command that forwards the given Bazel
+// command to Bazel. It's hidden, since it is only added for a Bazel command that
+// aspect doesn't wrap when the user runs it.
+func NewPassthroughCmd(
+	streams ioutils.Streams,
+	cfg *config.Config,
+	pluginSystem system.PluginSystem,
+	bzl bazel.Bazel,
+	command string,
+) *cobra.Command {
+	chain := []interceptors.Interceptor{
+		interceptors.WorkspaceRootInterceptor(),
+		interceptors.StartupOptionsInterceptor(cfg, bzl),
+		interceptors.ParseArgsInterceptor(bzl, command),
+	}
+	if passthrough.EmitsBEP(command) {
+		chain = append(chain, pluginSystem.BESBackendInterceptor())
+	}
+
+	return &cobra.Command{
+		Use:    command,
+		Short:  fmt.Sprintf("Runs bazel %s.", command),
+		Hidden: true,
+		// Bazel flags are validated by the ParseArgsInterceptor instead.
+		DisableFlagParsing: true,
+		ValidArgsFunction:  completion.ValidArgsFunction(bzl, command),
+		RunE: interceptors.Run(
+			chain,
+			func(ctx context.Context, cmd *cobra.Command, args []string) (exitErr error) {
+				workspaceRoot := ctx.Value(interceptors.WorkspaceRootKey).(string)
+				bzl.SetWorkspaceRoot(workspaceRoot)
+				p := passthrough.New(streams, bzl)
+				besBackend, _ := ctx.Value(system.BESBackendInterceptorKey).(bep.BESBackend)
+				return p.Run(command, args, besBackend)
+			},
+		),
+	}
+}
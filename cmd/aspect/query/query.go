@@ -8,20 +8,27 @@ package query
 
 import (
 	"context"
+	"os"
 
 	"github.com/spf13/cobra"
 
 	"aspect.build/cli/pkg/aspect/query"
+	"aspect.build/cli/pkg/aspect/query/shared"
+	"aspect.build/cli/pkg/aspect/root/config"
 	"aspect.build/cli/pkg/bazel"
 	"aspect.build/cli/pkg/interceptors"
 	"aspect.build/cli/pkg/ioutils"
 )
 
-func NewDefaultQueryCmd() *cobra.Command {
-	return NewQueryCommand(ioutils.DefaultStreams, bazel.New())
+func NewDefaultQueryCmd(cfg *config.Config) *cobra.Command {
+	return NewQueryCommand(ioutils.DefaultStreams, cfg, bazel.New())
 }
 
-func NewQueryCommand(streams ioutils.Streams, bzl bazel.Bazel) *cobra.Command {
+func NewQueryCommand(streams ioutils.Streams, cfg *config.Config, bzl bazel.Bazel) *cobra.Command {
+	var save string
+	var set []string
+	var output shared.Output
+
 	cmd := &cobra.Command{
 		Use:   "query",
 		Short: "Executes a dependency graph query.",
@@ -29,15 +36,47 @@ func NewQueryCommand(streams ioutils.Streams, bzl bazel.Bazel) *cobra.Command {
 		RunE: interceptors.Run(
 			[]interceptors.Interceptor{
 				interceptors.WorkspaceRootInterceptor(),
+				interceptors.StartupOptionsInterceptor(cfg, bzl),
 			},
 			func(ctx context.Context, cmd *cobra.Command, args []string) (exitErr error) {
 				workspaceRoot := ctx.Value(interceptors.WorkspaceRootKey).(string)
 				bzl.SetWorkspaceRoot(workspaceRoot)
-				q := query.New(streams, bzl, true)
+				q := query.New(streams, cfg, bzl, true)
+				q.Save = save
+				q.WorkspaceRoot = workspaceRoot
+				wd, err := os.Getwd()
+				if err != nil {
+					return err
+				}
+				if !output.NoCache {
+					cacheDir, err := shared.QueryCacheDir(workspaceRoot)
+					if err != nil {
+						return err
+					}
+					startupOptions, _ := ctx.Value(interceptors.StartupOptionsKey).([]string)
+					output.Cache = shared.NewQueryCache(cacheDir, workspaceRoot, startupOptions)
+				}
+				q.Output = output
+				if q.Set, err = shared.ParseSet(set); err != nil {
+					return err
+				}
+				q.PromptLabel = shared.LabelPrompt(workspaceRoot, wd)
+				historyFile, err := shared.HistoryFile(workspaceRoot)
+				if err != nil {
+					return err
+				}
+				q.History = shared.LoadHistory(historyFile, streams.Stderr)
 				return q.Run(cmd, args)
 			},
 		),
 	}
 
+	cmd.Flags().StringVarP(&save, "save", "", "", `Save the query as a preset of the workspace config with this name.
+Its ?placeholders, optionally typed as ?name:label, ?name:int or
+?name:enum(a,b), are prompted for when the preset is selected.`)
+	cmd.Flags().StringArrayVarP(&set, "set", "", nil, `Set a placeholder of the query by name, e.g. --set target=//foo.
+The other placeholders take the arguments after the preset name in
+order, and are prompted for when missing.`)
+	shared.AddOutputFlags(cmd, &output)
 	return cmd
 }
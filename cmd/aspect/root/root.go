@@ -11,21 +11,28 @@ import (
 
 	"github.com/fatih/color"
 	"github.com/mattn/go-isatty"
-	"github.com/mitchellh/go-homedir"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 
+	"aspect.build/cli/cmd/aspect/affected"
 	"aspect.build/cli/cmd/aspect/aquery"
 	"aspect.build/cli/cmd/aspect/build"
 	"aspect.build/cli/cmd/aspect/clean"
+	"aspect.build/cli/cmd/aspect/completion"
+	"aspect.build/cli/cmd/aspect/config"
+	"aspect.build/cli/cmd/aspect/coverage"
 	"aspect.build/cli/cmd/aspect/cquery"
 	"aspect.build/cli/cmd/aspect/docs"
+	"aspect.build/cli/cmd/aspect/du"
+	"aspect.build/cli/cmd/aspect/explaincache"
 	"aspect.build/cli/cmd/aspect/info"
+	"aspect.build/cli/cmd/aspect/passthrough"
 	"aspect.build/cli/cmd/aspect/query"
 	"aspect.build/cli/cmd/aspect/run"
 	"aspect.build/cli/cmd/aspect/test"
 	"aspect.build/cli/cmd/aspect/version"
+	"aspect.build/cli/cmd/aspect/why"
 	"aspect.build/cli/docs/help/topics"
+	rootConfig "aspect.build/cli/pkg/aspect/root/config"
 	"aspect.build/cli/pkg/aspect/root/flags"
 	"aspect.build/cli/pkg/ioutils"
 	"aspect.build/cli/pkg/plugin/system"
@@ -36,13 +43,18 @@ var (
 	faint    = color.New(color.Faint)
 )
 
-func NewDefaultRootCmd(pluginSystem system.PluginSystem) *cobra.Command {
+// NewDefaultRootCmd creates a new root cobra command with the default
+// dependencies. cfg is loaded by the root command before any child command
+// runs.
+func NewDefaultRootCmd(cfg *rootConfig.Config, pluginSystem system.PluginSystem) *cobra.Command {
 	defaultInteractive := isatty.IsTerminal(os.Stdout.Fd()) || isatty.IsCygwinTerminal(os.Stdout.Fd())
-	return NewRootCmd(ioutils.DefaultStreams, pluginSystem, defaultInteractive)
+	return NewRootCmd(ioutils.DefaultStreams, cfg, pluginSystem, defaultInteractive)
 }
 
+// NewRootCmd creates a new root cobra command.
 func NewRootCmd(
 	streams ioutils.Streams,
+	cfg *rootConfig.Config,
 	pluginSystem system.PluginSystem,
 	defaultInteractive bool,
 ) *cobra.Command {
@@ -62,56 +74,34 @@ func NewRootCmd(
 	cmd.PersistentFlags().StringVar(&cfgFile, flags.ConfigFlagName, "", "config file (default is $HOME/.aspect.yaml)")
 	cmd.PersistentFlags().BoolVar(&interactive, flags.InteractiveFlagName, defaultInteractive, "Interactive mode (e.g. prompts for user input)")
 
-	// If user specifies the config file to use then we want to only use that config.
-	// If user does not specify a config file to use then we want to load ".aspect" from the
-	// $HOME directory and from the root of the repo (if it exists).
-	// Adding a second config path using "AddConfigPath" does not work because we dont
-	// change the config name using "AddConfigPath". This results in loading the same config
-	// file twice. A workaround for this is to have a second viper instance load the repo
-	// config and merge them together. Source: https://github.com/spf13/viper/issues/181
-	repoViper := viper.New()
-
-	if cfgFile != "" {
-		// Use config file from the flag.
-		viper.SetConfigFile(cfgFile)
-	} else {
-		// Find home directory.
-		home, err := homedir.Dir()
-		cobra.CheckErr(err)
-
-		// Search for config in home directory with name ".aspect" (without extension).
-		viper.AddConfigPath(home)
-		viper.SetConfigName(".aspect")
-
-		// Search for config in root of current repo with name ".aspect" (without extension).
-		repoViper.AddConfigPath(".")
-		repoViper.SetConfigName(".aspect")
-		repoViper.AutomaticEnv()
-	}
-
-	viper.AutomaticEnv()
-	if err := viper.ReadInConfig(); err == nil {
-		faint.Fprintln(streams.Stderr, "Using config file:", viper.ConfigFileUsed())
+	// The config is loaded once the flags are parsed so that --config is taken
+	// into account. If user specifies the config file to use then we want to
+	// only use that config. Otherwise the config is layered from the $HOME
+	// directory, the root of the workspace (if any) and the environment. It's
+	// loaded once here, and the child commands share it.
+	cmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		return loadConfig(streams, cfg, cfgFile)
 	}
 
-	if err := repoViper.ReadInConfig(); err == nil {
-		faint.Fprintln(streams.Stderr, "Using config file:", repoViper.ConfigFileUsed())
-	}
-
-	viper.MergeConfigMap(repoViper.AllSettings())
-
 	// ### Child commands
 	// IMPORTANT: when adding a new command, also update the _DOCS list in /docs/BUILD.bazel
-	cmd.AddCommand(build.NewDefaultBuildCmd(pluginSystem))
-	cmd.AddCommand(clean.NewDefaultCleanCmd())
+	cmd.AddCommand(affected.NewDefaultAffectedCmd(cfg, pluginSystem))
+	cmd.AddCommand(build.NewDefaultBuildCmd(cfg, pluginSystem))
+	cmd.AddCommand(clean.NewDefaultCleanCmd(cfg))
+	cmd.AddCommand(completion.NewDefaultCompletionCmd())
+	cmd.AddCommand(config.NewDefaultConfigCmd(cfg))
+	cmd.AddCommand(coverage.NewDefaultCoverageCmd(cfg, pluginSystem))
 	cmd.AddCommand(docs.NewDefaultDocsCmd())
-	cmd.AddCommand(info.NewDefaultInfoCmd())
-	cmd.AddCommand(aquery.NewDefaultAQueryCmd())
-	cmd.AddCommand(cquery.NewDefaultCQueryCmd())
-	cmd.AddCommand(query.NewDefaultQueryCmd())
-	cmd.AddCommand(run.NewDefaultRunCmd(pluginSystem))
-	cmd.AddCommand(test.NewDefaultTestCmd(pluginSystem))
-	cmd.AddCommand(version.NewDefaultVersionCmd())
+	cmd.AddCommand(du.NewDefaultDUCmd(cfg))
+	cmd.AddCommand(explaincache.NewDefaultExplainCacheCmd(cfg, pluginSystem))
+	cmd.AddCommand(info.NewDefaultInfoCmd(cfg))
+	cmd.AddCommand(aquery.NewDefaultAQueryCmd(cfg))
+	cmd.AddCommand(cquery.NewDefaultCQueryCmd(cfg))
+	cmd.AddCommand(query.NewDefaultQueryCmd(cfg))
+	cmd.AddCommand(run.NewDefaultRunCmd(cfg, pluginSystem))
+	cmd.AddCommand(test.NewDefaultTestCmd(cfg, pluginSystem))
+	cmd.AddCommand(version.NewDefaultVersionCmd(cfg))
+	cmd.AddCommand(why.NewDefaultWhyCmd(cfg))
 
 	// ### "Additional help topic commands" which are not runnable
 	// https://pkg.go.dev/github.com/spf13/cobra#Command.IsAdditionalHelpTopicCommand
@@ -133,3 +123,37 @@ func NewRootCmd(
 
 	return cmd
 }
+
+// loadConfig loads and validates the config into cfg.
+func loadConfig(streams ioutils.Streams, cfg *rootConfig.Config, cfgFile string) error {
+	userFile, workspaceFile := cfgFile, ""
+	if cfgFile == "" {
+		var err error
+		if userFile, workspaceFile, err = rootConfig.DefaultFiles(); err != nil {
+			return err
+		}
+	}
+	if err := cfg.Load(userFile, workspaceFile, os.Environ()); err != nil {
+		return err
+	}
+	for _, file := range cfg.LoadedFiles() {
+		faint.Fprintln(streams.Stderr, "Using config file:", file)
+	}
+	return nil
+}
+
+// AddPassthroughCmd adds a command that forwards the command in args to Bazel
+// when it isn't an aspect command, e.g. `aspect sync`, so that aspect can be
+// used in place of bazel for every Bazel command.
+func AddPassthroughCmd(cmd *cobra.Command, cfg *rootConfig.Config, pluginSystem system.PluginSystem, args []string) {
+	i := flags.CommandIndex(cmd, args)
+	if i >= 0 && (args[i] == cobra.ShellCompRequestCmd || args[i] == cobra.ShellCompNoDescRequestCmd) {
+		// Completing the arguments of a Bazel command.
+		args = args[i+1:]
+		i = flags.CommandIndex(cmd, args)
+	}
+	if i < 0 || flags.IsCommand(cmd, args[i]) {
+		return
+	}
+	cmd.AddCommand(passthrough.NewDefaultPassthroughCmd(cfg, pluginSystem, args[i]))
+}
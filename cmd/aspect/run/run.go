@@ -11,8 +11,10 @@ import (
 
 	"github.com/spf13/cobra"
 
+	"aspect.build/cli/pkg/aspect/root/config"
 	"aspect.build/cli/pkg/aspect/run"
 	"aspect.build/cli/pkg/bazel"
+	"aspect.build/cli/pkg/completion"
 	"aspect.build/cli/pkg/interceptors"
 	"aspect.build/cli/pkg/ioutils"
 	"aspect.build/cli/pkg/plugin/system"
@@ -21,9 +23,10 @@ import (
 
 // NewDefaultRunCmd creates a new run cobra command with the default
 // dependencies.
-func NewDefaultRunCmd(pluginSystem system.PluginSystem) *cobra.Command {
+func NewDefaultRunCmd(cfg *config.Config, pluginSystem system.PluginSystem) *cobra.Command {
 	return NewRunCmd(
 		ioutils.DefaultStreams,
+		cfg,
 		pluginSystem,
 		bazel.New(),
 	)
@@ -31,6 +34,7 @@ func NewDefaultRunCmd(pluginSystem system.PluginSystem) *cobra.Command {
 
 func NewRunCmd(
 	streams ioutils.Streams,
+	cfg *config.Config,
 	pluginSystem system.PluginSystem,
 	bzl bazel.Bazel,
 ) *cobra.Command {
@@ -45,9 +49,14 @@ provided by .bazelrc.
 If your script needs stdin or execution not constrained by the bazel lock,
 use 'bazel run --script_path' to write a script and then execute it.
 `,
+		// Bazel flags are validated by the ParseArgsInterceptor instead.
+		DisableFlagParsing: true,
+		ValidArgsFunction:  completion.ValidArgsFunction(bzl, "run"),
 		RunE: interceptors.Run(
 			[]interceptors.Interceptor{
 				interceptors.WorkspaceRootInterceptor(),
+				interceptors.StartupOptionsInterceptor(cfg, bzl),
+				interceptors.ParseArgsInterceptor(bzl, "run"),
 				pluginSystem.BESBackendInterceptor(),
 				pluginSystem.RunHooksInterceptor(streams),
 			},
@@ -11,8 +11,10 @@ import (
 
 	"github.com/spf13/cobra"
 
+	"aspect.build/cli/pkg/aspect/root/config"
 	"aspect.build/cli/pkg/aspect/test"
 	"aspect.build/cli/pkg/bazel"
+	"aspect.build/cli/pkg/completion"
 	"aspect.build/cli/pkg/interceptors"
 	"aspect.build/cli/pkg/ioutils"
 	"aspect.build/cli/pkg/plugin/system"
@@ -21,9 +23,10 @@ import (
 
 // NewDefaultTestCmd creates a new test cobra command with the default
 // dependencies.
-func NewDefaultTestCmd(pluginSystem system.PluginSystem) *cobra.Command {
+func NewDefaultTestCmd(cfg *config.Config, pluginSystem system.PluginSystem) *cobra.Command {
 	return NewTestCmd(
 		ioutils.DefaultStreams,
+		cfg,
 		pluginSystem,
 		bazel.New(),
 	)
@@ -31,6 +34,7 @@ func NewDefaultTestCmd(pluginSystem system.PluginSystem) *cobra.Command {
 
 func NewTestCmd(
 	streams ioutils.Streams,
+	cfg *config.Config,
 	pluginSystem system.PluginSystem,
 	bzl bazel.Bazel,
 ) *cobra.Command {
@@ -48,9 +52,14 @@ don't forget to pass all your 'build' options to 'test' too.
 See 'bazel help target-syntax' for details and examples on how to
 specify targets.
 `,
+		// Bazel flags are validated by the ParseArgsInterceptor instead.
+		DisableFlagParsing: true,
+		ValidArgsFunction:  completion.ValidArgsFunction(bzl, "test"),
 		RunE: interceptors.Run(
 			[]interceptors.Interceptor{
 				interceptors.WorkspaceRootInterceptor(),
+				interceptors.StartupOptionsInterceptor(cfg, bzl),
+				interceptors.ParseArgsInterceptor(bzl, "test"),
 				pluginSystem.BESBackendInterceptor(),
 				pluginSystem.TestHooksInterceptor(streams),
 			},
@@ -12,17 +12,18 @@ import (
 	"github.com/spf13/cobra"
 
 	"aspect.build/cli/buildinfo"
+	"aspect.build/cli/pkg/aspect/root/config"
 	"aspect.build/cli/pkg/aspect/version"
 	"aspect.build/cli/pkg/bazel"
 	"aspect.build/cli/pkg/interceptors"
 	"aspect.build/cli/pkg/ioutils"
 )
 
-func NewDefaultVersionCmd() *cobra.Command {
-	return NewVersionCmd(ioutils.DefaultStreams, bazel.New())
+func NewDefaultVersionCmd(cfg *config.Config) *cobra.Command {
+	return NewVersionCmd(ioutils.DefaultStreams, cfg, bazel.New())
 }
 
-func NewVersionCmd(streams ioutils.Streams, bzl bazel.Bazel) *cobra.Command {
+func NewVersionCmd(streams ioutils.Streams, cfg *config.Config, bzl bazel.Bazel) *cobra.Command {
 	v := version.New(streams)
 
 	v.BuildinfoRelease = buildinfo.Release
@@ -31,10 +32,17 @@ func NewVersionCmd(streams ioutils.Streams, bzl bazel.Bazel) *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "version",
 		Short: "Print the version of aspect CLI as well as tools it invokes.",
-		Long:  `Prints version info on colon-separated lines, just like bazel does`,
+		Long: `Prints version info on colon-separated lines, just like bazel does.
+
+With --resolve, it explains which version of Bazel the workspace runs instead:
+which source decided it, e.g. the USE_BAZEL_VERSION environment variable, the
+tools/bazel wrapper, .bazeliskrc or .bazelversion, and which binary runs. The
+minimum_bazel_version of the WORKSPACE is only a lower bound, which the latest
+release satisfies when no version is set. The binary isn't downloaded.`,
 		RunE: interceptors.Run(
 			[]interceptors.Interceptor{
 				interceptors.WorkspaceRootInterceptor(),
+				interceptors.StartupOptionsInterceptor(cfg, bzl),
 			},
 			func(ctx context.Context, cmd *cobra.Command, args []string) (exitErr error) {
 				workspaceRoot := ctx.Value(interceptors.WorkspaceRootKey).(string)
@@ -45,6 +53,7 @@ func NewVersionCmd(streams ioutils.Streams, bzl bazel.Bazel) *cobra.Command {
 	}
 
 	cmd.PersistentFlags().BoolVarP(&v.GNUFormat, "gnu_format", "", false, "format space-separated following GNU convention")
+	cmd.PersistentFlags().BoolVarP(&v.Resolve, "resolve", "", false, "explain which version of Bazel the workspace runs and which binary")
 
 	return cmd
 }
@@ -0,0 +1,66 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package why
+
+import (
+	"context"
+
+	"github.com/spf13/cobra"
+
+	"aspect.build/cli/pkg/aspect/root/config"
+	"aspect.build/cli/pkg/aspect/why"
+	"aspect.build/cli/pkg/bazel"
+	"aspect.build/cli/pkg/interceptors"
+	"aspect.build/cli/pkg/ioutils"
+)
+
+// NewDefaultWhyCmd creates a new why cobra command with the default
+// dependencies.
+func NewDefaultWhyCmd(cfg *config.Config) *cobra.Command {
+	return NewWhyCmd(ioutils.DefaultStreams, cfg, bazel.New())
+}
+
+// NewWhyCmd creates a new why cobra command.
+func NewWhyCmd(streams ioutils.Streams, cfg *config.Config, bzl bazel.Bazel) *cobra.Command {
+	var all bool
+	var cquery bool
+	var configs bool
+
+	cmd := &cobra.Command{
+		Use:   "why",
+		Short: "Explains why a target depends on another one.",
+		Long: `Explains why a target depends on another one: 'aspect why <target> <dependency>'
+prints a dependency path between them as a tree. Each edge shows the attribute of the rule that lists
+the dependency, e.g. deps, data or srcs, or toolchain/implicit for the
+toolchains and the implicit dependencies of the rule.
+
+With --cquery, the configured dependencies are followed instead, and each
+target shows the hash of its configuration. --configs also explains the
+configuration changes along the path with 'bazel config'.`,
+		Args: cobra.ExactArgs(2),
+		RunE: interceptors.Run(
+			[]interceptors.Interceptor{
+				interceptors.WorkspaceRootInterceptor(),
+				interceptors.StartupOptionsInterceptor(cfg, bzl),
+			},
+			func(ctx context.Context, cmd *cobra.Command, args []string) (exitErr error) {
+				workspaceRoot := ctx.Value(interceptors.WorkspaceRootKey).(string)
+				bzl.SetWorkspaceRoot(workspaceRoot)
+				w := why.New(streams, bzl)
+				w.All = all
+				w.CQuery = cquery
+				w.Configs = configs
+				return w.Run(cmd, args)
+			},
+		),
+	}
+
+	cmd.Flags().BoolVarP(&all, "all", "", false, "Show every dependency path instead of one.")
+	cmd.Flags().BoolVarP(&cquery, "cquery", "", false, "Follow the configured dependencies with cquery.")
+	cmd.Flags().BoolVarP(&configs, "configs", "", false, "Explain the configuration changes along the path. Implies --cquery.")
+	return cmd
+}
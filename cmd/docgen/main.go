@@ -8,6 +8,7 @@ import (
 	"github.com/spf13/cobra/doc"
 
 	"aspect.build/cli/cmd/aspect/root"
+	"aspect.build/cli/pkg/aspect/root/config"
 	"aspect.build/cli/pkg/ioutils"
 	"aspect.build/cli/pkg/plugin/system"
 )
@@ -21,7 +22,7 @@ func main() {
 	}
 	defer pluginSystem.TearDown()
 
-	aspectRootCmd := root.NewDefaultRootCmd(pluginSystem)
+	aspectRootCmd := root.NewDefaultRootCmd(&config.Config{}, pluginSystem)
 
 	cmd.AddCommand(NewBzlCommandListCmd(aspectRootCmd))
 	cmd.AddCommand(NewGenMarkdownCmd(aspectRootCmd))
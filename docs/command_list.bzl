@@ -2,14 +2,21 @@
 This module contains the list of top-level commands from the aspect CLI.
 """
 COMMAND_LIST = [
+    "affected",
     "aquery",
     "build",
     "clean",
+    "completion",
+    "config",
+    "coverage",
     "cquery",
     "docs",
+    "du",
+    "explain-cache",
     "info",
     "query",
     "run",
     "test",
     "version",
+    "why",
 ]
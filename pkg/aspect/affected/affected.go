@@ -0,0 +1,288 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package affected
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"aspect.build/cli/pkg/aspecterrors"
+	"aspect.build/cli/pkg/bazel"
+	"aspect.build/cli/pkg/gitutils"
+	"aspect.build/cli/pkg/ioutils"
+)
+
+// buildFilenames are the names of the files that declare a package.
+var buildFilenames = []string{"BUILD.bazel", "BUILD"}
+
+// globalFiles are the files outside of the packages that every target
+// depends on.
+var globalFiles = map[string]bool{
+	".bazelrc":         true,
+	".bazelversion":    true,
+	"MODULE.bazel":     true,
+	"WORKSPACE":        true,
+	"WORKSPACE.bazel":  true,
+	"WORKSPACE.bzlmod": true,
+}
+
+// Affected represents the aspect affected command.
+type Affected struct {
+	ioutils.Streams
+	bzl bazel.Bazel
+
+	WorkspaceRoot string
+	// Base is the git revision that the changes are made against. The files
+	// are diffed against its merge-base with HEAD, so that the changes made
+	// on Base since the branch forked off it aren't taken as changes.
+	Base string
+	// Stdin reads the changed files from stdin instead of git, one per line
+	// and relative to the workspace root.
+	Stdin bool
+	// Universe is the target pattern of the targets that may be affected.
+	Universe string
+	// RunTests runs the affected tests with the given flags when it is set.
+	RunTests func(cmd *cobra.Command, args []string) error
+}
+
+// New creates an Affected command.
+func New(streams ioutils.Streams, bzl bazel.Bazel, workspaceRoot string) *Affected {
+	return &Affected{
+		Streams:       streams,
+		bzl:           bzl,
+		WorkspaceRoot: workspaceRoot,
+		Base:          "HEAD",
+		Universe:      "//...",
+	}
+}
+
+// Run prints the tests that are affected by the changed files, and runs them
+// with the given flags when RunTests is set.
+func (a *Affected) Run(cmd *cobra.Command, args []string) error {
+	files, err := a.changedFiles()
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		fmt.Fprintln(a.Stderr, "No file changed")
+		return nil
+	}
+	fmt.Fprintf(a.Stderr, "Found %d changed file(s)\n", len(files))
+
+	patterns, everything, err := a.owners(files)
+	if err != nil {
+		return err
+	}
+	var expression string
+	switch {
+	case everything:
+		expression = fmt.Sprintf("tests(%s)", a.Universe)
+	case len(patterns) > 0:
+		expression = fmt.Sprintf("tests(rdeps(%s, set(%s)))", a.Universe, strings.Join(patterns, " "))
+	default:
+		fmt.Fprintln(a.Stderr, "No target is affected")
+		return nil
+	}
+
+	result, err := a.query("--output=label", expression)
+	if err != nil {
+		return err
+	}
+	tests := lines(result)
+	if len(tests) == 0 {
+		fmt.Fprintln(a.Stderr, "No test is affected")
+		return nil
+	}
+	fmt.Fprintf(a.Stderr, "%d test(s) affected\n", len(tests))
+	for _, test := range tests {
+		fmt.Fprintln(a.Stdout, test)
+	}
+
+	if a.RunTests == nil {
+		return nil
+	}
+	return a.RunTests(cmd, append(args, tests...))
+}
+
+// changedFiles returns the changed files relative to the workspace root.
+func (a *Affected) changedFiles() ([]string, error) {
+	if a.Stdin {
+		var files []string
+		scanner := bufio.NewScanner(a.Streams.Stdin)
+		for scanner.Scan() {
+			file := strings.TrimSpace(scanner.Text())
+			if file == "" {
+				continue
+			}
+			if filepath.IsAbs(file) {
+				rel, err := filepath.Rel(a.WorkspaceRoot, file)
+				if err != nil {
+					return nil, fmt.Errorf("failed to read the changed files: %w", err)
+				}
+				file = rel
+			}
+			files = append(files, filepath.ToSlash(file))
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read the changed files: %w", err)
+		}
+		return files, nil
+	}
+
+	mergeBase, err := gitutils.Run(a.WorkspaceRoot, "merge-base", a.Base, "HEAD")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the changed files: %w", err)
+	}
+	// Both commands list the paths relative to the workspace root, where they
+	// run, and leave out the files outside of the workspace.
+	diff, err := gitutils.Run(a.WorkspaceRoot, "diff", "--name-only", "--relative", strings.TrimSpace(mergeBase))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the changed files: %w", err)
+	}
+	untracked, err := gitutils.Run(a.WorkspaceRoot, "ls-files", "--others", "--exclude-standard")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the changed files: %w", err)
+	}
+	return append(lines([]byte(diff)), lines([]byte(untracked))...), nil
+}
+
+// owners returns the target patterns that own the changed files: the source
+// file of a changed file, every target of a package whose BUILD file or a
+// deleted file changed, and every target of the packages that load a changed
+// .bzl file. A changed file that isn't a target, e.g. a README in a package,
+// owns nothing. everything is true when a file that every target depends on
+// changed.
+func (a *Affected) owners(files []string) (patterns []string, everything bool, err error) {
+	seen := make(map[string]bool)
+	add := func(pattern string) {
+		if !seen[pattern] {
+			seen[pattern] = true
+			patterns = append(patterns, pattern)
+		}
+	}
+	var sourceFiles, bzlFiles, packages []string
+	seenPackages := make(map[string]bool)
+	for _, file := range files {
+		if globalFiles[file] {
+			return nil, true, nil
+		}
+		pkg, ok := a.findPackage(path.Dir(file))
+		if !ok {
+			// The file isn't part of any package, e.g. a README at the
+			// root of the workspace.
+			continue
+		}
+		name := strings.TrimPrefix(strings.TrimPrefix(file, pkg), "/")
+		_, statErr := os.Stat(filepath.Join(a.WorkspaceRoot, file))
+		switch {
+		case isBuildFile(name) || os.IsNotExist(statErr):
+			add(fmt.Sprintf("//%s:all", pkg))
+		case strings.HasSuffix(name, ".bzl"):
+			bzlFiles = append(bzlFiles, fmt.Sprintf("//%s:%s", pkg, name))
+		default:
+			sourceFiles = append(sourceFiles, fmt.Sprintf("//%s:%s", pkg, name))
+			if !seenPackages[pkg] {
+				seenPackages[pkg] = true
+				packages = append(packages, fmt.Sprintf("//%s:*", pkg))
+			}
+		}
+	}
+
+	if len(sourceFiles) > 0 {
+		// A file is only a target when a rule of its package references it,
+		// and a query of a file that isn't a target fails.
+		result, err := a.query("--output=label", fmt.Sprintf("set(%s)", strings.Join(packages, " ")))
+		if err != nil {
+			return nil, false, err
+		}
+		targets := make(map[string]bool)
+		for _, target := range lines(result) {
+			targets[target] = true
+		}
+		for _, sourceFile := range sourceFiles {
+			if targets[sourceFile] {
+				add(sourceFile)
+			}
+		}
+	}
+
+	if len(bzlFiles) > 0 {
+		// rbuildfiles needs the universe to be loaded upfront.
+		result, err := a.query(fmt.Sprintf("--universe_scope=%s", a.Universe), "--order_output=no", "--output=label", fmt.Sprintf("rbuildfiles(%s)", strings.Join(bzlFiles, ", ")))
+		if err != nil {
+			return nil, false, err
+		}
+		for _, buildFile := range lines(result) {
+			add(strings.SplitN(buildFile, ":", 2)[0] + ":all")
+		}
+	}
+	return patterns, false, nil
+}
+
+// findPackage returns the package that contains the given directory, which
+// is the closest one with a BUILD file.
+func (a *Affected) findPackage(dir string) (string, bool) {
+	for {
+		if dir == "." {
+			dir = ""
+		}
+		for _, buildFilename := range buildFilenames {
+			if _, err := os.Stat(filepath.Join(a.WorkspaceRoot, dir, buildFilename)); err == nil {
+				return dir, true
+			}
+		}
+		if dir == "" {
+			return "", false
+		}
+		dir = path.Dir(dir)
+	}
+}
+
+// query runs a Bazel query and returns its result. The errors of Bazel are
+// printed to stderr.
+func (a *Affected) query(args ...string) ([]byte, error) {
+	var out bytes.Buffer
+	streams := ioutils.Streams{Stdout: &out, Stderr: a.Stderr}
+	exitCode, err := a.bzl.Run(append([]string{"query"}, args...), bazel.RunOptions{Streams: streams})
+	if exitCode != 0 {
+		return nil, &aspecterrors.ExitError{
+			Err:      err,
+			ExitCode: exitCode,
+		}
+	}
+	return out.Bytes(), nil
+}
+
+func isBuildFile(name string) bool {
+	for _, buildFilename := range buildFilenames {
+		if name == buildFilename {
+			return true
+		}
+	}
+	return false
+}
+
+// lines returns the sorted non-empty lines of the output.
+func lines(output []byte) []string {
+	var result []string
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			result = append(result, line)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
@@ -0,0 +1,237 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package affected_test
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/cobra"
+
+	"aspect.build/cli/pkg/aspect/affected"
+	"aspect.build/cli/pkg/aspecterrors"
+	"aspect.build/cli/pkg/bazel"
+	bazel_mock "aspect.build/cli/pkg/bazel/mock"
+	"aspect.build/cli/pkg/gitutils"
+	"aspect.build/cli/pkg/ioutils"
+)
+
+// workspace creates a workspace with the given files.
+func workspace(t *testing.T, files ...string) string {
+	dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "affected")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	for _, file := range append(files, "WORKSPACE") {
+		path := filepath.Join(dir, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// respond returns the given output to a Run call with the exit code.
+func respond(output string, exitCode int) func(command []string, options bazel.RunOptions) (int, error) {
+	return func(command []string, options bazel.RunOptions) (int, error) {
+		_, err := io.WriteString(options.Streams.Stdout, output)
+		return exitCode, err
+	}
+}
+
+func TestAffected(t *testing.T) {
+	t.Run("the changed files are mapped to their owners", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		root := workspace(t,
+			"README.md",
+			"lib/BUILD.bazel",
+			"lib/a.go",
+			"lib/internal/b.go",
+			"lib/defs.bzl",
+			"app/BUILD",
+		)
+		spawner := bazel_mock.NewMockBazel(ctrl)
+		gomock.InOrder(
+			spawner.
+				EXPECT().
+				Run([]string{"query", "--output=label", "set(//lib:*)"}, gomock.Any()).
+				DoAndReturn(respond("//lib:BUILD.bazel\n//lib:a.go\n//lib:a_test\n", 0)),
+			spawner.
+				EXPECT().
+				Run([]string{"query", "--universe_scope=//...", "--order_output=no", "--output=label", "rbuildfiles(//lib:defs.bzl)"}, gomock.Any()).
+				DoAndReturn(respond("//app:BUILD\n//lib:BUILD.bazel\n", 0)),
+			spawner.
+				EXPECT().
+				Run([]string{"query", "--output=label", "tests(rdeps(//..., set(//lib:a.go //app:all //lib:all)))"}, gomock.Any()).
+				DoAndReturn(respond("//lib:a_test\n//app:app_test\n", 0)),
+		)
+
+		var stdout, stderr strings.Builder
+		a := affected.New(ioutils.Streams{
+			Stdin:  strings.NewReader("README.md\nlib/a.go\n" + filepath.Join(root, "lib/internal/b.go") + "\n\nlib/defs.bzl\n"),
+			Stdout: &stdout,
+			Stderr: &stderr,
+		}, spawner, root)
+		a.Stdin = true
+		g.Expect(a.Run(nil, nil)).To(Succeed())
+		g.Expect(stdout.String()).To(Equal("//app:app_test\n//lib:a_test\n"))
+		g.Expect(stderr.String()).To(Equal("Found 4 changed file(s)\n2 test(s) affected\n"))
+	})
+
+	t.Run("a change to the WORKSPACE affects every test", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		root := workspace(t, "lib/BUILD.bazel")
+		spawner := bazel_mock.NewMockBazel(ctrl)
+		spawner.
+			EXPECT().
+			Run([]string{"query", "--output=label", "tests(//lib/...)"}, gomock.Any()).
+			DoAndReturn(respond("//lib:a_test\n", 0))
+
+		var stdout strings.Builder
+		a := affected.New(ioutils.Streams{Stdin: strings.NewReader("lib/deleted.go\nWORKSPACE\n"), Stdout: &stdout, Stderr: ioutil.Discard}, spawner, root)
+		a.Stdin = true
+		a.Universe = "//lib/..."
+		g.Expect(a.Run(nil, nil)).To(Succeed())
+		g.Expect(stdout.String()).To(Equal("//lib:a_test\n"))
+	})
+
+	t.Run("the changed files are read from git and the tests run", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		root := workspace(t, "lib/BUILD.bazel", "lib/a.go", "lib/b.go")
+		for _, args := range [][]string{
+			{"init", "--quiet"},
+			{"add", "."},
+			{"-c", "user.name=aspect", "-c", "user.email=aspect@example.com", "commit", "--quiet", "-m", "init"},
+		} {
+			_, err := gitutils.Run(root, args...)
+			g.Expect(err).To(BeNil())
+		}
+		g.Expect(ioutil.WriteFile(filepath.Join(root, "lib", "a.go"), []byte("package lib"), 0644)).To(Succeed())
+		g.Expect(ioutil.WriteFile(filepath.Join(root, "lib", "c.go"), nil, 0644)).To(Succeed())
+
+		spawner := bazel_mock.NewMockBazel(ctrl)
+		gomock.InOrder(
+			spawner.
+				EXPECT().
+				Run([]string{"query", "--output=label", "set(//lib:*)"}, gomock.Any()).
+				DoAndReturn(respond("//lib:a.go\n//lib:b.go\n//lib:c.go\n", 0)),
+			spawner.
+				EXPECT().
+				Run([]string{"query", "--output=label", "tests(rdeps(//..., set(//lib:a.go //lib:c.go)))"}, gomock.Any()).
+				DoAndReturn(respond("//lib:a_test\n", 0)),
+		)
+
+		var ranTests []string
+		a := affected.New(ioutils.Streams{Stdout: ioutil.Discard, Stderr: ioutil.Discard}, spawner, root)
+		a.RunTests = func(cmd *cobra.Command, args []string) error {
+			ranTests = args
+			return nil
+		}
+		g.Expect(a.Run(nil, []string{"--config=ci"})).To(Succeed())
+		g.Expect(ranTests).To(Equal([]string{"--config=ci", "//lib:a_test"}))
+	})
+
+	t.Run("the changes of the base since the branch forked off it aren't changed files", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		root := workspace(t, "lib/BUILD.bazel", "lib/a.go", "lib/b.go")
+		commit := []string{"-c", "user.name=aspect", "-c", "user.email=aspect@example.com", "commit", "--quiet", "--all", "-m", "change"}
+		git := func(args ...string) {
+			_, err := gitutils.Run(root, args...)
+			g.Expect(err).To(BeNil())
+		}
+		git("init", "--quiet")
+		git("add", ".")
+		git(commit...)
+		git("branch", "base")
+		git("checkout", "--quiet", "-b", "feature")
+		g.Expect(ioutil.WriteFile(filepath.Join(root, "lib", "a.go"), []byte("package lib"), 0644)).To(Succeed())
+		git(commit...)
+		git("checkout", "--quiet", "base")
+		g.Expect(ioutil.WriteFile(filepath.Join(root, "lib", "b.go"), []byte("package lib"), 0644)).To(Succeed())
+		git(commit...)
+		git("checkout", "--quiet", "feature")
+
+		spawner := bazel_mock.NewMockBazel(ctrl)
+		gomock.InOrder(
+			spawner.
+				EXPECT().
+				Run([]string{"query", "--output=label", "set(//lib:*)"}, gomock.Any()).
+				DoAndReturn(respond("//lib:a.go\n//lib:b.go\n", 0)),
+			spawner.
+				EXPECT().
+				Run([]string{"query", "--output=label", "tests(rdeps(//..., set(//lib:a.go)))"}, gomock.Any()).
+				DoAndReturn(respond("//lib:a_test\n", 0)),
+		)
+
+		var stdout strings.Builder
+		a := affected.New(ioutils.Streams{Stdout: &stdout, Stderr: ioutil.Discard}, spawner, root)
+		a.Base = "base"
+		g.Expect(a.Run(nil, nil)).To(Succeed())
+		g.Expect(stdout.String()).To(Equal("//lib:a_test\n"))
+	})
+
+	t.Run("a query error is returned", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		root := workspace(t, "lib/BUILD.bazel", "lib/a.go")
+		spawner := bazel_mock.NewMockBazel(ctrl)
+		spawner.
+			EXPECT().
+			Run(gomock.Any(), gomock.Any()).
+			Return(7, nil)
+
+		a := affected.New(ioutils.Streams{Stdin: strings.NewReader("lib/a.go\n"), Stdout: ioutil.Discard, Stderr: ioutil.Discard}, spawner, root)
+		a.Stdin = true
+		g.Expect(a.Run(nil, nil)).To(Equal(&aspecterrors.ExitError{ExitCode: 7}))
+	})
+	t.Run("a partial result fails with the errors of Bazel", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		root := workspace(t, "lib/BUILD.bazel")
+		spawner := bazel_mock.NewMockBazel(ctrl)
+		spawner.
+			EXPECT().
+			Run(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(command []string, options bazel.RunOptions) (int, error) {
+				io.WriteString(options.Streams.Stderr, "ERROR: error loading package 'app'\n")
+				return 3, nil
+			})
+
+		var stdout, stderr strings.Builder
+		a := affected.New(ioutils.Streams{Stdin: strings.NewReader("lib/BUILD.bazel\n"), Stdout: &stdout, Stderr: &stderr}, spawner, root)
+		a.Stdin = true
+		g.Expect(a.Run(nil, nil)).To(Equal(&aspecterrors.ExitError{ExitCode: 3}))
+		g.Expect(stdout.String()).To(BeEmpty())
+		g.Expect(stderr.String()).To(ContainSubstring("ERROR: error loading package 'app'"))
+	})
+}
@@ -0,0 +1,263 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package actiongraph
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+)
+
+// ActionGraph is the action graph output by bazel aquery --output=jsonproto.
+type ActionGraph struct {
+	Actions []*Action
+
+	// producers are the actions by the paths of their outputs.
+	producers map[string]*Action
+}
+
+// Action is an action of the action graph.
+type Action struct {
+	Target string
+	// RuleClass is the kind of the rule of Target, e.g. go_library.
+	RuleClass string
+	Mnemonic  string
+	// Configuration is the mnemonic of the configuration of the action, e.g.
+	// k8-fastbuild.
+	Configuration string
+	// ActionKey is the hash of what Bazel considers to identify the action,
+	// e.g. its arguments and environment.
+	ActionKey   string
+	Arguments   []string
+	Environment map[string]string
+	// Inputs are the exec paths of the inputs of the action, with the depsets
+	// expanded, in the order Bazel lists them.
+	Inputs []string
+	// Outputs are the exec paths of the outputs of the action, the primary
+	// output first.
+	Outputs []string
+}
+
+// actionGraphContainer mirrors the ActionGraphContainer message of Bazel's
+// analysis_v2.proto in its json form.
+type actionGraphContainer struct {
+	Artifacts []struct {
+		ID             uint32 `json:"id"`
+		PathFragmentID uint32 `json:"pathFragmentId"`
+		// ExecPath is set instead of PathFragmentID by Bazel before 5.0.
+		ExecPath string `json:"execPath"`
+	} `json:"artifacts"`
+	Actions []struct {
+		TargetID             uint32   `json:"targetId"`
+		ActionKey            string   `json:"actionKey"`
+		Mnemonic             string   `json:"mnemonic"`
+		ConfigurationID      uint32   `json:"configurationId"`
+		Arguments            []string `json:"arguments"`
+		EnvironmentVariables []struct {
+			Key   string `json:"key"`
+			Value string `json:"value"`
+		} `json:"environmentVariables"`
+		InputDepSetIDs  []uint32 `json:"inputDepSetIds"`
+		OutputIDs       []uint32 `json:"outputIds"`
+		PrimaryOutputID uint32   `json:"primaryOutputId"`
+	} `json:"actions"`
+	Targets []struct {
+		ID          uint32 `json:"id"`
+		Label       string `json:"label"`
+		RuleClassID uint32 `json:"ruleClassId"`
+	} `json:"targets"`
+	DepSetOfFiles []struct {
+		ID                  uint32   `json:"id"`
+		DirectArtifactIDs   []uint32 `json:"directArtifactIds"`
+		TransitiveDepSetIDs []uint32 `json:"transitiveDepSetIds"`
+	} `json:"depSetOfFiles"`
+	Configuration []struct {
+		ID       uint32 `json:"id"`
+		Mnemonic string `json:"mnemonic"`
+	} `json:"configuration"`
+	RuleClasses []struct {
+		ID   uint32 `json:"id"`
+		Name string `json:"name"`
+	} `json:"ruleClasses"`
+	PathFragments []struct {
+		ID       uint32 `json:"id"`
+		Label    string `json:"label"`
+		ParentID uint32 `json:"parentId"`
+	} `json:"pathFragments"`
+}
+
+// Parse parses the output of bazel aquery --output=jsonproto.
+func Parse(data []byte) (*ActionGraph, error) {
+	var container actionGraphContainer
+	if err := json.Unmarshal(data, &container); err != nil {
+		return nil, fmt.Errorf("failed to parse the action graph: %w", err)
+	}
+
+	type fragment struct {
+		label  string
+		parent uint32
+	}
+	fragments := make(map[uint32]fragment, len(container.PathFragments))
+	for _, f := range container.PathFragments {
+		fragments[f.ID] = fragment{label: f.Label, parent: f.ParentID}
+	}
+	artifacts := make(map[uint32]string, len(container.Artifacts))
+	for _, a := range container.Artifacts {
+		if a.ExecPath != "" {
+			artifacts[a.ID] = a.ExecPath
+			continue
+		}
+		var segments []string
+		for id := a.PathFragmentID; id != 0; id = fragments[id].parent {
+			segments = append([]string{fragments[id].label}, segments...)
+		}
+		artifacts[a.ID] = path.Join(segments...)
+	}
+	ruleClasses := make(map[uint32]string, len(container.RuleClasses))
+	for _, r := range container.RuleClasses {
+		ruleClasses[r.ID] = r.Name
+	}
+	type target struct{ label, ruleClass string }
+	targets := make(map[uint32]target, len(container.Targets))
+	for _, t := range container.Targets {
+		targets[t.ID] = target{label: t.Label, ruleClass: ruleClasses[t.RuleClassID]}
+	}
+	configurations := make(map[uint32]string, len(container.Configuration))
+	for _, c := range container.Configuration {
+		configurations[c.ID] = c.Mnemonic
+	}
+	type depSet struct{ direct, transitive []uint32 }
+	depSets := make(map[uint32]depSet, len(container.DepSetOfFiles))
+	for _, d := range container.DepSetOfFiles {
+		depSets[d.ID] = depSet{direct: d.DirectArtifactIDs, transitive: d.TransitiveDepSetIDs}
+	}
+
+	g := &ActionGraph{producers: make(map[string]*Action)}
+	for _, a := range container.Actions {
+		action := &Action{
+			Target:        targets[a.TargetID].label,
+			RuleClass:     targets[a.TargetID].ruleClass,
+			Mnemonic:      a.Mnemonic,
+			Configuration: configurations[a.ConfigurationID],
+			ActionKey:     a.ActionKey,
+			Arguments:     a.Arguments,
+			Environment:   make(map[string]string, len(a.EnvironmentVariables)),
+		}
+		for _, env := range a.EnvironmentVariables {
+			action.Environment[env.Key] = env.Value
+		}
+
+		// The depsets are shared between the actions, so each one is only
+		// expanded once per action.
+		seenDepSets := make(map[uint32]bool)
+		seenInputs := make(map[string]bool)
+		var expand func(id uint32)
+		expand = func(id uint32) {
+			if seenDepSets[id] {
+				return
+			}
+			seenDepSets[id] = true
+			for _, artifact := range depSets[id].direct {
+				if input := artifacts[artifact]; !seenInputs[input] {
+					seenInputs[input] = true
+					action.Inputs = append(action.Inputs, input)
+				}
+			}
+			for _, transitive := range depSets[id].transitive {
+				expand(transitive)
+			}
+		}
+		for _, id := range a.InputDepSetIDs {
+			expand(id)
+		}
+
+		if a.PrimaryOutputID != 0 {
+			action.Outputs = append(action.Outputs, artifacts[a.PrimaryOutputID])
+		}
+		for _, id := range a.OutputIDs {
+			if id != a.PrimaryOutputID {
+				action.Outputs = append(action.Outputs, artifacts[id])
+			}
+		}
+		for _, output := range action.Outputs {
+			g.producers[output] = action
+		}
+		g.Actions = append(g.Actions, action)
+	}
+	return g, nil
+}
+
+// Producer returns the action that generates the file at the given exec
+// path, or nil for a source file.
+func (g *ActionGraph) Producer(execPath string) *Action {
+	return g.producers[execPath]
+}
+
+// ActionsOf returns the actions registered by the given target.
+func (g *ActionGraph) ActionsOf(target string) []*Action {
+	var actions []*Action
+	for _, action := range g.Actions {
+		if action.Target == target {
+			actions = append(actions, action)
+		}
+	}
+	return actions
+}
+
+// String describes the action in a line.
+func (a *Action) String() string {
+	output := ""
+	if len(a.Outputs) > 0 {
+		output = " → " + a.Outputs[0]
+	}
+	return fmt.Sprintf("%s %s (%s)%s", a.Mnemonic, a.Target, a.Configuration, output)
+}
+
+// Print prints the action graph, with the mnemonic, command line, inputs and
+// outputs of each action.
+func (g *ActionGraph) Print(out io.Writer) {
+	for i, action := range g.Actions {
+		if i > 0 {
+			fmt.Fprintln(out)
+		}
+		fmt.Fprintf(out, "%s %s (%s)\n", action.Mnemonic, action.Target, action.Configuration)
+		if action.RuleClass != "" {
+			fmt.Fprintf(out, "  Rule:        %s\n", action.RuleClass)
+		}
+		fmt.Fprintf(out, "  Command:     %s\n", strings.Join(action.Arguments, " "))
+		if len(action.Environment) > 0 {
+			fmt.Fprintln(out, "  Environment:")
+			for _, key := range sortedKeys(action.Environment) {
+				fmt.Fprintf(out, "    %s=%s\n", key, action.Environment[key])
+			}
+		}
+		fmt.Fprintf(out, "  Inputs:      %d file(s)\n", len(action.Inputs))
+		for _, input := range action.Inputs {
+			if producer := g.Producer(input); producer != nil {
+				fmt.Fprintf(out, "    %s (%s)\n", input, producer.Mnemonic)
+			} else {
+				fmt.Fprintf(out, "    %s\n", input)
+			}
+		}
+		fmt.Fprintln(out, "  Outputs:")
+		for _, output := range action.Outputs {
+			fmt.Fprintf(out, "    %s\n", output)
+		}
+	}
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,143 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package actiongraph_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"aspect.build/cli/pkg/aspect/aquery/actiongraph"
+)
+
+// actionGraphJSON returns the action graph of a go_binary //app:bin linking
+// the go_library //lib:a, as output by bazel aquery --output=jsonproto.
+func actionGraphJSON(configuration string, compileArg string, env string) []byte {
+	return []byte(fmt.Sprintf(`{
+  "artifacts": [
+    {"id": 1, "pathFragmentId": 3},
+    {"id": 2, "pathFragmentId": 8},
+    {"id": 3, "pathFragmentId": 10}
+  ],
+  "actions": [{
+    "targetId": 1,
+    "actionKey": "compile-%[1]s-%[2]s-%[3]s",
+    "mnemonic": "GoCompile",
+    "configurationId": 1,
+    "arguments": ["compile", "%[2]s", "-o", "bazel-out/%[1]s/bin/lib/a.a", "lib/a.go"],
+    "environmentVariables": [{"key": "GOOS", "value": "%[3]s"}],
+    "inputDepSetIds": [1],
+    "outputIds": [2],
+    "primaryOutputId": 2
+  }, {
+    "targetId": 2,
+    "actionKey": "link-%[1]s",
+    "mnemonic": "GoLink",
+    "configurationId": 1,
+    "arguments": ["link", "-o", "bazel-out/%[1]s/bin/app/bin", "bazel-out/%[1]s/bin/lib/a.a"],
+    "inputDepSetIds": [2],
+    "outputIds": [3],
+    "primaryOutputId": 3
+  }],
+  "targets": [
+    {"id": 1, "label": "//lib:a", "ruleClassId": 1},
+    {"id": 2, "label": "//app:bin", "ruleClassId": 2}
+  ],
+  "depSetOfFiles": [
+    {"id": 1, "directArtifactIds": [1]},
+    {"id": 2, "directArtifactIds": [2], "transitiveDepSetIds": [1]}
+  ],
+  "configuration": [{"id": 1, "mnemonic": "%[1]s"}],
+  "ruleClasses": [
+    {"id": 1, "name": "go_library"},
+    {"id": 2, "name": "go_binary"}
+  ],
+  "pathFragments": [
+    {"id": 1, "label": "lib"},
+    {"id": 3, "label": "a.go", "parentId": 1},
+    {"id": 4, "label": "bazel-out"},
+    {"id": 5, "label": "%[1]s", "parentId": 4},
+    {"id": 6, "label": "bin", "parentId": 5},
+    {"id": 7, "label": "lib", "parentId": 6},
+    {"id": 8, "label": "a.a", "parentId": 7},
+    {"id": 9, "label": "app", "parentId": 6},
+    {"id": 10, "label": "bin", "parentId": 9}
+  ]
+}`, configuration, compileArg, env))
+}
+
+func TestParse(t *testing.T) {
+	t.Run("the artifacts, depsets and targets are resolved", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		graph, err := actiongraph.Parse(actionGraphJSON("k8-fastbuild", "-p", "linux"))
+		g.Expect(err).To(BeNil())
+		g.Expect(graph.Actions).To(HaveLen(2))
+
+		link := graph.Actions[1]
+		g.Expect(link.Target).To(Equal("//app:bin"))
+		g.Expect(link.RuleClass).To(Equal("go_binary"))
+		g.Expect(link.Configuration).To(Equal("k8-fastbuild"))
+		g.Expect(link.Inputs).To(Equal([]string{"bazel-out/k8-fastbuild/bin/lib/a.a", "lib/a.go"}))
+		g.Expect(link.Outputs).To(Equal([]string{"bazel-out/k8-fastbuild/bin/app/bin"}))
+
+		g.Expect(graph.Producer("bazel-out/k8-fastbuild/bin/lib/a.a")).To(Equal(graph.Actions[0]))
+		g.Expect(graph.Producer("lib/a.go")).To(BeNil())
+		g.Expect(graph.ActionsOf("//lib:a")).To(Equal([]*actiongraph.Action{graph.Actions[0]}))
+	})
+
+	t.Run("the exec paths of Bazel before 5.0 are supported", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		graph, err := actiongraph.Parse([]byte(`{
+  "artifacts": [{"id": 1, "execPath": "lib/a.go"}, {"id": 2, "execPath": "bazel-out/k8-fastbuild/bin/lib/a.a"}],
+  "actions": [{"targetId": 1, "mnemonic": "GoCompile", "inputDepSetIds": [1], "outputIds": [2], "primaryOutputId": 2}],
+  "targets": [{"id": 1, "label": "//lib:a"}],
+  "depSetOfFiles": [{"id": 1, "directArtifactIds": [1]}]
+}`))
+		g.Expect(err).To(BeNil())
+		g.Expect(graph.Actions[0].Inputs).To(Equal([]string{"lib/a.go"}))
+		g.Expect(graph.Actions[0].Outputs).To(Equal([]string{"bazel-out/k8-fastbuild/bin/lib/a.a"}))
+	})
+
+	t.Run("an invalid action graph fails", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		_, err := actiongraph.Parse([]byte("action graph"))
+		g.Expect(err).To(MatchError(ContainSubstring("failed to parse the action graph")))
+	})
+}
+
+func TestPrint(t *testing.T) {
+	g := NewGomegaWithT(t)
+	var out strings.Builder
+
+	graph, err := actiongraph.Parse(actionGraphJSON("k8-fastbuild", "-p", "linux"))
+	g.Expect(err).To(BeNil())
+	graph.Print(&out)
+	g.Expect(out.String()).To(Equal(`GoCompile //lib:a (k8-fastbuild)
+  Rule:        go_library
+  Command:     compile -p -o bazel-out/k8-fastbuild/bin/lib/a.a lib/a.go
+  Environment:
+    GOOS=linux
+  Inputs:      1 file(s)
+    lib/a.go
+  Outputs:
+    bazel-out/k8-fastbuild/bin/lib/a.a
+
+GoLink //app:bin (k8-fastbuild)
+  Rule:        go_binary
+  Command:     link -o bazel-out/k8-fastbuild/bin/app/bin bazel-out/k8-fastbuild/bin/lib/a.a
+  Inputs:      2 file(s)
+    bazel-out/k8-fastbuild/bin/lib/a.a (GoCompile)
+    lib/a.go
+  Outputs:
+    bazel-out/k8-fastbuild/bin/app/bin
+`))
+}
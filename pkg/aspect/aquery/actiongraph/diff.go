@@ -0,0 +1,271 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package actiongraph
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// maxReasons is the number of reasons printed for each action that differs.
+const maxReasons = 10
+
+// configurationRegex matches the configuration segment of an output path,
+// e.g. bazel-out/k8-fastbuild/, which differs between configurations even
+// when the actions are the same.
+var configurationRegex = regexp.MustCompile(`bazel-out/[^/]+/`)
+
+// Digests returns the digest of the content of a source file from its exec
+// path, or "" when it can't be read.
+type Digests func(execPath string) string
+
+// FileDigests returns the Digests of the source files of the workspace at
+// root. The generated files and the files of the external repositories
+// aren't under root, so they have no digest.
+func FileDigests(root string) Digests {
+	digests := make(map[string]string)
+	return func(execPath string) string {
+		if strings.HasPrefix(execPath, "bazel-out/") || strings.HasPrefix(execPath, "external/") {
+			return ""
+		}
+		if digest, ok := digests[execPath]; ok {
+			return digest
+		}
+		digest := ""
+		if data, err := ioutil.ReadFile(filepath.Join(root, execPath)); err == nil {
+			sum := sha256.Sum256(data)
+			digest = hex.EncodeToString(sum[:])
+		}
+		digests[execPath] = digest
+		return digest
+	}
+}
+
+// ActionDiff is an action that differs between two action graphs, which
+// makes it miss the cache.
+type ActionDiff struct {
+	Before *Action
+	After  *Action
+	// Reasons are the differences of the action, e.g. an argument, an
+	// environment variable or an input.
+	Reasons []string
+}
+
+// GraphDiff is the difference between two action graphs.
+type GraphDiff struct {
+	// Changed are the actions that differ, in the order of the second graph.
+	// They include the actions that are the same but read a generated file
+	// whose action differs.
+	Changed    []ActionDiff
+	OnlyBefore []*Action
+	OnlyAfter  []*Action
+	// Total is the number of actions of the second graph.
+	Total int
+}
+
+// Diff compares two action graphs. The actions are matched by mnemonic,
+// target and primary output, regardless of their configuration. The digests
+// compare the content of the source inputs, and are nil when the sources are
+// the same, e.g. when comparing two sets of flags.
+func Diff(before *ActionGraph, after *ActionGraph, beforeDigests Digests, afterDigests Digests) *GraphDiff {
+	beforeActions := make(map[string]*Action, len(before.Actions))
+	for _, action := range before.Actions {
+		if key := matchKey(action); beforeActions[key] == nil {
+			beforeActions[key] = action
+		}
+	}
+
+	d := &GraphDiff{Total: len(after.Actions)}
+	matched := make(map[string]bool)
+	differing := make(map[*Action][]string)
+	var pairs [][2]*Action
+	for _, action := range after.Actions {
+		key := matchKey(action)
+		previous := beforeActions[key]
+		if previous == nil {
+			d.OnlyAfter = append(d.OnlyAfter, action)
+			differing[action] = nil
+			continue
+		}
+		matched[key] = true
+		pairs = append(pairs, [2]*Action{previous, action})
+		if reasons := compare(previous, action, beforeDigests, afterDigests); len(reasons) > 0 {
+			differing[action] = reasons
+		}
+	}
+	for _, action := range before.Actions {
+		if !matched[matchKey(action)] {
+			d.OnlyBefore = append(d.OnlyBefore, action)
+		}
+	}
+
+	// An action that reads a generated file whose action differs misses the
+	// cache too, even though it is the same.
+	for changed := true; changed; {
+		changed = false
+		for _, pair := range pairs {
+			action := pair[1]
+			if _, ok := differing[action]; ok {
+				continue
+			}
+			for _, input := range action.Inputs {
+				producer := after.Producer(input)
+				if _, ok := differing[producer]; producer != nil && ok {
+					differing[action] = []string{fmt.Sprintf("input %s: generated by an action that differs (%s)", input, producer.Mnemonic)}
+					changed = true
+					break
+				}
+			}
+		}
+	}
+
+	for _, pair := range pairs {
+		if reasons, ok := differing[pair[1]]; ok {
+			d.Changed = append(d.Changed, ActionDiff{Before: pair[0], After: pair[1], Reasons: reasons})
+		}
+	}
+	return d
+}
+
+// matchKey identifies an action across two action graphs.
+func matchKey(action *Action) string {
+	output := ""
+	if len(action.Outputs) > 0 {
+		output = normalize(action.Outputs[0])
+	}
+	return fmt.Sprintf("%s %s %s", action.Mnemonic, action.Target, output)
+}
+
+func normalize(s string) string {
+	return configurationRegex.ReplaceAllString(s, "bazel-out/*/")
+}
+
+// compare returns the differences between two versions of an action.
+func compare(before *Action, after *Action, beforeDigests Digests, afterDigests Digests) []string {
+	var reasons []string
+	if before.Configuration != after.Configuration {
+		reasons = append(reasons, fmt.Sprintf("configuration: %s → %s", before.Configuration, after.Configuration))
+	}
+	reasons = append(reasons, compareArguments(before.Arguments, after.Arguments)...)
+
+	for _, key := range sortedKeys(after.Environment) {
+		value := after.Environment[key]
+		if previous, ok := before.Environment[key]; !ok {
+			reasons = append(reasons, fmt.Sprintf("+ env %s=%s", key, value))
+		} else if normalize(previous) != normalize(value) {
+			reasons = append(reasons, fmt.Sprintf("env %s: %s → %s", key, previous, value))
+		}
+	}
+	for _, key := range sortedKeys(before.Environment) {
+		if _, ok := after.Environment[key]; !ok {
+			reasons = append(reasons, fmt.Sprintf("- env %s", key))
+		}
+	}
+
+	beforeInputs := make(map[string]bool, len(before.Inputs))
+	for _, input := range before.Inputs {
+		beforeInputs[normalize(input)] = true
+	}
+	afterInputs := make(map[string]bool, len(after.Inputs))
+	for _, input := range after.Inputs {
+		afterInputs[normalize(input)] = true
+		if !beforeInputs[normalize(input)] {
+			reasons = append(reasons, fmt.Sprintf("+ input %s", input))
+		}
+	}
+	for _, input := range before.Inputs {
+		if !afterInputs[normalize(input)] {
+			reasons = append(reasons, fmt.Sprintf("- input %s", input))
+		}
+	}
+
+	if beforeDigests != nil && afterDigests != nil {
+		for _, input := range after.Inputs {
+			if !beforeInputs[input] {
+				continue
+			}
+			if beforeDigests(input) != afterDigests(input) {
+				reasons = append(reasons, fmt.Sprintf("input %s: content differs", input))
+			}
+		}
+	}
+	return reasons
+}
+
+// compareArguments returns the differences between two command lines: the
+// arguments that changed when they have the same length, or the ones that
+// were added and removed otherwise.
+func compareArguments(before []string, after []string) []string {
+	var reasons []string
+	if len(before) == len(after) {
+		for i := range after {
+			if normalize(before[i]) != normalize(after[i]) {
+				reasons = append(reasons, fmt.Sprintf("argument %d: %s → %s", i, before[i], after[i]))
+			}
+		}
+		return reasons
+	}
+
+	count := make(map[string]int, len(before))
+	for _, arg := range before {
+		count[normalize(arg)]++
+	}
+	for _, arg := range after {
+		if count[normalize(arg)] > 0 {
+			count[normalize(arg)]--
+		} else {
+			reasons = append(reasons, fmt.Sprintf("+ argument %s", arg))
+		}
+	}
+	count = make(map[string]int, len(after))
+	for _, arg := range after {
+		count[normalize(arg)]++
+	}
+	for _, arg := range before {
+		if count[normalize(arg)] > 0 {
+			count[normalize(arg)]--
+		} else {
+			reasons = append(reasons, fmt.Sprintf("- argument %s", arg))
+		}
+	}
+	return reasons
+}
+
+// Print prints the actions that differ with the reasons why, and the ones
+// that only one of the graphs has.
+func (d *GraphDiff) Print(out io.Writer, beforeName string, afterName string) {
+	fmt.Fprintf(out, "%d of %d action(s) differ between %s and %s, %d only in %s, %d only in %s\n",
+		len(d.Changed), d.Total, beforeName, afterName, len(d.OnlyBefore), beforeName, len(d.OnlyAfter), afterName)
+	for _, changed := range d.Changed {
+		fmt.Fprintf(out, "\n%s\n", changed.After)
+		for i, reason := range changed.Reasons {
+			if i == maxReasons {
+				fmt.Fprintf(out, "  ... and %d more\n", len(changed.Reasons)-maxReasons)
+				break
+			}
+			fmt.Fprintf(out, "  %s\n", reason)
+		}
+	}
+	if len(d.OnlyBefore) > 0 {
+		fmt.Fprintf(out, "\nOnly in %s:\n", beforeName)
+		for _, action := range d.OnlyBefore {
+			fmt.Fprintf(out, "  %s\n", action)
+		}
+	}
+	if len(d.OnlyAfter) > 0 {
+		fmt.Fprintf(out, "\nOnly in %s:\n", afterName)
+		for _, action := range d.OnlyAfter {
+			fmt.Fprintf(out, "  %s\n", action)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package actiongraph_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"aspect.build/cli/pkg/aspect/aquery/actiongraph"
+)
+
+func TestDiff(t *testing.T) {
+	parse := func(t *testing.T, configuration string, compileArg string, env string) *actiongraph.ActionGraph {
+		graph, err := actiongraph.Parse(actionGraphJSON(configuration, compileArg, env))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return graph
+	}
+
+	t.Run("the same action graphs don't differ", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		diff := actiongraph.Diff(parse(t, "k8-fastbuild", "-p", "linux"), parse(t, "k8-fastbuild", "-p", "linux"), nil, nil)
+		g.Expect(diff.Changed).To(BeEmpty())
+		g.Expect(diff.OnlyBefore).To(BeEmpty())
+		g.Expect(diff.OnlyAfter).To(BeEmpty())
+	})
+
+	t.Run("an argument and an environment variable that differ are explained", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		var out strings.Builder
+
+		diff := actiongraph.Diff(parse(t, "k8-fastbuild", "-p", "linux"), parse(t, "k8-fastbuild", "-race", "darwin"), nil, nil)
+		diff.Print(&out, "before", "after")
+		g.Expect(out.String()).To(Equal(`2 of 2 action(s) differ between before and after, 0 only in before, 0 only in after
+
+GoCompile //lib:a (k8-fastbuild) → bazel-out/k8-fastbuild/bin/lib/a.a
+  argument 1: -p → -race
+  env GOOS: linux → darwin
+
+GoLink //app:bin (k8-fastbuild) → bazel-out/k8-fastbuild/bin/app/bin
+  input bazel-out/k8-fastbuild/bin/lib/a.a: generated by an action that differs (GoCompile)
+`))
+	})
+
+	t.Run("the actions are matched across configurations", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		diff := actiongraph.Diff(parse(t, "k8-fastbuild", "-p", "linux"), parse(t, "k8-opt", "-p", "linux"), nil, nil)
+		g.Expect(diff.OnlyBefore).To(BeEmpty())
+		g.Expect(diff.OnlyAfter).To(BeEmpty())
+		g.Expect(diff.Changed).To(HaveLen(2))
+		g.Expect(diff.Changed[0].Reasons).To(Equal([]string{"configuration: k8-fastbuild → k8-opt"}))
+	})
+
+	t.Run("a source input whose content differs is explained", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "diff")
+		g.Expect(err).To(BeNil())
+		defer os.RemoveAll(dir)
+		for root, content := range map[string]string{"before": "package lib", "after": "package lib // changed"} {
+			g.Expect(os.MkdirAll(filepath.Join(dir, root, "lib"), 0755)).To(Succeed())
+			g.Expect(ioutil.WriteFile(filepath.Join(dir, root, "lib", "a.go"), []byte(content), 0644)).To(Succeed())
+		}
+
+		diff := actiongraph.Diff(
+			parse(t, "k8-fastbuild", "-p", "linux"),
+			parse(t, "k8-fastbuild", "-p", "linux"),
+			actiongraph.FileDigests(filepath.Join(dir, "before")),
+			actiongraph.FileDigests(filepath.Join(dir, "after")),
+		)
+		g.Expect(diff.Changed).To(HaveLen(2))
+		g.Expect(diff.Changed[0].Reasons).To(Equal([]string{"input lib/a.go: content differs"}))
+		g.Expect(diff.Changed[1].Reasons).To(Equal([]string{"input lib/a.go: content differs"}))
+	})
+
+	t.Run("the actions that only one graph has are listed", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		before := parse(t, "k8-fastbuild", "-p", "linux")
+		after := parse(t, "k8-fastbuild", "-p", "linux")
+		after.Actions = after.Actions[:1]
+		diff := actiongraph.Diff(before, after, nil, nil)
+		g.Expect(diff.Changed).To(BeEmpty())
+		g.Expect(diff.OnlyBefore).To(Equal([]*actiongraph.Action{before.Actions[1]}))
+	})
+}
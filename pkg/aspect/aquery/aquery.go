@@ -7,10 +7,14 @@ Not licensed for re-use.
 package aquery
 
 import (
+	"fmt"
+	"strings"
+
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 
+	"aspect.build/cli/pkg/aspect/aquery/actiongraph"
 	"aspect.build/cli/pkg/aspect/query/shared"
+	"aspect.build/cli/pkg/aspect/root/config"
 	"aspect.build/cli/pkg/bazel"
 	"aspect.build/cli/pkg/ioutils"
 )
@@ -22,17 +26,38 @@ type AQuery struct {
 	IsInteractive bool
 
 	Presets []*shared.PresetQuery
-	Prefs   viper.Viper
+	Config  *config.Config
+	// History remembers the recent queries and placeholder values of the
+	// workspace.
+	History *shared.History
+	// Save is the name of a preset to save the query as.
+	Save string
+	// Set are the values of the placeholders given by name.
+	Set map[string]string
+	// Output is how the result of the query is output. Without an output
+	// format, Output.Diff compares the action graphs of the query.
+	Output shared.Output
+	// Inspect prints the actions of the result with their command lines,
+	// inputs and outputs.
+	Inspect bool
+	// DiffFlags are Bazel flags whose action graph is compared with the one
+	// of the query without them.
+	DiffFlags []string
+	// WorkspaceRoot is where the query runs, which Output.Diff checks out
+	// again at a git revision.
+	WorkspaceRoot string
+
+	// PromptLabel prompts for the values of label placeholders, or Prompt
+	// does when it is nil.
+	PromptLabel func(label string) shared.PromptRunner
 
 	Prompt       func(label string) shared.PromptRunner
 	Confirmation func(question string) shared.ConfirmationRunner
 	Select       func(presetNames []string) shared.SelectRunner
 }
 
-func New(streams ioutils.Streams, bzl bazel.Bazel, isInteractive bool) *AQuery {
-	v := *viper.GetViper()
-
-	presets := shared.PrecannedQueries("aquery", v)
+func New(streams ioutils.Streams, cfg *config.Config, bzl bazel.Bazel, isInteractive bool) *AQuery {
+	presets := shared.PrecannedQueries("aquery", cfg.Values().Query.Presets)
 
 	return &AQuery{
 		Streams:       streams,
@@ -42,7 +67,7 @@ func New(streams ioutils.Streams, bzl bazel.Bazel, isInteractive bool) *AQuery {
 		Prompt:        shared.Prompt,
 		Select:        shared.Select,
 		Confirmation:  shared.Confirmation,
-		Prefs:         v,
+		Config:        cfg,
 	}
 }
 
@@ -52,19 +77,96 @@ func (q *AQuery) Run(cmd *cobra.Command, args []string) error {
 		return shared.GetPrettyError(cmd, err)
 	}
 
-	presetVerb, query, runReplacements, err := shared.SelectQuery(cmd.Use, presets, q.Presets, presetNames, q.Streams, args, q.Select)
+	presetVerb, query, runReplacements, err := shared.SelectQuery(cmd.Use, presets, q.Presets, presetNames, q.History.RecentQueries(cmd.Use), q.Streams, args, q.Select)
 
 	if err != nil {
 		return shared.GetPrettyError(cmd, err)
 	}
 
+	if q.Save != "" {
+		if err := shared.SavePreset(q.Config, q.Save, presetVerb, query); err != nil {
+			return shared.GetPrettyError(cmd, err)
+		}
+		fmt.Fprintf(q.Stdout, "Saved preset %q\n", q.Save)
+	}
+
 	if runReplacements {
-		query, err = shared.ReplacePlaceholders(query, args, q.Prompt)
+		query, err = shared.ReplacePlaceholders(query, shared.PlaceholderValues{
+			Set:         q.Set,
+			Args:        args,
+			Prompt:      q.Prompt,
+			PromptLabel: q.PromptLabel,
+			History:     q.History,
+			Stderr:      q.Stderr,
+		})
 
 		if err != nil {
 			return shared.GetPrettyError(cmd, err)
 		}
 	}
 
-	return shared.RunQuery(q.Bzl, presetVerb, query)
+	q.History.AddQuery(presetVerb, query)
+	if err := q.History.Save(); err != nil {
+		return shared.GetPrettyError(cmd, err)
+	}
+
+	switch {
+	case len(q.DiffFlags) > 0:
+		return q.diffFlags(presetVerb, query)
+	case q.Output.Diff != "" && q.Output.Format == "":
+		return q.diffRevision(presetVerb, query)
+	case q.Output.Diff != "":
+		return shared.DiffQuery(q.Bzl, q.Streams, q.WorkspaceRoot, presetVerb, query, q.Output)
+	case q.Inspect:
+		g, err := q.actionGraph(presetVerb, query)
+		if err != nil {
+			return err
+		}
+		g.Print(q.Stdout)
+		return nil
+	}
+	return shared.RunQueryOutput(q.Bzl, q.Streams, presetVerb, query, q.Output)
+}
+
+// actionGraph runs the query with the given flags and parses its action graph.
+func (q *AQuery) actionGraph(verb string, query string, flags ...string) (*actiongraph.ActionGraph, error) {
+	result, err := shared.CaptureQuery(q.Bzl, q.Output.Cache, verb, query, "jsonproto", flags...)
+	if err != nil {
+		return nil, err
+	}
+	return actiongraph.Parse(result)
+}
+
+// diffFlags prints the actions of the query that differ when DiffFlags are
+// set, which are the ones that miss the cache when building with them.
+func (q *AQuery) diffFlags(verb string, query string) error {
+	before, err := q.actionGraph(verb, query)
+	if err != nil {
+		return err
+	}
+	after, err := q.actionGraph(verb, query, q.DiffFlags...)
+	if err != nil {
+		return err
+	}
+	actiongraph.Diff(before, after, nil, nil).Print(q.Stdout, "the default flags", strings.Join(q.DiffFlags, " "))
+	return nil
+}
+
+// diffRevision prints the actions of the query that differ between the git
+// revision Output.Diff and the working tree, including the ones whose source
+// inputs changed.
+func (q *AQuery) diffRevision(verb string, query string) error {
+	after, err := q.actionGraph(verb, query)
+	if err != nil {
+		return err
+	}
+	return shared.QueryAtRevision(q.Bzl, q.WorkspaceRoot, q.Output.Diff, verb, query, "jsonproto", func(revRoot string, result []byte) error {
+		before, err := actiongraph.Parse(result)
+		if err != nil {
+			return err
+		}
+		diff := actiongraph.Diff(before, after, actiongraph.FileDigests(revRoot), actiongraph.FileDigests(q.WorkspaceRoot))
+		diff.Print(q.Stdout, q.Output.Diff, "the working tree")
+		return nil
+	})
 }
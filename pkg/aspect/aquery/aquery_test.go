@@ -8,6 +8,7 @@ package aquery_test
 
 import (
 	"fmt"
+	"io"
 	"strings"
 	"testing"
 
@@ -18,6 +19,7 @@ import (
 	"aspect.build/cli/pkg/aspect/aquery"
 	"aspect.build/cli/pkg/aspect/query/shared"
 	query_mock "aspect.build/cli/pkg/aspect/query/shared/mock"
+	"aspect.build/cli/pkg/aspect/root/config"
 	bazel_mock "aspect.build/cli/pkg/bazel/mock"
 	"aspect.build/cli/pkg/ioutils"
 )
@@ -36,7 +38,7 @@ func TestQuery(t *testing.T) {
 
 		var stdout strings.Builder
 		streams := ioutils.Streams{Stdout: &stdout}
-		q := aquery.New(streams, spawner, true)
+		q := aquery.New(streams, &config.Config{}, spawner, true)
 		q.Presets = []*shared.PresetQuery{
 			{
 				Name:        "why",
@@ -217,4 +219,56 @@ func TestQuery(t *testing.T) {
 		err := q.Run(cmd, []string{})
 		g.Expect(err).To(BeNil())
 	})
+
+	t.Run("the actions that differ with --diff-flags are explained", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		actionGraph := func(configuration string) string {
+			return fmt.Sprintf(`{
+  "artifacts": [{"id": 1, "execPath": "bazel-out/%[1]s/bin/lib/a.a"}],
+  "actions": [{"targetId": 1, "mnemonic": "GoCompile", "configurationId": 1, "arguments": ["compile", "-o", "bazel-out/%[1]s/bin/lib/a.a"], "outputIds": [1], "primaryOutputId": 1}],
+  "targets": [{"id": 1, "label": "//lib:a"}],
+  "configuration": [{"id": 1, "mnemonic": "%[1]s"}]
+}`, configuration)
+		}
+		spawner := bazel_mock.NewMockBazel(ctrl)
+		gomock.InOrder(
+			spawner.
+				EXPECT().
+				RunCommand([]string{"aquery", "--output=jsonproto", "deps(//lib:a)"}, gomock.Any()).
+				DoAndReturn(func(_ []string, out io.Writer) (int, error) {
+					_, err := io.WriteString(out, actionGraph("k8-fastbuild"))
+					return 0, err
+				}),
+			spawner.
+				EXPECT().
+				RunCommand([]string{"aquery", "--output=jsonproto", "--compilation_mode=opt", "deps(//lib:a)"}, gomock.Any()).
+				DoAndReturn(func(_ []string, out io.Writer) (int, error) {
+					_, err := io.WriteString(out, actionGraph("k8-opt"))
+					return 0, err
+				}),
+		)
+
+		var stdout strings.Builder
+		q := aquery.New(ioutils.Streams{Stdout: &stdout}, &config.Config{}, spawner, true)
+		q.Presets = []*shared.PresetQuery{
+			{
+				Name:        "deps",
+				Description: "The dependencies of a target",
+				Query:       "deps(?target)",
+				Verb:        "aquery",
+			},
+		}
+		q.DiffFlags = []string{"--compilation_mode=opt"}
+
+		cmd := &cobra.Command{Use: "aquery"}
+		g.Expect(q.Run(cmd, []string{"deps", "//lib:a"})).To(Succeed())
+		g.Expect(stdout.String()).To(HaveSuffix(`1 of 1 action(s) differ between the default flags and --compilation_mode=opt, 0 only in the default flags, 0 only in --compilation_mode=opt
+
+GoCompile //lib:a (k8-opt) → bazel-out/k8-opt/bin/lib/a.a
+  configuration: k8-fastbuild → k8-opt
+`))
+	})
 }
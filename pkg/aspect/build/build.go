@@ -19,6 +19,10 @@ import (
 type Build struct {
 	ioutils.Streams
 	bzl bazel.Bazel
+
+	// StartupOptions are added to the startup options of the build, e.g. to
+	// build in another output base.
+	StartupOptions []string
 }
 
 // New creates a Build command.
@@ -36,7 +40,10 @@ func New(
 // Event Protocol backend used by Aspect plugins to subscribe to build events.
 func (b *Build) Run(args []string, besBackend bep.BESBackend) (exitErr error) {
 	besBackendFlag := fmt.Sprintf("--bes_backend=grpc://%s", besBackend.Addr())
-	exitCode, bazelErr := b.bzl.Spawn(append([]string{"build", besBackendFlag}, args...))
+	exitCode, bazelErr := b.bzl.Run(
+		append([]string{"build", besBackendFlag}, args...),
+		bazel.RunOptions{StartupOptions: b.StartupOptions},
+	)
 
 	// Process the subscribers errors before the Bazel one.
 	subscriberErrors := besBackend.Errors()
@@ -16,6 +16,7 @@ import (
 
 	"aspect.build/cli/pkg/aspect/build"
 	"aspect.build/cli/pkg/aspecterrors"
+	"aspect.build/cli/pkg/bazel"
 	bazel_mock "aspect.build/cli/pkg/bazel/mock"
 	"aspect.build/cli/pkg/ioutils"
 	bep_mock "aspect.build/cli/pkg/plugin/system/bep/mock"
@@ -35,7 +36,7 @@ func TestBuild(t *testing.T) {
 		}
 		bzl.
 			EXPECT().
-			Spawn([]string{"build", "--bes_backend=grpc://127.0.0.1:12345", "//..."}).
+			Run([]string{"build", "--bes_backend=grpc://127.0.0.1:12345", "//..."}, bazel.RunOptions{}).
 			Return(expectErr.ExitCode, expectErr.Err)
 		besBackend := bep_mock.NewMockBESBackend(ctrl)
 		besBackend.
@@ -64,7 +65,7 @@ func TestBuild(t *testing.T) {
 		bzl := bazel_mock.NewMockBazel(ctrl)
 		bzl.
 			EXPECT().
-			Spawn([]string{"build", "--bes_backend=grpc://127.0.0.1:12345", "//..."}).
+			Run([]string{"build", "--bes_backend=grpc://127.0.0.1:12345", "//..."}, bazel.RunOptions{}).
 			Return(0, nil)
 		besBackend := bep_mock.NewMockBESBackend(ctrl)
 		besBackend.
@@ -97,7 +98,7 @@ func TestBuild(t *testing.T) {
 		bzl := bazel_mock.NewMockBazel(ctrl)
 		bzl.
 			EXPECT().
-			Spawn([]string{"build", "--bes_backend=grpc://127.0.0.1:12345", "//..."}).
+			Run([]string{"build", "--bes_backend=grpc://127.0.0.1:12345", "//..."}, bazel.RunOptions{}).
 			Return(0, nil)
 		besBackend := bep_mock.NewMockBESBackend(ctrl)
 		besBackend.
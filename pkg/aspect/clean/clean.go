@@ -8,14 +8,20 @@ package clean
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/manifoldco/promptui"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 
+	"aspect.build/cli/pkg/aspect/du"
+	"aspect.build/cli/pkg/aspect/root/config"
 	"aspect.build/cli/pkg/aspecterrors"
 	"aspect.build/cli/pkg/bazel"
 	"aspect.build/cli/pkg/ioutils"
+	"aspect.build/cli/pkg/outputbase"
 )
 
 const (
@@ -55,33 +61,67 @@ type PromptRunner interface {
 // Clean represents the aspect clean command.
 type Clean struct {
 	ioutils.Streams
+	cfg               *config.Config
 	bzl               bazel.Bazel
 	isInteractiveMode bool
 
-	Behavior   SelectRunner
-	Workaround PromptRunner
-	Remember   PromptRunner
-	Prefs      viper.Viper
+	Behavior              SelectRunner
+	NonIncrementalTargets PromptRunner
+	Sync                  PromptRunner
+	Targets               PromptRunner
+	BuildFlags            PromptRunner
+	Workaround            PromptRunner
+	Remember              PromptRunner
+	SelectWorkspaces      MultiSelectRunner
 
 	Expunge      bool
 	ExpungeAsync bool
+
+	// All reclaims the disk space of every Bazel workspace without prompting.
+	All bool
+	// OlderThan restricts reclaiming the disk space of all workspaces to the
+	// ones that Bazel hasn't used for at least this long.
+	OlderThan time.Duration
+	// OutputUserRoot is the directory where Bazel places the output bases of
+	// all workspaces.
+	OutputUserRoot string
+
+	// DryRun reports the disk space that the clean would reclaim, without
+	// removing anything.
+	DryRun bool
+	// Only removes a single category of the data that Bazel keeps on disk for
+	// the workspace, instead of running bazel clean.
+	Only du.Category
+	// Locations are the directories where Bazel keeps the data of the
+	// workspace.
+	Locations *du.Locations
+	// StartupOptions are the Bazel startup options, recorded in the bug
+	// report bundle of the workaround.
+	StartupOptions []string
+	// BugReportDir is the directory where the workaround writes the bug report
+	// bundle.
+	BugReportDir string
 }
 
 // New creates a Clean command.
 func New(
 	streams ioutils.Streams,
+	cfg *config.Config,
 	bzl bazel.Bazel,
 	isInteractiveMode bool) *Clean {
 	return &Clean{
 		Streams:           streams,
+		cfg:               cfg,
 		isInteractiveMode: isInteractiveMode,
 		bzl:               bzl,
+		BugReportDir:      os.TempDir(),
 	}
 }
 
-func NewDefault(bzl bazel.Bazel, isInteractive bool) *Clean {
+func NewDefault(cfg *config.Config, bzl bazel.Bazel, isInteractive bool) *Clean {
 	c := New(
 		ioutils.DefaultStreams,
+		cfg,
 		bzl,
 		isInteractive)
 	c.Behavior = &promptui.Select{
@@ -94,21 +134,46 @@ func NewDefault(bzl bazel.Bazel, isInteractive bool) *Clean {
 			WorkaroundOption,
 		},
 	}
+	c.NonIncrementalTargets = &promptui.Prompt{
+		Label: "Targets to build now in a temporary output base, e.g. //... (empty to skip)",
+	}
+	c.Sync = &promptui.Prompt{
+		Label:     "Run 'bazel sync --configure' now",
+		IsConfirm: true,
+	}
+	c.Targets = &promptui.Prompt{
+		Label: "Targets or packages whose outputs are inconsistent, e.g. //foo:bar //baz/...",
+	}
+	c.BuildFlags = &promptui.Prompt{
+		Label: "Flags the targets were built with, e.g. --config=ci (empty for none)",
+	}
 	c.Workaround = &promptui.Prompt{
-		Label:     "Temporarily workaround the bug by deleting the output folder",
+		Label:     "Temporarily workaround the bug by deleting these output files",
 		IsConfirm: true,
 	}
 	c.Remember = &promptui.Prompt{
 		Label:     rememberLine2,
 		IsConfirm: true,
 	}
-	c.Prefs = *viper.GetViper()
+	c.SelectWorkspaces = &MultiSelect{
+		Label: "Select the workspaces to reclaim disk space from",
+	}
 	return c
 }
 
 // Run runs the aspect build command.
 func (c *Clean) Run(_ *cobra.Command, _ []string) error {
-	skip := c.Prefs.GetBool(skipPromptKey)
+	if c.All {
+		return c.reclaimAll()
+	}
+	if c.Only != "" {
+		return c.cleanCategory(c.Only)
+	}
+	if c.DryRun {
+		return du.New(c.Streams, c.Locations).Report(c.removedCategories())
+	}
+
+	skip := c.cfg.Values().Clean.SkipPrompt
 	if c.isInteractiveMode && !skip {
 
 		_, chosen, err := c.Behavior.Run()
@@ -123,26 +188,21 @@ func (c *Clean) Run(_ *cobra.Command, _ []string) error {
 			// Allow user to opt-out of our fancy "clean" command and just behave like bazel
 			fmt.Fprint(c.Streams.Stdout, rememberLine1)
 			if _, err := c.Remember.Run(); err == nil {
-				c.Prefs.Set(skipPromptKey, "true")
-				if err := c.Prefs.WriteConfig(); err != nil {
-					return fmt.Errorf("failed to update config file: %w", err)
+				if err := c.cfg.Preferences().Set(skipPromptKey, true); err != nil {
+					return err
 				}
 			}
 		case ReclaimAllOption:
-			fmt.Fprint(c.Streams.Stdout, "Sorry, this is not implemented yet: discover all bazel workspaces on the machine\n")
-			return nil
+			return c.reclaimAll()
 		case NonIncrementalOption:
 			fmt.Fprint(c.Streams.Stdout, outputBaseHint)
-			return nil
+			return c.buildNonIncremental()
 		case InvalidateReposOption:
 			fmt.Fprint(c.Streams.Stdout, syncHint)
-			return nil
+			return c.syncConfigure()
 		case WorkaroundOption:
 			fmt.Fprint(c.Streams.Stdout, fileIssueHint)
-			_, err := c.Workaround.Run()
-			if err != nil {
-				return fmt.Errorf("prompt failed: %w", err)
-			}
+			return c.workaround()
 		}
 	}
 
@@ -163,3 +223,98 @@ func (c *Clean) Run(_ *cobra.Command, _ []string) error {
 
 	return nil
 }
+
+// removedCategories returns the categories that bazel clean removes.
+func (c *Clean) removedCategories() []du.Category {
+	if c.Expunge || c.ExpungeAsync {
+		return []du.Category{du.Execroot, du.External, du.ActionCache, du.Other}
+	}
+	return []du.Category{du.Execroot, du.ActionCache}
+}
+
+// cleanCategory removes a single category of the data that Bazel keeps on
+// disk for the workspace. The Bazel server is shut down first when the
+// category is in the output base, since the server holds its state.
+func (c *Clean) cleanCategory(category du.Category) error {
+	if c.DryRun {
+		return du.New(c.Streams, c.Locations).Report([]du.Category{category})
+	}
+	path := c.Locations.Dirs[category]
+	if path == "" {
+		return fmt.Errorf("failed to clean %s: it is not configured in the .bazelrc files", category)
+	}
+	size, err := outputbase.DirSize(path)
+	if err != nil {
+		return fmt.Errorf("failed to clean %s: %w", category, err)
+	}
+	if category.IsInOutputBase() && outputbase.IsServerRunning(c.Locations.OutputBase) {
+		if exitCode, err := c.bzl.Spawn([]string{"shutdown"}); exitCode != 0 {
+			return &aspecterrors.ExitError{
+				Err:      err,
+				ExitCode: exitCode,
+			}
+		}
+	}
+	if err := outputbase.Remove(path); err != nil {
+		return fmt.Errorf("failed to clean %s: %w", category, err)
+	}
+	fmt.Fprintf(c.Stdout, "Removed %s (%s)\n", path, outputbase.FormatSize(size))
+	return nil
+}
+
+// buildNonIncremental builds the targets that the user gives in a temporary
+// output base, which is removed afterwards, so that the build is
+// non-incremental without losing the state of the workspace's output base.
+func (c *Clean) buildNonIncremental() error {
+	answer, err := c.NonIncrementalTargets.Run()
+	if err != nil {
+		return fmt.Errorf("prompt failed: %w", err)
+	}
+	targets := strings.Fields(answer)
+	if len(targets) == 0 {
+		return nil
+	}
+
+	outputBase, err := ioutil.TempDir("", "aspect-output-base")
+	if err != nil {
+		return fmt.Errorf("failed to create a temporary output base: %w", err)
+	}
+	options := bazel.RunOptions{StartupOptions: []string{"--output_base=" + outputBase}}
+	buildExitCode, buildErr := c.bzl.Run(append([]string{"build"}, targets...), options)
+
+	// The build leaves a Bazel server running in the temporary output base,
+	// which must stop before the output base can be removed.
+	if exitCode, err := c.bzl.Run([]string{"shutdown"}, options); exitCode != 0 {
+		return &aspecterrors.ExitError{
+			Err:      err,
+			ExitCode: exitCode,
+		}
+	}
+	if err := outputbase.Remove(outputBase); err != nil {
+		return fmt.Errorf("failed to remove the temporary output base: %w", err)
+	}
+
+	if buildExitCode != 0 {
+		return &aspecterrors.ExitError{
+			Err:      buildErr,
+			ExitCode: buildExitCode,
+		}
+	}
+	return nil
+}
+
+// syncConfigure re-runs the repository rules that configure the workspace,
+// if the user confirms.
+func (c *Clean) syncConfigure() error {
+	if _, err := c.Sync.Run(); err != nil {
+		// The user declined.
+		return nil
+	}
+	if exitCode, err := c.bzl.Spawn([]string{"sync", "--configure"}); exitCode != 0 {
+		return &aspecterrors.ExitError{
+			Err:      err,
+			ExitCode: exitCode,
+		}
+	}
+	return nil
+}
@@ -7,17 +7,25 @@ Not licensed for re-use.
 package clean_test
 
 import (
+	"archive/tar"
+	"compress/gzip"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	. "github.com/onsi/gomega"
-	"github.com/spf13/viper"
 
 	"aspect.build/cli/pkg/aspect/clean"
+	"aspect.build/cli/pkg/aspect/du"
+	"aspect.build/cli/pkg/aspect/root/config"
+	"aspect.build/cli/pkg/aspecterrors"
+	"aspect.build/cli/pkg/bazel"
 	"aspect.build/cli/pkg/bazel/mock"
 	"aspect.build/cli/pkg/ioutils"
 )
@@ -34,12 +42,83 @@ func (p deny) Run() (string, error) {
 	return "", fmt.Errorf("said no")
 }
 
+type answer string
+
+func (p answer) Run() (string, error) {
+	return string(p), nil
+}
+
+// readBundle returns the files of the given bug report bundle.
+func readBundle(t *testing.T, path string) map[string]string {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string)
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[header.Name] = string(content)
+	}
+}
+
 type chooseReclaim struct{}
 
 func (p chooseReclaim) Run() (int, string, error) {
 	return 0, clean.ReclaimOption, nil
 }
 
+type chooseReclaimAll struct{}
+
+func (p chooseReclaimAll) Run() (int, string, error) {
+	return 1, clean.ReclaimAllOption, nil
+}
+
+type selectWorkspaces struct {
+	items   []string
+	indexes []int
+}
+
+func (p *selectWorkspaces) Run(items []string) ([]int, error) {
+	p.items = items
+	return p.indexes, nil
+}
+
+// writeOutputBase creates an output base of the given workspace under root,
+// last used the given number of days ago.
+func writeOutputBase(t *testing.T, root, name, workspace string, days int) string {
+	path := filepath.Join(root, name)
+	if err := os.MkdirAll(path, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(path, "DO_NOT_BUILD_HERE"), []byte(workspace), 0644); err != nil {
+		t.Fatal(err)
+	}
+	lastUsed := time.Now().Add(-time.Duration(days) * 24 * time.Hour)
+	if err := os.Chtimes(filepath.Join(path, "DO_NOT_BUILD_HERE"), lastUsed, lastUsed); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, lastUsed, lastUsed); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
 type chooseNonIncremental struct{}
 
 func (p chooseNonIncremental) Run() (int, string, error) {
@@ -71,7 +150,7 @@ func TestClean(t *testing.T) {
 			Spawn([]string{"clean"}).
 			Return(0, nil)
 
-		b := clean.New(ioutils.Streams{}, bzl, false)
+		b := clean.New(ioutils.Streams{}, &config.Config{}, bzl, false)
 		g.Expect(b.Run(nil, []string{})).Should(Succeed())
 	})
 
@@ -86,7 +165,7 @@ func TestClean(t *testing.T) {
 			Spawn([]string{"clean", "--expunge"}).
 			Return(0, nil)
 
-		b := clean.New(ioutils.Streams{}, bzl, false)
+		b := clean.New(ioutils.Streams{}, &config.Config{}, bzl, false)
 		b.Expunge = true
 		g.Expect(b.Run(nil, []string{})).Should(Succeed())
 	})
@@ -102,7 +181,7 @@ func TestClean(t *testing.T) {
 			Spawn([]string{"clean", "--expunge_async"}).
 			Return(0, nil)
 
-		b := clean.New(ioutils.Streams{}, bzl, false)
+		b := clean.New(ioutils.Streams{}, &config.Config{}, bzl, false)
 		b.ExpungeAsync = true
 		g.Expect(b.Run(nil, []string{})).Should(Succeed())
 	})
@@ -120,7 +199,7 @@ func TestClean(t *testing.T) {
 
 		var stdout strings.Builder
 		streams := ioutils.Streams{Stdout: &stdout}
-		b := clean.New(streams, bzl, true)
+		b := clean.New(streams, &config.Config{}, bzl, true)
 
 		b.Behavior = chooseReclaim{}
 		b.Remember = deny{}
@@ -142,27 +221,25 @@ func TestClean(t *testing.T) {
 
 		var stdout strings.Builder
 		streams := ioutils.Streams{Stdout: &stdout}
-		b := clean.New(streams, bzl, true)
 
-		viper := *viper.New()
-		cfg, err := os.CreateTemp(os.Getenv("TEST_TMPDIR"), "cfg***.ini")
+		cfgFile, err := os.CreateTemp(os.Getenv("TEST_TMPDIR"), "cfg***.yaml")
+		g.Expect(err).To(BeNil())
+		cfg, err := config.Load(cfgFile.Name(), "", nil)
 		g.Expect(err).To(BeNil())
 
-		viper.SetConfigFile(cfg.Name())
+		b := clean.New(streams, cfg, bzl, true)
 		b.Behavior = chooseReclaim{}
 		b.Remember = confirm{}
-		b.Prefs = viper
 		g.Expect(b.Run(nil, []string{})).Should(Succeed())
 		g.Expect(stdout.String()).To(ContainSubstring("skip this prompt"))
 
 		// Recorded your preference for next time
-		content, err := ioutil.ReadFile(cfg.Name())
+		content, err := ioutil.ReadFile(cfgFile.Name())
 		g.Expect(err).To(BeNil())
-		g.Expect(string(content)).To(Equal("[clean]\nskip_prompt=true\n\n"))
+		g.Expect(string(content)).To(Equal("version: 1\nclean:\n  skip_prompt: true\n"))
 
 		// If we run it again, there should be no prompt
-		c := clean.New(streams, bzl, true)
-		c.Prefs = viper
+		c := clean.New(streams, cfg, bzl, true)
 		g.Expect(c.Run(nil, []string{})).Should(Succeed())
 	})
 
@@ -171,24 +248,61 @@ func TestClean(t *testing.T) {
 		var stdout strings.Builder
 		streams := ioutils.Streams{Stdout: &stdout}
 
-		c := clean.New(streams, nil, true)
+		c := clean.New(streams, &config.Config{}, nil, true)
 		c.Behavior = chooseNonIncremental{}
+		c.NonIncrementalTargets = answer("")
 		g.Expect(c.Run(nil, []string{})).Should(Succeed())
 		g.Expect(stdout.String()).To(ContainSubstring("use the --output_base flag"))
 	})
 
+	t.Run("interactive clean prompts for usage, option 2 builds in a temporary output base", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		var outputBase string
+		buildErr := fmt.Errorf("build failed")
+		bzl := mock.NewMockBazel(ctrl)
+		gomock.InOrder(
+			bzl.
+				EXPECT().
+				Run([]string{"build", "//foo", "//bar/..."}, gomock.Any()).
+				DoAndReturn(func(_ []string, options bazel.RunOptions) (int, error) {
+					g.Expect(options.StartupOptions).To(HaveLen(1))
+					g.Expect(options.StartupOptions[0]).To(HavePrefix("--output_base="))
+					outputBase = strings.TrimPrefix(options.StartupOptions[0], "--output_base=")
+					g.Expect(outputBase).To(BeADirectory())
+					return 1, buildErr
+				}),
+			bzl.
+				EXPECT().
+				Run([]string{"shutdown"}, gomock.Any()).
+				DoAndReturn(func(_ []string, options bazel.RunOptions) (int, error) {
+					g.Expect(options.StartupOptions).To(Equal([]string{"--output_base=" + outputBase}))
+					return 0, nil
+				}),
+		)
+
+		c := clean.New(ioutils.Streams{Stdout: ioutil.Discard}, &config.Config{}, bzl, true)
+		c.Behavior = chooseNonIncremental{}
+		c.NonIncrementalTargets = answer("//foo //bar/...")
+		g.Expect(c.Run(nil, []string{})).To(MatchError(&aspecterrors.ExitError{Err: buildErr, ExitCode: 1}))
+		g.Expect(outputBase).NotTo(BeADirectory())
+	})
+
 	t.Run("interactive clean prompts for usage, option 3", func(t *testing.T) {
 		g := NewGomegaWithT(t)
 		var stdout strings.Builder
 		streams := ioutils.Streams{Stdout: &stdout}
 
-		c := clean.New(streams, nil, true)
+		c := clean.New(streams, &config.Config{}, nil, true)
 		c.Behavior = chooseInvalidateRepos{}
+		c.Sync = deny{}
 		g.Expect(c.Run(nil, []string{})).Should(Succeed())
 		g.Expect(stdout.String()).To(ContainSubstring("aspect sync --configure"))
 	})
 
-	t.Run("interactive clean prompts for usage, option 5", func(t *testing.T) {
+	t.Run("interactive clean prompts for usage, option 3 runs sync", func(t *testing.T) {
 		g := NewGomegaWithT(t)
 		ctrl := gomock.NewController(t)
 		defer ctrl.Finish()
@@ -196,16 +310,233 @@ func TestClean(t *testing.T) {
 		bzl := mock.NewMockBazel(ctrl)
 		bzl.
 			EXPECT().
-			Spawn([]string{"clean"}).
+			Spawn([]string{"sync", "--configure"}).
 			Return(0, nil)
 
+		c := clean.New(ioutils.Streams{Stdout: ioutil.Discard}, &config.Config{}, bzl, true)
+		c.Behavior = chooseInvalidateRepos{}
+		c.Sync = confirm{}
+		g.Expect(c.Run(nil, []string{})).Should(Succeed())
+	})
+
+	t.Run("interactive clean prompts for usage, option 5", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "clean")
+		g.Expect(err).To(BeNil())
+		defer os.RemoveAll(dir)
+		outputBase := filepath.Join(dir, "ob")
+		executionRoot := filepath.Join(outputBase, "execroot", "ws")
+		output := filepath.Join(executionRoot, "bazel-out", "k8-fastbuild", "bin", "foo", "bar.js")
+		g.Expect(os.MkdirAll(filepath.Dir(output), 0755)).To(Succeed())
+		g.Expect(ioutil.WriteFile(output, []byte("console.log(1)"), 0444)).To(Succeed())
+		g.Expect(ioutil.WriteFile(filepath.Join(outputBase, "command.log"), []byte("ERROR: inconsistent"), 0644)).To(Succeed())
+
+		bzl := mock.NewMockBazel(ctrl)
+		write := func(out string) func([]string, bazel.RunOptions) (int, error) {
+			return func(_ []string, options bazel.RunOptions) (int, error) {
+				fmt.Fprint(options.Streams.Stdout, out)
+				return 0, nil
+			}
+		}
+		bzl.
+			EXPECT().
+			Info().
+			Return(bazel.ParseInfo([]byte("execution_root: "+executionRoot+"\n")), nil)
+		bzl.
+			EXPECT().
+			Run([]string{"aquery", "--output=text", "--config=ci", "-c", "opt", "//foo:bar + //baz/..."}, gomock.Any()).
+			DoAndReturn(write(`action 'Copying file foo/bar.js'
+  Mnemonic: CopyFile
+  Inputs: [foo/bar.js]
+  Outputs: [bazel-out/k8-fastbuild/bin/foo/bar.js]
+action 'Writing file baz/out'
+  Outputs: [bazel-out/k8-fastbuild/bin/baz/out (TreeArtifact), ../outside]
+`))
+		bzl.
+			EXPECT().
+			Run([]string{"version"}, gomock.Any()).
+			DoAndReturn(write("Build label: 5.0.0\n"))
+
 		var stdout strings.Builder
 		streams := ioutils.Streams{Stdout: &stdout}
 
-		c := clean.New(streams, bzl, true)
+		c := clean.New(streams, &config.Config{}, bzl, true)
 		c.Behavior = chooseWorkaround{}
+		c.Targets = answer("//foo:bar //baz/...")
+		c.BuildFlags = answer("--config=ci -c opt")
 		c.Workaround = confirm{}
+		c.Locations = &du.Locations{Workspace: dir, OutputBase: outputBase}
+		c.BugReportDir = dir
 		g.Expect(c.Run(nil, []string{})).Should(Succeed())
 		g.Expect(stdout.String()).To(ContainSubstring("recommend you file a bug"))
+		g.Expect(stdout.String()).To(ContainSubstring("Found 2 output file(s) of //foo:bar //baz/...\nRemoved 1 output file(s)"))
+		g.Expect(output).NotTo(BeAnExistingFile())
+
+		bundles, err := filepath.Glob(filepath.Join(dir, "aspect-bug-report-*.tar.gz"))
+		g.Expect(err).To(BeNil())
+		g.Expect(bundles).To(HaveLen(1))
+		g.Expect(stdout.String()).To(ContainSubstring("Attach " + bundles[0] + " to a bug report"))
+		g.Expect(readBundle(t, bundles[0])).To(Equal(map[string]string{
+			"version.txt": "Build label: 5.0.0\n",
+			"flags.txt":   "startup options: \n\nbuild flags: --config=ci -c opt\n\n.bazelrc:\n",
+			"info.txt":    "execution_root: " + executionRoot + "\n",
+			"command.log": "ERROR: inconsistent",
+			"targets.txt": "targets: //foo:bar //baz/...\n\nremoved outputs:\nbazel-out/k8-fastbuild/bin/foo/bar.js\n",
+		}))
+	})
+
+	t.Run("interactive clean prompts for usage, option 5 reports when no output is found", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "clean")
+		g.Expect(err).To(BeNil())
+		defer os.RemoveAll(dir)
+
+		bzl := mock.NewMockBazel(ctrl)
+		bzl.
+			EXPECT().
+			Info().
+			Return(bazel.ParseInfo([]byte("execution_root: "+filepath.Join(dir, "execroot")+"\n")), nil)
+		bzl.
+			EXPECT().
+			Run([]string{"aquery", "--output=text", "//foo:bar"}, gomock.Any()).
+			Return(0, nil)
+		bzl.
+			EXPECT().
+			Run([]string{"version"}, gomock.Any()).
+			Return(0, nil)
+
+		var stdout strings.Builder
+		c := clean.New(ioutils.Streams{Stdout: &stdout}, &config.Config{}, bzl, true)
+		c.Behavior = chooseWorkaround{}
+		c.Targets = answer("//foo:bar")
+		c.BuildFlags = answer("")
+		c.Workaround = deny{}
+		c.Locations = &du.Locations{Workspace: dir, OutputBase: filepath.Join(dir, "ob")}
+		c.BugReportDir = dir
+		g.Expect(c.Run(nil, []string{})).Should(Succeed())
+		g.Expect(stdout.String()).To(ContainSubstring("Found no output file of //foo:bar, check the targets and the flags they were built with\n"))
+		g.Expect(stdout.String()).NotTo(ContainSubstring("Removed"))
+	})
+
+	t.Run("interactive clean prompts for usage, option 2 removes the selected workspaces", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		root, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "output_user_root")
+		g.Expect(err).To(BeNil())
+		defer os.RemoveAll(root)
+		foo := writeOutputBase(t, root, "a1", "/home/me/foo", 40)
+		bar := writeOutputBase(t, root, "b2", "/home/me/bar", 2)
+
+		var stdout strings.Builder
+		streams := ioutils.Streams{Stdout: &stdout}
+		selector := &selectWorkspaces{indexes: []int{1}}
+		c := clean.New(streams, &config.Config{}, nil, true)
+		c.Behavior = chooseReclaimAll{}
+		c.SelectWorkspaces = selector
+		c.OutputUserRoot = root
+		g.Expect(c.Run(nil, []string{})).Should(Succeed())
+
+		g.Expect(selector.items).To(Equal([]string{
+			"/home/me/foo  12 B  last used 40 days ago  (workspace deleted)",
+			"/home/me/bar  12 B  last used 2 days ago  (workspace deleted)",
+		}))
+		g.Expect(foo).To(BeADirectory())
+		g.Expect(bar).NotTo(BeADirectory())
+		g.Expect(stdout.String()).To(Equal("Removed " + bar + " (12 B) of /home/me/bar\nReclaimed 12 B from 1 workspace(s)\n"))
+	})
+
+	t.Run("clean all removes the workspaces older than the given age without prompting", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		root, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "output_user_root")
+		g.Expect(err).To(BeNil())
+		defer os.RemoveAll(root)
+		foo := writeOutputBase(t, root, "a1", "/home/me/foo", 40)
+		bar := writeOutputBase(t, root, "b2", "/home/me/bar", 2)
+
+		var stdout strings.Builder
+		streams := ioutils.Streams{Stdout: &stdout}
+		c := clean.New(streams, &config.Config{}, nil, false)
+		c.All = true
+		c.OlderThan = 30 * 24 * time.Hour
+		c.OutputUserRoot = root
+		g.Expect(c.Run(nil, []string{})).Should(Succeed())
+
+		g.Expect(foo).NotTo(BeADirectory())
+		g.Expect(bar).To(BeADirectory())
+		g.Expect(stdout.String()).To(Equal("Removed " + foo + " (12 B) of /home/me/foo\nReclaimed 12 B from 1 workspace(s)\n"))
+	})
+
+	t.Run("clean all dry run lists the workspaces without removing them", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		root, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "output_user_root")
+		g.Expect(err).To(BeNil())
+		defer os.RemoveAll(root)
+		foo := writeOutputBase(t, root, "a1", "/home/me/foo", 40)
+
+		var stdout strings.Builder
+		streams := ioutils.Streams{Stdout: &stdout}
+		c := clean.New(streams, &config.Config{}, nil, true)
+		c.All = true
+		c.DryRun = true
+		c.OutputUserRoot = root
+		g.Expect(c.Run(nil, []string{})).Should(Succeed())
+
+		g.Expect(foo).To(BeADirectory())
+		g.Expect(stdout.String()).To(Equal("Would remove " + foo + " (12 B) of /home/me/foo\nWould reclaim 12 B from 1 workspace(s)\n"))
+	})
+
+	t.Run("clean dry run reports the categories it would remove without prompting", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "clean")
+		g.Expect(err).To(BeNil())
+		defer os.RemoveAll(dir)
+
+		var stdout strings.Builder
+		streams := ioutils.Streams{Stdout: &stdout}
+		c := clean.New(streams, &config.Config{}, nil, true)
+		c.DryRun = true
+		c.Expunge = true
+		c.Locations = &du.Locations{
+			Workspace:  "/home/me/foo",
+			OutputBase: dir,
+			Dirs:       map[du.Category]string{},
+		}
+		g.Expect(c.Run(nil, []string{})).Should(Succeed())
+		g.Expect(stdout.String()).To(ContainSubstring("* execroot"))
+		g.Expect(stdout.String()).To(ContainSubstring("* external"))
+		g.Expect(stdout.String()).To(ContainSubstring("  bazelisk"))
+	})
+
+	t.Run("clean only removes a single category", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "clean")
+		g.Expect(err).To(BeNil())
+		defer os.RemoveAll(dir)
+		downloads := filepath.Join(dir, "bazelisk", "downloads")
+		g.Expect(os.MkdirAll(downloads, 0755)).To(Succeed())
+		g.Expect(ioutil.WriteFile(filepath.Join(downloads, "bazel"), []byte("#!/bin/sh"), 0755)).To(Succeed())
+
+		var stdout strings.Builder
+		streams := ioutils.Streams{Stdout: &stdout}
+		c := clean.New(streams, &config.Config{}, nil, true)
+		c.Only = du.Bazelisk
+		c.Locations = &du.Locations{
+			OutputBase: filepath.Join(dir, "ob"),
+			Dirs: map[du.Category]string{
+				du.Bazelisk:  downloads,
+				du.DiskCache: "",
+			},
+		}
+		g.Expect(c.Run(nil, []string{})).Should(Succeed())
+		g.Expect(downloads).NotTo(BeADirectory())
+		g.Expect(stdout.String()).To(Equal("Removed " + downloads + " (9 B)\n"))
+
+		c.Only = du.DiskCache
+		g.Expect(c.Run(nil, []string{})).To(MatchError("failed to clean disk_cache: it is not configured in the .bazelrc files"))
 	})
 }
@@ -0,0 +1,144 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package clean
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/manifoldco/promptui"
+
+	"aspect.build/cli/pkg/outputbase"
+)
+
+// MultiSelectRunner lets the user pick any number of the given items, and
+// returns their indexes.
+type MultiSelectRunner interface {
+	Run(items []string) ([]int, error)
+}
+
+// MultiSelect is a MultiSelectRunner where choosing an item toggles it, until
+// the user chooses Done.
+type MultiSelect struct {
+	Label string
+}
+
+// Run implements MultiSelectRunner.
+func (m *MultiSelect) Run(items []string) ([]int, error) {
+	selected := make([]bool, len(items))
+	cursor := 0
+	for {
+		options := make([]string, len(items)+1)
+		options[0] = "Done"
+		for i, item := range items {
+			mark := "[ ]"
+			if selected[i] {
+				mark = "[x]"
+			}
+			options[i+1] = mark + " " + item
+		}
+		s := &promptui.Select{
+			Label:        m.Label,
+			Items:        options,
+			Size:         10,
+			CursorPos:    cursor,
+			HideSelected: true,
+		}
+		i, _, err := s.Run()
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			break
+		}
+		selected[i-1] = !selected[i-1]
+		cursor = i
+	}
+
+	var indexes []int
+	for i, s := range selected {
+		if s {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes, nil
+}
+
+// reclaimAll removes the output bases of the Bazel workspaces that the user
+// selects, or all of them with c.All, skipping the ones used more recently
+// than c.OlderThan. With c.DryRun, it lists all of them without removing them.
+func (c *Clean) reclaimAll() error {
+	outputBases, err := outputbase.List(c.OutputUserRoot)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	var candidates []*outputbase.OutputBase
+	for _, o := range outputBases {
+		if now.Sub(o.LastUsed) >= c.OlderThan {
+			candidates = append(candidates, o)
+		}
+	}
+	if len(candidates) == 0 {
+		fmt.Fprintf(c.Stdout, "No Bazel workspaces found in %s\n", c.OutputUserRoot)
+		return nil
+	}
+
+	selected := candidates
+	if !c.All && !c.DryRun {
+		items := make([]string, len(candidates))
+		for i, o := range candidates {
+			items[i] = describeOutputBase(o, now)
+		}
+		indexes, err := c.SelectWorkspaces.Run(items)
+		if err != nil {
+			return fmt.Errorf("prompt failed: %w", err)
+		}
+		selected = nil
+		for _, i := range indexes {
+			selected = append(selected, candidates[i])
+		}
+	}
+
+	var reclaimed int64
+	var count int
+	for _, o := range selected {
+		if o.ServerRunning {
+			fmt.Fprintf(c.Stdout, "Skipped %s: a Bazel server is running, run 'bazel shutdown' in the workspace first\n", o.Workspace)
+			continue
+		}
+		if c.DryRun {
+			fmt.Fprintf(c.Stdout, "Would remove %s (%s) of %s\n", o.Path, outputbase.FormatSize(o.Size), o.Workspace)
+			reclaimed += o.Size
+			count++
+			continue
+		}
+		if err := outputbase.Remove(o.Path); err != nil {
+			return err
+		}
+		fmt.Fprintf(c.Stdout, "Removed %s (%s) of %s\n", o.Path, outputbase.FormatSize(o.Size), o.Workspace)
+		reclaimed += o.Size
+		count++
+	}
+	if c.DryRun {
+		fmt.Fprintf(c.Stdout, "Would reclaim %s from %d workspace(s)\n", outputbase.FormatSize(reclaimed), count)
+		return nil
+	}
+	fmt.Fprintf(c.Stdout, "Reclaimed %s from %d workspace(s)\n", outputbase.FormatSize(reclaimed), count)
+	return nil
+}
+
+func describeOutputBase(o *outputbase.OutputBase, now time.Time) string {
+	description := fmt.Sprintf("%s  %s  last used %s", o.Workspace, outputbase.FormatSize(o.Size), outputbase.FormatAge(o.LastUsed, now))
+	if !o.WorkspaceExists() {
+		description += "  (workspace deleted)"
+	}
+	if o.ServerRunning {
+		description += "  (server running)"
+	}
+	return description
+}
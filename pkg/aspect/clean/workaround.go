@@ -0,0 +1,201 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package clean
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/manifoldco/promptui"
+
+	"aspect.build/cli/pkg/aspecterrors"
+	"aspect.build/cli/pkg/bazel"
+	"aspect.build/cli/pkg/ioutils"
+	"aspect.build/cli/pkg/outputbase"
+)
+
+const bugReportHint = "Attach %s to a bug report: https://github.com/bazelbuild/bazel/issues/new/choose\n"
+
+// workaround deletes the output files of the targets that the user picks,
+// so that Bazel recreates them in the next build, and collects a bug report
+// bundle to attach to an issue.
+func (c *Clean) workaround() error {
+	// The command log is rewritten by every Bazel command, including the ones
+	// run below, so it's read before they run.
+	commandLog, _ := ioutil.ReadFile(filepath.Join(c.Locations.OutputBase, "command.log"))
+
+	answer, err := c.Targets.Run()
+	if err != nil {
+		return fmt.Errorf("prompt failed: %w", err)
+	}
+	patterns := strings.Fields(answer)
+	if len(patterns) == 0 {
+		return fmt.Errorf("failed to workaround inconsistent state: no targets or packages given")
+	}
+	// The outputs of the targets depend on the configuration, so aquery is
+	// given the flags they were built with.
+	answer, err = c.BuildFlags.Run()
+	if err != nil {
+		return fmt.Errorf("prompt failed: %w", err)
+	}
+	buildFlags := strings.Fields(answer)
+
+	info, err := c.bzl.Info()
+	if err != nil {
+		return err
+	}
+	executionRoot := info.ExecutionRoot
+	if executionRoot == "" {
+		return fmt.Errorf("failed to workaround inconsistent state: bazel info has no execution_root")
+	}
+	aquery := append(append([]string{"aquery", "--output=text"}, buildFlags...), strings.Join(patterns, " + "))
+	actions, err := c.capture(aquery...)
+	if err != nil {
+		return err
+	}
+	outputs := actionOutputs(actions)
+
+	var removed []string
+	if len(outputs) == 0 {
+		fmt.Fprintf(c.Stdout, "Found no output file of %s, check the targets and the flags they were built with\n", strings.Join(patterns, " "))
+	} else {
+		fmt.Fprintf(c.Stdout, "Found %d output file(s) of %s\n", len(outputs), strings.Join(patterns, " "))
+		if _, err := c.Workaround.Run(); err == nil {
+			for _, output := range outputs {
+				path := filepath.Join(executionRoot, output)
+				if _, err := os.Lstat(path); os.IsNotExist(err) {
+					continue
+				}
+				if err := outputbase.Remove(path); err != nil {
+					return fmt.Errorf("failed to workaround inconsistent state: %w", err)
+				}
+				removed = append(removed, output)
+			}
+			if len(removed) == 0 {
+				fmt.Fprintln(c.Stdout, "None of the output files exist, so none was removed")
+			} else {
+				fmt.Fprintf(c.Stdout, "Removed %d output file(s), Bazel recreates them in the next build\n", len(removed))
+			}
+		} else if err != promptui.ErrAbort {
+			return fmt.Errorf("prompt failed: %w", err)
+		}
+	}
+
+	version, err := c.capture("version")
+	if err != nil {
+		return err
+	}
+	bazelrc, _ := ioutil.ReadFile(filepath.Join(c.Locations.Workspace, ".bazelrc"))
+	var flags strings.Builder
+	fmt.Fprintf(&flags, "startup options: %s\n\nbuild flags: %s\n\n.bazelrc:\n%s", strings.Join(c.StartupOptions, " "), strings.Join(buildFlags, " "), bazelrc)
+	var targets strings.Builder
+	fmt.Fprintf(&targets, "targets: %s\n\nremoved outputs:\n", strings.Join(patterns, " "))
+	for _, output := range removed {
+		fmt.Fprintln(&targets, output)
+	}
+
+	bundle := filepath.Join(c.BugReportDir, fmt.Sprintf("aspect-bug-report-%s.tar.gz", time.Now().Format("20060102-150405")))
+	err = writeBundle(bundle, []bundleFile{
+		{"version.txt", version},
+		{"flags.txt", []byte(flags.String())},
+		{"info.txt", []byte(info.String())},
+		{"command.log", commandLog},
+		{"targets.txt", []byte(targets.String())},
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.Stdout, bugReportHint, bundle)
+	return nil
+}
+
+// capture runs the given Bazel command and returns its output.
+func (c *Clean) capture(command ...string) ([]byte, error) {
+	var out bytes.Buffer
+	streams := ioutils.Streams{Stdout: &out, Stderr: c.Stderr}
+	if exitCode, err := c.bzl.Run(command, bazel.RunOptions{Streams: streams}); exitCode != 0 {
+		return nil, &aspecterrors.ExitError{
+			Err:      err,
+			ExitCode: exitCode,
+		}
+	}
+	return out.Bytes(), nil
+}
+
+// actionOutputs returns the paths of the outputs under bazel-out listed in
+// the text output of bazel aquery, relative to the execution root.
+func actionOutputs(actions []byte) []string {
+	seen := make(map[string]bool)
+	var outputs []string
+	scanner := bufio.NewScanner(bytes.NewReader(actions))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "Outputs: [") {
+			continue
+		}
+		list := strings.TrimSuffix(strings.TrimPrefix(line, "Outputs: ["), "]")
+		for _, output := range strings.Split(list, ", ") {
+			// Tree artifacts are suffixed with their kind, e.g. (TreeArtifact).
+			if i := strings.Index(output, " ("); i >= 0 {
+				output = output[:i]
+			}
+			// Only remove the outputs in the output tree, never a source file.
+			if !strings.HasPrefix(output, "bazel-out/") || strings.Contains(output, "..") || seen[output] {
+				continue
+			}
+			seen[output] = true
+			outputs = append(outputs, output)
+		}
+	}
+	return outputs
+}
+
+type bundleFile struct {
+	name    string
+	content []byte
+}
+
+// writeBundle writes the given files to a gzipped tarball at path.
+func writeBundle(path string, files []bundleFile) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to write the bug report: %w", err)
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for _, file := range files {
+		header := &tar.Header{
+			Name:    file.name,
+			Mode:    0644,
+			Size:    int64(len(file.content)),
+			ModTime: time.Now(),
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return fmt.Errorf("failed to write the bug report: %w", err)
+		}
+		if _, err := tw.Write(file.content); err != nil {
+			return fmt.Errorf("failed to write the bug report: %w", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to write the bug report: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("failed to write the bug report: %w", err)
+	}
+	return f.Close()
+}
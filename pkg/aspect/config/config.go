@@ -0,0 +1,133 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"aspect.build/cli/pkg/aspect/root/config"
+	"aspect.build/cli/pkg/aspecterrors"
+	"aspect.build/cli/pkg/ioutils"
+)
+
+// Config represents the aspect config command group.
+type Config struct {
+	ioutils.Streams
+	cfg *config.Config
+
+	// ShowOrigin prints where each value comes from.
+	ShowOrigin bool
+	// Layer is the layer whose file is modified by Set and Unset.
+	Layer config.Layer
+}
+
+// New creates a Config command.
+func New(streams ioutils.Streams, cfg *config.Config) *Config {
+	return &Config{
+		Streams: streams,
+		cfg:     cfg,
+		Layer:   config.UserLayer,
+	}
+}
+
+// Get prints the effective value of the given key, or its default. It fails
+// with exit code 1 if the key is not set and has no default, e.g. a key of a
+// query preset.
+func (c *Config) Get(key string) error {
+	k, err := config.LookupKey(key)
+	if err != nil {
+		return fmt.Errorf("failed to get config: %w", err)
+	}
+	setting, ok := c.cfg.Get(key)
+	if !ok {
+		if strings.Contains(k.Pattern, "*") {
+			return &aspecterrors.ExitError{ExitCode: 1}
+		}
+		setting = config.Setting{Key: key, Value: k.Default, Layer: config.DefaultLayer}
+	}
+	c.print(setting, config.FormatValue(setting.Value))
+	return nil
+}
+
+// List prints the effective value of every key that is set.
+func (c *Config) List() error {
+	for _, setting := range c.cfg.List() {
+		c.print(setting, fmt.Sprintf("%s=%s", setting.Key, config.FormatValue(setting.Value)))
+	}
+	return nil
+}
+
+func (c *Config) print(setting config.Setting, line string) {
+	if c.ShowOrigin {
+		fmt.Fprintf(c.Stdout, "%s\t%s\n", setting.Origin(), line)
+	} else {
+		fmt.Fprintln(c.Stdout, line)
+	}
+}
+
+// Set sets the given key in the config file of c.Layer. A list takes one
+// value per element.
+func (c *Config) Set(key string, values []string) error {
+	path, err := c.layerFile()
+	if err != nil {
+		return fmt.Errorf("failed to set config: %w", err)
+	}
+	value, err := config.ParseValue(key, values)
+	if err != nil {
+		return fmt.Errorf("failed to set config: %w", err)
+	}
+	if err := config.SetInFile(path, key, value); err != nil {
+		return fmt.Errorf("failed to set config: %w", err)
+	}
+	c.warnIfOverridden(key)
+	return nil
+}
+
+// Unset removes the given key from the config file of c.Layer.
+func (c *Config) Unset(key string) error {
+	if _, err := config.LookupKey(key); err != nil {
+		return fmt.Errorf("failed to unset config: %w", err)
+	}
+	path, err := c.layerFile()
+	if err != nil {
+		return fmt.Errorf("failed to unset config: %w", err)
+	}
+	found, err := config.UnsetInFile(path, key)
+	if err != nil {
+		return fmt.Errorf("failed to unset config: %w", err)
+	}
+	if !found {
+		return &aspecterrors.ExitError{
+			Err:      fmt.Errorf("config key %q is not set in %s", key, path),
+			ExitCode: 1,
+		}
+	}
+	c.warnIfOverridden(key)
+	return nil
+}
+
+func (c *Config) layerFile() (string, error) {
+	path := c.cfg.File(c.Layer)
+	if path == "" {
+		if c.Layer == config.WorkspaceLayer {
+			return "", fmt.Errorf("the current working directory is not in a Bazel workspace")
+		}
+		return "", fmt.Errorf("the %s config can't be modified", c.Layer)
+	}
+	return path, nil
+}
+
+// warnIfOverridden warns when a layer with a higher precedence than c.Layer
+// sets the given key, since modifying c.Layer then has no effect.
+func (c *Config) warnIfOverridden(key string) {
+	setting, ok := c.cfg.Get(key)
+	if !ok || setting.Layer == c.Layer || (c.Layer == config.WorkspaceLayer && setting.Layer == config.UserLayer) {
+		return
+	}
+	fmt.Fprintf(c.Stderr, "Warning: %s is overridden by %s\n", key, setting.Origin())
+}
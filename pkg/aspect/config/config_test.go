@@ -0,0 +1,117 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package config_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"aspect.build/cli/pkg/aspect/config"
+	rootConfig "aspect.build/cli/pkg/aspect/root/config"
+	"aspect.build/cli/pkg/aspecterrors"
+	"aspect.build/cli/pkg/ioutils"
+)
+
+func setup(t *testing.T, environ ...string) (userFile string, workspaceFile string, cfg *rootConfig.Config) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	userFile = filepath.Join(dir, "user.yaml")
+	workspaceFile = filepath.Join(dir, "workspace.yaml")
+	if err := ioutil.WriteFile(userFile, []byte("clean:\n  skip_prompt: true\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(workspaceFile, []byte("startup_options: [--batch, --foo]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err = rootConfig.Load(userFile, workspaceFile, environ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return userFile, workspaceFile, cfg
+}
+
+func TestConfig(t *testing.T) {
+	t.Run("get prints the value with its origin", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		userFile, _, cfg := setup(t)
+
+		var stdout strings.Builder
+		c := config.New(ioutils.Streams{Stdout: &stdout}, cfg)
+		c.ShowOrigin = true
+		g.Expect(c.Get("clean.skip_prompt")).To(Succeed())
+		g.Expect(stdout.String()).To(Equal("user:" + userFile + "\ttrue\n"))
+	})
+
+	t.Run("get fails when the key is not set", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		_, _, cfg := setup(t)
+
+		c := config.New(ioutils.Streams{}, cfg)
+		g.Expect(c.Get("query.presets.foo.query")).To(MatchError(&aspecterrors.ExitError{ExitCode: 1}))
+		g.Expect(c.Get("query.all.alow")).To(MatchError(`failed to get config: unknown config key "query.all.alow"`))
+	})
+
+	t.Run("get prints the default when the key is not set", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		_, _, cfg := setup(t)
+
+		var stdout strings.Builder
+		c := config.New(ioutils.Streams{Stdout: &stdout}, cfg)
+		c.ShowOrigin = true
+		g.Expect(c.Get("query.all.allow")).To(Succeed())
+		g.Expect(stdout.String()).To(Equal("default\tfalse\n"))
+	})
+
+	t.Run("list prints every key", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		_, _, cfg := setup(t, "ASPECT_CLEAN_SKIP_PROMPT=false")
+
+		var stdout strings.Builder
+		c := config.New(ioutils.Streams{Stdout: &stdout}, cfg)
+		g.Expect(c.List()).To(Succeed())
+		g.Expect(stdout.String()).To(Equal("clean.skip_prompt=false\nstartup_options=--batch --foo\n"))
+	})
+
+	t.Run("set writes to the file of the layer and warns when overridden", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		_, workspaceFile, cfg := setup(t, "ASPECT_CLEAN_SKIP_PROMPT=false")
+
+		var stderr strings.Builder
+		c := config.New(ioutils.Streams{Stderr: &stderr}, cfg)
+		c.Layer = rootConfig.WorkspaceLayer
+		g.Expect(c.Set("clean.skip_prompt", []string{"true"})).To(Succeed())
+		g.Expect(stderr.String()).To(Equal("Warning: clean.skip_prompt is overridden by env:ASPECT_CLEAN_SKIP_PROMPT\n"))
+		data, err := ioutil.ReadFile(workspaceFile)
+		g.Expect(err).To(BeNil())
+		g.Expect(string(data)).To(Equal("version: 1\nstartup_options:\n- --batch\n- --foo\nclean:\n  skip_prompt: true\n"))
+
+		g.Expect(c.Set("clean.skip_prompt", []string{"maybe"})).To(MatchError(`failed to set config: invalid value for config key "clean.skip_prompt": expected a bool`))
+	})
+
+	t.Run("unset removes the key from the file of the layer", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		userFile, _, cfg := setup(t)
+
+		c := config.New(ioutils.Streams{}, cfg)
+		g.Expect(c.Unset("clean.skip_prompt")).To(Succeed())
+		data, err := ioutil.ReadFile(userFile)
+		g.Expect(err).To(BeNil())
+		g.Expect(string(data)).To(BeEmpty())
+
+		err = c.Unset("clean.skip_prompt")
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(err.Error()).To(ContainSubstring(`config key "clean.skip_prompt" is not set in ` + userFile))
+	})
+}
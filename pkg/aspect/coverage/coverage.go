@@ -0,0 +1,179 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package coverage
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"text/tabwriter"
+
+	buildeventstream "aspect.build/cli/bazel/buildeventstream/proto"
+	"aspect.build/cli/pkg/aspecterrors"
+	"aspect.build/cli/pkg/bazel"
+	"aspect.build/cli/pkg/ioutils"
+	"aspect.build/cli/pkg/plugin/system/bep"
+)
+
+// coverageOutputName is the name of the test action output that holds the
+// LCOV coverage of a test, i.e. its coverage.dat file.
+const coverageOutputName = "test.lcov"
+
+// Coverage represents the aspect coverage command.
+type Coverage struct {
+	ioutils.Streams
+	bzl bazel.Bazel
+
+	// Threshold is the minimum percentage of lines that must be covered for
+	// the command to succeed. Zero disables the check.
+	Threshold float64
+	// LCOVOutput is the path the merged LCOV report is written to.
+	LCOVOutput string
+}
+
+// New creates a Coverage command.
+func New(
+	streams ioutils.Streams,
+	bzl bazel.Bazel,
+) *Coverage {
+	return &Coverage{
+		Streams: streams,
+		bzl:     bzl,
+	}
+}
+
+// Run runs the aspect coverage command, calling `bazel coverage` with a local
+// Build Event Protocol backend. The coverage.dat files of the tests are
+// discovered from the test result events and merged into a single LCOV report,
+// and the line coverage of each package is printed.
+func (c *Coverage) Run(args []string, besBackend bep.BESBackend) (exitErr error) {
+	var mutex sync.Mutex
+	var tracefiles []string
+	seen := make(map[string]struct{})
+	besBackend.RegisterSubscriber(func(event *buildeventstream.BuildEvent) error {
+		for _, file := range event.GetTestResult().GetTestActionOutput() {
+			if file.GetName() != coverageOutputName {
+				continue
+			}
+			tracefile, err := localPath(file)
+			if err != nil {
+				label := event.GetId().GetTestResult().GetLabel()
+				return fmt.Errorf("failed to locate the coverage of %s: %w", label, err)
+			}
+			mutex.Lock()
+			if _, ok := seen[tracefile]; !ok {
+				seen[tracefile] = struct{}{}
+				tracefiles = append(tracefiles, tracefile)
+			}
+			mutex.Unlock()
+		}
+		return nil
+	})
+
+	besBackendFlag := fmt.Sprintf("--bes_backend=grpc://%s", besBackend.Addr())
+	exitCode, bazelErr := c.bzl.Spawn(append([]string{"coverage", besBackendFlag}, args...))
+
+	// Process the subscribers errors before the Bazel one.
+	subscriberErrors := besBackend.Errors()
+	if len(subscriberErrors) > 0 {
+		for _, err := range subscriberErrors {
+			fmt.Fprintf(c.Streams.Stderr, "Error: failed to run coverage command: %v\n", err)
+		}
+		exitCode = 1
+	}
+
+	if exitCode != 0 {
+		err := &aspecterrors.ExitError{ExitCode: exitCode}
+		if bazelErr != nil {
+			err.Err = bazelErr
+		}
+		return err
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(tracefiles) == 0 {
+		fmt.Fprintln(c.Streams.Stdout, "No coverage data was reported by the tests.")
+		return nil
+	}
+
+	report := NewReport()
+	for _, tracefile := range tracefiles {
+		if err := mergeTracefile(report, tracefile); err != nil {
+			return fmt.Errorf("failed to run coverage command: %w", err)
+		}
+	}
+	if err := c.writeReport(report); err != nil {
+		return fmt.Errorf("failed to run coverage command: %w", err)
+	}
+
+	c.printSummaries(report)
+	fmt.Fprintf(c.Streams.Stdout, "\nLCOV report written to %s\n", c.LCOVOutput)
+
+	if total := report.Total(); c.Threshold > 0 && total.Percent() < c.Threshold {
+		return &aspecterrors.ExitError{
+			Err:      fmt.Errorf("line coverage %.1f%% is below the threshold of %.1f%%", total.Percent(), c.Threshold),
+			ExitCode: 1,
+		}
+	}
+
+	return nil
+}
+
+func mergeTracefile(report *Report, tracefile string) error {
+	f, err := os.Open(tracefile)
+	if err != nil {
+		return fmt.Errorf("failed to merge coverage report: %w", err)
+	}
+	defer f.Close()
+	if err := report.Merge(f); err != nil {
+		return fmt.Errorf("failed to merge coverage report %s: %w", tracefile, err)
+	}
+	return nil
+}
+
+func (c *Coverage) writeReport(report *Report) error {
+	if err := os.MkdirAll(filepath.Dir(c.LCOVOutput), 0755); err != nil {
+		return fmt.Errorf("failed to write LCOV report: %w", err)
+	}
+	f, err := os.Create(c.LCOVOutput)
+	if err != nil {
+		return fmt.Errorf("failed to write LCOV report: %w", err)
+	}
+	defer f.Close()
+	if err := report.Write(f); err != nil {
+		return fmt.Errorf("failed to write LCOV report: %w", err)
+	}
+	return f.Close()
+}
+
+func (c *Coverage) printSummaries(report *Report) {
+	w := tabwriter.NewWriter(c.Streams.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "Package\tLines\tHit\tCoverage")
+	for _, summary := range report.Summaries() {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%.1f%%\n", summary.Package, summary.LinesFound, summary.LinesHit, summary.Percent())
+	}
+	total := report.Total()
+	fmt.Fprintf(w, "Total\t%d\t%d\t%.1f%%\n", total.LinesFound, total.LinesHit, total.Percent())
+	w.Flush()
+}
+
+// localPath returns the path of a file reported in a build event. Only files
+// on the local disk are supported, which excludes the outputs of remote
+// execution that were not downloaded.
+func localPath(file *buildeventstream.File) (string, error) {
+	u, err := url.Parse(file.GetUri())
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "file" {
+		return "", fmt.Errorf("unsupported URI %q, only local files are supported", file.GetUri())
+	}
+	return u.Path, nil
+}
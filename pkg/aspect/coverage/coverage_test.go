@@ -0,0 +1,175 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package coverage_test
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/gomega"
+
+	buildeventstream "aspect.build/cli/bazel/buildeventstream/proto"
+	"aspect.build/cli/pkg/aspect/coverage"
+	"aspect.build/cli/pkg/aspecterrors"
+	bazel_mock "aspect.build/cli/pkg/bazel/mock"
+	"aspect.build/cli/pkg/ioutils"
+	"aspect.build/cli/pkg/plugin/system/bep"
+	bep_mock "aspect.build/cli/pkg/plugin/system/bep/mock"
+)
+
+func testResultEvent(label string, coverageFile string) *buildeventstream.BuildEvent {
+	return &buildeventstream.BuildEvent{
+		Id: &buildeventstream.BuildEventId{
+			Id: &buildeventstream.BuildEventId_TestResult{
+				TestResult: &buildeventstream.BuildEventId_TestResultId{Label: label},
+			},
+		},
+		Payload: &buildeventstream.BuildEvent_TestResult{
+			TestResult: &buildeventstream.TestResult{
+				TestActionOutput: []*buildeventstream.File{
+					{Name: "test.log", File: &buildeventstream.File_Uri{Uri: "file:///dev/null"}},
+					{Name: "test.lcov", File: &buildeventstream.File_Uri{Uri: "file://" + coverageFile}},
+				},
+			},
+		},
+	}
+}
+
+// expectCoverage sets up the mocks so that `bazel coverage` reports a test
+// result event for each of the given coverage files.
+func expectCoverage(ctrl *gomock.Controller, exitCode int, coverageFiles ...string) (*bazel_mock.MockBazel, *bep_mock.MockBESBackend) {
+	var callback bep.CallbackFn
+	besBackend := bep_mock.NewMockBESBackend(ctrl)
+	besBackend.
+		EXPECT().
+		RegisterSubscriber(gomock.Any()).
+		Do(func(fn bep.CallbackFn) { callback = fn }).
+		Times(1)
+	besBackend.
+		EXPECT().
+		Addr().
+		Return("127.0.0.1:12345").
+		Times(1)
+
+	bzl := bazel_mock.NewMockBazel(ctrl)
+	bzl.
+		EXPECT().
+		Spawn([]string{"coverage", "--bes_backend=grpc://127.0.0.1:12345", "//..."}).
+		DoAndReturn(func(_ []string) (int, error) {
+			var errs []error
+			for i, coverageFile := range coverageFiles {
+				if err := callback(testResultEvent(fmt.Sprintf("//pkg:test_%d", i), coverageFile)); err != nil {
+					errs = append(errs, err)
+				}
+			}
+			besBackend.EXPECT().Errors().Return(errs).Times(1)
+			return exitCode, nil
+		})
+	return bzl, besBackend
+}
+
+func writeTracefile(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCoverage(t *testing.T) {
+	t.Run("the coverage files are merged and summarized", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		dir, err := ioutil.TempDir("", "coverage")
+		g.Expect(err).To(BeNil())
+		defer os.RemoveAll(dir)
+		foo := writeTracefile(t, dir, "foo.dat", fooTracefile)
+		bar := writeTracefile(t, dir, "bar.dat", barTracefile)
+
+		var stdout strings.Builder
+		streams := ioutils.Streams{Stdout: &stdout}
+		bzl, besBackend := expectCoverage(ctrl, 0, foo, bar, foo)
+
+		c := coverage.New(streams, bzl)
+		c.LCOVOutput = filepath.Join(dir, "out", "coverage.lcov")
+		err = c.Run([]string{"//..."}, besBackend)
+
+		g.Expect(err).To(BeNil())
+		g.Expect(stdout.String()).To(Equal(`Package    Lines  Hit  Coverage
+//pkg/foo  3      3    100.0%
+@lib//     1      0    0.0%
+Total      4      3    75.0%
+
+LCOV report written to ` + c.LCOVOutput + "\n"))
+		merged, err := ioutil.ReadFile(c.LCOVOutput)
+		g.Expect(err).To(BeNil())
+		g.Expect(string(merged)).To(ContainSubstring("SF:pkg/foo/foo.go\n"))
+		g.Expect(string(merged)).To(ContainSubstring("DA:3,3\n"))
+	})
+
+	t.Run("when the coverage is below the threshold, the command fails", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		dir, err := ioutil.TempDir("", "coverage")
+		g.Expect(err).To(BeNil())
+		defer os.RemoveAll(dir)
+		foo := writeTracefile(t, dir, "foo.dat", fooTracefile)
+
+		streams := ioutils.Streams{Stdout: ioutil.Discard}
+		bzl, besBackend := expectCoverage(ctrl, 0, foo)
+
+		c := coverage.New(streams, bzl)
+		c.LCOVOutput = filepath.Join(dir, "coverage.lcov")
+		c.Threshold = 80
+		err = c.Run([]string{"//..."}, besBackend)
+
+		g.Expect(err).To(MatchError(&aspecterrors.ExitError{
+			Err:      errors.New("line coverage 66.7% is below the threshold of 80.0%"),
+			ExitCode: 1,
+		}))
+	})
+
+	t.Run("when the bazel runner fails, the exit code is propagated", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		streams := ioutils.Streams{}
+		bzl, besBackend := expectCoverage(ctrl, 3)
+
+		c := coverage.New(streams, bzl)
+		err := c.Run([]string{"//..."}, besBackend)
+
+		g.Expect(err).To(MatchError(&aspecterrors.ExitError{ExitCode: 3}))
+	})
+
+	t.Run("without coverage data, nothing is written", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		var stdout strings.Builder
+		streams := ioutils.Streams{Stdout: &stdout}
+		bzl, besBackend := expectCoverage(ctrl, 0)
+
+		c := coverage.New(streams, bzl)
+		err := c.Run([]string{"//..."}, besBackend)
+
+		g.Expect(err).To(BeNil())
+		g.Expect(stdout.String()).To(Equal("No coverage data was reported by the tests.\n"))
+	})
+}
@@ -0,0 +1,333 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package coverage
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Report is the merged coverage of LCOV tracefiles, keyed by source file.
+// The format is described in
+// https://github.com/linux-test-project/lcov/blob/master/man/geninfo.1.
+type Report struct {
+	sourceFiles map[string]*sourceFile
+}
+
+type sourceFile struct {
+	// lines maps a line number to its execution count.
+	lines map[int]int
+	// functions maps a function name to its start line and execution count.
+	functions map[string]*function
+	// branches maps a branch to the number of times it was taken, or -1 if
+	// the block containing it was never executed.
+	branches map[branch]int
+}
+
+type function struct {
+	line  int
+	count int
+}
+
+type branch struct {
+	line   int
+	block  string
+	branch string
+}
+
+// Summary is the line coverage of a package.
+type Summary struct {
+	Package    string
+	LinesFound int
+	LinesHit   int
+}
+
+// Percent returns the percentage of lines hit. A package without
+// instrumented lines is fully covered.
+func (s Summary) Percent() float64 {
+	if s.LinesFound == 0 {
+		return 100
+	}
+	return 100 * float64(s.LinesHit) / float64(s.LinesFound)
+}
+
+// NewReport creates an empty Report.
+func NewReport() *Report {
+	return &Report{sourceFiles: make(map[string]*sourceFile)}
+}
+
+// Merge adds the coverage of the given LCOV tracefile to the report.
+// The execution counts of the lines, functions and branches found in more than
+// one tracefile are summed.
+func (r *Report) Merge(tracefile io.Reader) error {
+	var current *sourceFile
+	scanner := bufio.NewScanner(tracefile)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if line == "end_of_record" {
+			current = nil
+			continue
+		}
+		i := strings.Index(line, ":")
+		if i < 0 {
+			return fmt.Errorf("line %d: malformed record %q", lineNumber, line)
+		}
+		kind, value := line[:i], line[i+1:]
+		if kind == "SF" {
+			current = r.sourceFile(value)
+			continue
+		}
+		if current == nil {
+			if kind == "TN" {
+				continue
+			}
+			return fmt.Errorf("line %d: record %q outside of a source file", lineNumber, kind)
+		}
+		if err := current.add(kind, strings.Split(value, ",")); err != nil {
+			return fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+	}
+	return scanner.Err()
+}
+
+func (r *Report) sourceFile(name string) *sourceFile {
+	sf, ok := r.sourceFiles[name]
+	if !ok {
+		sf = &sourceFile{
+			lines:     make(map[int]int),
+			functions: make(map[string]*function),
+			branches:  make(map[branch]int),
+		}
+		r.sourceFiles[name] = sf
+	}
+	return sf
+}
+
+func (sf *sourceFile) add(kind string, fields []string) error {
+	switch kind {
+	case "DA":
+		// DA:<line number>,<execution count>[,<checksum>]
+		if len(fields) < 2 {
+			return fmt.Errorf("malformed DA record")
+		}
+		line, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return fmt.Errorf("malformed DA record: %w", err)
+		}
+		count, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return fmt.Errorf("malformed DA record: %w", err)
+		}
+		sf.lines[line] += count
+	case "FN":
+		// FN:<line number of function start>,<function name>
+		if len(fields) < 2 {
+			return fmt.Errorf("malformed FN record")
+		}
+		line, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return fmt.Errorf("malformed FN record: %w", err)
+		}
+		name := strings.Join(fields[1:], ",")
+		if fn, ok := sf.functions[name]; ok {
+			fn.line = line
+		} else {
+			sf.functions[name] = &function{line: line}
+		}
+	case "FNDA":
+		// FNDA:<execution count>,<function name>
+		if len(fields) < 2 {
+			return fmt.Errorf("malformed FNDA record")
+		}
+		count, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return fmt.Errorf("malformed FNDA record: %w", err)
+		}
+		name := strings.Join(fields[1:], ",")
+		if fn, ok := sf.functions[name]; ok {
+			fn.count += count
+		} else {
+			sf.functions[name] = &function{count: count}
+		}
+	case "BRDA":
+		// BRDA:<line number>,<block number>,<branch number>,<taken>
+		if len(fields) != 4 {
+			return fmt.Errorf("malformed BRDA record")
+		}
+		line, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return fmt.Errorf("malformed BRDA record: %w", err)
+		}
+		taken := -1
+		if fields[3] != "-" {
+			if taken, err = strconv.Atoi(fields[3]); err != nil {
+				return fmt.Errorf("malformed BRDA record: %w", err)
+			}
+		}
+		key := branch{line: line, block: fields[1], branch: fields[2]}
+		if previous, ok := sf.branches[key]; ok {
+			switch {
+			case taken < 0:
+				taken = previous
+			case previous > 0:
+				taken += previous
+			}
+		}
+		sf.branches[key] = taken
+	}
+	// The summary records (LF, LH, FNF, FNH, BRF and BRH) are computed when
+	// the report is written, and the test name (TN) is not kept.
+	return nil
+}
+
+// Write writes the report as an LCOV tracefile.
+func (r *Report) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	names := make([]string, 0, len(r.sourceFiles))
+	for name := range r.sourceFiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		sf := r.sourceFiles[name]
+		fmt.Fprintf(bw, "SF:%s\n", name)
+
+		functionNames := make([]string, 0, len(sf.functions))
+		for functionName := range sf.functions {
+			functionNames = append(functionNames, functionName)
+		}
+		sort.Slice(functionNames, func(i, j int) bool {
+			fi, fj := sf.functions[functionNames[i]], sf.functions[functionNames[j]]
+			if fi.line != fj.line {
+				return fi.line < fj.line
+			}
+			return functionNames[i] < functionNames[j]
+		})
+		functionsHit := 0
+		for _, functionName := range functionNames {
+			fmt.Fprintf(bw, "FN:%d,%s\n", sf.functions[functionName].line, functionName)
+		}
+		for _, functionName := range functionNames {
+			fn := sf.functions[functionName]
+			fmt.Fprintf(bw, "FNDA:%d,%s\n", fn.count, functionName)
+			if fn.count > 0 {
+				functionsHit++
+			}
+		}
+		fmt.Fprintf(bw, "FNF:%d\nFNH:%d\n", len(functionNames), functionsHit)
+
+		branches := make([]branch, 0, len(sf.branches))
+		for b := range sf.branches {
+			branches = append(branches, b)
+		}
+		sort.Slice(branches, func(i, j int) bool {
+			if branches[i].line != branches[j].line {
+				return branches[i].line < branches[j].line
+			}
+			if branches[i].block != branches[j].block {
+				return branches[i].block < branches[j].block
+			}
+			return branches[i].branch < branches[j].branch
+		})
+		branchesHit := 0
+		for _, b := range branches {
+			taken := "-"
+			if count := sf.branches[b]; count >= 0 {
+				taken = strconv.Itoa(count)
+				if count > 0 {
+					branchesHit++
+				}
+			}
+			fmt.Fprintf(bw, "BRDA:%d,%s,%s,%s\n", b.line, b.block, b.branch, taken)
+		}
+		fmt.Fprintf(bw, "BRF:%d\nBRH:%d\n", len(branches), branchesHit)
+
+		lines := make([]int, 0, len(sf.lines))
+		for line := range sf.lines {
+			lines = append(lines, line)
+		}
+		sort.Ints(lines)
+		for _, line := range lines {
+			fmt.Fprintf(bw, "DA:%d,%d\n", line, sf.lines[line])
+		}
+		found, hit := sf.lineCounts()
+		fmt.Fprintf(bw, "LF:%d\nLH:%d\nend_of_record\n", found, hit)
+	}
+	return bw.Flush()
+}
+
+// Summaries returns the line coverage of each package, sorted by package.
+// Packages are named after the directories of the source files, as labels.
+func (r *Report) Summaries() []Summary {
+	byPackage := make(map[string]*Summary)
+	for name, sf := range r.sourceFiles {
+		pkg := packageLabel(name)
+		summary, ok := byPackage[pkg]
+		if !ok {
+			summary = &Summary{Package: pkg}
+			byPackage[pkg] = summary
+		}
+		found, hit := sf.lineCounts()
+		summary.LinesFound += found
+		summary.LinesHit += hit
+	}
+
+	summaries := make([]Summary, 0, len(byPackage))
+	for _, summary := range byPackage {
+		summaries = append(summaries, *summary)
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].Package < summaries[j].Package
+	})
+	return summaries
+}
+
+// Total returns the line coverage of all the source files in the report.
+func (r *Report) Total() Summary {
+	total := Summary{}
+	for _, sf := range r.sourceFiles {
+		found, hit := sf.lineCounts()
+		total.LinesFound += found
+		total.LinesHit += hit
+	}
+	return total
+}
+
+func (sf *sourceFile) lineCounts() (found int, hit int) {
+	for _, count := range sf.lines {
+		found++
+		if count > 0 {
+			hit++
+		}
+	}
+	return found, hit
+}
+
+// packageLabel returns the label of the package that contains the given
+// source file path, relative to the execution root.
+func packageLabel(sourceFile string) string {
+	dir := path.Dir(sourceFile)
+	if dir == "." {
+		dir = ""
+	}
+	if strings.HasPrefix(dir, "external/") {
+		parts := strings.SplitN(strings.TrimPrefix(dir, "external/"), "/", 2)
+		if len(parts) == 1 {
+			return "@" + parts[0] + "//"
+		}
+		return "@" + parts[0] + "//" + parts[1]
+	}
+	return "//" + dir
+}
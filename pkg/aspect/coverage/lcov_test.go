@@ -0,0 +1,108 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package coverage_test
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"aspect.build/cli/pkg/aspect/coverage"
+)
+
+const fooTracefile = `TN:
+SF:pkg/foo/foo.go
+FN:3,Foo
+FNDA:1,Foo
+FNF:1
+FNH:1
+BRDA:4,0,0,1
+BRDA:4,0,1,-
+DA:3,1
+DA:4,1
+DA:5,0
+LF:3
+LH:2
+end_of_record
+`
+
+const barTracefile = `SF:pkg/foo/foo.go
+FN:3,Foo
+FNDA:2,Foo
+BRDA:4,0,0,0
+BRDA:4,0,1,1
+DA:3,2
+DA:4,2
+DA:5,1
+end_of_record
+SF:external/lib/lib.go
+DA:1,0
+end_of_record
+`
+
+func TestReport(t *testing.T) {
+	t.Run("tracefiles are merged", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		report := coverage.NewReport()
+		g.Expect(report.Merge(strings.NewReader(fooTracefile))).To(Succeed())
+		g.Expect(report.Merge(strings.NewReader(barTracefile))).To(Succeed())
+
+		var out strings.Builder
+		g.Expect(report.Write(&out)).To(Succeed())
+		g.Expect(out.String()).To(Equal(`SF:external/lib/lib.go
+FNF:0
+FNH:0
+BRF:0
+BRH:0
+DA:1,0
+LF:1
+LH:0
+end_of_record
+SF:pkg/foo/foo.go
+FN:3,Foo
+FNDA:3,Foo
+FNF:1
+FNH:1
+BRDA:4,0,0,1
+BRDA:4,0,1,1
+BRF:2
+BRH:2
+DA:3,3
+DA:4,3
+DA:5,1
+LF:3
+LH:3
+end_of_record
+`))
+	})
+
+	t.Run("the line coverage is summarized per package", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		report := coverage.NewReport()
+		g.Expect(report.Merge(strings.NewReader(fooTracefile))).To(Succeed())
+		g.Expect(report.Merge(strings.NewReader("SF:external/lib/lib.go\nDA:1,0\nDA:2,1\nend_of_record\n"))).To(Succeed())
+
+		g.Expect(report.Summaries()).To(Equal([]coverage.Summary{
+			{Package: "//pkg/foo", LinesFound: 3, LinesHit: 2},
+			{Package: "@lib//", LinesFound: 2, LinesHit: 1},
+		}))
+		g.Expect(report.Total()).To(Equal(coverage.Summary{LinesFound: 5, LinesHit: 3}))
+		g.Expect(report.Total().Percent()).To(Equal(60.0))
+	})
+
+	t.Run("a malformed record fails with its line number", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		report := coverage.NewReport()
+		err := report.Merge(strings.NewReader("SF:foo.go\nDA:1,2\nDA:x,1\n"))
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(err.Error()).To(HavePrefix("line 3: malformed DA record"))
+	})
+}
@@ -7,10 +7,12 @@ Not licensed for re-use.
 package cquery
 
 import (
+	"fmt"
+
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 
 	"aspect.build/cli/pkg/aspect/query/shared"
+	"aspect.build/cli/pkg/aspect/root/config"
 	"aspect.build/cli/pkg/bazel"
 	"aspect.build/cli/pkg/ioutils"
 )
@@ -22,17 +24,31 @@ type CQuery struct {
 	IsInteractive bool
 
 	Presets []*shared.PresetQuery
-	Prefs   viper.Viper
+	Config  *config.Config
+	// History remembers the recent queries and placeholder values of the
+	// workspace.
+	History *shared.History
+	// Save is the name of a preset to save the query as.
+	Save string
+	// Set are the values of the placeholders given by name.
+	Set map[string]string
+	// Output is how the result of the query is output.
+	Output shared.Output
+	// WorkspaceRoot is where the query runs, which Output.Diff checks out
+	// again at a git revision.
+	WorkspaceRoot string
+
+	// PromptLabel prompts for the values of label placeholders, or Prompt
+	// does when it is nil.
+	PromptLabel func(label string) shared.PromptRunner
 
 	Prompt       func(label string) shared.PromptRunner
 	Confirmation func(question string) shared.ConfirmationRunner
 	Select       func(presetNames []string) shared.SelectRunner
 }
 
-func New(streams ioutils.Streams, bzl bazel.Bazel, isInteractive bool) *CQuery {
-	v := *viper.GetViper()
-
-	presets := shared.PrecannedQueries("cquery", v)
+func New(streams ioutils.Streams, cfg *config.Config, bzl bazel.Bazel, isInteractive bool) *CQuery {
+	presets := shared.PrecannedQueries("cquery", cfg.Values().Query.Presets)
 
 	return &CQuery{
 		Streams:       streams,
@@ -42,7 +58,7 @@ func New(streams ioutils.Streams, bzl bazel.Bazel, isInteractive bool) *CQuery {
 		Prompt:        shared.Prompt,
 		Select:        shared.Select,
 		Confirmation:  shared.Confirmation,
-		Prefs:         v,
+		Config:        cfg,
 	}
 }
 
@@ -52,19 +68,41 @@ func (q *CQuery) Run(cmd *cobra.Command, args []string) error {
 		return shared.GetPrettyError(cmd, err)
 	}
 
-	presetVerb, query, runReplacements, err := shared.SelectQuery(cmd.Use, presets, q.Presets, presetNames, q.Streams, args, q.Select)
+	presetVerb, query, runReplacements, err := shared.SelectQuery(cmd.Use, presets, q.Presets, presetNames, q.History.RecentQueries(cmd.Use), q.Streams, args, q.Select)
 
 	if err != nil {
 		return shared.GetPrettyError(cmd, err)
 	}
 
+	if q.Save != "" {
+		if err := shared.SavePreset(q.Config, q.Save, presetVerb, query); err != nil {
+			return shared.GetPrettyError(cmd, err)
+		}
+		fmt.Fprintf(q.Stdout, "Saved preset %q\n", q.Save)
+	}
+
 	if runReplacements {
-		query, err = shared.ReplacePlaceholders(query, args, q.Prompt)
+		query, err = shared.ReplacePlaceholders(query, shared.PlaceholderValues{
+			Set:         q.Set,
+			Args:        args,
+			Prompt:      q.Prompt,
+			PromptLabel: q.PromptLabel,
+			History:     q.History,
+			Stderr:      q.Stderr,
+		})
 
 		if err != nil {
 			return shared.GetPrettyError(cmd, err)
 		}
 	}
 
-	return shared.RunQuery(q.Bzl, presetVerb, query)
+	q.History.AddQuery(presetVerb, query)
+	if err := q.History.Save(); err != nil {
+		return shared.GetPrettyError(cmd, err)
+	}
+
+	if q.Output.Diff != "" {
+		return shared.DiffQuery(q.Bzl, q.Streams, q.WorkspaceRoot, presetVerb, query, q.Output)
+	}
+	return shared.RunQueryOutput(q.Bzl, q.Streams, presetVerb, query, q.Output)
 }
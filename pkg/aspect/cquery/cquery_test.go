@@ -18,6 +18,7 @@ import (
 	"aspect.build/cli/pkg/aspect/cquery"
 	"aspect.build/cli/pkg/aspect/query/shared"
 	query_mock "aspect.build/cli/pkg/aspect/query/shared/mock"
+	"aspect.build/cli/pkg/aspect/root/config"
 	bazel_mock "aspect.build/cli/pkg/bazel/mock"
 	"aspect.build/cli/pkg/ioutils"
 )
@@ -36,7 +37,7 @@ func TestQuery(t *testing.T) {
 
 		var stdout strings.Builder
 		streams := ioutils.Streams{Stdout: &stdout}
-		q := cquery.New(streams, spawner, true)
+		q := cquery.New(streams, &config.Config{}, spawner, true)
 		q.Presets = []*shared.PresetQuery{
 			{
 				Name:        "why",
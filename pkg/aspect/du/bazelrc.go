@@ -0,0 +1,88 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package du
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mitchellh/go-homedir"
+)
+
+// rcCommands are the commands of the .bazelrc lines whose flags apply to
+// builds. Lines of a single command, e.g. `test`, or of a config are ignored.
+var rcCommands = map[string]bool{"common": true, "build": true}
+
+// rcFlags returns the values of the given flags in the .bazelrc files of the
+// workspace and the user, read in the order Bazel reads them so that the last
+// value wins. Relative paths are resolved against the workspace root.
+func rcFlags(workspaceRoot string, names ...string) map[string]string {
+	values := make(map[string]string)
+	rcFiles := []string{filepath.Join(workspaceRoot, ".bazelrc")}
+	if home, err := os.UserHomeDir(); err == nil {
+		rcFiles = append(rcFiles, filepath.Join(home, ".bazelrc"))
+	}
+	scanned := make(map[string]bool)
+	for _, rcFile := range rcFiles {
+		scanRcFlags(rcFile, workspaceRoot, names, values, scanned)
+	}
+	for name, value := range values {
+		if value == "" {
+			continue
+		}
+		if expanded, err := homedir.Expand(value); err == nil {
+			value = expanded
+		}
+		if !filepath.IsAbs(value) {
+			value = filepath.Join(workspaceRoot, value)
+		}
+		values[name] = value
+	}
+	return values
+}
+
+// scanRcFlags sets the values of the given flags found in the given rc file,
+// following its imports where they appear.
+func scanRcFlags(rcFile, workspaceRoot string, names []string, values map[string]string, scanned map[string]bool) {
+	if scanned[rcFile] {
+		return
+	}
+	scanned[rcFile] = true
+	f, err := os.Open(rcFile)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if (fields[0] == "import" || fields[0] == "try-import") && len(fields) > 1 {
+			imported := strings.ReplaceAll(fields[1], "%workspace%", workspaceRoot)
+			scanRcFlags(imported, workspaceRoot, names, values, scanned)
+			continue
+		}
+		if !rcCommands[fields[0]] {
+			continue
+		}
+		args := fields[1:]
+		for i, arg := range args {
+			for _, name := range names {
+				if strings.HasPrefix(arg, name+"=") {
+					values[name] = strings.ReplaceAll(strings.TrimPrefix(arg, name+"="), "%workspace%", workspaceRoot)
+				} else if arg == name && i+1 < len(args) {
+					values[name] = strings.ReplaceAll(args[i+1], "%workspace%", workspaceRoot)
+				}
+			}
+		}
+	}
+}
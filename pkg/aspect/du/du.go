@@ -0,0 +1,281 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package du
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"aspect.build/cli/pkg/bazel"
+	"aspect.build/cli/pkg/ioutils"
+	"aspect.build/cli/pkg/outputbase"
+)
+
+// Category is a kind of data that Bazel keeps on disk for a workspace.
+type Category string
+
+const (
+	// Execroot holds the build outputs and the symlink forest of the sources.
+	Execroot Category = "execroot"
+	// External holds the external repositories fetched by repository rules.
+	External Category = "external"
+	// ActionCache holds the results of the actions that ran locally.
+	ActionCache Category = "action_cache"
+	// Bazelisk holds the Bazel binaries downloaded by Bazelisk, shared by all
+	// workspaces.
+	Bazelisk Category = "bazelisk"
+	// RepositoryCache holds the files downloaded by repository rules, shared
+	// by all workspaces.
+	RepositoryCache Category = "repository_cache"
+	// DiskCache holds the outputs of the actions cached with --disk_cache,
+	// possibly shared by several workspaces.
+	DiskCache Category = "disk_cache"
+)
+
+// Categories lists every category, in the order of the report.
+var Categories = []Category{Execroot, External, ActionCache, Bazelisk, RepositoryCache, DiskCache}
+
+// outputBaseCategories are the categories that live in the output base.
+var outputBaseCategories = []Category{Execroot, External, ActionCache}
+
+// Other is the rest of the output base, e.g. the server state and the logs.
+// It is reported but can't be cleaned on its own.
+const Other Category = "other"
+
+// topExternal is the number of the largest external repositories that are
+// reported.
+const topExternal = 10
+
+// ParseCategory returns the category with the given name.
+func ParseCategory(name string) (Category, error) {
+	names := make([]string, len(Categories))
+	for i, c := range Categories {
+		if string(c) == name {
+			return c, nil
+		}
+		names[i] = string(c)
+	}
+	return "", fmt.Errorf("unknown category %q, expected one of %s", name, strings.Join(names, ", "))
+}
+
+// IsInOutputBase returns whether the category lives in the output base, so
+// that the Bazel server must be shut down before removing it.
+func (c Category) IsInOutputBase() bool {
+	for _, o := range outputBaseCategories {
+		if c == o {
+			return true
+		}
+	}
+	return false
+}
+
+// Locations are the directories where Bazel keeps the data of a workspace.
+type Locations struct {
+	Workspace  string
+	OutputBase string
+	// Dirs is the directory of every category, or "" when the category is not
+	// configured, e.g. the disk cache without --disk_cache.
+	Dirs map[Category]string
+}
+
+// Locate returns the directories where Bazel keeps the data of the given
+// workspace when it runs with the given startup options. They are computed
+// the way Bazel does, without running it, and may not exist yet.
+func Locate(workspaceRoot string, startupOptions []string) (*Locations, error) {
+	outputBase, err := outputbase.ForWorkspace(workspaceRoot, startupOptions)
+	if err != nil {
+		return nil, err
+	}
+	outputUserRoot, err := outputbase.OutputUserRoot(startupOptions)
+	if err != nil {
+		return nil, err
+	}
+	bazelisk, err := bazel.NewBazelisk(workspaceRoot)
+	if err != nil {
+		return nil, err
+	}
+	bazeliskHome, err := bazelisk.Home()
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Locations{
+		Workspace:  workspaceRoot,
+		OutputBase: outputBase,
+		Dirs: map[Category]string{
+			Execroot:        filepath.Join(outputBase, "execroot"),
+			External:        filepath.Join(outputBase, "external"),
+			ActionCache:     filepath.Join(outputBase, "action_cache"),
+			Bazelisk:        filepath.Join(bazeliskHome, "downloads"),
+			RepositoryCache: filepath.Join(outputUserRoot, "cache", "repos", "v1"),
+			DiskCache:       "",
+		},
+	}
+	flags := rcFlags(workspaceRoot, "--repository_cache", "--disk_cache")
+	if dir, ok := flags["--repository_cache"]; ok {
+		l.Dirs[RepositoryCache] = dir
+	}
+	if dir, ok := flags["--disk_cache"]; ok {
+		l.Dirs[DiskCache] = dir
+	}
+	return l, nil
+}
+
+// Usage is the disk space used by a category.
+type Usage struct {
+	Category Category
+	Path     string
+	// Size is the disk space used, in bytes.
+	Size int64
+}
+
+// Measure returns the disk space used by every category of l, plus the rest
+// of the output base.
+func Measure(l *Locations) ([]Usage, error) {
+	usages := make([]Usage, 0, len(Categories)+1)
+	var outputBaseCategoriesSize int64
+	for _, c := range Categories {
+		u := Usage{Category: c, Path: l.Dirs[c]}
+		if u.Path != "" {
+			size, err := outputbase.DirSize(u.Path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to measure the disk usage: %w", err)
+			}
+			u.Size = size
+		}
+		if c.IsInOutputBase() {
+			outputBaseCategoriesSize += u.Size
+		}
+		usages = append(usages, u)
+	}
+	outputBaseSize, err := outputbase.DirSize(l.OutputBase)
+	if err != nil {
+		return nil, fmt.Errorf("failed to measure the disk usage: %w", err)
+	}
+	usages = append(usages, Usage{Category: Other, Path: l.OutputBase, Size: outputBaseSize - outputBaseCategoriesSize})
+	return usages, nil
+}
+
+// repository is the disk usage of an external repository.
+type repository struct {
+	name string
+	size int64
+}
+
+// largestRepositories returns the disk usage of the n largest external
+// repositories under the given directory.
+func largestRepositories(external string, n int) ([]repository, error) {
+	entries, err := ioutil.ReadDir(external)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to measure the disk usage: %w", err)
+	}
+	var repos []repository
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		size, err := outputbase.DirSize(filepath.Join(external, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to measure the disk usage: %w", err)
+		}
+		repos = append(repos, repository{name: entry.Name(), size: size})
+	}
+	sort.SliceStable(repos, func(i, j int) bool {
+		return repos[i].size > repos[j].size
+	})
+	if len(repos) > n {
+		repos = repos[:n]
+	}
+	return repos, nil
+}
+
+// DU represents the aspect du command.
+type DU struct {
+	ioutils.Streams
+	Locations *Locations
+}
+
+// New creates a DU command.
+func New(streams ioutils.Streams, locations *Locations) *DU {
+	return &DU{
+		Streams:   streams,
+		Locations: locations,
+	}
+}
+
+// Run prints the disk usage report of the workspace.
+func (d *DU) Run(_ *cobra.Command, _ []string) error {
+	return d.Report(nil)
+}
+
+// Report prints the disk space used by every category. The given categories
+// are marked as the ones that a clean would remove, with the space it would
+// reclaim.
+func (d *DU) Report(removed []Category) error {
+	usages, err := Measure(d.Locations)
+	if err != nil {
+		return err
+	}
+	repos, err := largestRepositories(d.Locations.Dirs[External], topExternal)
+	if err != nil {
+		return err
+	}
+	isRemoved := make(map[Category]bool, len(removed))
+	for _, c := range removed {
+		isRemoved[c] = true
+	}
+
+	fmt.Fprintf(d.Stdout, "Disk usage of %s (output base %s):\n\n", d.Locations.Workspace, d.Locations.OutputBase)
+	w := tabwriter.NewWriter(d.Stdout, 0, 0, 2, ' ', 0)
+	header := "Category\tSize\tPath"
+	if removed != nil {
+		header = "  " + header
+	}
+	fmt.Fprintln(w, header)
+	var reclaimed int64
+	for _, u := range usages {
+		name := string(u.Category)
+		if removed != nil {
+			if isRemoved[u.Category] {
+				name = "* " + name
+				reclaimed += u.Size
+			} else {
+				name = "  " + name
+			}
+		}
+		if u.Path == "" {
+			fmt.Fprintf(w, "%s\t-\tnot configured\n", name)
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", name, outputbase.FormatSize(u.Size), u.Path)
+	}
+	w.Flush()
+
+	if len(repos) > 0 {
+		fmt.Fprintf(d.Stdout, "\nLargest external repositories:\n")
+		w = tabwriter.NewWriter(d.Stdout, 0, 0, 2, ' ', 0)
+		for _, repo := range repos {
+			fmt.Fprintf(w, "  %s\t%s\n", repo.name, outputbase.FormatSize(repo.size))
+		}
+		w.Flush()
+	}
+
+	if removed != nil {
+		fmt.Fprintf(d.Stdout, "\nThe clean would remove the categories marked with * and reclaim %s.\n", outputbase.FormatSize(reclaimed))
+	}
+	return nil
+}
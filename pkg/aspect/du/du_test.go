@@ -0,0 +1,155 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package du_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"aspect.build/cli/pkg/aspect/du"
+	"aspect.build/cli/pkg/ioutils"
+)
+
+// writeFiles creates the given files under dir.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for file, content := range files {
+		path := filepath.Join(dir, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// setup creates a workspace, and a home and a Bazelisk home that don't leak
+// the ones of the user running the tests.
+func setup(t *testing.T) string {
+	dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "du")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	t.Setenv("HOME", filepath.Join(dir, "home"))
+	t.Setenv("BAZELISK_HOME", filepath.Join(dir, "bazelisk"))
+	return dir
+}
+
+func TestLocate(t *testing.T) {
+	t.Run("the caches are read from the .bazelrc files", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		dir := setup(t)
+		workspace := filepath.Join(dir, "ws")
+		writeFiles(t, dir, map[string]string{
+			"ws/.bazelrc": `# Caches
+build --disk_cache=~/disk_cache
+test --disk_cache=/ignored
+build:ci --disk_cache=/ignored
+try-import %workspace%/user.bazelrc
+`,
+			"ws/user.bazelrc":    "common --repository_cache repos\n",
+			"home/.bazelrc":      "build --disk_cache=%workspace%/cache\n",
+			"ws/WORKSPACE.bazel": "",
+		})
+
+		l, err := du.Locate(workspace, []string{"--output_user_root=/tmp/bazel", "--output_base=/tmp/bazel/ob"})
+		g.Expect(err).To(BeNil())
+		g.Expect(l.OutputBase).To(Equal("/tmp/bazel/ob"))
+		g.Expect(l.Dirs).To(Equal(map[du.Category]string{
+			du.Execroot:        "/tmp/bazel/ob/execroot",
+			du.External:        "/tmp/bazel/ob/external",
+			du.ActionCache:     "/tmp/bazel/ob/action_cache",
+			du.Bazelisk:        filepath.Join(dir, "bazelisk", "downloads"),
+			du.RepositoryCache: filepath.Join(workspace, "repos"),
+			du.DiskCache:       filepath.Join(workspace, "cache"),
+		}))
+	})
+
+	t.Run("the caches have Bazel's defaults", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		dir := setup(t)
+		workspace := filepath.Join(dir, "ws")
+		writeFiles(t, dir, map[string]string{"ws/WORKSPACE.bazel": ""})
+
+		l, err := du.Locate(workspace, []string{"--output_user_root=/tmp/bazel"})
+		g.Expect(err).To(BeNil())
+		g.Expect(filepath.Dir(l.OutputBase)).To(Equal("/tmp/bazel"))
+		g.Expect(l.Dirs[du.RepositoryCache]).To(Equal("/tmp/bazel/cache/repos/v1"))
+		g.Expect(l.Dirs[du.DiskCache]).To(BeEmpty())
+	})
+}
+
+func TestParseCategory(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	c, err := du.ParseCategory("bazelisk")
+	g.Expect(err).To(BeNil())
+	g.Expect(c).To(Equal(du.Bazelisk))
+
+	_, err = du.ParseCategory("other")
+	g.Expect(err).To(MatchError(`unknown category "other", expected one of execroot, external, action_cache, bazelisk, repository_cache, disk_cache`))
+}
+
+func TestReport(t *testing.T) {
+	dir := setup(t)
+	writeFiles(t, dir, map[string]string{
+		"ob/DO_NOT_BUILD_HERE":                   "/ws",
+		"ob/execroot/ws/bazel-out/out.js":        "console.log(1)",
+		"ob/external/npm/package.json":           "{\"name\": \"npm\"}",
+		"ob/external/rules_go/WORKSPACE":         "",
+		"ob/action_cache/action_cache_v13.blaze": "12345678",
+		"bazelisk/downloads/bazel":               "#!/bin/sh",
+	})
+	l := &du.Locations{
+		Workspace:  "/ws",
+		OutputBase: filepath.Join(dir, "ob"),
+		Dirs: map[du.Category]string{
+			du.Execroot:        filepath.Join(dir, "ob", "execroot"),
+			du.External:        filepath.Join(dir, "ob", "external"),
+			du.ActionCache:     filepath.Join(dir, "ob", "action_cache"),
+			du.Bazelisk:        filepath.Join(dir, "bazelisk", "downloads"),
+			du.RepositoryCache: filepath.Join(dir, "repos"),
+			du.DiskCache:       "",
+		},
+	}
+
+	t.Run("the disk usage is broken down by category", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		var stdout strings.Builder
+		g.Expect(du.New(ioutils.Streams{Stdout: &stdout}, l).Run(nil, nil)).To(Succeed())
+		g.Expect(stdout.String()).To(Equal(`Disk usage of /ws (output base ` + dir + `/ob):
+
+Category          Size  Path
+execroot          14 B  ` + dir + `/ob/execroot
+external          15 B  ` + dir + `/ob/external
+action_cache      8 B   ` + dir + `/ob/action_cache
+bazelisk          9 B   ` + dir + `/bazelisk/downloads
+repository_cache  0 B   ` + dir + `/repos
+disk_cache        -     not configured
+other             3 B   ` + dir + `/ob
+
+Largest external repositories:
+  npm       15 B
+  rules_go  0 B
+`))
+	})
+
+	t.Run("the categories that a clean would remove are marked", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		var stdout strings.Builder
+		g.Expect(du.New(ioutils.Streams{Stdout: &stdout}, l).Report([]du.Category{du.Execroot, du.ActionCache})).To(Succeed())
+		g.Expect(stdout.String()).To(ContainSubstring("* execroot          14 B"))
+		g.Expect(stdout.String()).To(ContainSubstring("  external          15 B"))
+		g.Expect(stdout.String()).To(HaveSuffix("The clean would remove the categories marked with * and reclaim 22 B.\n"))
+	})
+}
@@ -0,0 +1,187 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package explaincache
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The field numbers of the SpawnExec message of Bazel's spawn.proto, and of
+// the messages it contains.
+const (
+	spawnExecCommandArgs          protowire.Number = 1
+	spawnExecEnvironmentVariables protowire.Number = 2
+	spawnExecInputs               protowire.Number = 4
+	spawnExecListedOutputs        protowire.Number = 5
+	spawnExecCacheable            protowire.Number = 7
+	spawnExecMnemonic             protowire.Number = 10
+	spawnExecActualOutputs        protowire.Number = 11
+	spawnExecRunner               protowire.Number = 12
+	spawnExecCacheHit             protowire.Number = 13
+	spawnExecTargetLabel          protowire.Number = 18
+
+	environmentVariableName  protowire.Number = 1
+	environmentVariableValue protowire.Number = 2
+
+	filePath   protowire.Number = 1
+	fileDigest protowire.Number = 2
+
+	digestHash protowire.Number = 1
+)
+
+// spawnExec is a spawn that Bazel executed, or got from a cache, as logged by
+// --execution_log_binary_file.
+type spawnExec struct {
+	CommandArgs []string
+	Environment map[string]string
+	// Inputs are the digests of the inputs by their paths.
+	Inputs        map[string]string
+	ListedOutputs []string
+	// ActualOutputs are the digests of the outputs by their paths.
+	ActualOutputs map[string]string
+	Cacheable     bool
+	Mnemonic      string
+	// Runner is how the spawn ran, e.g. linux-sandbox or disk cache hit.
+	Runner      string
+	CacheHit    bool
+	TargetLabel string
+}
+
+// readExecutionLog reads the spawns of an execution log written by
+// --execution_log_binary_file, which is a stream of length delimited
+// SpawnExec messages.
+func readExecutionLog(path string) ([]*spawnExec, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the execution log: %w", err)
+	}
+	var spawns []*spawnExec
+	for len(data) > 0 {
+		message, n := protowire.ConsumeBytes(data)
+		if n < 0 {
+			return nil, fmt.Errorf("failed to read the execution log %s: %w", path, protowire.ParseError(n))
+		}
+		data = data[n:]
+		spawn, err := parseSpawnExec(message)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the execution log %s: %w", path, err)
+		}
+		spawns = append(spawns, spawn)
+	}
+	return spawns, nil
+}
+
+func parseSpawnExec(message []byte) (*spawnExec, error) {
+	spawn := &spawnExec{
+		Environment:   make(map[string]string),
+		Inputs:        make(map[string]string),
+		ActualOutputs: make(map[string]string),
+	}
+	err := consumeMessage(message, func(num protowire.Number, value []byte, varint uint64) error {
+		switch num {
+		case spawnExecCommandArgs:
+			spawn.CommandArgs = append(spawn.CommandArgs, string(value))
+		case spawnExecEnvironmentVariables:
+			var name, val string
+			err := consumeMessage(value, func(num protowire.Number, value []byte, _ uint64) error {
+				switch num {
+				case environmentVariableName:
+					name = string(value)
+				case environmentVariableValue:
+					val = string(value)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			spawn.Environment[name] = val
+		case spawnExecInputs, spawnExecActualOutputs:
+			path, digest, err := parseFile(value)
+			if err != nil {
+				return err
+			}
+			if num == spawnExecInputs {
+				spawn.Inputs[path] = digest
+			} else {
+				spawn.ActualOutputs[path] = digest
+			}
+		case spawnExecListedOutputs:
+			spawn.ListedOutputs = append(spawn.ListedOutputs, string(value))
+		case spawnExecCacheable:
+			spawn.Cacheable = varint != 0
+		case spawnExecMnemonic:
+			spawn.Mnemonic = string(value)
+		case spawnExecRunner:
+			spawn.Runner = string(value)
+		case spawnExecCacheHit:
+			spawn.CacheHit = varint != 0
+		case spawnExecTargetLabel:
+			spawn.TargetLabel = string(value)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return spawn, nil
+}
+
+// parseFile parses a File message into its path and the hash of its digest.
+func parseFile(message []byte) (string, string, error) {
+	var path, hash string
+	err := consumeMessage(message, func(num protowire.Number, value []byte, _ uint64) error {
+		switch num {
+		case filePath:
+			path = string(value)
+		case fileDigest:
+			return consumeMessage(value, func(num protowire.Number, value []byte, _ uint64) error {
+				if num == digestHash {
+					hash = string(value)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	return path, hash, err
+}
+
+// consumeMessage calls field with each field of a message, with the value of
+// a length delimited field, or of a varint one. The other fields are skipped.
+func consumeMessage(message []byte, field func(num protowire.Number, value []byte, varint uint64) error) error {
+	for len(message) > 0 {
+		num, typ, n := protowire.ConsumeTag(message)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		message = message[n:]
+		var value []byte
+		var varint uint64
+		switch typ {
+		case protowire.BytesType:
+			value, n = protowire.ConsumeBytes(message)
+		case protowire.VarintType:
+			varint, n = protowire.ConsumeVarint(message)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, message)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		message = message[n:]
+		if typ == protowire.BytesType || typ == protowire.VarintType {
+			if err := field(num, value, varint); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package explaincache
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+
+	"aspect.build/cli/pkg/aspect/build"
+	"aspect.build/cli/pkg/bazel"
+	"aspect.build/cli/pkg/ioutils"
+	"aspect.build/cli/pkg/outputbase"
+	"aspect.build/cli/pkg/plugin/system/bep"
+)
+
+// digestLength is how many characters of a digest are printed.
+const digestLength = 12
+
+// ExplainCache represents the aspect explain-cache command.
+type ExplainCache struct {
+	ioutils.Streams
+	bzl bazel.Bazel
+}
+
+// New creates an ExplainCache command.
+func New(streams ioutils.Streams, bzl bazel.Bazel) *ExplainCache {
+	return &ExplainCache{
+		Streams: streams,
+		bzl:     bzl,
+	}
+}
+
+// Run builds the targets twice with an execution log. The second build runs in
+// a new output base, so that it can only reuse the results of the first one
+// from the disk or remote cache, while the output base of the workspace keeps
+// its incremental state. It then explains each action of the second build that
+// missed the cache with the first input, environment variable or argument that
+// differs from the first build.
+func (e *ExplainCache) Run(args []string, besBackend bep.BESBackend) error {
+	dir, err := ioutil.TempDir("", "aspect-explain-cache")
+	if err != nil {
+		return fmt.Errorf("failed to explain the cache misses: %w", err)
+	}
+	defer outputbase.Remove(dir)
+
+	logs := []string{filepath.Join(dir, "first.log"), filepath.Join(dir, "second.log")}
+	for i, log := range logs {
+		b := build.New(e.Streams, e.bzl)
+		if i > 0 {
+			fmt.Fprintln(e.Stderr, "Building again in a new output base, so that the second build can only hit the disk or remote cache")
+			b.StartupOptions = []string{"--output_base=" + filepath.Join(dir, "output_base")}
+		}
+		buildArgs := append([]string{"--execution_log_binary_file=" + log}, args...)
+		err := b.Run(buildArgs, besBackend)
+		if i > 0 {
+			// The build leaves a Bazel server running in the new output base,
+			// which must stop before the output base can be removed.
+			e.bzl.Run([]string{"shutdown"}, bazel.RunOptions{
+				StartupOptions: b.StartupOptions,
+				Streams:        ioutils.Streams{Stdout: ioutil.Discard, Stderr: ioutil.Discard},
+			})
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	first, err := readExecutionLog(logs[0])
+	if err != nil {
+		return err
+	}
+	second, err := readExecutionLog(logs[1])
+	if err != nil {
+		return err
+	}
+	e.explain(first, second)
+	return nil
+}
+
+// explain prints the spawns of the second build that missed the cache, and
+// why.
+func (e *ExplainCache) explain(first []*spawnExec, second []*spawnExec) {
+	firstSpawns := make(map[string]*spawnExec, len(first))
+	for _, spawn := range first {
+		firstSpawns[spawnKey(spawn)] = spawn
+	}
+
+	var misses []*spawnExec
+	for _, spawn := range second {
+		if !spawn.CacheHit {
+			misses = append(misses, spawn)
+		}
+	}
+	fmt.Fprintf(e.Stdout, "%d of %d action(s) missed the cache in the second build\n", len(misses), len(second))
+	for _, spawn := range misses {
+		fmt.Fprintf(e.Stdout, "\n%s %s", spawn.Mnemonic, spawn.TargetLabel)
+		if len(spawn.ListedOutputs) > 0 {
+			fmt.Fprintf(e.Stdout, " → %s", spawn.ListedOutputs[0])
+		}
+		fmt.Fprintf(e.Stdout, " (%s)\n", spawn.Runner)
+		for _, reason := range explainMiss(firstSpawns[spawnKey(spawn)], spawn) {
+			fmt.Fprintf(e.Stdout, "  %s\n", reason)
+		}
+	}
+}
+
+// spawnKey identifies a spawn across two builds.
+func spawnKey(spawn *spawnExec) string {
+	output := ""
+	if len(spawn.ListedOutputs) > 0 {
+		output = spawn.ListedOutputs[0]
+	}
+	return fmt.Sprintf("%s %s %s", spawn.Mnemonic, spawn.TargetLabel, output)
+}
+
+// explainMiss returns why a spawn of the second build missed the cache, given
+// the same spawn in the first build.
+func explainMiss(first *spawnExec, second *spawnExec) []string {
+	if !second.Cacheable {
+		return []string{"the action isn't cacheable, e.g. it is tagged no-cache or no-remote"}
+	}
+	if first == nil {
+		return []string{"the action didn't run in the first build"}
+	}
+	if reason := firstDifference(first, second); reason != "" {
+		return []string{reason}
+	}
+
+	reasons := []string{"no input differs, so the first build didn't store the result in a cache, e.g. it ran without --disk_cache or --remote_cache"}
+	for _, path := range sortedKeys(second.ActualOutputs) {
+		if digest, ok := first.ActualOutputs[path]; ok && digest != second.ActualOutputs[path] {
+			reasons = append(reasons, fmt.Sprintf("output %s: %s → %s, so the action isn't deterministic", path, short(digest), short(second.ActualOutputs[path])))
+			break
+		}
+	}
+	return reasons
+}
+
+// firstDifference returns the first argument, environment variable or input
+// that differs between two runs of a spawn, or "" when they are the same.
+func firstDifference(first *spawnExec, second *spawnExec) string {
+	for i := 0; i < len(first.CommandArgs) && i < len(second.CommandArgs); i++ {
+		if first.CommandArgs[i] != second.CommandArgs[i] {
+			return fmt.Sprintf("argument %d: %s → %s", i, first.CommandArgs[i], second.CommandArgs[i])
+		}
+	}
+	if len(first.CommandArgs) != len(second.CommandArgs) {
+		return fmt.Sprintf("arguments: %d → %d", len(first.CommandArgs), len(second.CommandArgs))
+	}
+
+	for _, name := range sortedKeys(union(first.Environment, second.Environment)) {
+		before, inFirst := first.Environment[name]
+		after, inSecond := second.Environment[name]
+		switch {
+		case !inFirst:
+			return fmt.Sprintf("+ env %s=%s", name, after)
+		case !inSecond:
+			return fmt.Sprintf("- env %s", name)
+		case before != after:
+			return fmt.Sprintf("env %s: %s → %s", name, before, after)
+		}
+	}
+
+	for _, path := range sortedKeys(union(first.Inputs, second.Inputs)) {
+		before, inFirst := first.Inputs[path]
+		after, inSecond := second.Inputs[path]
+		switch {
+		case !inFirst:
+			return fmt.Sprintf("+ input %s", path)
+		case !inSecond:
+			return fmt.Sprintf("- input %s", path)
+		case before != after:
+			return fmt.Sprintf("input %s: %s → %s", path, short(before), short(after))
+		}
+	}
+	return ""
+}
+
+func short(digest string) string {
+	if len(digest) > digestLength {
+		return digest[:digestLength]
+	}
+	return digest
+}
+
+func union(a map[string]string, b map[string]string) map[string]string {
+	m := make(map[string]string, len(a)+len(b))
+	for key, value := range a {
+		m[key] = value
+	}
+	for key, value := range b {
+		m[key] = value
+	}
+	return m
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,259 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package explaincache_test
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/gomega"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"aspect.build/cli/pkg/aspect/explaincache"
+	"aspect.build/cli/pkg/bazel"
+	bazel_mock "aspect.build/cli/pkg/bazel/mock"
+	"aspect.build/cli/pkg/ioutils"
+	bep_mock "aspect.build/cli/pkg/plugin/system/bep/mock"
+)
+
+// spawn is a SpawnExec message of the execution log.
+type spawn struct {
+	args      []string
+	env       [][2]string
+	inputs    [][2]string
+	outputs   [][2]string
+	mnemonic  string
+	target    string
+	cacheable bool
+	cacheHit  bool
+}
+
+func (s spawn) marshal() []byte {
+	file := func(path string, hash string) []byte {
+		digest := protowire.AppendTag(nil, 1, protowire.BytesType)
+		digest = protowire.AppendString(digest, hash)
+		b := protowire.AppendTag(nil, 1, protowire.BytesType)
+		b = protowire.AppendString(b, path)
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		return protowire.AppendBytes(b, digest)
+	}
+	var b []byte
+	for _, arg := range s.args {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendString(b, arg)
+	}
+	for _, env := range s.env {
+		v := protowire.AppendTag(nil, 1, protowire.BytesType)
+		v = protowire.AppendString(v, env[0])
+		v = protowire.AppendTag(v, 2, protowire.BytesType)
+		v = protowire.AppendString(v, env[1])
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendBytes(b, v)
+	}
+	for _, input := range s.inputs {
+		b = protowire.AppendTag(b, 4, protowire.BytesType)
+		b = protowire.AppendBytes(b, file(input[0], input[1]))
+	}
+	for _, output := range s.outputs {
+		b = protowire.AppendTag(b, 5, protowire.BytesType)
+		b = protowire.AppendString(b, output[0])
+		b = protowire.AppendTag(b, 11, protowire.BytesType)
+		b = protowire.AppendBytes(b, file(output[0], output[1]))
+	}
+	b = protowire.AppendTag(b, 7, protowire.VarintType)
+	b = protowire.AppendVarint(b, protowire.EncodeBool(s.cacheable))
+	b = protowire.AppendTag(b, 10, protowire.BytesType)
+	b = protowire.AppendString(b, s.mnemonic)
+	b = protowire.AppendTag(b, 12, protowire.BytesType)
+	if s.cacheHit {
+		b = protowire.AppendString(b, "disk cache hit")
+	} else {
+		b = protowire.AppendString(b, "linux-sandbox")
+	}
+	b = protowire.AppendTag(b, 13, protowire.VarintType)
+	b = protowire.AppendVarint(b, protowire.EncodeBool(s.cacheHit))
+	b = protowire.AppendTag(b, 18, protowire.BytesType)
+	return protowire.AppendString(b, s.target)
+}
+
+// writeLog returns a Run of a build that writes the spawns to the execution
+// log of the build.
+func writeLog(spawns ...spawn) func(command []string, options bazel.RunOptions) (int, error) {
+	return func(command []string, options bazel.RunOptions) (int, error) {
+		var log []byte
+		for _, s := range spawns {
+			log = protowire.AppendBytes(log, s.marshal())
+		}
+		for _, arg := range command {
+			if strings.HasPrefix(arg, "--execution_log_binary_file=") {
+				return 0, ioutil.WriteFile(strings.TrimPrefix(arg, "--execution_log_binary_file="), log, 0644)
+			}
+		}
+		return 1, nil
+	}
+}
+
+// inNewOutputBase matches the options of a Bazel invocation in an output base
+// other than the one of the workspace.
+type inNewOutputBase struct{}
+
+func (inNewOutputBase) Matches(x interface{}) bool {
+	options, ok := x.(bazel.RunOptions)
+	return ok && len(options.StartupOptions) == 1 && strings.HasPrefix(options.StartupOptions[0], "--output_base=")
+}
+
+func (inNewOutputBase) String() string {
+	return "runs in a new output base"
+}
+
+func TestExplainCache(t *testing.T) {
+	compile := spawn{
+		args:      []string{"compile", "-o", "bazel-out/k8-fastbuild/bin/lib/a.a", "lib/a.go"},
+		env:       [][2]string{{"GOOS", "linux"}},
+		inputs:    [][2]string{{"lib/a.go", "0123456789abcdef"}, {"lib/stamp.txt", "1111"}},
+		outputs:   [][2]string{{"bazel-out/k8-fastbuild/bin/lib/a.a", "aaaa"}},
+		mnemonic:  "GoCompile",
+		target:    "//lib:a",
+		cacheable: true,
+	}
+	link := spawn{
+		args:      []string{"link", "-o", "bazel-out/k8-fastbuild/bin/app/bin"},
+		inputs:    [][2]string{{"bazel-out/k8-fastbuild/bin/lib/a.a", "aaaa"}},
+		outputs:   [][2]string{{"bazel-out/k8-fastbuild/bin/app/bin", "bbbb"}},
+		mnemonic:  "GoLink",
+		target:    "//app:bin",
+		cacheable: true,
+	}
+
+	t.Run("the actions that missed the cache are explained", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		changedCompile := compile
+		changedCompile.inputs = [][2]string{{"lib/a.go", "0123456789abcdef"}, {"lib/stamp.txt", "2222"}}
+		changedCompile.outputs = [][2]string{{"bazel-out/k8-fastbuild/bin/lib/a.a", "cccc"}}
+		changedLink := link
+		changedLink.inputs = [][2]string{{"bazel-out/k8-fastbuild/bin/lib/a.a", "cccc"}}
+		cachedLink := link
+		cachedLink.cacheHit = true
+
+		bzl := bazel_mock.NewMockBazel(ctrl)
+		gomock.InOrder(
+			bzl.
+				EXPECT().
+				Run(gomock.Any(), bazel.RunOptions{}).
+				DoAndReturn(writeLog(compile, link)),
+			bzl.
+				EXPECT().
+				Run(gomock.Any(), inNewOutputBase{}).
+				DoAndReturn(writeLog(changedCompile, changedLink)),
+			bzl.
+				EXPECT().
+				Run([]string{"shutdown"}, inNewOutputBase{}).
+				Return(0, nil),
+		)
+		besBackend := bep_mock.NewMockBESBackend(ctrl)
+		besBackend.
+			EXPECT().
+			Addr().
+			Return("127.0.0.1:12345").
+			Times(2)
+		besBackend.
+			EXPECT().
+			Errors().
+			Times(2)
+
+		var stdout strings.Builder
+		e := explaincache.New(ioutils.Streams{Stdout: &stdout, Stderr: ioutil.Discard}, bzl)
+		g.Expect(e.Run([]string{"//app:bin"}, besBackend)).To(Succeed())
+		g.Expect(stdout.String()).To(Equal(`2 of 2 action(s) missed the cache in the second build
+
+GoCompile //lib:a → bazel-out/k8-fastbuild/bin/lib/a.a (linux-sandbox)
+  input lib/stamp.txt: 1111 → 2222
+
+GoLink //app:bin → bazel-out/k8-fastbuild/bin/app/bin (linux-sandbox)
+  input bazel-out/k8-fastbuild/bin/lib/a.a: aaaa → cccc
+`))
+	})
+
+	t.Run("an action whose inputs didn't change wasn't cached by the first build", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		cachedCompile := compile
+		cachedCompile.cacheHit = true
+		nondeterministicLink := link
+		nondeterministicLink.outputs = [][2]string{{"bazel-out/k8-fastbuild/bin/app/bin", "dddd"}}
+		uncacheable := spawn{mnemonic: "Genrule", target: "//app:gen"}
+
+		bzl := bazel_mock.NewMockBazel(ctrl)
+		gomock.InOrder(
+			bzl.
+				EXPECT().
+				Run(gomock.Any(), bazel.RunOptions{}).
+				DoAndReturn(writeLog(compile, link, uncacheable)),
+			bzl.
+				EXPECT().
+				Run(gomock.Any(), inNewOutputBase{}).
+				DoAndReturn(writeLog(cachedCompile, nondeterministicLink, uncacheable)),
+			bzl.
+				EXPECT().
+				Run([]string{"shutdown"}, inNewOutputBase{}).
+				Return(0, nil),
+		)
+		besBackend := bep_mock.NewMockBESBackend(ctrl)
+		besBackend.
+			EXPECT().
+			Addr().
+			Return("127.0.0.1:12345").
+			Times(2)
+		besBackend.
+			EXPECT().
+			Errors().
+			Times(2)
+
+		var stdout strings.Builder
+		e := explaincache.New(ioutils.Streams{Stdout: &stdout, Stderr: ioutil.Discard}, bzl)
+		g.Expect(e.Run([]string{"//app:bin"}, besBackend)).To(Succeed())
+		g.Expect(stdout.String()).To(Equal(`2 of 3 action(s) missed the cache in the second build
+
+GoLink //app:bin → bazel-out/k8-fastbuild/bin/app/bin (linux-sandbox)
+  no input differs, so the first build didn't store the result in a cache, e.g. it ran without --disk_cache or --remote_cache
+  output bazel-out/k8-fastbuild/bin/app/bin: bbbb → dddd, so the action isn't deterministic
+
+Genrule //app:gen (linux-sandbox)
+  the action isn't cacheable, e.g. it is tagged no-cache or no-remote
+`))
+	})
+
+	t.Run("a failed build isn't explained", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		bzl := bazel_mock.NewMockBazel(ctrl)
+		bzl.
+			EXPECT().
+			Run(gomock.Any(), bazel.RunOptions{}).
+			Return(1, nil)
+		besBackend := bep_mock.NewMockBESBackend(ctrl)
+		besBackend.
+			EXPECT().
+			Addr().
+			Return("127.0.0.1:12345")
+		besBackend.
+			EXPECT().
+			Errors()
+
+		e := explaincache.New(ioutils.Streams{Stderr: ioutil.Discard}, bzl)
+		g.Expect(e.Run([]string{"//app:bin"}, besBackend)).NotTo(Succeed())
+	})
+}
@@ -7,7 +7,11 @@ Not licensed for re-use.
 package info
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
 
 	"aspect.build/cli/pkg/bazel"
 	"aspect.build/cli/pkg/interceptors"
@@ -20,33 +24,83 @@ import (
 type Info struct {
 	ioutils.Streams
 
+	bzl bazel.Bazel
+
 	ShowMakeEnv bool
+	// Output is the output format, text or json.
+	Output string
 }
 
-func New(streams ioutils.Streams) *Info {
+func New(streams ioutils.Streams, bzl bazel.Bazel) *Info {
 	return &Info{
 		Streams: streams,
+		bzl:     bzl,
 	}
 }
 
 func (v *Info) Run(ctx context.Context, _ *cobra.Command, args []string) error {
-	bazelCmd := []string{"info"}
-	if v.ShowMakeEnv {
-		// Propagate the flag
-		bazelCmd = append(bazelCmd, "--show_make_env")
+	if v.Output != "" && v.Output != "text" && v.Output != "json" {
+		return fmt.Errorf("invalid --output %q: the output must be text or json", v.Output)
 	}
-	bazelCmd = append(bazelCmd, args...)
-	bzl := bazel.New()
 	workspaceRoot := ctx.Value(interceptors.WorkspaceRootKey).(string)
-	bzl.SetWorkspaceRoot(workspaceRoot)
+	v.bzl.SetWorkspaceRoot(workspaceRoot)
 
-	if exitCode, err := bzl.Spawn(bazelCmd); exitCode != 0 {
-		err = &aspecterrors.ExitError{
-			Err:      err,
-			ExitCode: exitCode,
-		}
+	info, err := v.info()
+	if err != nil {
 		return err
 	}
+	keys := info.Keys
+	values := info.Values
+	if len(args) == 1 {
+		keys = args
+		if _, ok := values[args[0]]; !ok {
+			// Some keys, e.g. build-language, are only output when asked for.
+			out, err := v.capture("info", args[0])
+			if err != nil {
+				return err
+			}
+			values = map[string]string{args[0]: strings.TrimSpace(string(out))}
+		}
+	}
 
+	if v.Output == "json" {
+		object := make(map[string]string, len(keys))
+		for _, key := range keys {
+			object[key] = values[key]
+		}
+		encoder := json.NewEncoder(v.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(object)
+	}
+	if len(args) == 1 {
+		fmt.Fprintln(v.Stdout, values[args[0]])
+		return nil
+	}
+	fmt.Fprint(v.Stdout, info)
 	return nil
 }
+
+// info returns the info of the workspace, which is cached unless it includes
+// the "Make" environment.
+func (v *Info) info() (*bazel.Info, error) {
+	if !v.ShowMakeEnv {
+		return v.bzl.Info()
+	}
+	out, err := v.capture("info", "--show_make_env")
+	if err != nil {
+		return nil, err
+	}
+	return bazel.ParseInfo(out), nil
+}
+
+func (v *Info) capture(command ...string) ([]byte, error) {
+	var out bytes.Buffer
+	streams := ioutils.Streams{Stdout: &out, Stderr: v.Stderr}
+	if exitCode, err := v.bzl.Run(command, bazel.RunOptions{Streams: streams}); exitCode != 0 {
+		return nil, &aspecterrors.ExitError{
+			Err:      err,
+			ExitCode: exitCode,
+		}
+	}
+	return out.Bytes(), nil
+}
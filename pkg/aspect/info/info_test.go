@@ -0,0 +1,95 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package info_test
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/gomega"
+
+	"aspect.build/cli/pkg/aspect/info"
+	"aspect.build/cli/pkg/bazel"
+	bazel_mock "aspect.build/cli/pkg/bazel/mock"
+	"aspect.build/cli/pkg/interceptors"
+	"aspect.build/cli/pkg/ioutils"
+)
+
+func TestInfo(t *testing.T) {
+	ctx := context.WithValue(context.Background(), interceptors.WorkspaceRootKey, "/ws")
+	bazelInfo := bazel.ParseInfo([]byte("output_base: /ob\nrelease: release 5.0.0\n"))
+
+	t.Run("the info is printed like bazel info", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		bzl := bazel_mock.NewMockBazel(ctrl)
+		bzl.EXPECT().SetWorkspaceRoot("/ws")
+		bzl.
+			EXPECT().
+			Info().
+			Return(bazelInfo, nil)
+
+		var stdout strings.Builder
+		v := info.New(ioutils.Streams{Stdout: &stdout}, bzl)
+		g.Expect(v.Run(ctx, nil, nil)).To(Succeed())
+		g.Expect(stdout.String()).To(Equal("output_base: /ob\nrelease: release 5.0.0\n"))
+	})
+
+	t.Run("the info is printed as json", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		bzl := bazel_mock.NewMockBazel(ctrl)
+		bzl.EXPECT().SetWorkspaceRoot("/ws")
+		bzl.
+			EXPECT().
+			Info().
+			Return(bazelInfo, nil)
+
+		var stdout strings.Builder
+		v := info.New(ioutils.Streams{Stdout: &stdout}, bzl)
+		v.Output = "json"
+		g.Expect(v.Run(ctx, nil, nil)).To(Succeed())
+		g.Expect(stdout.String()).To(Equal(`{
+  "output_base": "/ob",
+  "release": "release 5.0.0"
+}
+`))
+	})
+
+	t.Run("a key that isn't output by default is asked to Bazel", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		bzl := bazel_mock.NewMockBazel(ctrl)
+		bzl.EXPECT().SetWorkspaceRoot("/ws")
+		bzl.
+			EXPECT().
+			Info().
+			Return(bazelInfo, nil)
+		bzl.
+			EXPECT().
+			Run([]string{"info", "build-language"}, gomock.Any()).
+			DoAndReturn(func(_ []string, options bazel.RunOptions) (int, error) {
+				_, err := io.WriteString(options.Streams.Stdout, "proto\n")
+				return 0, err
+			})
+
+		var stdout strings.Builder
+		v := info.New(ioutils.Streams{Stdout: &stdout}, bzl)
+		v.Output = "json"
+		g.Expect(v.Run(ctx, nil, []string{"build-language"})).To(Succeed())
+		g.Expect(stdout.String()).To(Equal("{\n  \"build-language\": \"proto\"\n}\n"))
+	})
+}
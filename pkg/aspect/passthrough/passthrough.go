@@ -0,0 +1,79 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package passthrough
+
+import (
+	"fmt"
+
+	"aspect.build/cli/pkg/aspecterrors"
+	"aspect.build/cli/pkg/bazel"
+	"aspect.build/cli/pkg/ioutils"
+	"aspect.build/cli/pkg/plugin/system/bep"
+)
+
+// bepCommands are the Bazel commands that aspect doesn't wrap but that build
+// targets, so they emit the Build Event Protocol events plugins subscribe to.
+var bepCommands = map[string]bool{
+	"mobile-install": true,
+	"print_action":   true,
+}
+
+// EmitsBEP returns whether the given Bazel command emits Build Event Protocol
+// events, in which case Run requires a BES backend.
+func EmitsBEP(command string) bool {
+	return bepCommands[command]
+}
+
+// Passthrough represents a Bazel command that aspect doesn't wrap, such as
+// `sync` or `shutdown`, which is forwarded to Bazel as is.
+type Passthrough struct {
+	ioutils.Streams
+	bzl bazel.Bazel
+}
+
+// New creates a Passthrough command.
+func New(
+	streams ioutils.Streams,
+	bzl bazel.Bazel,
+) *Passthrough {
+	return &Passthrough{
+		Streams: streams,
+		bzl:     bzl,
+	}
+}
+
+// Run runs the given Bazel command with args. When besBackend is not nil, it
+// is passed to Bazel so that plugins receive the build events.
+func (p *Passthrough) Run(command string, args []string, besBackend bep.BESBackend) error {
+	bazelCmd := []string{command}
+	if besBackend != nil {
+		bazelCmd = append(bazelCmd, fmt.Sprintf("--bes_backend=grpc://%s", besBackend.Addr()))
+	}
+	bazelCmd = append(bazelCmd, args...)
+	exitCode, bazelErr := p.bzl.Spawn(bazelCmd)
+
+	// Process the subscribers errors before the Bazel one.
+	if besBackend != nil {
+		subscriberErrors := besBackend.Errors()
+		if len(subscriberErrors) > 0 {
+			for _, err := range subscriberErrors {
+				fmt.Fprintf(p.Streams.Stderr, "Error: failed to run %s command: %v\n", command, err)
+			}
+			exitCode = 1
+		}
+	}
+
+	if exitCode != 0 {
+		err := &aspecterrors.ExitError{ExitCode: exitCode}
+		if bazelErr != nil {
+			err.Err = bazelErr
+		}
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,95 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package passthrough_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/gomega"
+
+	"aspect.build/cli/pkg/aspect/passthrough"
+	"aspect.build/cli/pkg/aspecterrors"
+	bazel_mock "aspect.build/cli/pkg/bazel/mock"
+	"aspect.build/cli/pkg/ioutils"
+	bep_mock "aspect.build/cli/pkg/plugin/system/bep/mock"
+)
+
+func TestPassthrough(t *testing.T) {
+	t.Run("when the bazel runner fails, the exit code is propagated", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		streams := ioutils.Streams{}
+		bzl := bazel_mock.NewMockBazel(ctrl)
+		expectErr := &aspecterrors.ExitError{
+			Err:      fmt.Errorf("failed to run bazel sync"),
+			ExitCode: 37,
+		}
+		bzl.
+			EXPECT().
+			Spawn([]string{"sync", "--configure"}).
+			Return(expectErr.ExitCode, expectErr.Err)
+
+		p := passthrough.New(streams, bzl)
+		err := p.Run("sync", []string{"--configure"}, nil)
+
+		g.Expect(err).To(MatchError(expectErr))
+	})
+
+	t.Run("when the bazel runner succeeds, the command succeeds", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		streams := ioutils.Streams{}
+		bzl := bazel_mock.NewMockBazel(ctrl)
+		bzl.
+			EXPECT().
+			Spawn([]string{"shutdown"}).
+			Return(0, nil)
+
+		p := passthrough.New(streams, bzl)
+		err := p.Run("shutdown", nil, nil)
+
+		g.Expect(err).To(BeNil())
+	})
+
+	t.Run("with a BES backend, the backend is passed to bazel and its errors fail the command", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		var stderr strings.Builder
+		streams := ioutils.Streams{Stderr: &stderr}
+		bzl := bazel_mock.NewMockBazel(ctrl)
+		bzl.
+			EXPECT().
+			Spawn([]string{"mobile-install", "--bes_backend=grpc://127.0.0.1:12345", "//..."}).
+			Return(0, nil)
+		besBackend := bep_mock.NewMockBESBackend(ctrl)
+		besBackend.
+			EXPECT().
+			Addr().
+			Return("127.0.0.1:12345").
+			Times(1)
+		besBackend.
+			EXPECT().
+			Errors().
+			Return([]error{fmt.Errorf("error 1")}).
+			Times(1)
+
+		p := passthrough.New(streams, bzl)
+		err := p.Run("mobile-install", []string{"//..."}, besBackend)
+
+		g.Expect(err).To(MatchError(&aspecterrors.ExitError{ExitCode: 1}))
+		g.Expect(stderr.String()).To(Equal("Error: failed to run mobile-install command: error 1\n"))
+	})
+}
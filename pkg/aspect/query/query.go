@@ -10,9 +10,9 @@ import (
 	"fmt"
 
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 
 	"aspect.build/cli/pkg/aspect/query/shared"
+	"aspect.build/cli/pkg/aspect/root/config"
 	"aspect.build/cli/pkg/bazel"
 	"aspect.build/cli/pkg/ioutils"
 )
@@ -31,20 +31,34 @@ type Query struct {
 	IsInteractive bool
 
 	Presets []*shared.PresetQuery
-	Prefs   viper.Viper
+	Config  *config.Config
+	// History remembers the recent queries and placeholder values of the
+	// workspace.
+	History *shared.History
+	// Save is the name of a preset to save the query as.
+	Save string
+	// Set are the values of the placeholders given by name.
+	Set map[string]string
+	// Output is how the result of the query is output.
+	Output shared.Output
+	// WorkspaceRoot is where the query runs, which Output.Diff checks out
+	// again at a git revision.
+	WorkspaceRoot string
+
+	// PromptLabel prompts for the values of label placeholders, or Prompt
+	// does when it is nil.
+	PromptLabel func(label string) shared.PromptRunner
 
 	Prompt       func(label string) shared.PromptRunner
 	Confirmation func(question string) shared.ConfirmationRunner
 	Select       func(presetNames []string) shared.SelectRunner
 }
 
-func New(streams ioutils.Streams, bzl bazel.Bazel, isInteractive bool) *Query {
-	v := *viper.GetViper()
-
+func New(streams ioutils.Streams, cfg *config.Config, bzl bazel.Bazel, isInteractive bool) *Query {
 	// the list of available preset queries will potentially be updated during the "Run" function.
 	// if the user requests that query also show aquery and cquery predefined queries then these
 	// will be added to the list of presets
-	presets := shared.PrecannedQueries("query", v)
+	presets := shared.PrecannedQueries("query", cfg.Values().Query.Presets)
 
 	return &Query{
 		Streams:       streams,
@@ -54,12 +68,13 @@ func New(streams ioutils.Streams, bzl bazel.Bazel, isInteractive bool) *Query {
 		Prompt:        shared.Prompt,
 		Select:        shared.Select,
 		Confirmation:  shared.Confirmation,
-		Prefs:         v,
+		Config:        cfg,
 	}
 }
 
 func (q *Query) Run(cmd *cobra.Command, args []string) error {
 	err := q.checkConfig(
+		q.Config.Values().Query.All.Inquired,
 		allowAllQueries,
 		allowAllQueriesInquired,
 		"Include predefined aquery's and cquery's when calling query",
@@ -69,6 +84,7 @@ func (q *Query) Run(cmd *cobra.Command, args []string) error {
 	}
 
 	err = q.checkConfig(
+		q.Config.Values().Query.CQuery.Inquired,
 		useCQuery,
 		useCQueryInquired,
 		"Use cquery instead of query",
@@ -77,14 +93,17 @@ func (q *Query) Run(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	values := q.Config.Values()
 	verb := cmd.Use
 
-	if q.Prefs.GetBool(useCQuery) {
+	if values.Query.CQuery.Use {
 		verb = "cquery"
 	}
 
-	if q.Prefs.GetBool(allowAllQueries) {
-		q.Presets = shared.PrecannedQueries("", q.Prefs)
+	historyVerb := verb
+	if values.Query.All.Allow {
+		q.Presets = shared.PrecannedQueries("", values.Query.Presets)
+		historyVerb = ""
 	}
 
 	presets, presetNames, err := shared.ProcessQueries(q.Presets)
@@ -92,34 +111,56 @@ func (q *Query) Run(cmd *cobra.Command, args []string) error {
 		return shared.GetPrettyError(cmd, err)
 	}
 
-	presetVerb, query, runReplacements, err := shared.SelectQuery(verb, presets, q.Presets, presetNames, q.Streams, args, q.Select)
+	presetVerb, query, runReplacements, err := shared.SelectQuery(verb, presets, q.Presets, presetNames, q.History.RecentQueries(historyVerb), q.Streams, args, q.Select)
 
 	if err != nil {
 		return shared.GetPrettyError(cmd, err)
 	}
 
+	if q.Save != "" {
+		if err := shared.SavePreset(q.Config, q.Save, presetVerb, query); err != nil {
+			return shared.GetPrettyError(cmd, err)
+		}
+		fmt.Fprintf(q.Stdout, "Saved preset %q\n", q.Save)
+	}
+
 	if runReplacements {
-		query, err = shared.ReplacePlaceholders(query, args, q.Prompt)
+		query, err = shared.ReplacePlaceholders(query, shared.PlaceholderValues{
+			Set:         q.Set,
+			Args:        args,
+			Prompt:      q.Prompt,
+			PromptLabel: q.PromptLabel,
+			History:     q.History,
+			Stderr:      q.Stderr,
+		})
 
 		if err != nil {
 			return shared.GetPrettyError(cmd, err)
 		}
 	}
 
-	return shared.RunQuery(q.Bzl, presetVerb, query)
-}
+	q.History.AddQuery(presetVerb, query)
+	if err := q.History.Save(); err != nil {
+		return shared.GetPrettyError(cmd, err)
+	}
 
-func (q *Query) checkConfig(baseUseKey string, baseInquiredKey string, question string) error {
-	if !q.Prefs.GetBool(baseInquiredKey) {
-		q.Prefs.Set(baseInquiredKey, true)
+	if q.Output.Diff != "" {
+		return shared.DiffQuery(q.Bzl, q.Streams, q.WorkspaceRoot, presetVerb, query, q.Output)
+	}
+	return shared.RunQueryOutput(q.Bzl, q.Streams, presetVerb, query, q.Output)
+}
 
+func (q *Query) checkConfig(inquired bool, baseUseKey string, baseInquiredKey string, question string) error {
+	if !inquired {
 		// Y = no error; N = error
 		_, err := q.Confirmation(question).Run()
 
-		q.Prefs.Set(baseUseKey, err == nil)
-
-		if err := q.Prefs.WriteConfig(); err != nil {
-			return fmt.Errorf("failed to update config file: %w", err)
+		prefs := q.Config.Preferences()
+		if err := prefs.Set(baseInquiredKey, true); err != nil {
+			return err
+		}
+		if err := prefs.Set(baseUseKey, err == nil); err != nil {
+			return err
 		}
 	}
 
@@ -8,22 +8,38 @@ package query_test
 
 import (
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
 	"github.com/golang/mock/gomock"
 	. "github.com/onsi/gomega"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 
 	"aspect.build/cli/pkg/aspect/query"
 	"aspect.build/cli/pkg/aspect/query/shared"
 	query_mock "aspect.build/cli/pkg/aspect/query/shared/mock"
+	"aspect.build/cli/pkg/aspect/root/config"
 	bazel_mock "aspect.build/cli/pkg/bazel/mock"
 	"aspect.build/cli/pkg/ioutils"
 )
 
+// newConfig returns a config backed by an empty user config file.
+func newConfig(t *testing.T) *config.Config {
+	cfgFile, err := os.CreateTemp(os.Getenv("TEST_TMPDIR"), "cfg***.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Remove(cfgFile.Name()) })
+	cfg, err := config.Load(cfgFile.Name(), "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
 func TestQuery(t *testing.T) {
 	t.Run("long version of preset query calls directly down to bazel query", func(t *testing.T) {
 		g := NewGomegaWithT(t)
@@ -38,7 +54,7 @@ func TestQuery(t *testing.T) {
 
 		var stdout strings.Builder
 		streams := ioutils.Streams{Stdout: &stdout}
-		q := query.New(streams, spawner, true)
+		q := query.New(streams, newConfig(t), spawner, true)
 		q.Presets = []*shared.PresetQuery{
 			{
 				Name:        "why",
@@ -48,12 +64,7 @@ func TestQuery(t *testing.T) {
 			},
 		}
 
-		viper := *viper.New()
-		cfg, err := os.CreateTemp(os.Getenv("TEST_TMPDIR"), "cfg***.ini")
-		g.Expect(err).To(BeNil())
-
-		viper.SetConfigFile(cfg.Name())
-		q.Prefs = viper
+		q.Config = newConfig(t)
 
 		cmd := &cobra.Command{Use: "query"}
 		g.Expect(q.Run(cmd, []string{"why", "//cmd/aspect/query:query", "@com_github_bazelbuild_bazelisk//core:go_default_library"})).Should(Succeed())
@@ -121,14 +132,9 @@ func TestQuery(t *testing.T) {
 			},
 		}
 
-		viper := *viper.New()
-		cfg, err := os.CreateTemp(os.Getenv("TEST_TMPDIR"), "cfg***.ini")
-		g.Expect(err).To(BeNil())
-
-		viper.SetConfigFile(cfg.Name())
-		q.Prefs = viper
+		q.Config = newConfig(t)
 		cmd := &cobra.Command{Use: "query"}
-		err = q.Run(cmd, []string{"why"})
+		err := q.Run(cmd, []string{"why"})
 		g.Expect(err).To(BeNil())
 	})
 
@@ -192,15 +198,10 @@ func TestQuery(t *testing.T) {
 			},
 		}
 
-		viper := *viper.New()
-		cfg, err := os.CreateTemp(os.Getenv("TEST_TMPDIR"), "cfg***.ini")
-		g.Expect(err).To(BeNil())
-
-		viper.SetConfigFile(cfg.Name())
-		q.Prefs = viper
+		q.Config = newConfig(t)
 
 		cmd := &cobra.Command{Use: "query"}
-		err = q.Run(cmd, []string{"why"})
+		err := q.Run(cmd, []string{"why"})
 		g.Expect(err).To(MatchError(expectedError))
 	})
 
@@ -289,31 +290,109 @@ func TestQuery(t *testing.T) {
 			},
 		}
 
-		viper := *viper.New()
-		cfg, err := os.CreateTemp(os.Getenv("TEST_TMPDIR"), "cfg***.ini")
-		g.Expect(err).To(BeNil())
-
-		viper.SetConfigFile(cfg.Name())
-		q.Prefs = viper
+		q.Config = newConfig(t)
 		cmd := &cobra.Command{Use: "query"}
-		err = q.Run(cmd, []string{})
+		err := q.Run(cmd, []string{})
 		g.Expect(err).To(BeNil())
 	})
 
-	t.Run("user defined queries can overwrite default predefined queries", func(t *testing.T) {
+	t.Run("an ad-hoc query is saved as a preset and remembered", func(t *testing.T) {
 		g := NewGomegaWithT(t)
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		dir, err := os.MkdirTemp(os.Getenv("TEST_TMPDIR"), "query")
+		g.Expect(err).To(BeNil())
+		defer os.RemoveAll(dir)
+		workspaceFile := filepath.Join(dir, ".aspect.yaml")
+		cfg, err := config.Load(filepath.Join(dir, "user.yaml"), workspaceFile, nil)
+		g.Expect(err).To(BeNil())
+		history := shared.LoadHistory(filepath.Join(dir, "history.json"), io.Discard)
+
+		spawner := bazel_mock.NewMockBazel(ctrl)
+		spawner.
+			EXPECT().
+			Spawn([]string{"query", "rdeps(//..., //foo)"}).
+			Return(0, nil).
+			Times(3)
+
+		confirmationRunner := query_mock.NewMockConfirmationRunner(ctrl)
+		confirmationRunner.
+			EXPECT().
+			Run().
+			Return("N", fmt.Errorf("")).
+			Times(2)
+
+		promptRunner := query_mock.NewMockPromptRunner(ctrl)
+		promptRunner.
+			EXPECT().
+			Run().
+			Return("", nil).
+			Times(1)
 
-		viper := *viper.New()
-		cfg, err := os.CreateTemp(os.Getenv("TEST_TMPDIR"), "cfg***.ini")
+		var selectItems []string
+		selectRunner := query_mock.NewMockSelectRunner(ctrl)
 
+		newQuery := func() *query.Query {
+			q := query.New(ioutils.Streams{Stdout: &strings.Builder{}}, cfg, spawner, true)
+			q.History = history
+			q.Confirmation = func(question string) shared.ConfirmationRunner {
+				return confirmationRunner
+			}
+			q.Prompt = func(label string) shared.PromptRunner {
+				g.Expect(label).To(Equal("Value for 'target' (empty for //foo)"))
+				return promptRunner
+			}
+			q.Select = func(presetNames []string) shared.SelectRunner {
+				selectItems = presetNames
+				return selectRunner
+			}
+			return q
+		}
+		cmd := &cobra.Command{Use: "query"}
+
+		q := newQuery()
+		q.Save = "rdeps"
+		g.Expect(q.Run(cmd, []string{"rdeps(//..., ?target)", "//foo"})).To(Succeed())
+		content, err := os.ReadFile(workspaceFile)
 		g.Expect(err).To(BeNil())
+		g.Expect(string(content)).To(ContainSubstring("rdeps:\n      query: rdeps(//..., ?target)\n      verb: query\n"))
+
+		// The saved preset prompts for its placeholder, with the recent value.
+		g.Expect(newQuery().Run(cmd, []string{"rdeps"})).To(Succeed())
+
+		// The recent queries are offered after the presets.
+		q = newQuery()
+		selectRunner.
+			EXPECT().
+			Run().
+			Return(len(q.Presets), "", nil).
+			Times(1)
+		g.Expect(q.Run(cmd, []string{})).To(Succeed())
+		g.Expect(selectItems[len(q.Presets):]).To(Equal([]string{"recent: query rdeps(//..., //foo)"}))
 
-		viper.SetConfigFile(cfg.Name())
-		viper.Set("query.presets.why.description", "Override the default why verb. Determine why targetA depends on targetB")
-		viper.Set("query.presets.why.query", "somepath(?targetA, ?targetB)")
-		viper.Set("query.presets.why.verb", "query")
+		history = shared.LoadHistory(filepath.Join(dir, "history.json"), io.Discard)
+		g.Expect(history.RecentValues("target")).To(Equal([]string{"//foo"}))
+	})
 
-		result := shared.PrecannedQueries("query", viper)
+	t.Run("the preset selector matches fuzzily", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		g.Expect(shared.FuzzyMatch("rdfoo", "recent: query rdeps(//..., //foo)")).To(BeTrue())
+		g.Expect(shared.FuzzyMatch("Why", "why: Determine why targetA depends on targetB")).To(BeTrue())
+		g.Expect(shared.FuzzyMatch("depsx", "deps: Get the deps of a target")).To(BeFalse())
+	})
+
+	t.Run("user defined queries can overwrite default predefined queries", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		result := shared.PrecannedQueries("query", map[string]config.QueryPresetValues{
+			"why": {
+				Description: "Override the default why verb. Determine why targetA depends on targetB",
+				Query:       "somepath(?targetA, ?targetB)",
+				Verb:        "query",
+			},
+		})
 		g.Expect(len(result)).To(Equal(2))
 
 		g.Expect(result[0].Description).To(Equal("Get the deps of a target"))
@@ -0,0 +1,380 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package shared
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bazelbuild/buildtools/build"
+
+	"aspect.build/cli/pkg/bazel"
+	"aspect.build/cli/pkg/ioutils"
+)
+
+// maxCacheAge is how long a cached query result is kept.
+const maxCacheAge = 7 * 24 * time.Hour
+
+// cacheKeyFiles are the files outside of the packages that a query result
+// depends on.
+var cacheKeyFiles = map[string]bool{
+	".bazelrc":         true,
+	".bazelversion":    true,
+	"MODULE.bazel":     true,
+	"WORKSPACE":        true,
+	"WORKSPACE.bazel":  true,
+	"WORKSPACE.bzlmod": true,
+}
+
+// lockFiles are the files that the repository rules of the common rulesets
+// read to create the external repositories, whose targets a query may return.
+var lockFiles = map[string]bool{
+	"Cargo.lock":            true,
+	"MODULE.bazel.lock":     true,
+	"go.mod":                true,
+	"go.sum":                true,
+	"maven_install.json":    true,
+	"package-lock.json":     true,
+	"package.json":          true,
+	"pnpm-lock.yaml":        true,
+	"requirements.txt":      true,
+	"requirements_lock.txt": true,
+	"yarn.lock":             true,
+}
+
+// versionEnvVars are the environment variables other than BAZELISK_* that
+// select the version of Bazel that runs the queries.
+var versionEnvVars = []string{
+	"USE_BAZEL_VERSION",
+	"USE_BAZEL_NIGHTLY",
+	"USE_BAZEL_CANARY",
+	"USE_NIGHTLY_BAZEL",
+	"USE_CANARY_BAZEL",
+}
+
+// QueryCache caches the results of the queries of a workspace. A result is
+// keyed on the query, the working directory, which relative labels resolve
+// against, the environment variables that select the version of Bazel, the
+// startup options, the rc files, the names of the
+// files of the workspace, which globs depend on, and its BUILD, .bzl,
+// WORKSPACE and lock files, so that it is invalidated as soon as one of them
+// changes. Other files that repository rules read aren't part of the key. A
+// nil QueryCache caches nothing.
+type QueryCache struct {
+	dir            string
+	workspaceRoot  string
+	startupOptions []string
+	// filesHash is the hash of the files that the results depend on, which
+	// is computed once.
+	filesHash string
+}
+
+// QueryCacheDir returns the directory where the query results of the given
+// workspace are cached, under the user cache directory.
+func QueryCacheDir(workspaceRoot string) (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate the query cache: %w", err)
+	}
+	hash := md5.Sum([]byte(workspaceRoot))
+	return filepath.Join(cacheDir, "aspect", "query_cache", hex.EncodeToString(hash[:])), nil
+}
+
+// NewQueryCache creates a QueryCache for the workspace in dir, for queries run
+// with the given startup options.
+func NewQueryCache(dir string, workspaceRoot string, startupOptions []string) *QueryCache {
+	return &QueryCache{dir: dir, workspaceRoot: workspaceRoot, startupOptions: startupOptions}
+}
+
+// run runs the Bazel command, or writes its cached result to out when the
+// files it depends on didn't change since it last ran.
+func (c *QueryCache) run(bzl bazel.Bazel, command []string, out io.Writer) (int, error) {
+	if c == nil {
+		return bzl.RunCommand(command, out)
+	}
+	key, err := c.key(command)
+	if err != nil {
+		return 1, err
+	}
+	path := filepath.Join(c.dir, key)
+	if result, err := ioutil.ReadFile(path); err == nil {
+		_, err := out.Write(result)
+		return 0, err
+	}
+
+	var result bytes.Buffer
+	exitCode, err := bzl.RunCommand(command, io.MultiWriter(out, &result))
+	if exitCode != 0 {
+		return exitCode, err
+	}
+	if err := c.store(path, result.Bytes()); err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
+
+// store writes a result to the cache, and removes the results that weren't
+// used for a while, which are mostly ones that were invalidated.
+func (c *QueryCache) store(path string, result []byte) error {
+	// The result is renamed into place, so that a concurrent query never
+	// reads a partial result.
+	if err := ioutils.WriteFileAtomic(path, result); err != nil {
+		return fmt.Errorf("failed to cache the query result: %w", err)
+	}
+	entries, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		return fmt.Errorf("failed to cache the query result: %w", err)
+	}
+	for _, entry := range entries {
+		if time.Since(entry.ModTime()) > maxCacheAge {
+			os.Remove(filepath.Join(c.dir, entry.Name()))
+		}
+	}
+	return nil
+}
+
+// key returns the key of the result of a command.
+func (c *QueryCache) key(command []string) (string, error) {
+	if c.filesHash == "" {
+		filesHash, err := c.hashFiles()
+		if err != nil {
+			return "", err
+		}
+		c.filesHash = filesHash
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", fmt.Errorf("failed to get the working directory: %w", err)
+	}
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\x00%s\x00%s\x00%s\x00%s", c.filesHash, wd, strings.Join(versionEnv(), "\x00"), strings.Join(c.startupOptions, "\x00"), strings.Join(command, "\x00"))
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// versionEnv returns the sorted environment variables that select the
+// version of Bazel.
+func versionEnv() []string {
+	var env []string
+	for _, v := range os.Environ() {
+		if strings.HasPrefix(v, "BAZELISK_") {
+			env = append(env, v)
+		}
+	}
+	for _, name := range versionEnvVars {
+		if value, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+value)
+		}
+	}
+	sort.Strings(env)
+	return env
+}
+
+// hashFiles hashes the path, size and modification time of the files that
+// the query results depend on, which is faster than hashing their contents,
+// and the paths of the other files of the workspace.
+func (c *QueryCache) hashFiles() (string, error) {
+	ignored, err := c.ignoredDirs()
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	err = filepath.WalkDir(c.workspaceRoot, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(c.workspaceRoot, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		name := d.Name()
+		if d.IsDir() {
+			// The convenience symlinks, e.g. bazel-bin, aren't followed since
+			// they aren't directories to WalkDir, but they may be ones on
+			// Windows, where they are junctions.
+			if rel != "." && (strings.HasPrefix(name, ".") || ignored[rel] || (rel == name && strings.HasPrefix(name, "bazel-"))) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if name != "BUILD" && name != "BUILD.bazel" && !strings.HasSuffix(name, ".bzl") && !lockFiles[name] && !(rel == name && cacheKeyFiles[name]) {
+			fmt.Fprintf(hash, "%s\n", rel)
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(hash, "%s\x00%d\x00%d\n", rel, info.Size(), info.ModTime().UnixNano())
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to hash the BUILD files: %w", err)
+	}
+	c.hashRcFiles(hash)
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// hashRcFiles hashes the path, size and modification time of the rc files
+// that Bazel reads and of the files they import. A missing file is hashed too,
+// so that creating it, e.g. a try-imported user.bazelrc, changes the hash.
+func (c *QueryCache) hashRcFiles(hash io.Writer) {
+	rcFiles := c.rcFiles()
+	scanned := make(map[string]bool)
+	for len(rcFiles) > 0 {
+		rcFile := rcFiles[0]
+		rcFiles = rcFiles[1:]
+		if scanned[rcFile] {
+			continue
+		}
+		scanned[rcFile] = true
+		info, err := os.Stat(rcFile)
+		if err != nil {
+			fmt.Fprintf(hash, "%s\x00missing\n", rcFile)
+			continue
+		}
+		fmt.Fprintf(hash, "%s\x00%d\x00%d\n", rcFile, info.Size(), info.ModTime().UnixNano())
+		rcFiles = append(rcFiles, c.rcImports(rcFile)...)
+	}
+}
+
+// rcFiles returns the rc files that Bazel reads with the startup options of
+// the cache.
+func (c *QueryCache) rcFiles() []string {
+	systemRc, workspaceRc, homeRc := true, true, true
+	var bazelrcs []string
+	for _, option := range c.startupOptions {
+		switch {
+		case option == "--nosystem_rc":
+			systemRc = false
+		case option == "--noworkspace_rc":
+			workspaceRc = false
+		case option == "--nohome_rc":
+			homeRc = false
+		case strings.HasPrefix(option, "--bazelrc="):
+			bazelrcs = append(bazelrcs, strings.TrimPrefix(option, "--bazelrc="))
+		}
+	}
+
+	var rcFiles []string
+	if systemRc {
+		rcFiles = append(rcFiles, "/etc/bazel.bazelrc")
+	}
+	if workspaceRc {
+		rcFiles = append(rcFiles, filepath.Join(c.workspaceRoot, ".bazelrc"))
+	}
+	if homeRc {
+		if home, err := os.UserHomeDir(); err == nil {
+			rcFiles = append(rcFiles, filepath.Join(home, ".bazelrc"))
+		}
+	}
+	for _, bazelrc := range bazelrcs {
+		// Bazel reads no further --bazelrc after /dev/null.
+		if bazelrc == "/dev/null" {
+			break
+		}
+		rcFiles = append(rcFiles, bazelrc)
+	}
+	return rcFiles
+}
+
+// rcImports returns the files that the given rc file imports or try-imports.
+func (c *QueryCache) rcImports(rcFile string) []string {
+	f, err := os.Open(rcFile)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var imports []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 1 && (fields[0] == "import" || fields[0] == "try-import") {
+			imports = append(imports, strings.ReplaceAll(fields[1], "%workspace%", c.workspaceRoot))
+		}
+	}
+	return imports
+}
+
+// ignoredDirs returns the directories listed in the .bazelignore file, which
+// Bazel doesn't look into, and the managed directories of the WORKSPACE file,
+// e.g. node_modules, which hold no packages.
+func (c *QueryCache) ignoredDirs() (map[string]bool, error) {
+	ignored, err := c.managedDirs()
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(filepath.Join(c.workspaceRoot, ".bazelignore"))
+	if os.IsNotExist(err) {
+		return ignored, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the .bazelignore: %w", err)
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			ignored[strings.Trim(line, "/")] = true
+		}
+	}
+	return ignored, nil
+}
+
+// managedDirs returns the directories given in the managed_directories
+// attribute of the workspace rule of the WORKSPACE file, e.g.
+// `workspace(managed_directories = {"@npm": ["node_modules"]})`.
+func (c *QueryCache) managedDirs() (map[string]bool, error) {
+	managed := make(map[string]bool)
+	for _, name := range []string{"WORKSPACE.bazel", "WORKSPACE"} {
+		path := filepath.Join(c.workspaceRoot, name)
+		data, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the %s: %w", name, err)
+		}
+		f, err := build.ParseWorkspace(path, data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the %s: %w", name, err)
+		}
+		for _, rule := range f.Rules("workspace") {
+			dict, ok := rule.Attr("managed_directories").(*build.DictExpr)
+			if !ok {
+				continue
+			}
+			for _, kv := range dict.List {
+				dirs, ok := kv.Value.(*build.ListExpr)
+				if !ok {
+					continue
+				}
+				for _, dir := range dirs.List {
+					if str, ok := dir.(*build.StringExpr); ok {
+						managed[strings.Trim(str.Value, "/")] = true
+					}
+				}
+			}
+		}
+		// Bazel reads WORKSPACE.bazel instead of WORKSPACE when both exist.
+		break
+	}
+	return managed, nil
+}
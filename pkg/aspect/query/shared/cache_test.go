@@ -0,0 +1,204 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package shared_test
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/gomega"
+
+	"aspect.build/cli/pkg/aspect/query/shared"
+	"aspect.build/cli/pkg/aspecterrors"
+	bazel_mock "aspect.build/cli/pkg/bazel/mock"
+	"aspect.build/cli/pkg/ioutils"
+)
+
+func TestQueryCache(t *testing.T) {
+	setup := func(t *testing.T) (string, string) {
+		dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "cache")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { os.RemoveAll(dir) })
+		workspace := filepath.Join(dir, "ws")
+		for _, file := range []string{"WORKSPACE", "lib/BUILD.bazel", "lib/defs.bzl", "lib/a.go"} {
+			path := filepath.Join(workspace, file)
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return filepath.Join(dir, "cache"), workspace
+	}
+	query := func(spawner *bazel_mock.MockBazel, cache *shared.QueryCache) (string, error) {
+		var stdout strings.Builder
+		err := shared.RunQueryOutput(spawner, ioutils.Streams{Stdout: &stdout}, "query", "deps(//lib)", shared.Output{Cache: cache})
+		return stdout.String(), err
+	}
+
+	t.Run("a result is cached until a BUILD file changes", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		cacheDir, workspace := setup(t)
+
+		spawner := bazel_mock.NewMockBazel(ctrl)
+		spawner.
+			EXPECT().
+			RunCommand([]string{"query", "deps(//lib)"}, gomock.Any()).
+			DoAndReturn(func(_ []string, out io.Writer) (int, error) {
+				_, err := io.WriteString(out, "//lib\n")
+				return 0, err
+			}).
+			Times(2)
+
+		for i := 0; i < 2; i++ {
+			result, err := query(spawner, shared.NewQueryCache(cacheDir, workspace, nil))
+			g.Expect(err).To(BeNil())
+			g.Expect(result).To(Equal("//lib\n"))
+		}
+
+		// A source file isn't part of the key.
+		g.Expect(ioutil.WriteFile(filepath.Join(workspace, "lib", "a.go"), []byte("package lib"), 0644)).To(Succeed())
+		_, err := query(spawner, shared.NewQueryCache(cacheDir, workspace, nil))
+		g.Expect(err).To(BeNil())
+
+		g.Expect(ioutil.WriteFile(filepath.Join(workspace, "lib", "defs.bzl"), []byte("load()"), 0644)).To(Succeed())
+		result, err := query(spawner, shared.NewQueryCache(cacheDir, workspace, nil))
+		g.Expect(err).To(BeNil())
+		g.Expect(result).To(Equal("//lib\n"))
+	})
+
+	t.Run("a result is cached until a file is added, an rc file or the startup options change", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		cacheDir, workspace := setup(t)
+		g.Expect(ioutil.WriteFile(filepath.Join(workspace, ".bazelrc"), []byte("try-import %workspace%/user.bazelrc\n"), 0644)).To(Succeed())
+
+		spawner := bazel_mock.NewMockBazel(ctrl)
+		spawner.
+			EXPECT().
+			RunCommand([]string{"query", "deps(//lib)"}, gomock.Any()).
+			Return(0, nil).
+			Times(4)
+
+		_, err := query(spawner, shared.NewQueryCache(cacheDir, workspace, nil))
+		g.Expect(err).To(BeNil())
+
+		// A glob of a BUILD file may match the new file.
+		g.Expect(ioutil.WriteFile(filepath.Join(workspace, "lib", "b.go"), nil, 0644)).To(Succeed())
+		_, err = query(spawner, shared.NewQueryCache(cacheDir, workspace, nil))
+		g.Expect(err).To(BeNil())
+
+		g.Expect(ioutil.WriteFile(filepath.Join(workspace, "user.bazelrc"), []byte("query --noimplicit_deps\n"), 0644)).To(Succeed())
+		_, err = query(spawner, shared.NewQueryCache(cacheDir, workspace, nil))
+		g.Expect(err).To(BeNil())
+
+		_, err = query(spawner, shared.NewQueryCache(cacheDir, workspace, []string{"--output_base=/tmp/other"}))
+		g.Expect(err).To(BeNil())
+		_, err = query(spawner, shared.NewQueryCache(cacheDir, workspace, []string{"--output_base=/tmp/other"}))
+		g.Expect(err).To(BeNil())
+	})
+
+	t.Run("a result is keyed on the working directory and the version environment variables", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		cacheDir, workspace := setup(t)
+		wd, err := os.Getwd()
+		g.Expect(err).To(BeNil())
+		t.Cleanup(func() { os.Chdir(wd) })
+
+		spawner := bazel_mock.NewMockBazel(ctrl)
+		spawner.
+			EXPECT().
+			RunCommand([]string{"query", "deps(//lib)"}, gomock.Any()).
+			Return(0, nil).
+			Times(4)
+
+		g.Expect(os.Chdir(workspace)).To(Succeed())
+		_, err = query(spawner, shared.NewQueryCache(cacheDir, workspace, nil))
+		g.Expect(err).To(BeNil())
+
+		// A relative label, e.g. :foo, resolves against the working directory.
+		g.Expect(os.Chdir(filepath.Join(workspace, "lib"))).To(Succeed())
+		_, err = query(spawner, shared.NewQueryCache(cacheDir, workspace, nil))
+		g.Expect(err).To(BeNil())
+		_, err = query(spawner, shared.NewQueryCache(cacheDir, workspace, nil))
+		g.Expect(err).To(BeNil())
+
+		t.Setenv("USE_BAZEL_VERSION", "5.0.0")
+		_, err = query(spawner, shared.NewQueryCache(cacheDir, workspace, nil))
+		g.Expect(err).To(BeNil())
+
+		t.Setenv("BAZELISK_BASE_URL", "https://example.com/bazel")
+		_, err = query(spawner, shared.NewQueryCache(cacheDir, workspace, nil))
+		g.Expect(err).To(BeNil())
+	})
+
+	t.Run("the files of the convenience symlinks, ignored and managed directories aren't part of the key", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		cacheDir, workspace := setup(t)
+		g.Expect(ioutil.WriteFile(filepath.Join(workspace, "WORKSPACE"), []byte(`workspace(
+    name = "ws",
+    managed_directories = {"@npm": ["node_modules"]},
+)
+`), 0644)).To(Succeed())
+		g.Expect(ioutil.WriteFile(filepath.Join(workspace, ".bazelignore"), []byte("ignored\n"), 0644)).To(Succeed())
+
+		spawner := bazel_mock.NewMockBazel(ctrl)
+		spawner.
+			EXPECT().
+			RunCommand([]string{"query", "deps(//lib)"}, gomock.Any()).
+			Return(0, nil).
+			Times(1)
+
+		_, err := query(spawner, shared.NewQueryCache(cacheDir, workspace, nil))
+		g.Expect(err).To(BeNil())
+
+		for _, file := range []string{"node_modules/pkg/BUILD.bazel", "ignored/BUILD.bazel", "bazel-out/k8-fastbuild/bin/BUILD.bazel"} {
+			path := filepath.Join(workspace, file)
+			g.Expect(os.MkdirAll(filepath.Dir(path), 0755)).To(Succeed())
+			g.Expect(ioutil.WriteFile(path, nil, 0644)).To(Succeed())
+		}
+		_, err = query(spawner, shared.NewQueryCache(cacheDir, workspace, nil))
+		g.Expect(err).To(BeNil())
+	})
+
+	t.Run("a failed query isn't cached", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		cacheDir, workspace := setup(t)
+
+		spawner := bazel_mock.NewMockBazel(ctrl)
+		spawner.
+			EXPECT().
+			RunCommand([]string{"query", "deps(//lib)"}, gomock.Any()).
+			Return(7, nil).
+			Times(2)
+
+		for i := 0; i < 2; i++ {
+			_, err := query(spawner, shared.NewQueryCache(cacheDir, workspace, nil))
+			var exitErr *aspecterrors.ExitError
+			g.Expect(errors.As(err, &exitErr)).To(BeTrue())
+			g.Expect(exitErr.ExitCode).To(Equal(7))
+		}
+	})
+}
@@ -0,0 +1,159 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package shared
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"aspect.build/cli/pkg/bazel"
+	"aspect.build/cli/pkg/gitutils"
+	"aspect.build/cli/pkg/ioutils"
+	"aspect.build/cli/pkg/outputbase"
+)
+
+// DiffQuery compares the result of the query in the working tree of the
+// workspace with its result at the git revision output.Diff, and prints the
+// lines that were added or removed since that revision.
+func DiffQuery(bzl bazel.Bazel, streams ioutils.Streams, workspaceRoot string, verb string, query string, output Output) error {
+	format := output.Format
+	switch format {
+	case "":
+		format = "label"
+	case "graph":
+		return fmt.Errorf("--diff can't be combined with --output=graph")
+	}
+
+	current, err := captureQuery(bzl, output.Cache, verb, query, format, nil)
+	if err != nil {
+		return err
+	}
+	var previous []byte
+	err = QueryAtRevision(bzl, workspaceRoot, output.Diff, verb, query, format, func(_ string, result []byte) error {
+		previous = result
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	added, removed := diffLines(previous, current)
+	if len(added) == 0 && len(removed) == 0 {
+		fmt.Fprintf(streams.Stdout, "The result of the query is the same at %s\n", output.Diff)
+		return nil
+	}
+	fmt.Fprintf(streams.Stdout, "Compared with %s:\n", output.Diff)
+	for _, line := range added {
+		fmt.Fprintf(streams.Stdout, "+ %s\n", line)
+	}
+	for _, line := range removed {
+		fmt.Fprintf(streams.Stdout, "- %s\n", line)
+	}
+	fmt.Fprintf(streams.Stdout, "\n%d added, %d removed\n", len(added), len(removed))
+	return nil
+}
+
+// QueryAtRevision runs the query in a worktree checked out at the given git
+// revision, and calls visit with the workspace root of the worktree and the
+// result before the worktree is removed. It uses a temporary output base, so
+// that the Bazel server and the analysis cache of the workspace are left
+// untouched.
+func QueryAtRevision(bzl bazel.Bazel, workspaceRoot string, rev string, verb string, query string, format string, visit func(revRoot string, result []byte) error) error {
+	root, err := filepath.EvalSymlinks(workspaceRoot)
+	if err != nil {
+		return fmt.Errorf("failed to query %s: %w", rev, err)
+	}
+	topLevel, err := gitutils.TopLevel(root)
+	if err != nil {
+		return fmt.Errorf("failed to query %s: %w", rev, err)
+	}
+	rel, err := filepath.Rel(topLevel, root)
+	if err != nil {
+		return fmt.Errorf("failed to query %s: %w", rev, err)
+	}
+
+	tmp, err := ioutil.TempDir("", "aspect-query-diff")
+	if err != nil {
+		return fmt.Errorf("failed to query %s: %w", rev, err)
+	}
+	defer outputbase.Remove(tmp)
+	worktree := filepath.Join(tmp, "worktree")
+	if err := gitutils.AddWorktree(root, rev, worktree); err != nil {
+		return fmt.Errorf("failed to check out %s: %w", rev, err)
+	}
+	defer gitutils.RemoveWorktree(root, worktree)
+	revRoot := filepath.Join(worktree, rel)
+
+	// Bazel resolves relative labels against the working directory, so the
+	// query runs in the same package of the worktree.
+	wd, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to query %s: %w", rev, err)
+	}
+	options := bazel.RunOptions{
+		Dir:            revRoot,
+		StartupOptions: []string{"--output_base=" + filepath.Join(tmp, "output_base")},
+	}
+	if realWd, err := filepath.EvalSymlinks(wd); err == nil {
+		if relWd, err := filepath.Rel(root, realWd); err == nil && !strings.HasPrefix(relWd, "..") {
+			if _, err := os.Stat(filepath.Join(revRoot, relWd)); err == nil {
+				options.Dir = filepath.Join(revRoot, relWd)
+			}
+		}
+	}
+	bzl.SetWorkspaceRoot(revRoot)
+	defer bzl.SetWorkspaceRoot(workspaceRoot)
+
+	var result bytes.Buffer
+	options.Streams.Stdout = &result
+	exitCode, queryErr := bzl.Run([]string{verb, "--output=" + format, query}, options)
+	// The query leaves a Bazel server running in the temporary output base,
+	// which must stop before the output base can be removed.
+	options.Streams.Stdout = ioutil.Discard
+	bzl.Run([]string{"shutdown"}, options)
+	if exitCode != 0 {
+		return fmt.Errorf("failed to query %s: %w", rev, queryError(verb, query, exitCode, queryErr))
+	}
+	return visit(revRoot, result.Bytes())
+}
+
+// diffLines returns the sorted lines that are only in current, and the ones
+// that are only in previous.
+func diffLines(previous []byte, current []byte) (added []string, removed []string) {
+	previousLines := lineSet(previous)
+	currentLines := lineSet(current)
+	for line := range currentLines {
+		if !previousLines[line] {
+			added = append(added, line)
+		}
+	}
+	for line := range previousLines {
+		if !currentLines[line] {
+			removed = append(removed, line)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
+
+func lineSet(result []byte) map[string]bool {
+	lines := make(map[string]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(result))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			lines[line] = true
+		}
+	}
+	return lines
+}
@@ -0,0 +1,108 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package shared_test
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/gomega"
+
+	"aspect.build/cli/pkg/aspect/query/shared"
+	"aspect.build/cli/pkg/bazel"
+	bazel_mock "aspect.build/cli/pkg/bazel/mock"
+	"aspect.build/cli/pkg/gitutils"
+	"aspect.build/cli/pkg/ioutils"
+)
+
+func TestDiffQuery(t *testing.T) {
+	t.Run("the result is compared with the one at a git revision", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "diff")
+		g.Expect(err).To(BeNil())
+		defer os.RemoveAll(dir)
+		workspace, err := filepath.EvalSymlinks(dir)
+		g.Expect(err).To(BeNil())
+		g.Expect(ioutil.WriteFile(filepath.Join(workspace, "WORKSPACE"), nil, 0644)).To(Succeed())
+		for _, args := range [][]string{
+			{"init", "--quiet"},
+			{"add", "WORKSPACE"},
+			{"-c", "user.name=aspect", "-c", "user.email=aspect@example.com", "commit", "--quiet", "-m", "init"},
+		} {
+			_, err := gitutils.Run(workspace, args...)
+			g.Expect(err).To(BeNil())
+		}
+
+		var revOptions bazel.RunOptions
+		spawner := bazel_mock.NewMockBazel(ctrl)
+		gomock.InOrder(
+			spawner.
+				EXPECT().
+				RunCommand([]string{"query", "--output=label", "deps(//app)"}, gomock.Any()).
+				DoAndReturn(func(_ []string, out io.Writer) (int, error) {
+					_, err := io.WriteString(out, "//app\n//lib:a\n//lib:new\n")
+					return 0, err
+				}),
+			spawner.
+				EXPECT().
+				SetWorkspaceRoot(gomock.Any()).
+				Do(func(root string) {
+					g.Expect(filepath.Join(root, "WORKSPACE")).To(BeAnExistingFile())
+					g.Expect(root).ToNot(Equal(workspace))
+				}),
+			spawner.
+				EXPECT().
+				Run([]string{"query", "--output=label", "deps(//app)"}, gomock.Any()).
+				DoAndReturn(func(_ []string, options bazel.RunOptions) (int, error) {
+					g.Expect(options.StartupOptions).To(HaveLen(1))
+					g.Expect(options.StartupOptions[0]).To(HavePrefix("--output_base="))
+					g.Expect(filepath.Join(options.Dir, "WORKSPACE")).To(BeAnExistingFile())
+					revOptions = options
+					_, err := io.WriteString(options.Streams.Stdout, "//app\n//lib:a\n//lib:old\n")
+					return 0, err
+				}),
+			spawner.
+				EXPECT().
+				Run([]string{"shutdown"}, gomock.Any()).
+				DoAndReturn(func(_ []string, options bazel.RunOptions) (int, error) {
+					g.Expect(options.StartupOptions).To(Equal(revOptions.StartupOptions))
+					return 0, nil
+				}),
+			spawner.
+				EXPECT().
+				SetWorkspaceRoot(workspace),
+		)
+
+		var stdout strings.Builder
+		err = shared.DiffQuery(spawner, ioutils.Streams{Stdout: &stdout}, workspace, "query", "deps(//app)", shared.Output{Diff: "HEAD"})
+		g.Expect(err).To(BeNil())
+		g.Expect(stdout.String()).To(Equal(`Compared with HEAD:
++ //lib:new
+- //lib:old
+
+1 added, 1 removed
+`))
+		worktrees, err := gitutils.Run(workspace, "worktree", "list")
+		g.Expect(err).To(BeNil())
+		g.Expect(strings.Count(worktrees, "\n")).To(Equal(1))
+	})
+
+	t.Run("a graph can't be compared", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		err := shared.DiffQuery(nil, ioutils.Streams{}, "/ws", "query", "deps(//app)", shared.Output{Format: "graph", Diff: "HEAD"})
+		g.Expect(err).To(MatchError("--diff can't be combined with --output=graph"))
+	})
+}
@@ -0,0 +1,152 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package shared
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"aspect.build/cli/pkg/ioutils"
+)
+
+const (
+	// maxHistoryQueries is the number of recent queries that are remembered.
+	maxHistoryQueries = 20
+	// maxHistoryValues is the number of recent values that are remembered for
+	// each placeholder.
+	maxHistoryValues = 10
+)
+
+// HistoryQuery is a query that ran recently.
+type HistoryQuery struct {
+	Verb  string `json:"verb"`
+	Query string `json:"query"`
+}
+
+// History holds the recent queries and placeholder values of a workspace, the
+// most recent first. A nil History remembers nothing.
+type History struct {
+	path string
+
+	Queries      []HistoryQuery      `json:"queries"`
+	Placeholders map[string][]string `json:"placeholders"`
+}
+
+// HistoryFile returns the file where the query history of the given workspace
+// is kept, under the user cache directory.
+func HistoryFile(workspaceRoot string) (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate the query history: %w", err)
+	}
+	hash := md5.Sum([]byte(workspaceRoot))
+	return filepath.Join(cacheDir, "aspect", "query_history", hex.EncodeToString(hash[:])+".json"), nil
+}
+
+// LoadHistory reads the query history from the given file. A missing file is
+// an empty history, and so is an unreadable one, which is reported to stderr
+// and replaced on the next save, so that it doesn't break every query.
+func LoadHistory(path string, stderr io.Writer) *History {
+	h := &History{path: path, Placeholders: make(map[string][]string)}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return h
+	}
+	if err == nil {
+		err = json.Unmarshal(data, h)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "Ignoring the query history %s: %v\n", path, err)
+		return &History{path: path, Placeholders: make(map[string][]string)}
+	}
+	if h.Placeholders == nil {
+		h.Placeholders = make(map[string][]string)
+	}
+	return h
+}
+
+// Save writes the history back to its file. The file is renamed into place, so
+// that a concurrent query never reads a partial history.
+func (h *History) Save() error {
+	if h == nil {
+		return nil
+	}
+	data, err := json.MarshalIndent(h, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to save the query history: %w", err)
+	}
+	if err := ioutils.WriteFileAtomic(h.path, data); err != nil {
+		return fmt.Errorf("failed to save the query history: %w", err)
+	}
+	return nil
+}
+
+// AddQuery records a query that ran, moving it first if it ran before.
+func (h *History) AddQuery(verb, query string) {
+	if h == nil {
+		return
+	}
+	entry := HistoryQuery{Verb: verb, Query: query}
+	queries := []HistoryQuery{entry}
+	for _, q := range h.Queries {
+		if q != entry {
+			queries = append(queries, q)
+		}
+	}
+	if len(queries) > maxHistoryQueries {
+		queries = queries[:maxHistoryQueries]
+	}
+	h.Queries = queries
+}
+
+// AddPlaceholderValue records a value given to a placeholder, moving it first
+// if it was given before.
+func (h *History) AddPlaceholderValue(placeholder, value string) {
+	if h == nil {
+		return
+	}
+	values := []string{value}
+	for _, v := range h.Placeholders[placeholder] {
+		if v != value {
+			values = append(values, v)
+		}
+	}
+	if len(values) > maxHistoryValues {
+		values = values[:maxHistoryValues]
+	}
+	h.Placeholders[placeholder] = values
+}
+
+// RecentQueries returns the recent queries of the given verb, or of every
+// verb when it is empty, the most recent first.
+func (h *History) RecentQueries(verb string) []HistoryQuery {
+	if h == nil {
+		return nil
+	}
+	var queries []HistoryQuery
+	for _, q := range h.Queries {
+		if verb == "" || q.Verb == verb {
+			queries = append(queries, q)
+		}
+	}
+	return queries
+}
+
+// RecentValues returns the recent values of the given placeholder, the most
+// recent first.
+func (h *History) RecentValues(placeholder string) []string {
+	if h == nil {
+		return nil
+	}
+	return h.Placeholders[placeholder]
+}
@@ -0,0 +1,60 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package shared_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"aspect.build/cli/pkg/aspect/query/shared"
+)
+
+func TestHistory(t *testing.T) {
+	t.Run("a saved history is loaded", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		dir, err := os.MkdirTemp(os.Getenv("TEST_TMPDIR"), "history")
+		g.Expect(err).To(BeNil())
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "history", "ws.json")
+
+		var stderr strings.Builder
+		history := shared.LoadHistory(path, &stderr)
+		history.AddQuery("query", "deps(//lib)")
+		history.AddPlaceholderValue("target", "//lib")
+		g.Expect(history.Save()).To(Succeed())
+
+		history = shared.LoadHistory(path, &stderr)
+		g.Expect(history.RecentQueries("")).To(Equal([]shared.HistoryQuery{{Verb: "query", Query: "deps(//lib)"}}))
+		g.Expect(history.RecentValues("target")).To(Equal([]string{"//lib"}))
+		g.Expect(stderr.String()).To(BeEmpty())
+	})
+
+	t.Run("a malformed history is empty and replaced on save", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		dir, err := os.MkdirTemp(os.Getenv("TEST_TMPDIR"), "history")
+		g.Expect(err).To(BeNil())
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "ws.json")
+		g.Expect(os.WriteFile(path, []byte(`{"queries": [`), 0644)).To(Succeed())
+
+		var stderr strings.Builder
+		history := shared.LoadHistory(path, &stderr)
+		g.Expect(stderr.String()).To(HavePrefix("Ignoring the query history " + path))
+		g.Expect(history.RecentQueries("")).To(BeEmpty())
+
+		history.AddQuery("query", "deps(//lib)")
+		g.Expect(history.Save()).To(Succeed())
+		stderr.Reset()
+		history = shared.LoadHistory(path, &stderr)
+		g.Expect(stderr.String()).To(BeEmpty())
+		g.Expect(history.RecentQueries("query")).To(HaveLen(1))
+	})
+}
@@ -0,0 +1,290 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package shared
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/manifoldco/promptui"
+
+	"aspect.build/cli/pkg/completion"
+)
+
+// placeholderRegex matches a ?placeholder of a preset query, with an optional
+// type, e.g. ?target, ?target:label, ?depth:int or ?kind:enum(a,b).
+var placeholderRegex = regexp.MustCompile(`\?([a-zA-Z_][a-zA-Z0-9_]*)(?::(label|int|enum\(([^)]*)\)))?`)
+
+// labelRegex matches the labels and target patterns that a label placeholder
+// accepts, e.g. //foo:bar, @repo//foo/..., :bar or foo/bar.
+var labelRegex = regexp.MustCompile(`^(@@?[\w.~+-]*)?(//)?[\w./+=,@~$%^&-]*(:[\w./+=,@~$%^&*-]+)?$`)
+
+// Placeholder types.
+const (
+	TextPlaceholder  = ""
+	LabelPlaceholder = "label"
+	IntPlaceholder   = "int"
+	EnumPlaceholder  = "enum"
+)
+
+// Placeholder is a value to fill in a preset query.
+type Placeholder struct {
+	Name string
+	// Type is one of the placeholder types, TextPlaceholder accepting any
+	// value.
+	Type string
+	// Options are the values that an EnumPlaceholder accepts.
+	Options []string
+}
+
+// ParsePlaceholders returns the placeholders of the query, in the order they
+// first appear. A placeholder that appears several times is filled with the
+// same value, and only needs its type once.
+func ParsePlaceholders(query string) ([]Placeholder, error) {
+	var placeholders []Placeholder
+	index := make(map[string]int)
+	for _, match := range placeholderRegex.FindAllStringSubmatch(query, -1) {
+		p := Placeholder{Name: match[1], Type: match[2]}
+		if strings.HasPrefix(p.Type, EnumPlaceholder+"(") {
+			p.Type = EnumPlaceholder
+			for _, option := range strings.Split(match[3], ",") {
+				if option = strings.TrimSpace(option); option != "" {
+					p.Options = append(p.Options, option)
+				}
+			}
+			if len(p.Options) == 0 {
+				return nil, fmt.Errorf("placeholder %q has an enum type without options", p.Name)
+			}
+		}
+		i, seen := index[p.Name]
+		if !seen {
+			index[p.Name] = len(placeholders)
+			placeholders = append(placeholders, p)
+			continue
+		}
+		switch existing := placeholders[i]; {
+		case p.Type == TextPlaceholder:
+		case existing.Type == TextPlaceholder:
+			placeholders[i] = p
+		case existing.Type != p.Type || strings.Join(existing.Options, ",") != strings.Join(p.Options, ","):
+			return nil, fmt.Errorf("placeholder %q has conflicting types", p.Name)
+		}
+	}
+	return placeholders, nil
+}
+
+// Validate returns an error when the value doesn't have the type of the
+// placeholder.
+func (p Placeholder) Validate(value string) error {
+	switch p.Type {
+	case LabelPlaceholder:
+		if value == "" || !labelRegex.MatchString(value) {
+			return fmt.Errorf("invalid value %q for placeholder %q: expected a label", value, p.Name)
+		}
+	case IntPlaceholder:
+		if _, err := strconv.Atoi(value); err != nil {
+			return fmt.Errorf("invalid value %q for placeholder %q: expected an integer", value, p.Name)
+		}
+	case EnumPlaceholder:
+		for _, option := range p.Options {
+			if value == option {
+				return nil
+			}
+		}
+		return fmt.Errorf("invalid value %q for placeholder %q: expected one of %s", value, p.Name, strings.Join(p.Options, ", "))
+	}
+	return nil
+}
+
+// PlaceholderValues are the sources of the values of the placeholders of a
+// query, by precedence.
+type PlaceholderValues struct {
+	// Set are the values given by name, e.g. with --set target=//foo.
+	Set map[string]string
+	// Args are the arguments of the command: the preset name or the query,
+	// followed by the values given by position, which fill the placeholders
+	// that aren't set by name in order.
+	Args []string
+	// Prompt prompts for the values that aren't given.
+	Prompt func(label string) PromptRunner
+	// PromptLabel prompts for the values of label placeholders, offering the
+	// labels of the workspace. Prompt is used when it is nil.
+	PromptLabel func(label string) PromptRunner
+	// History remembers the values, and offers the most recent one as the
+	// default of a prompt.
+	History *History
+	// Stderr is where the values that aren't prompted for are reported, so
+	// that they don't mix with the result of the query. Nothing is reported
+	// when it is nil.
+	Stderr io.Writer
+}
+
+// ParseSet parses the name=value pairs of the --set flag.
+func ParseSet(pairs []string) (map[string]string, error) {
+	set := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		i := strings.Index(pair, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid --set %q: expected name=value", pair)
+		}
+		set[pair[:i]] = pair[i+1:]
+	}
+	return set, nil
+}
+
+// ReplacePlaceholders fills the placeholders of the query with the given
+// values, and prompts for the missing ones. Every value is validated against
+// the type of its placeholder.
+func ReplacePlaceholders(query string, v PlaceholderValues) (string, error) {
+	placeholders, err := ParsePlaceholders(query)
+	if err != nil {
+		return "", err
+	}
+	byName := make(map[string]Placeholder, len(placeholders))
+	for _, p := range placeholders {
+		byName[p.Name] = p
+	}
+	for name := range v.Set {
+		if _, ok := byName[name]; !ok {
+			return "", fmt.Errorf("unknown placeholder %q in --set, expected one of %s", name, placeholderNames(placeholders))
+		}
+	}
+
+	values := make(map[string]string, len(placeholders))
+	var args []string
+	if len(v.Args) > 0 {
+		args = v.Args[1:]
+	}
+	for _, p := range placeholders {
+		if value, ok := v.Set[p.Name]; ok {
+			values[p.Name] = value
+		} else if len(args) > 0 {
+			values[p.Name] = args[0]
+			args = args[1:]
+		}
+	}
+	if len(args) > 0 {
+		return "", fmt.Errorf("too many values: the query takes %d (%s), got %d more", len(placeholders), placeholderNames(placeholders), len(args))
+	}
+
+	for _, p := range placeholders {
+		value, ok := values[p.Name]
+		if ok {
+			if v.Stderr != nil {
+				fmt.Fprintf(v.Stderr, "%s set to %s\n", p.Name, value)
+			}
+		} else {
+			if value, err = promptPlaceholder(p, v); err != nil {
+				return "", err
+			}
+		}
+		if err := p.Validate(value); err != nil {
+			return "", err
+		}
+		values[p.Name] = value
+		v.History.AddPlaceholderValue(p.Name, value)
+	}
+
+	return placeholderRegex.ReplaceAllStringFunc(query, func(token string) string {
+		return values[placeholderRegex.FindStringSubmatch(token)[1]]
+	}), nil
+}
+
+// promptPlaceholder prompts for the value of the placeholder. An empty answer
+// picks the most recent value.
+func promptPlaceholder(p Placeholder, v PlaceholderValues) (string, error) {
+	label := fmt.Sprintf("Value for '%s'", p.Name)
+	switch p.Type {
+	case LabelPlaceholder:
+		label += " (a label)"
+	case IntPlaceholder:
+		label += " (an integer)"
+	case EnumPlaceholder:
+		label += fmt.Sprintf(" (one of %s)", strings.Join(p.Options, ", "))
+	}
+	recent := v.History.RecentValues(p.Name)
+	if len(recent) > 0 {
+		label = fmt.Sprintf("%s (empty for %s)", label, recent[0])
+	}
+	prompt := v.Prompt
+	if p.Type == LabelPlaceholder && v.PromptLabel != nil {
+		prompt = v.PromptLabel
+	}
+	value, err := prompt(label).Run()
+	if err != nil {
+		return "", err
+	}
+	if value == "" && len(recent) > 0 {
+		value = recent[0]
+	}
+	return value, nil
+}
+
+func placeholderNames(placeholders []Placeholder) string {
+	names := make([]string, len(placeholders))
+	for i, p := range placeholders {
+		names[i] = p.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// typeLabel is the first item of the label picker, to type a label instead
+// of picking one.
+const typeLabel = "Type a label or a pattern"
+
+// LabelPicker is a PromptRunner that completes a label from the packages and
+// targets of the workspace, one package segment at a time.
+type LabelPicker struct {
+	Label         string
+	WorkspaceRoot string
+	// Wd is the working directory, which relative labels are resolved
+	// against.
+	Wd string
+}
+
+// LabelPrompt returns a function creating a LabelPicker for the given
+// workspace, to use as PlaceholderValues.PromptLabel.
+func LabelPrompt(workspaceRoot, wd string) func(label string) PromptRunner {
+	return func(label string) PromptRunner {
+		return &LabelPicker{Label: label, WorkspaceRoot: workspaceRoot, Wd: wd}
+	}
+}
+
+// Run implements PromptRunner.
+func (l *LabelPicker) Run() (string, error) {
+	partial := "//"
+	for {
+		candidates := completion.Labels(l.WorkspaceRoot, l.Wd, partial)
+		if strings.HasSuffix(partial, "/") && partial != "//" {
+			candidates = append([]string{partial + "..."}, candidates...)
+		}
+		items := append([]string{typeLabel}, candidates...)
+		s := &promptui.Select{
+			Label: fmt.Sprintf("%s: %s", l.Label, partial),
+			Items: items,
+			Size:  10,
+			Searcher: func(input string, index int) bool {
+				return FuzzyMatch(input, items[index])
+			},
+		}
+		i, _, err := s.Run()
+		if err != nil {
+			return "", err
+		}
+		if i == 0 {
+			return (&promptui.Prompt{Label: l.Label, Default: strings.TrimSuffix(partial, ":")}).Run()
+		}
+		candidate := candidates[i-1]
+		if !strings.HasSuffix(candidate, ":") && !strings.HasSuffix(candidate, "/") {
+			return candidate, nil
+		}
+		partial = candidate
+	}
+}
@@ -0,0 +1,129 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package shared_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"aspect.build/cli/pkg/aspect/query/shared"
+)
+
+type answers []string
+
+func (a *answers) prompt(label string) shared.PromptRunner {
+	return a
+}
+
+func (a *answers) Run() (string, error) {
+	if len(*a) == 0 {
+		return "", fmt.Errorf("no more answers")
+	}
+	answer := (*a)[0]
+	*a = (*a)[1:]
+	return answer, nil
+}
+
+func TestParsePlaceholders(t *testing.T) {
+	t.Run("placeholders are typed and unique by name", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		placeholders, err := shared.ParsePlaceholders("kind(?kind:enum(cc_library, go_library), deps(?target:label, ?depth:int)) except ?target")
+		g.Expect(err).To(BeNil())
+		g.Expect(placeholders).To(Equal([]shared.Placeholder{
+			{Name: "kind", Type: shared.EnumPlaceholder, Options: []string{"cc_library", "go_library"}},
+			{Name: "target", Type: shared.LabelPlaceholder},
+			{Name: "depth", Type: shared.IntPlaceholder},
+		}))
+	})
+
+	t.Run("a placeholder can't have conflicting types", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		_, err := shared.ParsePlaceholders("somepath(?a:label, ?a:int)")
+		g.Expect(err).To(MatchError(`placeholder "a" has conflicting types`))
+	})
+}
+
+func TestReplacePlaceholders(t *testing.T) {
+	t.Run("values are given by name, by position, then prompted for", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		prompted := &answers{"go_library"}
+
+		var stderr strings.Builder
+		query, err := shared.ReplacePlaceholders("deps(?target:label, ?depth:int) except kind(?kind, ?target)", shared.PlaceholderValues{
+			Set:    map[string]string{"target": "//foo"},
+			Args:   []string{"preset", "3"},
+			Prompt: prompted.prompt,
+			Stderr: &stderr,
+		})
+		g.Expect(err).To(BeNil())
+		g.Expect(query).To(Equal("deps(//foo, 3) except kind(go_library, //foo)"))
+		g.Expect(*prompted).To(BeEmpty())
+		g.Expect(stderr.String()).To(Equal("target set to //foo\ndepth set to 3\n"))
+	})
+
+	t.Run("label placeholders are prompted for with the label prompt", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		prompted := &answers{}
+		labels := &answers{"//foo/..."}
+
+		query, err := shared.ReplacePlaceholders("deps(?target:label)", shared.PlaceholderValues{
+			Prompt:      prompted.prompt,
+			PromptLabel: labels.prompt,
+		})
+		g.Expect(err).To(BeNil())
+		g.Expect(query).To(Equal("deps(//foo/...)"))
+	})
+
+	t.Run("values are validated", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		_, err := shared.ReplacePlaceholders("deps(?target:label, ?depth:int)", shared.PlaceholderValues{
+			Args: []string{"preset", "//foo", "deep"},
+		})
+		g.Expect(err).To(MatchError(`invalid value "deep" for placeholder "depth": expected an integer`))
+
+		_, err = shared.ReplacePlaceholders("deps(?target:label)", shared.PlaceholderValues{
+			Args: []string{"preset", "not a label"},
+		})
+		g.Expect(err).To(MatchError(`invalid value "not a label" for placeholder "target": expected a label`))
+
+		_, err = shared.ReplacePlaceholders("kind(?kind:enum(cc_library,go_library), //...)", shared.PlaceholderValues{
+			Set: map[string]string{"kind": "java_library"},
+		})
+		g.Expect(err).To(MatchError(`invalid value "java_library" for placeholder "kind": expected one of cc_library, go_library`))
+	})
+
+	t.Run("extra values and unknown names are rejected", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		_, err := shared.ReplacePlaceholders("deps(?target)", shared.PlaceholderValues{
+			Args: []string{"preset", "//foo", "//bar"},
+		})
+		g.Expect(err).To(MatchError("too many values: the query takes 1 (target), got 1 more"))
+
+		_, err = shared.ReplacePlaceholders("deps(?target)", shared.PlaceholderValues{
+			Set: map[string]string{"tagret": "//foo"},
+		})
+		g.Expect(err).To(MatchError(`unknown placeholder "tagret" in --set, expected one of target`))
+	})
+
+	t.Run("--set takes name=value pairs", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		set, err := shared.ParseSet([]string{"target=//foo:bar", "expr=a=b"})
+		g.Expect(err).To(BeNil())
+		g.Expect(set).To(Equal(map[string]string{"target": "//foo:bar", "expr": "a=b"}))
+
+		_, err = shared.ParseSet([]string{"target"})
+		g.Expect(err).To(MatchError(`invalid --set "target": expected name=value`))
+	})
+}
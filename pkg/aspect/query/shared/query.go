@@ -12,20 +12,18 @@ This package is meant to contain code that will be shared between the 3 query ve
 
 import (
 	"fmt"
-	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/manifoldco/promptui"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 
+	"aspect.build/cli/pkg/aspect/root/config"
 	"aspect.build/cli/pkg/aspecterrors"
 	"aspect.build/cli/pkg/bazel"
 	"aspect.build/cli/pkg/ioutils"
 )
 
-var placeholderRegex = regexp.MustCompile(`(\?[a-zA-Z]*)`)
-
 type PresetQuery struct {
 	Name        string
 	Description string
@@ -60,12 +58,29 @@ type SelectRunner interface {
 
 func Select(presetNames []string) SelectRunner {
 	return &promptui.Select{
-		Label: "Select a preset query",
+		Label: "Select a preset query, or type / to search",
 		Items: presetNames,
+		Searcher: func(input string, index int) bool {
+			return FuzzyMatch(input, presetNames[index])
+		},
 	}
 }
 
-func PrecannedQueries(verb string, viper viper.Viper) []*PresetQuery {
+// FuzzyMatch returns whether the characters of input appear in s in the same
+// order, ignoring case and spaces, e.g. "rdfoo" matches "rdeps: //foo".
+func FuzzyMatch(input string, s string) bool {
+	s = strings.ToLower(s)
+	for _, r := range strings.ToLower(strings.ReplaceAll(input, " ", "")) {
+		i := strings.IndexRune(s, r)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(string(r)):]
+	}
+	return true
+}
+
+func PrecannedQueries(verb string, userDefinedQueries map[string]config.QueryPresetValues) []*PresetQuery {
 	presets := []*PresetQuery{
 		{
 			Name:        "why",
@@ -93,18 +108,20 @@ func PrecannedQueries(verb string, viper viper.Viper) []*PresetQuery {
 		},
 	}
 
-	presetsKey := "query.presets"
-
-	userDefinedQueries := viper.GetStringMap(presetsKey)
-
+	names := make([]string, 0, len(userDefinedQueries))
 	for name := range userDefinedQueries {
-		userDefinedQuery := viper.GetStringMapString(fmt.Sprintf("%s.%s", presetsKey, name))
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		userDefinedQuery := userDefinedQueries[name]
 
 		presetQuery := &PresetQuery{
 			Name:        name,
-			Description: userDefinedQuery["description"],
-			Query:       userDefinedQuery["query"],
-			Verb:        userDefinedQuery["verb"],
+			Description: userDefinedQuery.Description,
+			Query:       userDefinedQuery.Query,
+			Verb:        userDefinedQuery.Verb,
 		}
 
 		presetExists, existingPresetIndex := isPresetQueryInSlice(presetQuery, presets)
@@ -171,30 +188,17 @@ func RunQuery(bzl bazel.Bazel, verb string, query string) error {
 	return nil
 }
 
-func ReplacePlaceholders(query string, args []string, p func(label string) PromptRunner) (string, error) {
-	placeholders := placeholderRegex.FindAllString(query, -1)
-
-	if len(placeholders) == len(args)-1 {
-		for i, placeholder := range placeholders {
-			fmt.Printf("%s set to %s\n", strings.Replace(placeholder, "?", "", 1), args[i+1])
-			// todo.... Print out targetA was set to //foo and targetB was set to //bar
-			query = strings.ReplaceAll(query, placeholder, args[i+1])
-		}
-	} else if len(placeholders) > 0 {
-		for _, placeholder := range placeholders {
-			label := fmt.Sprintf("Value for '%s'", strings.TrimPrefix(placeholder, "?"))
-			prompt := p(label)
-			val, err := prompt.Run()
-
-			if err != nil {
-				return "", err
-			}
-
-			query = strings.ReplaceAll(query, placeholder, val)
+// SavePreset saves the query as a preset in the workspace config, so that it
+// can be selected by name later.
+func SavePreset(cfg *config.Config, name string, verb string, query string) error {
+	prefix := fmt.Sprintf("query.presets.%s.", name)
+	prefs := cfg.Preferences()
+	for _, kv := range [][2]string{{"query", query}, {"verb", verb}, {"description", query}} {
+		if err := prefs.Set(prefix+kv[0], kv[1]); err != nil {
+			return fmt.Errorf("failed to save preset %q: %w", name, err)
 		}
 	}
-
-	return query, nil
+	return nil
 }
 
 func SelectQuery(
@@ -202,6 +206,7 @@ func SelectQuery(
 	processedPresets map[string]*PresetQuery,
 	rawPresets []*PresetQuery,
 	presetNames []string,
+	recent []HistoryQuery,
 	streams ioutils.Streams,
 	args []string,
 	s func(presetNames []string) SelectRunner,
@@ -209,7 +214,12 @@ func SelectQuery(
 
 	var preset *PresetQuery
 	if len(args) == 0 {
-		selectQueryPrompt := s(presetNames)
+		// The recent queries are offered after the presets.
+		items := append([]string{}, presetNames...)
+		for _, r := range recent {
+			items = append(items, fmt.Sprintf("recent: %s %s", r.Verb, r.Query))
+		}
+		selectQueryPrompt := s(items)
 
 		i, _, err := selectQueryPrompt.Run()
 
@@ -217,6 +227,10 @@ func SelectQuery(
 			return verb, "", false, err
 		}
 
+		if i >= len(rawPresets) {
+			r := recent[i-len(rawPresets)]
+			return r.Verb, r.Query, false, nil
+		}
 		preset = rawPresets[i]
 	} else {
 		maybeQueryOrPreset := args[0]
@@ -226,8 +240,9 @@ func SelectQuery(
 			fmt.Fprintf(streams.Stdout, "%s: %s\n", value.Name, value.Description)
 			preset = value
 		} else {
-			// Treat this as a raw query expression.
-			return verb, maybeQueryOrPreset, false, nil
+			// Treat this as a raw query expression, which has placeholders
+			// when it is meant to be saved as a preset.
+			return verb, maybeQueryOrPreset, placeholderRegex.MatchString(maybeQueryOrPreset), nil
 		}
 	}
 
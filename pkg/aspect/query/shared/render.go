@@ -0,0 +1,392 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package shared
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"aspect.build/cli/pkg/aspecterrors"
+	"aspect.build/cli/pkg/bazel"
+	"aspect.build/cli/pkg/ioutils"
+)
+
+// Graph renderings.
+const (
+	RenderTree = "tree"
+	RenderDot  = "dot"
+	RenderSVG  = "svg"
+	RenderHTML = "html"
+)
+
+// Output is how the result of a query is output.
+type Output struct {
+	// Format is the --output of Bazel, e.g. label, graph or label_kind. Bazel's
+	// default is used when it is empty.
+	Format string
+	// Render is how a graph is rendered: a tree in the terminal, Bazel's dot
+	// output, or an svg or html file.
+	Render string
+	// File is where an svg or html rendering is written.
+	File string
+	// Diff is a git revision to compare the result of the query with.
+	Diff string
+	// NoCache runs the query even when its result is cached.
+	NoCache bool
+	// Cache caches the result of the query when it is set.
+	Cache *QueryCache
+}
+
+// AddOutputFlags adds the flags that set the output of a query to the command.
+func AddOutputFlags(cmd *cobra.Command, output *Output) {
+	cmd.Flags().StringVarP(&output.Format, "output", "", "", `The output format of Bazel, e.g. label, graph or label_kind.
+A graph is rendered as set by --render, and label_kind as a table.`)
+	cmd.Flags().StringVarP(&output.Render, "render", "", RenderTree, `How to render a graph: tree prints it in the terminal, dot as Bazel
+outputs it, svg (with Graphviz) and html write it to --render-file.`)
+	cmd.Flags().StringVarP(&output.File, "render-file", "", "", `The file to write an svg or html rendering to.
+Defaults to query.svg or query.html.`)
+	cmd.Flags().StringVarP(&output.Diff, "diff", "", "", `Compare the result of the query in the working tree with its result
+at this git revision, which is queried in a separate output base.`)
+	cmd.Flags().BoolVarP(&output.NoCache, "no-cache", "", false, `Run the query even when its result is cached. The results are cached
+until a BUILD, .bzl, WORKSPACE, rc or lock file changes, a file is added
+or removed, or the startup options change. Set it when a repository rule
+reads another file.`)
+}
+
+// RunQueryOutput runs the query with the given output format, and renders its
+// result when the format is a graph or label_kind.
+func RunQueryOutput(bzl bazel.Bazel, streams ioutils.Streams, verb string, query string, output Output) error {
+	switch output.Format {
+	case "":
+		if output.Cache == nil {
+			return RunQuery(bzl, verb, query)
+		}
+		return spawnQuery(bzl, streams, output.Cache, verb, query, []string{verb, query})
+	case "graph":
+		if output.Render == RenderDot {
+			break
+		}
+		result, err := captureQuery(bzl, output.Cache, verb, query, output.Format, nil)
+		if err != nil {
+			return err
+		}
+		return renderGraph(streams, ParseGraph(result), output)
+	case "label_kind":
+		result, err := captureQuery(bzl, output.Cache, verb, query, output.Format, nil)
+		if err != nil {
+			return err
+		}
+		return RenderLabelKinds(streams.Stdout, result)
+	}
+
+	return spawnQuery(bzl, streams, output.Cache, verb, query, []string{verb, "--output=" + output.Format, query})
+}
+
+// spawnQuery runs the Bazel command of a query with its result written to
+// stdout.
+func spawnQuery(bzl bazel.Bazel, streams ioutils.Streams, cache *QueryCache, verb string, query string, bazelCmd []string) error {
+	var exitCode int
+	var err error
+	if cache == nil {
+		exitCode, err = bzl.Spawn(bazelCmd)
+	} else {
+		exitCode, err = cache.run(bzl, bazelCmd, streams.Stdout)
+	}
+	if exitCode != 0 {
+		return queryError(verb, query, exitCode, err)
+	}
+	return nil
+}
+
+// CaptureQuery runs the query with the given output format and flags, and
+// returns its result.
+func CaptureQuery(bzl bazel.Bazel, cache *QueryCache, verb string, query string, format string, flags ...string) ([]byte, error) {
+	return captureQuery(bzl, cache, verb, query, format, flags)
+}
+
+func captureQuery(bzl bazel.Bazel, cache *QueryCache, verb string, query string, format string, flags []string) ([]byte, error) {
+	bazelCmd := append(append([]string{verb, "--output=" + format}, flags...), query)
+	var out bytes.Buffer
+	if exitCode, err := cache.run(bzl, bazelCmd, &out); exitCode != 0 {
+		return nil, queryError(verb, query, exitCode, err)
+	}
+	return out.Bytes(), nil
+}
+
+// queryError is the error of a query that Bazel exited with the given code
+// for.
+func queryError(verb string, query string, exitCode int, err error) error {
+	err = &aspecterrors.ExitError{
+		Err:      err,
+		ExitCode: exitCode,
+	}
+	return fmt.Errorf("failed to run %q %q: %w", verb, query, err)
+}
+
+// Graph is the result of a query with --output=graph.
+type Graph struct {
+	// Nodes are the labels of the graph, in the order Bazel outputs them. A
+	// factored node groups several labels, one per line.
+	Nodes []string
+	// Edges are the dependencies of each node.
+	Edges map[string][]string
+}
+
+// graphLineRegex matches a node or an edge of the dot output of Bazel, e.g.
+// "//foo:bar" or "//foo:bar" -> "//baz:qux".
+var graphLineRegex = regexp.MustCompile(`^\s*"([^"]*)"(?:\s*->\s*"([^"]*)")?`)
+
+// ParseGraph parses the dot output of a query with --output=graph.
+func ParseGraph(dot []byte) *Graph {
+	g := &Graph{Edges: make(map[string][]string)}
+	seen := make(map[string]bool)
+	addNode := func(node string) {
+		if !seen[node] {
+			seen[node] = true
+			g.Nodes = append(g.Nodes, node)
+		}
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(dot))
+	for scanner.Scan() {
+		match := graphLineRegex.FindStringSubmatch(scanner.Text())
+		if match == nil {
+			continue
+		}
+		from := strings.ReplaceAll(match[1], `\n`, "\n")
+		addNode(from)
+		if match[2] != "" {
+			to := strings.ReplaceAll(match[2], `\n`, "\n")
+			addNode(to)
+			g.Edges[from] = append(g.Edges[from], to)
+		}
+	}
+	return g
+}
+
+// Roots returns the nodes that no other node depends on. In a graph made only
+// of cycles, the first node is the root.
+func (g *Graph) Roots() []string {
+	dependedOn := make(map[string]bool)
+	for _, deps := range g.Edges {
+		for _, dep := range deps {
+			dependedOn[dep] = true
+		}
+	}
+	var roots []string
+	for _, node := range g.Nodes {
+		if !dependedOn[node] {
+			roots = append(roots, node)
+		}
+	}
+	if len(roots) == 0 && len(g.Nodes) > 0 {
+		roots = g.Nodes[:1]
+	}
+	return roots
+}
+
+// RenderTree prints the graph as a tree from its roots. The dependencies of a
+// node are only printed under its first occurrence.
+func (g *Graph) RenderTree(out io.Writer) {
+	g.RenderTreeFunc(out, func(parent string, node string) string {
+		return strings.ReplaceAll(node, "\n", ", ")
+	})
+}
+
+// RenderTreeFunc is RenderTree with the given name for each node, which is
+// called with the parent of the node, or "" for a root.
+func (g *Graph) RenderTreeFunc(out io.Writer, nodeName func(parent string, node string) string) {
+	printed := make(map[string]bool)
+	var render func(parent string, node string, prefix string, branch string, indent string)
+	render = func(parent string, node string, prefix string, branch string, indent string) {
+		name := nodeName(parent, node)
+		if printed[node] && len(g.Edges[node]) > 0 {
+			fmt.Fprintf(out, "%s%s%s (see above)\n", prefix, branch, name)
+			return
+		}
+		printed[node] = true
+		fmt.Fprintf(out, "%s%s%s\n", prefix, branch, name)
+		deps := g.Edges[node]
+		for i, dep := range deps {
+			if i == len(deps)-1 {
+				render(node, dep, prefix+indent, "└── ", "    ")
+			} else {
+				render(node, dep, prefix+indent, "├── ", "│   ")
+			}
+		}
+	}
+	for _, root := range g.Roots() {
+		render("", root, "", "", "")
+	}
+	// The cycles that no root depends on.
+	for _, node := range g.Nodes {
+		if !printed[node] {
+			render("", node, "", "", "")
+		}
+	}
+}
+
+// RenderHTML writes the graph as an html page of collapsible trees, which
+// doesn't need any tool to be installed.
+func (g *Graph) RenderHTML(out io.Writer) {
+	rendered := make(map[string]bool)
+	var render func(node string)
+	render = func(node string) {
+		name := html.EscapeString(strings.ReplaceAll(node, "\n", ", "))
+		deps := g.Edges[node]
+		if len(deps) == 0 {
+			fmt.Fprintf(out, "<li>%s</li>\n", name)
+			return
+		}
+		if rendered[node] {
+			fmt.Fprintf(out, "<li>%s <em>(see above)</em></li>\n", name)
+			return
+		}
+		rendered[node] = true
+		fmt.Fprintf(out, "<li><details open><summary>%s</summary>\n<ul>\n", name)
+		for _, dep := range deps {
+			render(dep)
+		}
+		fmt.Fprint(out, "</ul>\n</details></li>\n")
+	}
+	fmt.Fprint(out, `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Query graph</title>
+<style>
+body { font-family: monospace; }
+ul { list-style: none; padding-left: 1.5em; }
+summary { cursor: pointer; }
+</style>
+</head>
+<body>
+<ul>
+`)
+	for _, root := range g.Roots() {
+		render(root)
+	}
+	// The cycles that no root depends on.
+	for _, node := range g.Nodes {
+		if len(g.Edges[node]) > 0 && !rendered[node] {
+			render(node)
+		}
+	}
+	fmt.Fprint(out, "</ul>\n</body>\n</html>\n")
+}
+
+// renderGraph renders the graph as set by the output.
+func renderGraph(streams ioutils.Streams, g *Graph, output Output) error {
+	switch output.Render {
+	case RenderTree:
+		g.RenderTree(streams.Stdout)
+		return nil
+	case RenderSVG, RenderHTML:
+	default:
+		return fmt.Errorf("unknown rendering %q, expected one of %s, %s, %s or %s", output.Render, RenderTree, RenderDot, RenderSVG, RenderHTML)
+	}
+
+	file := output.File
+	if file == "" {
+		file = "query." + output.Render
+	}
+	var rendered bytes.Buffer
+	if output.Render == RenderHTML {
+		g.RenderHTML(&rendered)
+	} else {
+		dot, err := exec.LookPath("dot")
+		if err != nil {
+			return fmt.Errorf("failed to render the graph as svg: Graphviz's dot isn't installed, use --render=html instead")
+		}
+		cmd := exec.Command(dot, "-Tsvg")
+		cmd.Stdin = strings.NewReader(g.Dot())
+		cmd.Stdout = &rendered
+		cmd.Stderr = streams.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("failed to render the graph as svg: %w", err)
+		}
+	}
+	if err := ioutil.WriteFile(file, rendered.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write the graph: %w", err)
+	}
+	fmt.Fprintf(streams.Stdout, "Wrote the graph to %s\n", file)
+	return nil
+}
+
+// Dot returns the graph in the dot language.
+func (g *Graph) Dot() string {
+	var dot strings.Builder
+	quote := func(node string) string {
+		return `"` + strings.ReplaceAll(node, "\n", `\n`) + `"`
+	}
+	dot.WriteString("digraph query {\n  node [shape=box];\n")
+	for _, node := range g.Nodes {
+		fmt.Fprintf(&dot, "  %s\n", quote(node))
+		for _, dep := range g.Edges[node] {
+			fmt.Fprintf(&dot, "  %s -> %s\n", quote(node), quote(dep))
+		}
+	}
+	dot.WriteString("}\n")
+	return dot.String()
+}
+
+// RenderLabelKinds prints the result of a query with --output=label_kind as a
+// table sorted by kind. The configuration that cquery appends to each line,
+// e.g. "(9a2b1c)" or "(null)" for a source file, gets a column of its own.
+func RenderLabelKinds(out io.Writer, result []byte) error {
+	type labelKind struct{ kind, label, config string }
+	var rows []labelKind
+	hasConfig := false
+	scanner := bufio.NewScanner(bytes.NewReader(result))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		var config string
+		if i := strings.LastIndex(line, " ("); i >= 0 && strings.HasSuffix(line, ")") {
+			config = line[i+2 : len(line)-1]
+			line = line[:i]
+			hasConfig = true
+		}
+		// The kind has several words, e.g. "source file", so the label is
+		// the last one.
+		i := strings.LastIndex(line, " ")
+		if i < 0 {
+			continue
+		}
+		rows = append(rows, labelKind{kind: line[:i], label: line[i+1:], config: config})
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		return rows[i].kind < rows[j].kind
+	})
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	if hasConfig {
+		fmt.Fprintln(w, "Kind\tLabel\tConfiguration")
+		for _, row := range rows {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", row.kind, row.label, row.config)
+		}
+	} else {
+		fmt.Fprintln(w, "Kind\tLabel")
+		for _, row := range rows {
+			fmt.Fprintf(w, "%s\t%s\n", row.kind, row.label)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "\n%d target(s)\n", len(rows))
+	return nil
+}
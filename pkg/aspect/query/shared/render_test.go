@@ -0,0 +1,195 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package shared_test
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/gomega"
+
+	"aspect.build/cli/pkg/aspect/query/shared"
+	bazel_mock "aspect.build/cli/pkg/bazel/mock"
+	"aspect.build/cli/pkg/ioutils"
+)
+
+const graph = `digraph mygraph {
+  node [shape=box];
+  "//app:bin"
+  "//app:bin" -> "//lib:a"
+  "//app:bin" -> "//lib:b"
+  "//lib:a"
+  "//lib:a" -> "//lib:c\n//lib:d"
+  "//lib:b"
+  "//lib:b" -> "//lib:a"
+  "//lib:c\n//lib:d"
+}
+`
+
+func TestParseGraph(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	parsed := shared.ParseGraph([]byte(graph))
+	g.Expect(parsed.Nodes).To(Equal([]string{"//app:bin", "//lib:a", "//lib:b", "//lib:c\n//lib:d"}))
+	g.Expect(parsed.Edges).To(Equal(map[string][]string{
+		"//app:bin": {"//lib:a", "//lib:b"},
+		"//lib:a":   {"//lib:c\n//lib:d"},
+		"//lib:b":   {"//lib:a"},
+	}))
+	g.Expect(parsed.Roots()).To(Equal([]string{"//app:bin"}))
+}
+
+func TestRenderTree(t *testing.T) {
+	t.Run("the dependencies of a node are printed once", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		var out strings.Builder
+
+		shared.ParseGraph([]byte(graph)).RenderTree(&out)
+		g.Expect(out.String()).To(Equal(`//app:bin
+├── //lib:a
+│   └── //lib:c, //lib:d
+└── //lib:b
+    └── //lib:a (see above)
+`))
+	})
+
+	t.Run("cycles are printed", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		var out strings.Builder
+
+		shared.ParseGraph([]byte(`"//:a" -> "//:b"
+"//:b" -> "//:a"
+`)).RenderTree(&out)
+		g.Expect(out.String()).To(Equal(`//:a
+└── //:b
+    └── //:a (see above)
+`))
+	})
+}
+
+func TestRunQueryOutput(t *testing.T) {
+	t.Run("a graph is rendered as a tree", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		spawner := bazel_mock.NewMockBazel(ctrl)
+		spawner.
+			EXPECT().
+			RunCommand([]string{"query", "--output=graph", "deps(//app:bin)"}, gomock.Any()).
+			DoAndReturn(func(_ []string, out io.Writer) (int, error) {
+				_, err := io.WriteString(out, graph)
+				return 0, err
+			})
+
+		var stdout strings.Builder
+		err := shared.RunQueryOutput(spawner, ioutils.Streams{Stdout: &stdout}, "query", "deps(//app:bin)", shared.Output{Format: "graph", Render: shared.RenderTree})
+		g.Expect(err).To(BeNil())
+		g.Expect(stdout.String()).To(HavePrefix("//app:bin\n├── //lib:a\n"))
+	})
+
+	t.Run("a graph is written as an html file", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "render")
+		g.Expect(err).To(BeNil())
+		defer os.RemoveAll(dir)
+		file := filepath.Join(dir, "graph.html")
+
+		spawner := bazel_mock.NewMockBazel(ctrl)
+		spawner.
+			EXPECT().
+			RunCommand([]string{"cquery", "--output=graph", "deps(//app:bin)"}, gomock.Any()).
+			DoAndReturn(func(_ []string, out io.Writer) (int, error) {
+				_, err := io.WriteString(out, graph)
+				return 0, err
+			})
+
+		var stdout strings.Builder
+		err = shared.RunQueryOutput(spawner, ioutils.Streams{Stdout: &stdout}, "cquery", "deps(//app:bin)", shared.Output{Format: "graph", Render: shared.RenderHTML, File: file})
+		g.Expect(err).To(BeNil())
+		g.Expect(stdout.String()).To(Equal("Wrote the graph to " + file + "\n"))
+		html, err := ioutil.ReadFile(file)
+		g.Expect(err).To(BeNil())
+		g.Expect(string(html)).To(ContainSubstring("<li><details open><summary>//app:bin</summary>"))
+		g.Expect(string(html)).To(ContainSubstring("<li>//lib:a <em>(see above)</em></li>"))
+	})
+
+	t.Run("label_kind is tabulated by kind", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		spawner := bazel_mock.NewMockBazel(ctrl)
+		spawner.
+			EXPECT().
+			RunCommand([]string{"query", "--output=label_kind", "//lib/..."}, gomock.Any()).
+			DoAndReturn(func(_ []string, out io.Writer) (int, error) {
+				_, err := io.WriteString(out, "source file //lib:a.go\ngo_library rule //lib:a\ngo_test rule //lib:a_test\ngo_library rule //lib:b\n")
+				return 0, err
+			})
+
+		var stdout strings.Builder
+		err := shared.RunQueryOutput(spawner, ioutils.Streams{Stdout: &stdout}, "query", "//lib/...", shared.Output{Format: "label_kind"})
+		g.Expect(err).To(BeNil())
+		g.Expect(stdout.String()).To(Equal(`Kind             Label
+go_library rule  //lib:a
+go_library rule  //lib:b
+go_test rule     //lib:a_test
+source file      //lib:a.go
+
+4 target(s)
+`))
+	})
+
+	t.Run("the configuration of cquery's label_kind gets a column", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		spawner := bazel_mock.NewMockBazel(ctrl)
+		spawner.
+			EXPECT().
+			RunCommand([]string{"cquery", "--output=label_kind", "//lib/..."}, gomock.Any()).
+			DoAndReturn(func(_ []string, out io.Writer) (int, error) {
+				_, err := io.WriteString(out, "source file //lib:a.go (null)\ngo_library rule //lib:a (9a2b1c)\n")
+				return 0, err
+			})
+
+		var stdout strings.Builder
+		err := shared.RunQueryOutput(spawner, ioutils.Streams{Stdout: &stdout}, "cquery", "//lib/...", shared.Output{Format: "label_kind"})
+		g.Expect(err).To(BeNil())
+		g.Expect(stdout.String()).To(Equal(`Kind             Label       Configuration
+go_library rule  //lib:a     9a2b1c
+source file      //lib:a.go  null
+
+2 target(s)
+`))
+	})
+
+	t.Run("other formats are passed to Bazel", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		spawner := bazel_mock.NewMockBazel(ctrl)
+		spawner.
+			EXPECT().
+			Spawn([]string{"query", "--output=xml", "//lib/..."}).
+			Return(0, nil)
+
+		err := shared.RunQueryOutput(spawner, ioutils.Streams{}, "query", "//lib/...", shared.Output{Format: "xml", Render: shared.RenderTree})
+		g.Expect(err).To(BeNil())
+	})
+}
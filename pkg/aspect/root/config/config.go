@@ -0,0 +1,319 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mitchellh/go-homedir"
+	yaml "gopkg.in/yaml.v2"
+
+	"aspect.build/cli/pkg/pathutils"
+)
+
+// Filename is the name of the aspect config files.
+const Filename = ".aspect.yaml"
+
+// EnvPrefix is the prefix of the environment variables that set config keys.
+// The rest of the name is the key in upper case with `_` in place of `.`, e.g.
+// ASPECT_CLEAN_SKIP_PROMPT for clean.skip_prompt.
+const EnvPrefix = "ASPECT_"
+
+// Layer is a source of config values. The values of a layer take precedence
+// over the ones of the layers before it: user, workspace, then env.
+type Layer string
+
+const (
+	// DefaultLayer is the defaults of the keys, used when no layer sets them.
+	DefaultLayer Layer = "default"
+	// UserLayer is the config file in the user's home directory.
+	UserLayer Layer = "user"
+	// WorkspaceLayer is the config file at the root of the workspace.
+	WorkspaceLayer Layer = "workspace"
+	// EnvLayer is the environment variables prefixed with EnvPrefix.
+	EnvLayer Layer = "env"
+)
+
+// Setting is the value of a config key and where it comes from.
+type Setting struct {
+	Key   string
+	Value interface{}
+	Layer Layer
+	// Source is the file or the environment variable the value comes from.
+	Source string
+}
+
+// Origin returns the layer and source of the setting, e.g.
+// `user:/home/me/.aspect.yaml`, or only the layer if it has no source.
+func (s Setting) Origin() string {
+	if s.Source == "" {
+		return string(s.Layer)
+	}
+	return fmt.Sprintf("%s:%s", s.Layer, s.Source)
+}
+
+// Config is the aspect config merged from its layers.
+type Config struct {
+	layers []*layer
+}
+
+type layer struct {
+	layer  Layer
+	source string
+	exists bool
+	// settings are keyed by the dot-separated path of the keys.
+	settings map[string]interface{}
+}
+
+// DefaultFiles returns the paths of the user config file and of the workspace
+// config file. The latter is empty outside of a workspace.
+func DefaultFiles() (userFile string, workspaceFile string, err error) {
+	return defaultFiles(homedir.Dir, os.Getwd, pathutils.DefaultWorkspaceFinder)
+}
+
+func defaultFiles(
+	homeDir func() (string, error),
+	osGetwd func() (string, error),
+	workspaceFinder pathutils.WorkspaceFinder,
+) (string, string, error) {
+	home, err := homeDir()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to locate the user config: %w", err)
+	}
+	userFile := filepath.Join(home, Filename)
+
+	wd, err := osGetwd()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to locate the workspace config: %w", err)
+	}
+	workspacePath, err := workspaceFinder.Find(wd)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to locate the workspace config: %w", err)
+	}
+	if workspacePath == "" {
+		return userFile, "", nil
+	}
+	return userFile, filepath.Join(path.Dir(workspacePath), Filename), nil
+}
+
+// Load reads a new config from the given user and workspace files, either of
+// which may be empty or missing, and from the environment.
+func Load(userFile, workspaceFile string, environ []string) (*Config, error) {
+	c := &Config{}
+	if err := c.Load(userFile, workspaceFile, environ); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Load replaces the settings of c with the ones read from the given user and
+// workspace files, either of which may be empty or missing, and from the
+// environment. Unknown keys and values of the wrong type are errors located
+// by file and line.
+func (c *Config) Load(userFile, workspaceFile string, environ []string) error {
+	var layers []*layer
+	for _, f := range []struct {
+		layer Layer
+		path  string
+	}{
+		{UserLayer, userFile},
+		{WorkspaceLayer, workspaceFile},
+	} {
+		l := &layer{layer: f.layer, source: f.path, settings: map[string]interface{}{}}
+		if f.path != "" {
+			settings, exists, err := readFile(f.path)
+			if err != nil {
+				return fmt.Errorf("failed to load config: %w", err)
+			}
+			l.settings, l.exists = settings, exists
+		}
+		layers = append(layers, l)
+	}
+
+	envLayer, err := readEnv(environ)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	c.layers = append(layers, envLayer)
+	return nil
+}
+
+func readFile(path string) (map[string]interface{}, bool, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return map[string]interface{}{}, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	var content yaml.MapSlice
+	if err := yaml.Unmarshal(data, &content); err != nil {
+		return nil, false, fmt.Errorf("%s: %w", path, err)
+	}
+	if content, err = migrate(content); err != nil {
+		return nil, false, fmt.Errorf("%s: %w", location(path, data, versionKey), err)
+	}
+	settings := map[string]interface{}{}
+	mapLeaves("", content, func(key string, value interface{}) interface{} {
+		settings[key] = value
+		return value
+	})
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := Check(key, settings[key]); err != nil {
+			return nil, false, fmt.Errorf("%s: %w", location(path, data, key), err)
+		}
+	}
+	return settings, true, nil
+}
+
+// location returns the path of a config file followed by the line of the
+// given key, e.g. `/home/me/.aspect.yaml:3`, or only the path when the line
+// can't be found.
+func location(path string, data []byte, key string) string {
+	if line := lineOf(data, key); line > 0 {
+		return fmt.Sprintf("%s:%d", path, line)
+	}
+	return path
+}
+
+// lineOf returns the line of the given dot-separated key in a YAML document
+// made of block mappings, or 0 if it isn't found, e.g. in a flow mapping.
+func lineOf(data []byte, key string) int {
+	type entry struct {
+		indent int
+		key    string
+	}
+	var path []entry
+	for i, line := range strings.Split(string(data), "\n") {
+		trimmed := strings.TrimLeft(line, " ")
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, "-") {
+			continue
+		}
+		colon := strings.Index(trimmed, ":")
+		if colon <= 0 {
+			continue
+		}
+		indent := len(line) - len(trimmed)
+		for len(path) > 0 && path[len(path)-1].indent >= indent {
+			path = path[:len(path)-1]
+		}
+		path = append(path, entry{indent, strings.Trim(trimmed[:colon], `"' `)})
+		keys := make([]string, len(path))
+		for j, e := range path {
+			keys[j] = e.key
+		}
+		if strings.Join(keys, ".") == key {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+func readEnv(environ []string) (*layer, error) {
+	l := &layer{layer: EnvLayer, exists: true, settings: map[string]interface{}{}}
+	envKeys := make(map[string]string)
+	for _, k := range Keys {
+		if !strings.Contains(k.Pattern, "*") {
+			envKeys[EnvName(k.Pattern)] = k.Pattern
+		}
+	}
+	for _, env := range environ {
+		i := strings.Index(env, "=")
+		if i < 0 || !strings.HasPrefix(env, EnvPrefix) {
+			continue
+		}
+		key, ok := envKeys[env[:i]]
+		if !ok {
+			continue
+		}
+		values := []string{env[i+1:]}
+		if k, _ := LookupKey(key); k.Type == StringListType {
+			values = strings.Fields(env[i+1:])
+		}
+		value, err := ParseValue(key, values)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", env[:i], err)
+		}
+		l.settings[key] = value
+	}
+	return l, nil
+}
+
+// EnvName returns the name of the environment variable that sets the given
+// config key.
+func EnvName(key string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// Get returns the effective setting of the given key.
+func (c *Config) Get(key string) (Setting, bool) {
+	for i := len(c.layers) - 1; i >= 0; i-- {
+		if value, ok := c.layers[i].settings[key]; ok {
+			return c.layers[i].setting(key, value), true
+		}
+	}
+	return Setting{}, false
+}
+
+// List returns the effective settings, sorted by key.
+func (c *Config) List() []Setting {
+	effective := make(map[string]Setting)
+	for _, l := range c.layers {
+		for key, value := range l.settings {
+			effective[key] = l.setting(key, value)
+		}
+	}
+	settings := make([]Setting, 0, len(effective))
+	for _, s := range effective {
+		settings = append(settings, s)
+	}
+	sort.Slice(settings, func(i, j int) bool {
+		return settings[i].Key < settings[j].Key
+	})
+	return settings
+}
+
+func (l *layer) setting(key string, value interface{}) Setting {
+	source := l.source
+	if l.layer == EnvLayer {
+		source = EnvName(key)
+	}
+	return Setting{Key: key, Value: value, Layer: l.layer, Source: source}
+}
+
+// File returns the path of the config file of the given layer, or an empty
+// string if there is none, e.g. outside of a workspace.
+func (c *Config) File(layer Layer) string {
+	for _, l := range c.layers {
+		if l.layer == layer && l.layer != EnvLayer {
+			return l.source
+		}
+	}
+	return ""
+}
+
+// LoadedFiles returns the paths of the config files that exist.
+func (c *Config) LoadedFiles() []string {
+	var files []string
+	for _, l := range c.layers {
+		if l.layer != EnvLayer && l.exists {
+			files = append(files, l.source)
+		}
+	}
+	return files
+}
@@ -0,0 +1,258 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package config_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"aspect.build/cli/pkg/aspect/root/config"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func TestLoad(t *testing.T) {
+	t.Run("the layers are merged by precedence", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		dir := tempDir(t)
+		userFile := filepath.Join(dir, "user.yaml")
+		workspaceFile := filepath.Join(dir, "workspace.yaml")
+		writeFile(t, userFile, "clean:\n  skip_prompt: true\nquery:\n  all:\n    allow: true\n")
+		writeFile(t, workspaceFile, "clean:\n  skip_prompt: false\nstartup_options:\n  - --batch\n")
+
+		cfg, err := config.Load(userFile, workspaceFile, []string{
+			"ASPECT_QUERY_ALL_ALLOW=false",
+			"ASPECT_BUILD_STARTUP_OPTIONS=--foo",
+			"OTHER=1",
+		})
+		g.Expect(err).To(BeNil())
+
+		g.Expect(cfg.List()).To(Equal([]config.Setting{
+			{Key: "clean.skip_prompt", Value: false, Layer: config.WorkspaceLayer, Source: workspaceFile},
+			{Key: "query.all.allow", Value: false, Layer: config.EnvLayer, Source: "ASPECT_QUERY_ALL_ALLOW"},
+			{Key: "startup_options", Value: []interface{}{"--batch"}, Layer: config.WorkspaceLayer, Source: workspaceFile},
+		}))
+		setting, ok := cfg.Get("query.all.allow")
+		g.Expect(ok).To(BeTrue())
+		g.Expect(setting.Origin()).To(Equal("env:ASPECT_QUERY_ALL_ALLOW"))
+		g.Expect(cfg.LoadedFiles()).To(Equal([]string{userFile, workspaceFile}))
+	})
+
+	t.Run("missing files are empty", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		dir := tempDir(t)
+
+		cfg, err := config.Load(filepath.Join(dir, "missing.yaml"), "", nil)
+		g.Expect(err).To(BeNil())
+		g.Expect(cfg.List()).To(BeEmpty())
+		g.Expect(cfg.LoadedFiles()).To(BeEmpty())
+		g.Expect(cfg.File(config.WorkspaceLayer)).To(BeEmpty())
+	})
+
+	t.Run("unknown keys are rejected", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		dir := tempDir(t)
+		userFile := filepath.Join(dir, "user.yaml")
+		writeFile(t, userFile, "clean:\n  skip_promt: true\n")
+
+		_, err := config.Load(userFile, "", nil)
+		g.Expect(err).To(MatchError(`failed to load config: ` + userFile + `:2: unknown config key "clean.skip_promt"`))
+	})
+
+	t.Run("values of the wrong type are rejected", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		dir := tempDir(t)
+		userFile := filepath.Join(dir, "user.yaml")
+		writeFile(t, userFile, "# My presets\nquery:\n  presets:\n    foo:\n      verb: query\n      query: [a]\n")
+
+		_, err := config.Load(userFile, "", nil)
+		g.Expect(err).To(MatchError(`failed to load config: ` + userFile + `:6: invalid value for config key "query.presets.foo.query": expected a string, got a list`))
+	})
+
+	t.Run("the startup options of an unknown command are rejected", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		dir := tempDir(t)
+		userFile := filepath.Join(dir, "user.yaml")
+		writeFile(t, userFile, "build:\n  startup_options: [--batch]\nbiuld:\n  startup_options: [--batch]\n")
+
+		_, err := config.Load(userFile, "", nil)
+		g.Expect(err).To(MatchError(`failed to load config: ` + userFile + `:4: unknown config key "biuld.startup_options": "biuld" is not a command`))
+	})
+
+	t.Run("query presets with an unknown verb are rejected", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		dir := tempDir(t)
+		userFile := filepath.Join(dir, "user.yaml")
+		writeFile(t, userFile, "query:\n  presets:\n    foo:\n      verb: cqeury\n")
+
+		_, err := config.Load(userFile, "", nil)
+		g.Expect(err).To(MatchError(`failed to load config: ` + userFile + `:4: invalid value for config key "query.presets.foo.verb": expected query, cquery or aquery, got "cqeury"`))
+	})
+
+	t.Run("the line is omitted when the key is in a flow mapping", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		dir := tempDir(t)
+		userFile := filepath.Join(dir, "user.yaml")
+		writeFile(t, userFile, "clean: {skip_prompt: 1}\n")
+
+		_, err := config.Load(userFile, "", nil)
+		g.Expect(err).To(MatchError(`failed to load config: ` + userFile + `: invalid value for config key "clean.skip_prompt": expected a bool, got 1`))
+	})
+
+	t.Run("files without a version are migrated", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		dir := tempDir(t)
+		userFile := filepath.Join(dir, "user.yaml")
+		writeFile(t, userFile, "clean:\n  skip_prompt: \"true\"\nquery:\n  presets:\n    foo:\n      query: \"true\"\n")
+
+		cfg, err := config.Load(userFile, "", nil)
+		g.Expect(err).To(BeNil())
+		g.Expect(cfg.Values().Clean.SkipPrompt).To(BeTrue())
+		g.Expect(cfg.Values().Query.Presets["foo"].Query).To(Equal("true"))
+	})
+
+	t.Run("files of a newer version are rejected", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		dir := tempDir(t)
+		userFile := filepath.Join(dir, "user.yaml")
+		writeFile(t, userFile, "clean:\n  skip_prompt: true\nversion: 99\n")
+
+		_, err := config.Load(userFile, "", nil)
+		g.Expect(err).To(MatchError(`failed to load config: ` + userFile + `:3: config version 99 is newer than the version 1 supported by this aspect, upgrade aspect to use it`))
+	})
+}
+
+func TestValues(t *testing.T) {
+	t.Run("the defaults apply to the keys that aren't set", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		g.Expect((&config.Config{}).Values()).To(Equal(config.Values{
+			Query: config.QueryValues{
+				Presets: map[string]config.QueryPresetValues{},
+			},
+			CommandStartupOptions: map[string][]string{},
+		}))
+	})
+
+	t.Run("the settings are typed", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		dir := tempDir(t)
+		userFile := filepath.Join(dir, "user.yaml")
+		writeFile(t, userFile, `version: 1
+clean:
+  skip_prompt: true
+query:
+  all:
+    allow: true
+    inquired: true
+  cquery:
+    inquired: true
+  presets:
+    foo:
+      description: Foo
+      query: deps(?target)
+    bar:
+      query: deps(?target)
+      verb: cquery
+startup_options: [--batch]
+build:
+  startup_options: [--host_jvm_args=-Xmx4g]
+`)
+
+		cfg, err := config.Load(userFile, "", nil)
+		g.Expect(err).To(BeNil())
+		g.Expect(cfg.Values()).To(Equal(config.Values{
+			Clean: config.CleanValues{SkipPrompt: true},
+			Query: config.QueryValues{
+				All:    config.QueryAllValues{Allow: true, Inquired: true},
+				CQuery: config.QueryCQueryValues{Inquired: true},
+				Presets: map[string]config.QueryPresetValues{
+					"foo": {Description: "Foo", Query: "deps(?target)", Verb: "query"},
+					"bar": {Query: "deps(?target)", Verb: "cquery"},
+				},
+			},
+			StartupOptions: []string{"--batch"},
+			CommandStartupOptions: map[string][]string{
+				"build": {"--host_jvm_args=-Xmx4g"},
+			},
+		}))
+	})
+}
+
+func TestSetInFile(t *testing.T) {
+	t.Run("keys are set and unset in place", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		dir := tempDir(t)
+		path := filepath.Join(dir, "sub", ".aspect.yaml")
+
+		g.Expect(config.SetInFile(path, "query.all.allow", true)).To(Succeed())
+		g.Expect(config.SetInFile(path, "clean.skip_prompt", true)).To(Succeed())
+		g.Expect(config.SetInFile(path, "query.all.inquired", true)).To(Succeed())
+		g.Expect(config.SetInFile(path, "query.all.allow", false)).To(Succeed())
+		data, err := ioutil.ReadFile(path)
+		g.Expect(err).To(BeNil())
+		g.Expect(string(data)).To(Equal("version: 1\nquery:\n  all:\n    allow: false\n    inquired: true\nclean:\n  skip_prompt: true\n"))
+
+		found, err := config.UnsetInFile(path, "query.all.allow")
+		g.Expect(err).To(BeNil())
+		g.Expect(found).To(BeTrue())
+		found, err = config.UnsetInFile(path, "query.all.inquired")
+		g.Expect(err).To(BeNil())
+		g.Expect(found).To(BeTrue())
+		found, err = config.UnsetInFile(path, "query.all.allow")
+		g.Expect(err).To(BeNil())
+		g.Expect(found).To(BeFalse())
+		data, err = ioutil.ReadFile(path)
+		g.Expect(err).To(BeNil())
+		g.Expect(string(data)).To(Equal("version: 1\nclean:\n  skip_prompt: true\n"))
+	})
+
+	t.Run("the file is migrated", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		dir := tempDir(t)
+		path := filepath.Join(dir, ".aspect.yaml")
+		writeFile(t, path, "clean:\n  skip_prompt: \"true\"\n")
+
+		g.Expect(config.SetInFile(path, "query.all.allow", true)).To(Succeed())
+		data, err := ioutil.ReadFile(path)
+		g.Expect(err).To(BeNil())
+		g.Expect(string(data)).To(Equal("version: 1\nclean:\n  skip_prompt: true\nquery:\n  all:\n    allow: true\n"))
+	})
+
+	t.Run("values of the wrong type can't be set", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		dir := tempDir(t)
+
+		err := config.SetInFile(filepath.Join(dir, ".aspect.yaml"), "clean.skip_prompt", "yes")
+		g.Expect(err).To(MatchError(`invalid value for config key "clean.skip_prompt": expected a bool, got "yes"`))
+	})
+
+	t.Run("unknown keys can't be set", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		dir := tempDir(t)
+
+		err := config.SetInFile(filepath.Join(dir, ".aspect.yaml"), "clean.skip_promt", true)
+		g.Expect(err).To(MatchError(`unknown config key "clean.skip_promt"`))
+	})
+}
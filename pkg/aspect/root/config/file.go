@@ -0,0 +1,122 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"aspect.build/cli/pkg/ioutils"
+)
+
+// SetInFile sets the given key to value in the config file at path, creating
+// the file if it doesn't exist. The order of the other keys is kept, and the
+// file is migrated to CurrentVersion.
+func SetInFile(path, key string, value interface{}) error {
+	if err := Check(key, value); err != nil {
+		return err
+	}
+	content, err := readMapSlice(path)
+	if err != nil {
+		return fmt.Errorf("failed to set %q in %s: %w", key, path, err)
+	}
+	content = setIn(content, strings.Split(key, "."), value)
+	if err := writeMapSlice(path, content); err != nil {
+		return fmt.Errorf("failed to set %q in %s: %w", key, path, err)
+	}
+	return nil
+}
+
+// UnsetInFile removes the given key from the config file at path, along with
+// the mappings left empty. It returns whether the key was set.
+func UnsetInFile(path, key string) (bool, error) {
+	content, err := readMapSlice(path)
+	if err != nil {
+		return false, fmt.Errorf("failed to unset %q in %s: %w", key, path, err)
+	}
+	content, found := unsetIn(content, strings.Split(key, "."))
+	if !found {
+		return false, nil
+	}
+	if err := writeMapSlice(path, content); err != nil {
+		return false, fmt.Errorf("failed to unset %q in %s: %w", key, path, err)
+	}
+	return true, nil
+}
+
+func readMapSlice(path string) (yaml.MapSlice, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return yaml.MapSlice{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var content yaml.MapSlice
+	if err := yaml.Unmarshal(data, &content); err != nil {
+		return nil, err
+	}
+	return migrate(content)
+}
+
+func writeMapSlice(path string, content yaml.MapSlice) error {
+	data, err := yaml.Marshal(stampVersion(content))
+	if err != nil {
+		return err
+	}
+	if len(content) == 0 {
+		data = nil
+	}
+	return ioutils.WriteFileAtomic(path, data)
+}
+
+func setIn(content yaml.MapSlice, segments []string, value interface{}) yaml.MapSlice {
+	for i := range content {
+		if fmt.Sprint(content[i].Key) != segments[0] {
+			continue
+		}
+		if len(segments) == 1 {
+			content[i].Value = value
+		} else {
+			nested, _ := content[i].Value.(yaml.MapSlice)
+			content[i].Value = setIn(nested, segments[1:], value)
+		}
+		return content
+	}
+	if len(segments) == 1 {
+		return append(content, yaml.MapItem{Key: segments[0], Value: value})
+	}
+	return append(content, yaml.MapItem{Key: segments[0], Value: setIn(nil, segments[1:], value)})
+}
+
+func unsetIn(content yaml.MapSlice, segments []string) (yaml.MapSlice, bool) {
+	for i := range content {
+		if fmt.Sprint(content[i].Key) != segments[0] {
+			continue
+		}
+		if len(segments) > 1 {
+			nested, ok := content[i].Value.(yaml.MapSlice)
+			if !ok {
+				return content, false
+			}
+			nested, found := unsetIn(nested, segments[1:])
+			if !found {
+				return content, false
+			}
+			if len(nested) > 0 {
+				content[i].Value = nested
+				return content, true
+			}
+		}
+		return append(content[:i], content[i+1:]...), true
+	}
+	return content, false
+}
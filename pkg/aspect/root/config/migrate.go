@@ -0,0 +1,96 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package config
+
+import (
+	"fmt"
+	"strconv"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// CurrentVersion is the version of the config files written by this version of
+// aspect. Files without a version key predate versioning and are version 0.
+const CurrentVersion = 1
+
+const versionKey = "version"
+
+// migrations upgrade the content of a config file from the version at their
+// index to the next one, so len(migrations) must equal CurrentVersion.
+var migrations = []func(content yaml.MapSlice){
+	// Version 0 files were written through viper, which stored some booleans
+	// as strings, e.g. `skip_prompt: "true"`.
+	func(content yaml.MapSlice) {
+		mapLeaves("", content, func(key string, value interface{}) interface{} {
+			s, ok := value.(string)
+			if !ok {
+				return value
+			}
+			if k, err := LookupKey(key); err != nil || k.Type != BoolType {
+				return value
+			}
+			if b, err := strconv.ParseBool(s); err == nil {
+				return b
+			}
+			return value
+		})
+	},
+}
+
+// migrate removes the version key from the content of a config file and
+// upgrades the rest to CurrentVersion.
+func migrate(content yaml.MapSlice) (yaml.MapSlice, error) {
+	version := 0
+	for i, item := range content {
+		if item.Key != versionKey {
+			continue
+		}
+		v, ok := item.Value.(int)
+		if !ok || v < 0 {
+			return nil, fmt.Errorf("invalid config version %s", describe(item.Value))
+		}
+		if v > CurrentVersion {
+			return nil, fmt.Errorf("config version %d is newer than the version %d supported by this aspect, upgrade aspect to use it", v, CurrentVersion)
+		}
+		version = v
+		content = append(content[:i:i], content[i+1:]...)
+		break
+	}
+	for _, m := range migrations[version:] {
+		m(content)
+	}
+	return content, nil
+}
+
+// stampVersion adds the current version in front of the content of a config
+// file, unless it's empty.
+func stampVersion(content yaml.MapSlice) yaml.MapSlice {
+	if len(content) == 0 {
+		return content
+	}
+	return append(yaml.MapSlice{{Key: versionKey, Value: CurrentVersion}}, content...)
+}
+
+// mapLeaves replaces the leaves of the given YAML mapping, keyed by their
+// dot-separated path, with the result of fn.
+func mapLeaves(prefix string, content yaml.MapSlice, fn func(key string, value interface{}) interface{}) {
+	for i := range content {
+		key := joinKey(prefix, fmt.Sprint(content[i].Key))
+		if nested, ok := content[i].Value.(yaml.MapSlice); ok {
+			mapLeaves(key, nested, fn)
+			continue
+		}
+		content[i].Value = fn(key, content[i].Value)
+	}
+}
+
+func joinKey(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
@@ -0,0 +1,51 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package config
+
+import (
+	"fmt"
+)
+
+// Preferences is the store of the choices users make in the prompts of the
+// commands, e.g. to skip a prompt in the future.
+type Preferences struct {
+	cfg *Config
+}
+
+// Preferences returns the store that remembers preferences in the config
+// files of c.
+func (c *Config) Preferences() *Preferences {
+	return &Preferences{cfg: c}
+}
+
+// Set remembers the given preference in the config file of the layer the key
+// belongs to, creating the file if it doesn't exist, and in the loaded config.
+// Only the given key is written to the file, so the settings merged from the
+// other layers never leak into it.
+func (p *Preferences) Set(key string, value interface{}) error {
+	k, err := LookupKey(key)
+	if err != nil {
+		return fmt.Errorf("failed to remember %s: %w", key, err)
+	}
+	path := p.cfg.File(k.Layer)
+	if path == "" {
+		if k.Layer == WorkspaceLayer {
+			return fmt.Errorf("failed to remember %s: the current working directory is not in a Bazel workspace", key)
+		}
+		return fmt.Errorf("failed to remember %s: there is no %s config file", key, k.Layer)
+	}
+	if err := SetInFile(path, key, value); err != nil {
+		return fmt.Errorf("failed to remember %s: %w", key, err)
+	}
+	for _, l := range p.cfg.layers {
+		if l.layer == k.Layer {
+			l.settings[key] = value
+			l.exists = true
+		}
+	}
+	return nil
+}
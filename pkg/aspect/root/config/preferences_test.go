@@ -0,0 +1,83 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package config_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"aspect.build/cli/pkg/aspect/root/config"
+)
+
+func TestPreferences(t *testing.T) {
+	t.Run("a preference is written to the file of its layer only", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		dir := tempDir(t)
+		userFile := filepath.Join(dir, "home", ".aspect.yaml")
+		workspaceFile := filepath.Join(dir, "workspace", ".aspect.yaml")
+		g.Expect(os.MkdirAll(filepath.Dir(workspaceFile), 0755)).To(Succeed())
+		writeFile(t, workspaceFile, "query:\n  presets:\n    foo:\n      query: deps(//...)\n")
+		cfg, err := config.Load(userFile, workspaceFile, []string{"ASPECT_QUERY_ALL_ALLOW=true"})
+		g.Expect(err).To(BeNil())
+
+		g.Expect(cfg.Preferences().Set("clean.skip_prompt", true)).To(Succeed())
+		g.Expect(cfg.Preferences().Set("query.presets.bar.query", "deps(//bar)")).To(Succeed())
+
+		data, err := ioutil.ReadFile(userFile)
+		g.Expect(err).To(BeNil())
+		g.Expect(string(data)).To(Equal("version: 1\nclean:\n  skip_prompt: true\n"))
+		data, err = ioutil.ReadFile(workspaceFile)
+		g.Expect(err).To(BeNil())
+		g.Expect(string(data)).To(Equal("version: 1\nquery:\n  presets:\n    foo:\n      query: deps(//...)\n    bar:\n      query: deps(//bar)\n"))
+
+		g.Expect(cfg.Values().Clean.SkipPrompt).To(BeTrue())
+		g.Expect(cfg.Values().Query.Presets).To(HaveKey("bar"))
+		g.Expect(cfg.LoadedFiles()).To(Equal([]string{userFile, workspaceFile}))
+	})
+
+	t.Run("a workspace preference can't be remembered outside of a workspace", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		dir := tempDir(t)
+		cfg, err := config.Load(filepath.Join(dir, ".aspect.yaml"), "", nil)
+		g.Expect(err).To(BeNil())
+
+		err = cfg.Preferences().Set("query.presets.bar.query", "deps(//bar)")
+		g.Expect(err).To(MatchError("failed to remember query.presets.bar.query: the current working directory is not in a Bazel workspace"))
+	})
+
+	t.Run("the file is replaced atomically through symlinks", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		dir := tempDir(t)
+		target := filepath.Join(dir, "dotfiles", "aspect.yaml")
+		g.Expect(os.MkdirAll(filepath.Dir(target), 0755)).To(Succeed())
+		writeFile(t, target, "query:\n  all:\n    allow: true\n")
+		g.Expect(os.Chmod(target, 0600)).To(Succeed())
+		userFile := filepath.Join(dir, ".aspect.yaml")
+		g.Expect(os.Symlink(target, userFile)).To(Succeed())
+		cfg, err := config.Load(userFile, "", nil)
+		g.Expect(err).To(BeNil())
+
+		g.Expect(cfg.Preferences().Set("clean.skip_prompt", true)).To(Succeed())
+
+		info, err := os.Lstat(userFile)
+		g.Expect(err).To(BeNil())
+		g.Expect(info.Mode() & os.ModeSymlink).NotTo(BeZero())
+		info, err = os.Stat(target)
+		g.Expect(err).To(BeNil())
+		g.Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+		data, err := ioutil.ReadFile(target)
+		g.Expect(err).To(BeNil())
+		g.Expect(string(data)).To(Equal("version: 1\nquery:\n  all:\n    allow: true\nclean:\n  skip_prompt: true\n"))
+		entries, err := ioutil.ReadDir(filepath.Dir(target))
+		g.Expect(err).To(BeNil())
+		g.Expect(entries).To(HaveLen(1))
+	})
+}
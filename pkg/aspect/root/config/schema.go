@@ -0,0 +1,213 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"aspect.build/cli/pkg/bazel"
+)
+
+// ValueType is the type of the value of a config key.
+type ValueType int
+
+const (
+	// BoolType is the type of a true or false value.
+	BoolType ValueType = iota
+	// StringType is the type of a string value.
+	StringType
+	// StringListType is the type of a list of strings.
+	StringListType
+)
+
+// String returns the name of the type as shown to users.
+func (t ValueType) String() string {
+	switch t {
+	case BoolType:
+		return "bool"
+	case StringType:
+		return "string"
+	case StringListType:
+		return "list of strings"
+	}
+	return "unknown"
+}
+
+// Key describes a key accepted in the aspect config files.
+type Key struct {
+	// Pattern is the dot-separated path of the key, where `*` matches any
+	// single segment, e.g. the name of a query preset.
+	Pattern string
+	Type    ValueType
+	// Default is the value of the key when no layer sets it.
+	Default interface{}
+	// Layer is the layer whose config file stores the key when it's
+	// remembered as a preference, e.g. the answer to a prompt.
+	Layer Layer
+	// Description is shown to users listing the keys.
+	Description string
+}
+
+// Keys are all the keys accepted in the aspect config files. A key that is not
+// listed here is rejected rather than silently ignored, so that a typo doesn't
+// go unnoticed.
+var Keys = []Key{
+	{"clean.skip_prompt", BoolType, false, UserLayer, "Skip the prompt of 'aspect clean' and behave like 'bazel clean'."},
+	{"query.all.allow", BoolType, false, UserLayer, "List the presets of every query verb in 'aspect query'."},
+	{"query.all.inquired", BoolType, false, UserLayer, "Whether the user was asked about query.all.allow."},
+	{"query.cquery.use", BoolType, false, UserLayer, "Run the presets of 'aspect query' with cquery."},
+	{"query.cquery.inquired", BoolType, false, UserLayer, "Whether the user was asked about query.cquery.use."},
+	{"query.presets.*.description", StringType, "", WorkspaceLayer, "The description of a user-defined query preset."},
+	{"query.presets.*.query", StringType, "", WorkspaceLayer, "The query of a user-defined query preset."},
+	{"query.presets.*.verb", StringType, "query", WorkspaceLayer, "The verb (query, cquery or aquery) of a user-defined query preset."},
+	{"startup_options", StringListType, []interface{}{}, WorkspaceLayer, "Bazel startup options for every command."},
+	{"*.startup_options", StringListType, []interface{}{}, WorkspaceLayer, "Bazel startup options for the given command."},
+}
+
+// aspectCommands are the commands of aspect that run Bazel besides the Bazel
+// ones, which can have their own startup options.
+var aspectCommands = map[string]bool{
+	"affected":      true,
+	"du":            true,
+	"explain-cache": true,
+	"why":           true,
+}
+
+// queryVerbs are the verbs of a query preset.
+var queryVerbs = map[string]bool{
+	"query":  true,
+	"cquery": true,
+	"aquery": true,
+}
+
+// LookupKey returns the schema of the given dot-separated key.
+func LookupKey(key string) (Key, error) {
+	segments := strings.Split(key, ".")
+	for _, k := range Keys {
+		if matchPattern(strings.Split(k.Pattern, "."), segments) {
+			return k, nil
+		}
+	}
+	return Key{}, fmt.Errorf("unknown config key %q", key)
+}
+
+func matchPattern(pattern, segments []string) bool {
+	if len(pattern) != len(segments) {
+		return false
+	}
+	for i := range pattern {
+		if segments[i] == "" || (pattern[i] != "*" && pattern[i] != segments[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Check checks that the given key is known and that value is of its type. The
+// command of the startup options of a command and the verb of a query preset
+// are checked too.
+func Check(key string, value interface{}) error {
+	k, err := LookupKey(key)
+	if err != nil {
+		return err
+	}
+	if !hasType(value, k.Type) {
+		return fmt.Errorf("invalid value for config key %q: expected a %s, got %s", key, k.Type, describe(value))
+	}
+	switch k.Pattern {
+	case "*.startup_options":
+		command := strings.Split(key, ".")[0]
+		if !bazel.Commands[command] && !aspectCommands[command] {
+			return fmt.Errorf("unknown config key %q: %q is not a command", key, command)
+		}
+	case "query.presets.*.verb":
+		if !queryVerbs[value.(string)] {
+			return fmt.Errorf("invalid value for config key %q: expected query, cquery or aquery, got %s", key, describe(value))
+		}
+	}
+	return nil
+}
+
+// describe returns the YAML type of value for error messages.
+func describe(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "bool " + strconv.FormatBool(v)
+	case string:
+		return strconv.Quote(v)
+	case []interface{}:
+		return "a list"
+	}
+	return fmt.Sprint(value)
+}
+
+func hasType(value interface{}, t ValueType) bool {
+	switch t {
+	case BoolType:
+		_, ok := value.(bool)
+		return ok
+	case StringType:
+		_, ok := value.(string)
+		return ok
+	case StringListType:
+		list, ok := value.([]interface{})
+		if !ok {
+			return false
+		}
+		for _, item := range list {
+			if _, ok := item.(string); !ok {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// ParseValue converts the textual values given for a key, e.g. on the command
+// line or in an environment variable, to a value of the key's type. A list
+// takes one value per element.
+func ParseValue(key string, values []string) (interface{}, error) {
+	k, err := LookupKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if k.Type == StringListType {
+		list := make([]interface{}, len(values))
+		for i, value := range values {
+			list[i] = value
+		}
+		return list, nil
+	}
+	if len(values) != 1 {
+		return nil, fmt.Errorf("config key %q takes a single value", key)
+	}
+	if k.Type == BoolType {
+		b, err := strconv.ParseBool(values[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for config key %q: expected a bool", key)
+		}
+		return b, nil
+	}
+	return values[0], nil
+}
+
+// FormatValue returns the textual form of a config value.
+func FormatValue(value interface{}) string {
+	if list, ok := value.([]interface{}); ok {
+		items := make([]string, len(list))
+		for i, item := range list {
+			items[i] = fmt.Sprint(item)
+		}
+		return strings.Join(items, " ")
+	}
+	return fmt.Sprint(value)
+}
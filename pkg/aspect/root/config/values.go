@@ -0,0 +1,121 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package config
+
+import (
+	"strings"
+)
+
+// Values is the typed aspect config, with the defaults of the keys that no
+// layer sets.
+type Values struct {
+	Clean CleanValues
+	Query QueryValues
+	// StartupOptions are the Bazel startup options for every command.
+	StartupOptions []string
+	// CommandStartupOptions are the Bazel startup options for a single
+	// command, keyed by the command name.
+	CommandStartupOptions map[string][]string
+}
+
+// CleanValues are the settings of `aspect clean`.
+type CleanValues struct {
+	SkipPrompt bool
+}
+
+// QueryValues are the settings of `aspect query`, `aspect cquery` and
+// `aspect aquery`.
+type QueryValues struct {
+	All    QueryAllValues
+	CQuery QueryCQueryValues
+	// Presets are the user-defined query presets, keyed by name.
+	Presets map[string]QueryPresetValues
+}
+
+// QueryAllValues is whether `aspect query` lists the presets of every verb.
+type QueryAllValues struct {
+	Allow    bool
+	Inquired bool
+}
+
+// QueryCQueryValues is whether `aspect query` runs its presets with cquery.
+type QueryCQueryValues struct {
+	Use      bool
+	Inquired bool
+}
+
+// QueryPresetValues is a user-defined query preset.
+type QueryPresetValues struct {
+	Description string
+	Query       string
+	Verb        string
+}
+
+// Values returns the typed effective settings of c. c must have been loaded,
+// so that the types of the settings have been checked.
+func (c *Config) Values() Values {
+	v := Values{
+		Query: QueryValues{
+			Presets: make(map[string]QueryPresetValues),
+		},
+		CommandStartupOptions: make(map[string][]string),
+	}
+	for _, s := range c.List() {
+		k, _ := LookupKey(s.Key)
+		segments := strings.Split(s.Key, ".")
+		switch k.Pattern {
+		case "clean.skip_prompt":
+			v.Clean.SkipPrompt = s.Value.(bool)
+		case "query.all.allow":
+			v.Query.All.Allow = s.Value.(bool)
+		case "query.all.inquired":
+			v.Query.All.Inquired = s.Value.(bool)
+		case "query.cquery.use":
+			v.Query.CQuery.Use = s.Value.(bool)
+		case "query.cquery.inquired":
+			v.Query.CQuery.Inquired = s.Value.(bool)
+		case "query.presets.*.description", "query.presets.*.query", "query.presets.*.verb":
+			name := segments[2]
+			preset, ok := v.Query.Presets[name]
+			if !ok {
+				preset.Verb = defaultOf("query.presets.*.verb").(string)
+			}
+			switch segments[3] {
+			case "description":
+				preset.Description = s.Value.(string)
+			case "query":
+				preset.Query = s.Value.(string)
+			case "verb":
+				preset.Verb = s.Value.(string)
+			}
+			v.Query.Presets[name] = preset
+		case "startup_options":
+			v.StartupOptions = toStrings(s.Value)
+		case "*.startup_options":
+			v.CommandStartupOptions[segments[0]] = toStrings(s.Value)
+		}
+	}
+	return v
+}
+
+func defaultOf(pattern string) interface{} {
+	for _, k := range Keys {
+		if k.Pattern == pattern {
+			return k.Default
+		}
+	}
+	return nil
+}
+
+func toStrings(value interface{}) []string {
+	list := value.([]interface{})
+	strs := make([]string, len(list))
+	for i, item := range list {
+		strs[i] = item.(string)
+	}
+	return strs
+}
@@ -0,0 +1,79 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package flags
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// ParseFlags sets the flags of flagSet that appear in args before any `--`
+// separator and returns the remaining arguments. Only the long form of the
+// flags is recognized. It lets the commands that disable the flag parsing of
+// cobra, to hand the Bazel flags over, have flags of their own.
+func ParseFlags(flagSet *pflag.FlagSet, args []string) ([]string, error) {
+	remaining := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			remaining = append(remaining, args[i:]...)
+			break
+		}
+		if !strings.HasPrefix(arg, "--") {
+			remaining = append(remaining, arg)
+			continue
+		}
+
+		name := strings.TrimPrefix(arg, "--")
+		value := ""
+		hasValue := false
+		if j := strings.Index(name, "="); j >= 0 {
+			name, value = name[:j], name[j+1:]
+			hasValue = true
+		}
+		flag := flagSet.Lookup(name)
+		if flag == nil {
+			remaining = append(remaining, arg)
+			continue
+		}
+		if !hasValue {
+			switch {
+			case flag.NoOptDefVal != "":
+				value = flag.NoOptDefVal
+			case i+1 < len(args):
+				value = args[i+1]
+				i++
+			default:
+				return nil, fmt.Errorf("flag needs an argument: %s", arg)
+			}
+		}
+		if err := flagSet.Set(name, value); err != nil {
+			return nil, fmt.Errorf("invalid argument %q for %q flag: %w", value, arg, err)
+		}
+	}
+	return remaining, nil
+}
+
+// ParseRootFlags sets the flags of the root command that are placed before the
+// command, as in `aspect --config=aspect.yaml build //...`, and returns the
+// arguments without them. Cobra doesn't parse them for the commands that
+// disable flag parsing, which would hand them over to Bazel, and the config
+// must be known before any command runs.
+func ParseRootFlags(root *cobra.Command, args []string) ([]string, error) {
+	i := CommandIndex(root, args)
+	if i < 0 {
+		return args, nil
+	}
+	rest, err := ParseFlags(root.PersistentFlags(), args[:i])
+	if err != nil {
+		return nil, err
+	}
+	return append(rest, args[i:]...), nil
+}
@@ -0,0 +1,66 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package flags_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/spf13/cobra"
+
+	"aspect.build/cli/pkg/aspect/root/flags"
+)
+
+func TestParseRootFlags(t *testing.T) {
+	t.Run("the root flags before the command are set and removed", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		root := newRootCmd()
+
+		args, err := flags.ParseRootFlags(root, []string{"--config=x.yaml", "--interactive", "build", "--config=ci", "//foo"})
+		g.Expect(err).To(BeNil())
+		g.Expect(args).To(Equal([]string{"build", "--config=ci", "//foo"}))
+		g.Expect(root.PersistentFlags().GetString(flags.ConfigFlagName)).To(Equal("x.yaml"))
+		g.Expect(root.PersistentFlags().GetBool(flags.InteractiveFlagName)).To(BeTrue())
+	})
+
+	t.Run("aspect --config=<file> build loads the config before the command runs", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		root := &cobra.Command{Use: "aspect"}
+		cfgFile := root.PersistentFlags().String(flags.ConfigFlagName, "", "")
+		root.PersistentFlags().Bool(flags.InteractiveFlagName, false, "")
+		var loadedCfgFile string
+		root.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+			loadedCfgFile = *cfgFile
+			return nil
+		}
+		var buildArgs []string
+		root.AddCommand(&cobra.Command{
+			Use:                "build",
+			DisableFlagParsing: true,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				buildArgs = args
+				return nil
+			},
+		})
+
+		args, err := flags.ParseRootFlags(root, []string{"--config=x.yaml", "build", "//foo"})
+		g.Expect(err).To(BeNil())
+		root.SetArgs(args)
+		g.Expect(root.Execute()).To(Succeed())
+		g.Expect(loadedCfgFile).To(Equal("x.yaml"))
+		g.Expect(buildArgs).To(Equal([]string{"//foo"}))
+	})
+
+	t.Run("an invalid value fails", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		root := newRootCmd()
+
+		_, err := flags.ParseRootFlags(root, []string{"--interactive=maybe", "build"})
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(err.Error()).To(HavePrefix(`invalid argument "maybe" for "--interactive=maybe" flag`))
+	})
+}
@@ -0,0 +1,133 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package flags
+
+import (
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"aspect.build/cli/pkg/bazel"
+)
+
+// SplitStartupOptions separates the Bazel startup options that precede the
+// command, as in `aspect --output_base=/tmp/foo build //...`, from the rest of
+// the arguments given to the root command. The flags of the root command
+// itself are kept in the rest of the arguments.
+//
+// A startup option without `=` takes the next argument as its value unless it
+// is boolean, which bazelFlags tells: a startup option with a negative form
+// (--no<name>) is boolean. bazelFlags may run Bazel, so it is only called for
+// such an option. When it is nil or returns nil, e.g. outside of a workspace,
+// the option takes the next argument unless it is a flag, a command of the root
+// command or a Bazel command.
+func SplitStartupOptions(root *cobra.Command, args []string, bazelFlags func() map[string]*bazel.FlagInfo) (startupOptions []string, rest []string) {
+	var startupFlags map[string]*bazel.FlagInfo
+	loaded := false
+	takesNext := func(name string, next string) bool {
+		if !loaded && bazelFlags != nil {
+			startupFlags = bazelFlags()
+		}
+		loaded = true
+		if startupFlags == nil {
+			return !IsCommand(root, next) && !bazel.Commands[next]
+		}
+		info, ok := startupFlags[name]
+		if !ok {
+			// A negated boolean, e.g. --nohome_rc, or a flag Bazel doesn't
+			// know, which it reports.
+			return false
+		}
+		return !info.GetHasNegativeFlag()
+	}
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" || !strings.HasPrefix(arg, "-") || arg == "-" {
+			return startupOptions, append(rest, args[i:]...)
+		}
+
+		name := strings.TrimLeft(arg, "-")
+		hasValue := false
+		if j := strings.Index(name, "="); j >= 0 {
+			name = name[:j]
+			hasValue = true
+		}
+		hasNextValue := i+1 < len(args) && !strings.HasPrefix(args[i+1], "-")
+
+		if name == "help" || arg == "-h" {
+			rest = append(rest, arg)
+			continue
+		}
+		flag := root.PersistentFlags().Lookup(name)
+		if !strings.HasPrefix(arg, "--") {
+			flag = nil
+			if len(name) == 1 {
+				flag = root.PersistentFlags().ShorthandLookup(name)
+			}
+		}
+		if flag != nil {
+			rest = append(rest, arg)
+			if !hasValue && flag.NoOptDefVal == "" && hasNextValue {
+				rest = append(rest, args[i+1])
+				i++
+			}
+			continue
+		}
+
+		startupOptions = append(startupOptions, arg)
+		if !hasValue && hasNextValue && takesNext(name, args[i+1]) {
+			startupOptions = append(startupOptions, args[i+1])
+			i++
+		}
+	}
+	return startupOptions, rest
+}
+
+// CommandIndex returns the index of the command name in the arguments given to
+// the root command, skipping the root command flags and their values, or -1 if
+// there is no command.
+func CommandIndex(root *cobra.Command, args []string) int {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			return -1
+		}
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			return i
+		}
+		if strings.Contains(arg, "=") {
+			continue
+		}
+		name := strings.TrimLeft(arg, "-")
+		flag := root.PersistentFlags().Lookup(name)
+		if !strings.HasPrefix(arg, "--") {
+			flag = nil
+			if len(name) == 1 {
+				flag = root.PersistentFlags().ShorthandLookup(name)
+			}
+		}
+		if flag != nil && flag.NoOptDefVal == "" {
+			i++
+		}
+	}
+	return -1
+}
+
+// IsCommand returns whether name is a command of the root command, including
+// the ones cobra only adds when executing.
+func IsCommand(root *cobra.Command, name string) bool {
+	if name == "help" || name == cobra.ShellCompRequestCmd || name == cobra.ShellCompNoDescRequestCmd {
+		return true
+	}
+	for _, cmd := range root.Commands() {
+		if cmd.Name() == name || cmd.HasAlias(name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,118 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package flags_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/proto"
+
+	"aspect.build/cli/pkg/aspect/root/flags"
+	"aspect.build/cli/pkg/bazel"
+)
+
+func newRootCmd() *cobra.Command {
+	root := &cobra.Command{Use: "aspect"}
+	root.PersistentFlags().String(flags.ConfigFlagName, "", "")
+	root.PersistentFlags().Bool(flags.InteractiveFlagName, false, "")
+	root.AddCommand(&cobra.Command{Use: "build"})
+	return root
+}
+
+func TestSplitStartupOptions(t *testing.T) {
+	t.Run("startup options before the command are split", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		startupOptions, rest := flags.SplitStartupOptions(newRootCmd(), []string{
+			"--output_base=/tmp/base", "--batch", "build", "--config=ci", "//...",
+		}, nil)
+		g.Expect(startupOptions).To(Equal([]string{"--output_base=/tmp/base", "--batch"}))
+		g.Expect(rest).To(Equal([]string{"build", "--config=ci", "//..."}))
+	})
+
+	t.Run("a startup option takes a separate value unless it is a command", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		startupOptions, rest := flags.SplitStartupOptions(newRootCmd(), []string{
+			"--output_base", "/tmp/base", "--batch", "build",
+		}, nil)
+		g.Expect(startupOptions).To(Equal([]string{"--output_base", "/tmp/base", "--batch"}))
+		g.Expect(rest).To(Equal([]string{"build"}))
+	})
+
+	t.Run("the root command flags are kept", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		startupOptions, rest := flags.SplitStartupOptions(newRootCmd(), []string{
+			"--config", ".aspect.yaml", "--interactive", "--batch", "build", "--help",
+		}, nil)
+		g.Expect(startupOptions).To(Equal([]string{"--batch"}))
+		g.Expect(rest).To(Equal([]string{"--config", ".aspect.yaml", "--interactive", "build", "--help"}))
+	})
+
+	t.Run("single-dash startup options are split", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		startupOptions, rest := flags.SplitStartupOptions(newRootCmd(), []string{"-batch", "build"}, nil)
+		g.Expect(startupOptions).To(Equal([]string{"-batch"}))
+		g.Expect(rest).To(Equal([]string{"build"}))
+	})
+
+	t.Run("a command after a boolean startup option isn't taken as its value", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		bazelFlags := func() map[string]*bazel.FlagInfo {
+			return map[string]*bazel.FlagInfo{
+				"batch":       {Name: proto.String("batch"), HasNegativeFlag: proto.Bool(true), Commands: []string{bazel.StartupCommand}},
+				"home_rc":     {Name: proto.String("home_rc"), HasNegativeFlag: proto.Bool(true), Commands: []string{bazel.StartupCommand}},
+				"output_base": {Name: proto.String("output_base"), Commands: []string{bazel.StartupCommand}},
+			}
+		}
+
+		// sync isn't a command of the root command until the passthrough
+		// command is added for it.
+		startupOptions, rest := flags.SplitStartupOptions(newRootCmd(), []string{"--batch", "sync"}, bazelFlags)
+		g.Expect(startupOptions).To(Equal([]string{"--batch"}))
+		g.Expect(rest).To(Equal([]string{"sync"}))
+
+		startupOptions, rest = flags.SplitStartupOptions(newRootCmd(), []string{"--nohome_rc", "--output_base", "/tmp/base", "fetch", "//..."}, bazelFlags)
+		g.Expect(startupOptions).To(Equal([]string{"--nohome_rc", "--output_base", "/tmp/base"}))
+		g.Expect(rest).To(Equal([]string{"fetch", "//..."}))
+	})
+
+	t.Run("without the Bazel flags, a Bazel command isn't taken as a value", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		startupOptions, rest := flags.SplitStartupOptions(newRootCmd(), []string{"--nohome_rc", "fetch", "//..."}, func() map[string]*bazel.FlagInfo { return nil })
+		g.Expect(startupOptions).To(Equal([]string{"--nohome_rc"}))
+		g.Expect(rest).To(Equal([]string{"fetch", "//..."}))
+	})
+
+	t.Run("without startup options, the arguments are unchanged", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		startupOptions, rest := flags.SplitStartupOptions(newRootCmd(), []string{"build", "//..."}, nil)
+		g.Expect(startupOptions).To(BeNil())
+		g.Expect(rest).To(Equal([]string{"build", "//..."}))
+	})
+}
+
+func TestCommandIndex(t *testing.T) {
+	t.Run("the root command flags and their values are skipped", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		args := []string{"--config", ".aspect.yaml", "--interactive", "sync", "--configure"}
+		g.Expect(flags.CommandIndex(newRootCmd(), args)).To(Equal(3))
+	})
+
+	t.Run("without a command, the index is -1", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		g.Expect(flags.CommandIndex(newRootCmd(), []string{"--interactive"})).To(Equal(-1))
+	})
+}
@@ -20,6 +20,9 @@ type Version struct {
 	BuildinfoRelease   string
 	BuildinfoGitStatus string
 	GNUFormat          bool
+	// Resolve explains which Bazel the workspace runs, instead of running
+	// bazel version.
+	Resolve bool
 }
 
 func New(streams ioutils.Streams) *Version {
@@ -49,7 +52,31 @@ func (v *Version) Run(bzl bazel.Bazel) error {
 	} else {
 		fmt.Fprintf(v.Stdout, "Aspect version: %s\n", version)
 	}
+	if v.Resolve {
+		return v.resolve(bzl)
+	}
 	bzl.Spawn(bazelCmd)
 
 	return nil
 }
+
+// resolve prints the version of Bazel the workspace asks for, what decided
+// it, and the binary that runs.
+func (v *Version) resolve(bzl bazel.Bazel) error {
+	resolution, err := bzl.ResolveVersion()
+	if err != nil {
+		return fmt.Errorf("failed to resolve the Bazel version: %w", err)
+	}
+	fmt.Fprintf(v.Stdout, "Bazel version: %s\n", resolution.Version)
+	fmt.Fprintf(v.Stdout, "Bazel version source: %s\n", resolution.Source)
+	fmt.Fprintf(v.Stdout, "Resolved Bazel version: %s\n", resolution.ResolvedVersion)
+	if resolution.NotDownloaded {
+		fmt.Fprintf(v.Stdout, "Bazel binary: %s (not downloaded yet)\n", resolution.BinaryPath)
+	} else {
+		fmt.Fprintf(v.Stdout, "Bazel binary: %s\n", resolution.BinaryPath)
+	}
+	if resolution.Wrapper != "" {
+		fmt.Fprintf(v.Stdout, "Bazel wrapper: %s\n", resolution.Wrapper)
+	}
+	return nil
+}
@@ -10,10 +10,12 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/golang/mock/gomock"
 	. "github.com/onsi/gomega"
 
 	"aspect.build/cli/pkg/aspect/version"
 	"aspect.build/cli/pkg/bazel"
+	bazel_mock "aspect.build/cli/pkg/bazel/mock"
 	"aspect.build/cli/pkg/ioutils"
 )
 
@@ -68,4 +70,40 @@ func TestVersion(t *testing.T) {
 		g.Expect(err).To(BeNil())
 		g.Expect(stdout.String()).To(Equal("Aspect 1.2.3\n"))
 	})
+
+	t.Run("with --resolve", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		bzl := bazel_mock.NewMockBazel(ctrl)
+		bzl.
+			EXPECT().
+			ResolveVersion().
+			Return(&bazel.VersionResolution{
+				Version:         "5.0.0",
+				Source:          "/ws/.bazelversion",
+				ResolvedVersion: "5.0.0",
+				BinaryPath:      "/cache/bazelisk/downloads/bazelbuild/bazel-5.0.0-linux-x86_64/bin/bazel",
+				NotDownloaded:   true,
+				Wrapper:         "/ws/tools/bazel",
+			}, nil).
+			Times(1)
+
+		var stdout strings.Builder
+		streams := ioutils.Streams{Stdout: &stdout}
+		v := version.New(streams)
+		v.Resolve = true
+		v.BuildinfoRelease = "1.2.3"
+		v.BuildinfoGitStatus = "clean"
+		err := v.Run(bzl)
+		g.Expect(err).To(BeNil())
+		g.Expect(stdout.String()).To(Equal(`Aspect version: 1.2.3
+Bazel version: 5.0.0
+Bazel version source: /ws/.bazelversion
+Resolved Bazel version: 5.0.0
+Bazel binary: /cache/bazelisk/downloads/bazelbuild/bazel-5.0.0-linux-x86_64/bin/bazel (not downloaded yet)
+Bazel wrapper: /ws/tools/bazel
+`))
+	})
 }
@@ -0,0 +1,258 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package why
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"aspect.build/cli/pkg/aspect/query/shared"
+	"aspect.build/cli/pkg/aspecterrors"
+	"aspect.build/cli/pkg/bazel"
+	"aspect.build/cli/pkg/ioutils"
+)
+
+// implicitEdge is the attribute of the dependencies that no attribute of the
+// rule lists, e.g. toolchains and the implicit dependencies of the rule.
+const implicitEdge = "toolchain/implicit"
+
+// configuredLabelRegex matches a label in the output of cquery, which is
+// followed by the hash of its configuration, e.g. //foo:bar (a1b2c3d).
+var configuredLabelRegex = regexp.MustCompile(`^(.*) \(([^)]*)\)$`)
+
+// Why represents the aspect why command.
+type Why struct {
+	ioutils.Streams
+	bzl bazel.Bazel
+
+	// All shows every path between the targets instead of one.
+	All bool
+	// CQuery follows the configured dependencies with cquery, which shows the
+	// configuration of each target.
+	CQuery bool
+	// Configs explains the configuration changes along the paths with bazel
+	// config. It implies CQuery.
+	Configs bool
+}
+
+// New creates a Why command.
+func New(streams ioutils.Streams, bzl bazel.Bazel) *Why {
+	return &Why{
+		Streams: streams,
+		bzl:     bzl,
+	}
+}
+
+// Run explains why the first target depends on the second one, printing the
+// dependency path between them as a tree.
+func (w *Why) Run(cmd *cobra.Command, args []string) error {
+	from, to := args[0], args[1]
+	verb := "query"
+	if w.CQuery || w.Configs {
+		verb = "cquery"
+	}
+	function := "somepath"
+	if w.All {
+		function = "allpaths"
+	}
+
+	dot, err := w.capture(verb, "--output=graph", "--nograph:factored", fmt.Sprintf("%s(%s, %s)", function, from, to))
+	if err != nil {
+		return err
+	}
+	graph := shared.ParseGraph(dot)
+	if len(graph.Nodes) == 0 {
+		fmt.Fprintf(w.Stdout, "%s doesn't depend on %s\n", from, to)
+		return nil
+	}
+
+	labels := make([]string, len(graph.Nodes))
+	for i, node := range graph.Nodes {
+		labels[i], _ = splitConfig(node)
+	}
+	rules, err := w.capture("query", "--output=xml", fmt.Sprintf("set(%s)", strings.Join(labels, " ")))
+	if err != nil {
+		return err
+	}
+	targets, err := parseTargets(rules)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w.Stdout, "Why %s depends on %s:\n\n", from, to)
+	var transitions [][2]string
+	seenTransitions := make(map[[2]string]bool)
+	graph.RenderTreeFunc(w.Stdout, func(parent string, node string) string {
+		label, config := splitConfig(node)
+		name := label
+		if t, ok := targets[label]; ok {
+			name = fmt.Sprintf("%s (%s)", label, t.kind)
+		}
+		if parent != "" {
+			parentLabel, parentConfig := splitConfig(parent)
+			name = fmt.Sprintf("%s → %s", targets[parentLabel].edge(label), name)
+			if config != "" && config != "null" && config != parentConfig {
+				name = fmt.Sprintf("%s [config %s, transitioned]", name, config)
+				transition := [2]string{parentConfig, config}
+				if !seenTransitions[transition] {
+					seenTransitions[transition] = true
+					transitions = append(transitions, transition)
+				}
+				return name
+			}
+		}
+		if config != "" && config != "null" {
+			name = fmt.Sprintf("%s [config %s]", name, config)
+		}
+		return name
+	})
+
+	if !w.Configs || len(transitions) == 0 {
+		return nil
+	}
+	fmt.Fprintln(w.Stdout, "\nConfiguration changes:")
+	for _, transition := range transitions {
+		diff, err := w.capture("config", transition[0], transition[1])
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w.Stdout, "\n%s → %s:\n", transition[0], transition[1])
+		for _, line := range strings.Split(strings.TrimRight(string(diff), "\n"), "\n") {
+			fmt.Fprintf(w.Stdout, "  %s\n", line)
+		}
+	}
+	return nil
+}
+
+// capture runs the given Bazel command and returns its output.
+func (w *Why) capture(command ...string) ([]byte, error) {
+	var out bytes.Buffer
+	streams := ioutils.Streams{Stdout: &out, Stderr: w.Stderr}
+	if exitCode, err := w.bzl.Run(command, bazel.RunOptions{Streams: streams}); exitCode != 0 {
+		return nil, &aspecterrors.ExitError{
+			Err:      err,
+			ExitCode: exitCode,
+		}
+	}
+	return out.Bytes(), nil
+}
+
+// splitConfig splits a label output by cquery into the label and the hash of
+// its configuration, which is empty for a label output by query.
+func splitConfig(node string) (string, string) {
+	if match := configuredLabelRegex.FindStringSubmatch(node); match != nil {
+		return match[1], match[2]
+	}
+	return node, ""
+}
+
+// target is a target in the xml output of query.
+type target struct {
+	kind string
+	// attributes are the names of the attributes of a rule that list each of
+	// its dependencies.
+	attributes map[string][]string
+	// generatingRule is the rule that generates a generated file.
+	generatingRule string
+}
+
+// edge returns the attributes through which the target depends on dep.
+func (t *target) edge(dep string) string {
+	if t != nil && t.generatingRule == dep {
+		return "generated by"
+	}
+	if t == nil || len(t.attributes[dep]) == 0 {
+		return implicitEdge
+	}
+	return strings.Join(t.attributes[dep], ", ")
+}
+
+// parseTargets parses the xml output of query into the kind of each target,
+// and the attributes of each rule that list its dependencies.
+func parseTargets(data []byte) (map[string]*target, error) {
+	// Bazel declares the output as xml 1.1, which encoding/xml refuses to
+	// parse even though the output is also valid xml 1.0.
+	if bytes.HasPrefix(data, []byte("<?xml")) {
+		if i := bytes.Index(data, []byte("?>")); i >= 0 {
+			data = data[i+2:]
+		}
+	}
+	targets := make(map[string]*target)
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	var rule *target
+	// depth is the depth of the current element inside a rule, where the
+	// elements at depth 1 are the attributes.
+	depth := 0
+	attribute := ""
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the query output: %w", err)
+		}
+		switch token := token.(type) {
+		case xml.StartElement:
+			attrs := make(map[string]string, len(token.Attr))
+			for _, attr := range token.Attr {
+				attrs[attr.Name.Local] = attr.Value
+			}
+			if rule == nil {
+				switch token.Name.Local {
+				case "rule":
+					rule = &target{kind: attrs["class"], attributes: make(map[string][]string)}
+					targets[attrs["name"]] = rule
+					depth = 0
+				case "source-file":
+					targets[attrs["name"]] = &target{kind: "source file"}
+				case "generated-file":
+					targets[attrs["name"]] = &target{kind: "generated file", generatingRule: attrs["generating-rule"]}
+				}
+				continue
+			}
+			depth++
+			if depth == 1 {
+				attribute = attrs["name"]
+			}
+			if token.Name.Local == "label" && attrs["value"] != "" && attribute != "" {
+				rule.addAttribute(attrs["value"], attribute)
+			}
+		case xml.EndElement:
+			if rule == nil {
+				continue
+			}
+			if depth == 0 {
+				rule = nil
+				continue
+			}
+			depth--
+		}
+	}
+	for _, t := range targets {
+		for _, attributes := range t.attributes {
+			sort.Strings(attributes)
+		}
+	}
+	return targets, nil
+}
+
+func (t *target) addAttribute(dep string, attribute string) {
+	for _, existing := range t.attributes[dep] {
+		if existing == attribute {
+			return
+		}
+	}
+	t.attributes[dep] = append(t.attributes[dep], attribute)
+}
@@ -0,0 +1,163 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package why_test
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/gomega"
+
+	"aspect.build/cli/pkg/aspect/why"
+	"aspect.build/cli/pkg/bazel"
+	bazel_mock "aspect.build/cli/pkg/bazel/mock"
+	"aspect.build/cli/pkg/ioutils"
+)
+
+const rules = `<?xml version="1.1" encoding="UTF-8" standalone="no"?>
+<query version="2">
+    <rule class="go_binary" location="/ws/app/BUILD:3:10" name="//app:bin">
+        <list name="deps">
+            <label value="//lib:a"/>
+        </list>
+        <label name="embed" value="//lib:a"/>
+        <rule-input name="//lib:a"/>
+    </rule>
+    <rule class="go_library" location="/ws/lib/BUILD:1:11" name="//lib:a">
+        <list name="srcs">
+            <label value="//lib:a.go"/>
+        </list>
+        <dict name="x_defs">
+            <pair>
+                <label value="//lib:version"/>
+                <string value="v1"/>
+            </pair>
+        </dict>
+    </rule>
+    <source-file location="/ws/lib/BUILD:1:11" name="//lib:a.go"/>
+    <generated-file generating-rule="//lib:gen" location="/ws/lib/BUILD:5:8" name="//lib:version"/>
+</query>
+`
+
+// respond returns the given output to a Run call.
+func respond(output string) func(command []string, options bazel.RunOptions) (int, error) {
+	return func(command []string, options bazel.RunOptions) (int, error) {
+		_, err := io.WriteString(options.Streams.Stdout, output)
+		return 0, err
+	}
+}
+
+func TestWhy(t *testing.T) {
+	t.Run("the path is printed with the kinds and attributes", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		spawner := bazel_mock.NewMockBazel(ctrl)
+		gomock.InOrder(
+			spawner.
+				EXPECT().
+				Run([]string{"query", "--output=graph", "--nograph:factored", "somepath(//app:bin, //lib:version)"}, gomock.Any()).
+				DoAndReturn(respond(`digraph mygraph {
+  node [shape=box];
+  "//app:bin"
+  "//app:bin" -> "//lib:a"
+  "//lib:a"
+  "//lib:a" -> "//lib:version"
+  "//lib:version"
+  "//lib:version" -> "//lib:gen"
+  "//lib:gen"
+}
+`)),
+			spawner.
+				EXPECT().
+				Run([]string{"query", "--output=xml", "set(//app:bin //lib:a //lib:version //lib:gen)"}, gomock.Any()).
+				DoAndReturn(respond(rules)),
+		)
+
+		var stdout strings.Builder
+		err := why.New(ioutils.Streams{Stdout: &stdout}, spawner).Run(nil, []string{"//app:bin", "//lib:version"})
+		g.Expect(err).To(BeNil())
+		g.Expect(stdout.String()).To(Equal(`Why //app:bin depends on //lib:version:
+
+//app:bin (go_binary)
+└── deps, embed → //lib:a (go_library)
+    └── x_defs → //lib:version (generated file)
+        └── generated by → //lib:gen
+`))
+	})
+
+	t.Run("the configuration changes are explained with cquery", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		spawner := bazel_mock.NewMockBazel(ctrl)
+		gomock.InOrder(
+			spawner.
+				EXPECT().
+				Run([]string{"cquery", "--output=graph", "--nograph:factored", "allpaths(//app:bin, //lib:a.go)"}, gomock.Any()).
+				DoAndReturn(respond(`digraph mygraph {
+  node [shape=box];
+  "//app:bin (a1b2c3)"
+  "//app:bin (a1b2c3)" -> "//lib:a (d4e5f6)"
+  "//lib:a (d4e5f6)"
+  "//lib:a (d4e5f6)" -> "//lib:a.go (null)"
+  "//lib:a.go (null)"
+}
+`)),
+			spawner.
+				EXPECT().
+				Run([]string{"query", "--output=xml", "set(//app:bin //lib:a //lib:a.go)"}, gomock.Any()).
+				DoAndReturn(respond(rules)),
+			spawner.
+				EXPECT().
+				Run([]string{"config", "a1b2c3", "d4e5f6"}, gomock.Any()).
+				DoAndReturn(respond("Displaying diff between configs a1b2c3 and d4e5f6\nFragmentOptions com.google.devtools.build.lib.analysis.PlatformOptions {\n  platforms: [//:linux], [//:arm64]\n}\n")),
+		)
+
+		var stdout strings.Builder
+		w := why.New(ioutils.Streams{Stdout: &stdout}, spawner)
+		w.All = true
+		w.Configs = true
+		err := w.Run(nil, []string{"//app:bin", "//lib:a.go"})
+		g.Expect(err).To(BeNil())
+		g.Expect(stdout.String()).To(Equal(`Why //app:bin depends on //lib:a.go:
+
+//app:bin (go_binary) [config a1b2c3]
+└── deps, embed → //lib:a (go_library) [config d4e5f6, transitioned]
+    └── srcs → //lib:a.go (source file)
+
+Configuration changes:
+
+a1b2c3 → d4e5f6:
+  Displaying diff between configs a1b2c3 and d4e5f6
+  FragmentOptions com.google.devtools.build.lib.analysis.PlatformOptions {
+    platforms: [//:linux], [//:arm64]
+  }
+`))
+	})
+
+	t.Run("an unrelated target has no path", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		spawner := bazel_mock.NewMockBazel(ctrl)
+		spawner.
+			EXPECT().
+			Run([]string{"query", "--output=graph", "--nograph:factored", "somepath(//app:bin, //other)"}, gomock.Any()).
+			DoAndReturn(respond("digraph mygraph {\n  node [shape=box];\n}\n"))
+
+		var stdout strings.Builder
+		err := why.New(ioutils.Streams{Stdout: &stdout}, spawner).Run(nil, []string{"//app:bin", "//other"})
+		g.Expect(err).To(BeNil())
+		g.Expect(stdout.String()).To(Equal("//app:bin doesn't depend on //other\n"))
+	})
+}
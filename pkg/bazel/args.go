@@ -0,0 +1,265 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package bazel
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// maxSuggestionDistance is the maximum edit distance between an unknown flag
+// and a known one for the latter to be suggested.
+const maxSuggestionDistance = 3
+
+// ParsedArgs holds the arguments of a Bazel command line split by their role.
+// Flags are kept in the form they were given, so a flag with a separate value
+// (e.g. `-c opt`) occupies two elements.
+type ParsedArgs struct {
+	// Command is the Bazel command the arguments were parsed for.
+	Command string
+	// StartupOptions are the flags that Bazel only accepts before the command.
+	StartupOptions []string
+	// Flags are the flags accepted by the command.
+	Flags []string
+	// Targets are the non-flag arguments, e.g. the target patterns.
+	Targets []string
+	// Residue are the arguments after a `--` separator, passed verbatim.
+	Residue []string
+	// unparsed are the arguments before the `--` separator in their original
+	// order, when they were split without the flag metadata.
+	unparsed []string
+}
+
+// Args returns the arguments to be placed after the command when invoking
+// Bazel. The arguments split without the flag metadata keep their original
+// order, since a flag may take the target that follows it as its value.
+func (p *ParsedArgs) Args() []string {
+	args := make([]string, 0, len(p.Flags)+len(p.Targets)+len(p.Residue)+1)
+	if p.unparsed != nil {
+		args = append(args, p.unparsed...)
+	} else {
+		args = append(args, p.Flags...)
+		args = append(args, p.Targets...)
+	}
+	if len(p.Residue) > 0 {
+		args = append(args, "--")
+		args = append(args, p.Residue...)
+	}
+	return args
+}
+
+// UnknownFlagError is returned by ParseArgs when an argument looks like a flag
+// that Bazel doesn't know about.
+type UnknownFlagError struct {
+	Flag        string
+	Command     string
+	Suggestions []string
+}
+
+// Error returns the error message with the suggestions, if any.
+func (err *UnknownFlagError) Error() string {
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "unknown flag %q for command %q", err.Flag, err.Command)
+	switch len(err.Suggestions) {
+	case 0:
+	case 1:
+		fmt.Fprintf(&msg, ", did you mean %q?", err.Suggestions[0])
+	default:
+		quoted := make([]string, len(err.Suggestions))
+		for i, s := range err.Suggestions {
+			quoted[i] = fmt.Sprintf("%q", s)
+		}
+		fmt.Fprintf(&msg, ", did you mean one of %s?", strings.Join(quoted, ", "))
+	}
+	return msg.String()
+}
+
+// ParseArgs splits the arguments given to a Bazel command into startup options,
+// command flags and targets using the flags reported by Flags(). When flags is
+// nil, the arguments are split without being validated: anything that starts
+// with a dash is a command flag and anything else a target, so the separate
+// value of a flag, e.g. `-c opt`, is a target. Args then returns the arguments
+// in their original order.
+//
+// The flag metadata doesn't tell whether a flag takes a value, so flags that
+// have a negative form (--no<name>) are assumed to be boolean and all the
+// others to take a value, either attached with `=` or as the next argument.
+// Since expansion flags take no value and have no negative form either, the
+// next argument is only taken when it isn't a flag Bazel knows, or when the
+// flag can be repeated, which expansion flags can't, e.g.
+// `--test_arg --verbose`.
+func ParseArgs(flags map[string]*FlagInfo, command string, args []string) (*ParsedArgs, error) {
+	parsed := &ParsedArgs{Command: command}
+	if flags == nil {
+		parsed.unparsed = []string{}
+	}
+
+	abbreviations := make(map[string]*FlagInfo)
+	for _, info := range flags {
+		if info.GetAbbreviation() != "" {
+			abbreviations[info.GetAbbreviation()] = info
+		}
+	}
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			parsed.Residue = append(parsed.Residue, args[i+1:]...)
+			break
+		}
+		if flags == nil {
+			parsed.unparsed = append(parsed.unparsed, arg)
+		}
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			parsed.Targets = append(parsed.Targets, arg)
+			continue
+		}
+		if flags == nil {
+			parsed.Flags = append(parsed.Flags, arg)
+			continue
+		}
+
+		info, name, hasValue, negated := lookupFlag(flags, abbreviations, arg)
+		if info == nil {
+			return nil, &UnknownFlagError{
+				Flag:        arg,
+				Command:     command,
+				Suggestions: suggestFlags(flags, command, name),
+			}
+		}
+
+		isCommandFlag := supportsCommand(info, command)
+		isStartupOption := supportsCommand(info, StartupCommand)
+		if !isCommandFlag && !isStartupOption {
+			return nil, fmt.Errorf("flag %q is not supported by command %q", arg, command)
+		}
+
+		tokens := []string{arg}
+		if !hasValue && !negated && !info.GetHasNegativeFlag() {
+			if i+1 < len(args) && takesNext(flags, abbreviations, info, args[i+1]) {
+				tokens = append(tokens, args[i+1])
+				i++
+			}
+		}
+
+		if isCommandFlag {
+			parsed.Flags = append(parsed.Flags, tokens...)
+		} else {
+			parsed.StartupOptions = append(parsed.StartupOptions, tokens...)
+		}
+	}
+
+	return parsed, nil
+}
+
+// lookupFlag returns the flag of an argument that looks like a flag, or nil if
+// Bazel doesn't know it, with its name and whether the argument is negated or
+// has the value attached with `=`.
+func lookupFlag(flags map[string]*FlagInfo, abbreviations map[string]*FlagInfo, arg string) (info *FlagInfo, name string, hasValue bool, negated bool) {
+	name = strings.TrimLeft(arg, "-")
+	if j := strings.Index(name, "="); j >= 0 {
+		name = name[:j]
+		hasValue = true
+	}
+
+	if !strings.HasPrefix(arg, "--") {
+		return abbreviations[name], name, hasValue, false
+	}
+	info = flags[name]
+	if info == nil && strings.HasPrefix(name, "no") {
+		if positive, ok := flags[strings.TrimPrefix(name, "no")]; ok && positive.GetHasNegativeFlag() {
+			return positive, name, hasValue, true
+		}
+	}
+	return info, name, hasValue, false
+}
+
+// takesNext returns whether a flag that isn't boolean and has no value
+// attached takes the next argument as its value.
+func takesNext(flags map[string]*FlagInfo, abbreviations map[string]*FlagInfo, info *FlagInfo, next string) bool {
+	if next == "--" {
+		return false
+	}
+	if !strings.HasPrefix(next, "-") || next == "-" || info.GetAllowsMultiple() {
+		return true
+	}
+	known, _, _, _ := lookupFlag(flags, abbreviations, next)
+	return known == nil
+}
+
+func supportsCommand(info *FlagInfo, command string) bool {
+	for _, c := range info.GetCommands() {
+		if c == command {
+			return true
+		}
+	}
+	return false
+}
+
+// suggestFlags returns the known flags for the command that are the closest to
+// the given unknown flag name, formatted as they would be typed.
+func suggestFlags(flags map[string]*FlagInfo, command string, name string) []string {
+	type candidate struct {
+		name     string
+		distance int
+	}
+	var candidates []candidate
+	for flagName, info := range flags {
+		if !supportsCommand(info, command) && !supportsCommand(info, StartupCommand) {
+			continue
+		}
+		if d := levenshtein(name, flagName); d <= maxSuggestionDistance {
+			candidates = append(candidates, candidate{flagName, d})
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].distance != candidates[j].distance {
+			return candidates[i].distance < candidates[j].distance
+		}
+		return candidates[i].name < candidates[j].name
+	})
+
+	suggestions := make([]string, 0, 3)
+	for _, c := range candidates {
+		if len(suggestions) == cap(suggestions) {
+			break
+		}
+		suggestions = append(suggestions, "--"+c.name)
+	}
+	return suggestions
+}
+
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+func min(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
@@ -0,0 +1,126 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package bazel_test
+
+import (
+	"errors"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"google.golang.org/protobuf/proto"
+
+	"aspect.build/cli/pkg/bazel"
+)
+
+func testFlags() map[string]*bazel.FlagInfo {
+	flags := []*bazel.FlagInfo{
+		{
+			Name:         proto.String("compilation_mode"),
+			Commands:     []string{"build", "test"},
+			Abbreviation: proto.String("c"),
+		},
+		{
+			Name:            proto.String("keep_going"),
+			HasNegativeFlag: proto.Bool(true),
+			Commands:        []string{"build", "test"},
+			Abbreviation:    proto.String("k"),
+		},
+		{
+			Name:     proto.String("test_output"),
+			Commands: []string{"test"},
+		},
+		{
+			Name:           proto.String("test_arg"),
+			Commands:       []string{"test"},
+			AllowsMultiple: proto.Bool(true),
+		},
+		{
+			Name:     proto.String("experimental_expansion"),
+			Commands: []string{"build", "test"},
+		},
+		{
+			Name:     proto.String("output_base"),
+			Commands: []string{"startup"},
+		},
+	}
+	m := make(map[string]*bazel.FlagInfo)
+	for _, f := range flags {
+		m[f.GetName()] = f
+	}
+	return m
+}
+
+func TestParseArgs(t *testing.T) {
+	t.Run("splits startup options, flags and targets", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		parsed, err := bazel.ParseArgs(testFlags(), "build", []string{
+			"--output_base=/tmp/out", "//foo/...", "-c", "opt", "--nokeep_going", "//bar", "--", "-//foo/baz",
+		})
+		g.Expect(err).To(BeNil())
+		g.Expect(parsed.StartupOptions).To(Equal([]string{"--output_base=/tmp/out"}))
+		g.Expect(parsed.Flags).To(Equal([]string{"-c", "opt", "--nokeep_going"}))
+		g.Expect(parsed.Targets).To(Equal([]string{"//foo/...", "//bar"}))
+		g.Expect(parsed.Residue).To(Equal([]string{"-//foo/baz"}))
+		g.Expect(parsed.Args()).To(Equal([]string{"-c", "opt", "--nokeep_going", "//foo/...", "//bar", "--", "-//foo/baz"}))
+	})
+
+	t.Run("boolean flags don't take the next argument as value", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		parsed, err := bazel.ParseArgs(testFlags(), "build", []string{"--keep_going", "//foo"})
+		g.Expect(err).To(BeNil())
+		g.Expect(parsed.Flags).To(Equal([]string{"--keep_going"}))
+		g.Expect(parsed.Targets).To(Equal([]string{"//foo"}))
+	})
+
+	t.Run("flags that take a value take the next argument even if it starts with --", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		parsed, err := bazel.ParseArgs(testFlags(), "test", []string{
+			"--test_arg", "--verbose", "--test_arg", "--keep_going", "--test_output", "--summary", "//foo",
+		})
+		g.Expect(err).To(BeNil())
+		g.Expect(parsed.Flags).To(Equal([]string{"--test_arg", "--verbose", "--test_arg", "--keep_going", "--test_output", "--summary"}))
+		g.Expect(parsed.Targets).To(Equal([]string{"//foo"}))
+	})
+
+	t.Run("flags that may take no value don't take a following known flag", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		parsed, err := bazel.ParseArgs(testFlags(), "build", []string{"--experimental_expansion", "--keep_going", "//foo"})
+		g.Expect(err).To(BeNil())
+		g.Expect(parsed.Flags).To(Equal([]string{"--experimental_expansion", "--keep_going"}))
+		g.Expect(parsed.Targets).To(Equal([]string{"//foo"}))
+	})
+
+	t.Run("unknown flags are rejected with suggestions", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		_, err := bazel.ParseArgs(testFlags(), "build", []string{"--compilaton_mode=opt"})
+		var unknownErr *bazel.UnknownFlagError
+		g.Expect(errors.As(err, &unknownErr)).To(BeTrue())
+		g.Expect(unknownErr.Suggestions).To(Equal([]string{"--compilation_mode"}))
+		g.Expect(err.Error()).To(Equal(`unknown flag "--compilaton_mode=opt" for command "build", did you mean "--compilation_mode"?`))
+	})
+
+	t.Run("flags of other commands are rejected", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		_, err := bazel.ParseArgs(testFlags(), "build", []string{"--test_output=errors"})
+		g.Expect(err).To(MatchError(`flag "--test_output=errors" is not supported by command "build"`))
+	})
+
+	t.Run("without flag metadata the arguments are not validated", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		parsed, err := bazel.ParseArgs(nil, "build", []string{"--whatever=1", "//foo"})
+		g.Expect(err).To(BeNil())
+		g.Expect(parsed.Flags).To(Equal([]string{"--whatever=1"}))
+		g.Expect(parsed.Targets).To(Equal([]string{"//foo"}))
+	})
+
+	t.Run("without flag metadata the arguments keep their order", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		parsed, err := bazel.ParseArgs(nil, "build", []string{"//foo", "-c", "opt", "--", "-//bar"})
+		g.Expect(err).To(BeNil())
+		g.Expect(parsed.Args()).To(Equal([]string{"//foo", "-c", "opt", "--", "-//bar"}))
+	})
+}
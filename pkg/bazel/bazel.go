@@ -7,24 +7,49 @@ Not licensed for re-use.
 package bazel
 
 import (
-	"encoding/base64"
-	"fmt"
+	"context"
 	"io"
-	"io/ioutil"
 
-	"github.com/bazelbuild/bazelisk/core"
-	"github.com/bazelbuild/bazelisk/repositories"
-	"google.golang.org/protobuf/proto"
+	"aspect.build/cli/pkg/ioutils"
 )
 
 type Bazel interface {
 	SetWorkspaceRoot(workspaceRoot string)
+	SetStartupOptions(startupOptions []string)
+	SetContext(ctx context.Context)
+	Flags() (map[string]*FlagInfo, error)
+	CachedFlags() (map[string]*FlagInfo, error)
+	Info() (*Info, error)
+	ResolveVersion() (*VersionResolution, error)
 	Spawn(command []string) (int, error)
 	RunCommand(command []string, out io.Writer) (int, error)
+	Run(command []string, options RunOptions) (int, error)
+}
+
+// RunOptions are the options of a single Bazel invocation.
+type RunOptions struct {
+	// Context stops Bazel when it is done, like an interrupt does. It
+	// defaults to the context set with SetContext.
+	Context context.Context
+	// Streams are the standard streams of Bazel. The ones that aren't set
+	// are the streams of the process.
+	Streams ioutils.Streams
+	// Env are added to the environment of Bazel, as KEY=value.
+	Env []string
+	// Dir is the working directory of Bazel, which Bazel resolves relative
+	// labels against. It defaults to the working directory of the process.
+	Dir string
+	// StartupOptions are placed before the command, after the ones set with
+	// SetStartupOptions.
+	StartupOptions []string
 }
 
 type bazel struct {
-	workspaceRoot string
+	workspaceRoot  string
+	startupOptions []string
+	ctx            context.Context
+	// infos caches the output of bazel info by workspace and startup options.
+	infos map[string]*Info
 }
 
 func New() Bazel {
@@ -35,60 +60,53 @@ func (b *bazel) SetWorkspaceRoot(workspaceRoot string) {
 	b.workspaceRoot = workspaceRoot
 }
 
-func (*bazel) createRepositories() *core.Repositories {
-	gcs := &repositories.GCSRepo{}
-	gitHub := repositories.CreateGitHubRepo(core.GetEnvOrConfig("BAZELISK_GITHUB_TOKEN"))
-	// Fetch LTS releases, release candidates and Bazel-at-commits from GCS, forks and rolling releases from GitHub.
-	// TODO(https://github.com/bazelbuild/bazelisk/issues/228): get rolling releases from GCS, too.
-	return core.CreateRepositories(gcs, gcs, gitHub, gcs, gitHub, true)
+// SetStartupOptions sets the startup options placed before the command in
+// every Bazel invocation, including the ones aspect makes on its own, so that
+// they don't cause the Bazel server to restart.
+func (b *bazel) SetStartupOptions(startupOptions []string) {
+	b.startupOptions = startupOptions
+}
+
+// SetContext sets the context of every Bazel invocation that doesn't set its
+// own, so that Bazel stops when the command is cancelled, e.g. on Ctrl-C.
+func (b *bazel) SetContext(ctx context.Context) {
+	b.ctx = ctx
+}
+
+// ResolveVersion decides the version of Bazel the workspace runs, without
+// downloading or running it. A floating version, e.g. latest, is looked up.
+func (b *bazel) ResolveVersion() (*VersionResolution, error) {
+	bazelisk, err := NewBazelisk(b.workspaceRoot)
+	if err != nil {
+		return nil, err
+	}
+	return bazelisk.resolveWithoutDownload(bazelisk.createRepositories())
 }
 
 // Spawn is similar to the main() function of bazelisk
 // see https://github.com/bazelbuild/bazelisk/blob/7c3d9d5/bazelisk.go
 func (b *bazel) Spawn(command []string) (int, error) {
-	return b.RunCommand(command, nil)
+	return b.Run(command, RunOptions{})
 }
 
+// RunCommand runs Bazel with its stdout written to out.
 func (b *bazel) RunCommand(command []string, out io.Writer) (int, error) {
-	repos := b.createRepositories()
-	if len(b.workspaceRoot) < 1 {
-		panic("Illegal state: running bazel without the workspaceRoot set")
-	}
-
-	bazelisk := NewBazelisk(b.workspaceRoot)
-	exitCode, err := bazelisk.Run(command, repos, out)
-	return exitCode, err
+	return b.Run(command, RunOptions{Streams: ioutils.Streams{Stdout: out}})
 }
 
-func (b *bazel) Flags() (map[string]*FlagInfo, error) {
-	r, w := io.Pipe()
-	decoder := base64.NewDecoder(base64.StdEncoding, r)
-	bazelErrs := make(chan error, 1)
-	defer close(bazelErrs)
-	go func() {
-		defer w.Close()
-		_, err := b.RunCommand([]string{"help", "flags-as-proto"}, w)
-		bazelErrs <- err
-	}()
-
-	helpProtoBytes, err := ioutil.ReadAll(decoder)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get Bazel flags: %w", err)
-	}
-
-	if err := <-bazelErrs; err != nil {
-		return nil, fmt.Errorf("failed to get Bazel flags: %w", err)
+// Run runs Bazel with the given options.
+func (b *bazel) Run(command []string, options RunOptions) (int, error) {
+	if len(b.workspaceRoot) < 1 {
+		panic("Illegal state: running bazel without the workspaceRoot set")
 	}
 
-	flagCollection := &FlagCollection{}
-	if err := proto.Unmarshal(helpProtoBytes, flagCollection); err != nil {
-		return nil, fmt.Errorf("failed to get Bazel flags: %w", err)
+	if options.Context == nil {
+		options.Context = b.ctx
 	}
-
-	flags := make(map[string]*FlagInfo)
-	for i := range flagCollection.FlagInfos {
-		flags[*flagCollection.FlagInfos[i].Name] = flagCollection.FlagInfos[i]
+	bazelisk, err := NewBazelisk(b.workspaceRoot)
+	if err != nil {
+		return -1, err
 	}
-
-	return flags, nil
+	bazelisk.startupOptions = append(append([]string{}, b.startupOptions...), options.StartupOptions...)
+	return bazelisk.Run(command, bazelisk.createRepositories(), options)
 }
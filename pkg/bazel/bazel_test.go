@@ -0,0 +1,150 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package bazel_test
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"google.golang.org/protobuf/proto"
+
+	"aspect.build/cli/pkg/bazel"
+	"aspect.build/cli/pkg/ioutils"
+)
+
+func TestRun(t *testing.T) {
+	t.Run("the options of an invocation are passed to Bazel", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		workspace := fakeBazel(t, g, `#!/bin/sh
+echo "args: $@"
+echo "pwd: $(pwd)"
+echo "foo: $FOO"
+cat
+echo "stderr" >&2
+`)
+
+		bzl := bazel.New()
+		bzl.SetWorkspaceRoot(workspace)
+		bzl.SetStartupOptions([]string{"--nohome_rc"})
+		var stdout, stderr strings.Builder
+		exitCode, err := bzl.Run([]string{"info"}, bazel.RunOptions{
+			Streams: ioutils.Streams{
+				Stdin:  strings.NewReader("stdin\n"),
+				Stdout: &stdout,
+				Stderr: &stderr,
+			},
+			Env:            []string{"FOO=bar"},
+			Dir:            filepath.Join(workspace, "pkg"),
+			StartupOptions: []string{"--output_base=/tmp/ob"},
+		})
+		g.Expect(err).To(BeNil())
+		g.Expect(exitCode).To(Equal(0))
+		g.Expect(stdout.String()).To(Equal("args: --nohome_rc --output_base=/tmp/ob info\npwd: " + filepath.Join(workspace, "pkg") + "\nfoo: bar\nstdin\n"))
+		g.Expect(stderr.String()).To(Equal("stderr\n"))
+	})
+
+	t.Run("cancelling the context interrupts Bazel", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		workspace := fakeBazel(t, g, `#!/bin/sh
+trap 'kill $!; echo interrupted; exit 8' INT
+echo started
+sleep 10 >/dev/null &
+wait
+`)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		bzl := bazel.New()
+		bzl.SetWorkspaceRoot(workspace)
+		bzl.SetContext(ctx)
+		stdout, stdoutWriter := io.Pipe()
+		output := make(chan string)
+		go func() {
+			started := make([]byte, len("started\n"))
+			io.ReadFull(stdout, started)
+			cancel()
+			rest, _ := ioutil.ReadAll(stdout)
+			output <- string(started) + string(rest)
+		}()
+
+		begin := time.Now()
+		exitCode, _ := bzl.Run([]string{"build"}, bazel.RunOptions{
+			Streams: ioutils.Streams{Stdout: stdoutWriter},
+		})
+		stdoutWriter.Close()
+		g.Expect(exitCode).To(Equal(8))
+		g.Expect(time.Since(begin)).To(BeNumerically("<", 5*time.Second))
+		g.Expect(<-output).To(Equal("started\ninterrupted\n"))
+	})
+}
+
+func TestFlags(t *testing.T) {
+	t.Run("the flags of a binary without a version number are cached until it changes", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		helpProto, err := proto.Marshal(&bazel.FlagCollection{
+			FlagInfos: []*bazel.FlagInfo{{Name: proto.String("keep_going"), Commands: []string{"build"}}},
+		})
+		g.Expect(err).To(BeNil())
+		dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "flags")
+		g.Expect(err).To(BeNil())
+		defer os.RemoveAll(dir)
+		t.Setenv("XDG_CACHE_HOME", filepath.Join(dir, "cache"))
+		calls := filepath.Join(dir, "calls")
+		script := "#!/bin/sh\necho \"$@\" >> " + calls + "\necho " + base64.StdEncoding.EncodeToString(helpProto) + "\n"
+		workspace := fakeBazel(t, g, script)
+
+		bzl := bazel.New()
+		bzl.SetWorkspaceRoot(workspace)
+		cachedFlags, err := bzl.CachedFlags()
+		g.Expect(err).To(BeNil())
+		g.Expect(cachedFlags).To(BeNil())
+		for i := 0; i < 2; i++ {
+			flags, err := bzl.Flags()
+			g.Expect(err).To(BeNil())
+			g.Expect(flags).To(HaveKey("keep_going"))
+		}
+		cachedFlags, err = bzl.CachedFlags()
+		g.Expect(err).To(BeNil())
+		g.Expect(cachedFlags).To(HaveKey("keep_going"))
+		g.Expect(ioutil.ReadFile(calls)).To(Equal([]byte("help flags-as-proto\n")))
+
+		// A new binary at the same path has its own flags.
+		g.Expect(ioutil.WriteFile(os.Getenv("USE_BAZEL_VERSION"), []byte(script+"# new\n"), 0755)).To(Succeed())
+		_, err = bzl.Flags()
+		g.Expect(err).To(BeNil())
+		g.Expect(ioutil.ReadFile(calls)).To(Equal([]byte("help flags-as-proto\nhelp flags-as-proto\n")))
+	})
+}
+
+// fakeBazel makes Bazelisk run a script instead of Bazel, which it does when
+// USE_BAZEL_VERSION is the path of a binary, and returns a workspace to run
+// it in.
+func fakeBazel(t *testing.T, g *WithT, script string) string {
+	dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "bazel")
+	g.Expect(err).To(BeNil())
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	dir, err = filepath.EvalSymlinks(dir)
+	g.Expect(err).To(BeNil())
+	workspace := filepath.Join(dir, "ws")
+	g.Expect(os.MkdirAll(filepath.Join(workspace, "pkg"), 0755)).To(Succeed())
+	bazel := filepath.Join(dir, "bazel")
+	g.Expect(ioutil.WriteFile(bazel, []byte(script), 0755)).To(Succeed())
+	t.Setenv("USE_BAZEL_VERSION", bazel)
+	t.Setenv("BAZELISK_HOME", filepath.Join(dir, "bazelisk"))
+	return workspace
+}
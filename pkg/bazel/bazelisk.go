@@ -2,13 +2,12 @@ package bazel
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
 	"os"
 	"os/exec"
-	"os/signal"
 	"path/filepath"
 	"regexp"
 	"runtime"
@@ -20,8 +19,11 @@ import (
 	"github.com/bazelbuild/bazelisk/core"
 	"github.com/bazelbuild/bazelisk/httputil"
 	"github.com/bazelbuild/bazelisk/platforms"
+	"github.com/bazelbuild/bazelisk/repositories"
 	"github.com/bazelbuild/bazelisk/versions"
 	"github.com/mitchellh/go-homedir"
+
+	"aspect.build/cli/pkg/ioutils"
 )
 
 const (
@@ -30,67 +32,197 @@ const (
 	wrapperPath    = "./tools/bazel"
 )
 
-var (
-	// BazeliskVersion is filled in via x_defs when building a release.
-	BazeliskVersion = "development"
+// BazeliskVersion is filled in via x_defs when building a release.
+var BazeliskVersion = "development"
 
-	fileConfig     map[string]string
-	fileConfigOnce sync.Once
+var (
+	// wrapperVersionRegex matches a tools/bazel wrapper setting the version
+	// of Bazel, e.g. export USE_BAZEL_VERSION=5.0.0.
+	wrapperVersionRegex = regexp.MustCompile(`(?m)^\s*(?:export\s+)?((?:USE_)?BAZEL_VERSION)=["']?([^"'\s$]+)["']?\s*$`)
+	// minimumBazelVersionRegex matches the minimum version of a WORKSPACE
+	// checking it with versions.check of bazel_skylib, which is only reported
+	// since the latest release satisfies it.
+	minimumBazelVersionRegex = regexp.MustCompile(`minimum_bazel_version\s*=\s*"([^"]+)"`)
+	// userAgentOnce sets the user agent of the downloads of the process.
+	userAgentOnce sync.Once
+	// resolutions are the versions of Bazel the process resolved, by
+	// workspace, so that a floating version, e.g. latest, is only looked up
+	// once.
+	resolutions   = make(map[string]*VersionResolution)
+	resolutionsMu sync.Mutex
 )
 
+// Bazelisk runs Bazel in a workspace, with the version of Bazel the workspace
+// asks for. Each Bazelisk has the configuration of its own workspace, so that
+// a process can run Bazel in several workspaces at the same time.
 type Bazelisk struct {
 	workspaceRoot string
+	// fileConfig is the configuration from the .bazeliskrc of the workspace.
+	fileConfig map[string]string
+	// startupOptions are placed before the arguments of every Bazel invocation.
+	startupOptions []string
 }
 
-func NewBazelisk(workspaceRoot string) *Bazelisk {
-	return &Bazelisk{workspaceRoot: workspaceRoot}
+// NewBazelisk creates a Bazelisk for the workspace at workspaceRoot, reading
+// its .bazeliskrc when it has one.
+func NewBazelisk(workspaceRoot string) (*Bazelisk, error) {
+	fileConfig, err := readFileConfig(workspaceRoot)
+	if err != nil {
+		return nil, err
+	}
+	return &Bazelisk{
+		workspaceRoot: workspaceRoot,
+		fileConfig:    fileConfig,
+	}, nil
 }
 
-// Run runs the main Bazelisk logic for the given arguments and Bazel repositories.
-func (bazelisk *Bazelisk) Run(args []string, repos *core.Repositories, out io.Writer) (int, error) {
-	httputil.UserAgent = bazelisk.getUserAgent()
-
-	bazeliskHome := bazelisk.GetEnvOrConfig("BAZELISK_HOME")
-	if len(bazeliskHome) == 0 {
-		userCacheDir, err := os.UserCacheDir()
-		if err != nil {
-			return -1, fmt.Errorf("could not get the user's cache directory: %v", err)
+// readFileConfig parses the KEY=value lines of the .bazeliskrc in the
+// workspace root. It returns no configuration when the file doesn't exist.
+func readFileConfig(workspaceRoot string) (map[string]string, error) {
+	fileConfig := make(map[string]string)
+	if len(workspaceRoot) == 0 {
+		return fileConfig, nil
+	}
+	rcFilePath := filepath.Join(workspaceRoot, ".bazeliskrc")
+	contents, err := ioutil.ReadFile(rcFilePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fileConfig, nil
+		}
+		return nil, fmt.Errorf("could not read %s: %v", rcFilePath, err)
+	}
+	for _, line := range strings.Split(string(contents), "\n") {
+		if strings.HasPrefix(line, "#") {
+			// comments
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) < 2 {
+			continue
 		}
+		key := strings.TrimSpace(parts[0])
+		fileConfig[key] = strings.TrimSpace(parts[1])
+	}
+	return fileConfig, nil
+}
+
+// createRepositories creates the repositories Bazel is downloaded from.
+func (bazelisk *Bazelisk) createRepositories() *core.Repositories {
+	gcs := &repositories.GCSRepo{}
+	gitHub := repositories.CreateGitHubRepo(bazelisk.GetEnvOrConfig("BAZELISK_GITHUB_TOKEN"))
+	// Fetch LTS releases, release candidates and Bazel-at-commits from GCS, forks and rolling releases from GitHub.
+	// TODO(https://github.com/bazelbuild/bazelisk/issues/228): get rolling releases from GCS, too.
+	return core.CreateRepositories(gcs, gcs, gitHub, gcs, gitHub, true)
+}
 
-		bazeliskHome = filepath.Join(userCacheDir, "bazelisk")
+// Home returns the directory where Bazelisk keeps the Bazel binaries it
+// downloads, under downloads/.
+func (bazelisk *Bazelisk) Home() (string, error) {
+	if bazeliskHome := bazelisk.GetEnvOrConfig("BAZELISK_HOME"); len(bazeliskHome) > 0 {
+		return bazeliskHome, nil
 	}
+	userCacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("could not get the user's cache directory: %v", err)
+	}
+	return filepath.Join(userCacheDir, "bazelisk"), nil
+}
 
-	err := os.MkdirAll(bazeliskHome, 0755)
+// VersionResolution explains which Bazel a workspace runs.
+type VersionResolution struct {
+	// Version is the version the workspace asks for, e.g. 5.0.0, latest,
+	// last_green or the path of a Bazel binary.
+	Version string
+	// Source is what decided the version, e.g. the USE_BAZEL_VERSION
+	// environment variable or a .bazelversion file.
+	Source string
+	// ResolvedVersion is the version Version resolves to, e.g. the version of
+	// the latest release, or "unknown" for a binary.
+	ResolvedVersion string
+	// BinaryPath is the path of the Bazel binary.
+	BinaryPath string
+	// NotDownloaded is true when the binary at BinaryPath wasn't downloaded
+	// yet, which running Bazel does.
+	NotDownloaded bool
+	// Wrapper is the path of the tools/bazel wrapper that runs the binary,
+	// or "" when the workspace has none.
+	Wrapper string
+}
+
+// resolve decides the version of Bazel to run, and downloads it from repos
+// when it isn't a local binary. The version of a workspace is resolved once
+// per process.
+func (bazelisk *Bazelisk) resolve(repos *core.Repositories) (*VersionResolution, error) {
+	resolutionsMu.Lock()
+	defer resolutionsMu.Unlock()
+	if resolution, ok := resolutions[bazelisk.workspaceRoot]; ok {
+		return resolution, nil
+	}
+	resolution, err := bazelisk.resolveUncached(repos, true)
 	if err != nil {
-		return -1, fmt.Errorf("could not create directory %s: %v", bazeliskHome, err)
+		return nil, err
 	}
+	resolutions[bazelisk.workspaceRoot] = resolution
+	return resolution, nil
+}
+
+// resolveWithoutDownload is like resolve, but it only tells where the binary
+// of a version that wasn't downloaded yet would be downloaded to.
+func (bazelisk *Bazelisk) resolveWithoutDownload(repos *core.Repositories) (*VersionResolution, error) {
+	resolutionsMu.Lock()
+	defer resolutionsMu.Unlock()
+	if resolution, ok := resolutions[bazelisk.workspaceRoot]; ok {
+		return resolution, nil
+	}
+	return bazelisk.resolveUncached(repos, false)
+}
 
-	bazelVersionString, err := bazelisk.getBazelVersion()
+func (bazelisk *Bazelisk) resolveUncached(repos *core.Repositories, download bool) (*VersionResolution, error) {
+	// The user agent of the downloads is global to the process, so it is set
+	// once, before the first download reads it, from the workspace that
+	// resolves Bazel first. A different BAZELISK_USER_AGENT of another
+	// workspace of the same process is ignored.
+	userAgentOnce.Do(func() {
+		httputil.UserAgent = bazelisk.getUserAgent()
+	})
+
+	bazeliskHome, err := bazelisk.Home()
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(bazeliskHome, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("could not create directory %s: %v", bazeliskHome, err)
+	}
+
+	bazelVersionString, source, err := bazelisk.getBazelVersion()
 	if err != nil {
-		return -1, fmt.Errorf("could not get Bazel version: %v", err)
+		return nil, fmt.Errorf("could not get Bazel version: %v", err)
 	}
 
 	bazelPath, err := homedir.Expand(bazelVersionString)
 	if err != nil {
-		return -1, fmt.Errorf("could not expand home directory in path: %v", err)
+		return nil, fmt.Errorf("could not expand home directory in path: %v", err)
 	}
 
 	// If the Bazel version is an absolute path to a Bazel binary in the filesystem, we can
 	// use it directly. In that case, we don't know which exact version it is, though.
 	resolvedBazelVersion := "unknown"
+	notDownloaded := false
 
 	// If we aren't using a local Bazel binary, we'll have to parse the version string and
 	// download the version that the user wants.
 	if !filepath.IsAbs(bazelPath) {
 		bazelFork, bazelVersion, err := bazelisk.parseBazelForkAndVersion(bazelVersionString)
 		if err != nil {
-			return -1, fmt.Errorf("could not parse Bazel fork and version: %v", err)
+			return nil, fmt.Errorf("could not parse Bazel fork and version: %v", err)
 		}
 
 		var downloader core.DownloadFunc
 		resolvedBazelVersion, downloader, err = repos.ResolveVersion(bazeliskHome, bazelFork, bazelVersion)
 		if err != nil {
-			return -1, fmt.Errorf("could not resolve the version '%s' to an actual version number: %v", bazelVersion, err)
+			return nil, fmt.Errorf("could not resolve the version '%s' to an actual version number: %v", bazelVersion, err)
 		}
 
 		bazelForkOrURL := dirForURL(bazelisk.GetEnvOrConfig(core.BaseURLEnv))
@@ -99,24 +231,57 @@ func (bazelisk *Bazelisk) Run(args []string, repos *core.Repositories, out io.Wr
 		}
 
 		baseDirectory := filepath.Join(bazeliskHome, "downloads", bazelForkOrURL)
-		bazelPath, err = bazelisk.downloadBazel(bazelFork, resolvedBazelVersion, baseDirectory, repos, downloader)
-		if err != nil {
-			return -1, fmt.Errorf("could not download Bazel: %v", err)
+		if download {
+			bazelPath, err = bazelisk.downloadBazel(bazelFork, resolvedBazelVersion, baseDirectory, repos, downloader)
+			if err != nil {
+				return nil, fmt.Errorf("could not download Bazel: %v", err)
+			}
+		} else {
+			bazelPath, err = bazelisk.downloadPath(resolvedBazelVersion, baseDirectory)
+			if err != nil {
+				return nil, err
+			}
+			if _, err := os.Stat(bazelPath); err != nil {
+				notDownloaded = true
+			}
 		}
 	} else {
 		baseDirectory := filepath.Join(bazeliskHome, "local")
 		bazelPath, err = bazelisk.linkLocalBazel(baseDirectory, bazelPath)
 		if err != nil {
-			return -1, fmt.Errorf("cound not link local Bazel: %v", err)
+			return nil, fmt.Errorf("cound not link local Bazel: %v", err)
 		}
 	}
 
+	resolution := &VersionResolution{
+		Version:         bazelVersionString,
+		Source:          source,
+		ResolvedVersion: resolvedBazelVersion,
+		BinaryPath:      bazelPath,
+		NotDownloaded:   notDownloaded,
+	}
+	if wrapper := bazelisk.maybeDelegateToWrapper(bazelPath); wrapper != bazelPath {
+		resolution.Wrapper = wrapper
+	}
+	return resolution, nil
+}
+
+// Run runs the main Bazelisk logic for the given arguments and Bazel repositories.
+func (bazelisk *Bazelisk) Run(args []string, repos *core.Repositories, options RunOptions) (int, error) {
+	options = withDefaults(options)
+
+	resolution, err := bazelisk.resolve(repos)
+	if err != nil {
+		return -1, err
+	}
+	bazelPath := resolution.BinaryPath
+
 	// --print_env must be the first argument.
 	if len(args) > 0 && args[0] == "--print_env" {
 		// print environment variables for sub-processes
-		cmd := bazelisk.makeBazelCmd(bazelPath, args, nil)
+		cmd := bazelisk.makeBazelCmd(bazelPath, args, options)
 		for _, val := range cmd.Env {
-			fmt.Println(val)
+			fmt.Fprintln(options.Streams.Stdout, val)
 		}
 		return 0, nil
 	}
@@ -133,7 +298,7 @@ func (bazelisk *Bazelisk) Run(args []string, repos *core.Repositories, out io.Wr
 		}
 
 		if args[0] == "--migrate" {
-			bazelisk.migrate(bazelPath, args[1:], newFlags)
+			return bazelisk.migrate(bazelPath, args[1:], newFlags, options)
 		} else {
 			// When --strict is present, it expands to the list of --incompatible_ flags
 			// that should be enabled for the given Bazel version.
@@ -155,13 +320,13 @@ func (bazelisk *Bazelisk) Run(args []string, repos *core.Repositories, out io.Wr
 		}
 
 		if gnuFormat {
-			fmt.Printf("Bazelisk %s\n", BazeliskVersion)
+			fmt.Fprintf(options.Streams.Stdout, "Bazelisk %s\n", BazeliskVersion)
 		} else {
-			fmt.Printf("Bazelisk version: %s\n", BazeliskVersion)
+			fmt.Fprintf(options.Streams.Stdout, "Bazelisk version: %s\n", BazeliskVersion)
 		}
 	}
 
-	exitCode, err := bazelisk.runBazel(bazelPath, args, out)
+	exitCode, err := bazelisk.runBazel(bazelPath, args, options)
 	if err != nil {
 		return -1, fmt.Errorf("could not run Bazel: %v", err)
 	}
@@ -190,76 +355,87 @@ func (bazelisk *Bazelisk) GetEnvOrConfig(name string) string {
 	if val := os.Getenv(name); val != "" {
 		return val
 	}
-
-	// Parse .bazeliskrc in the workspace root, once, if it can be found.
-	fileConfigOnce.Do(func() {
-		rcFilePath := filepath.Join(bazelisk.workspaceRoot, ".bazeliskrc")
-		contents, err := ioutil.ReadFile(rcFilePath)
-		if err != nil {
-			if os.IsNotExist(err) {
-				return
-			}
-			log.Fatal(err)
-		}
-		fileConfig = make(map[string]string)
-		for _, line := range strings.Split(string(contents), "\n") {
-			if strings.HasPrefix(line, "#") {
-				// comments
-				continue
-			}
-			parts := strings.SplitN(line, "=", 2)
-			if len(parts) < 2 {
-				continue
-			}
-			key := strings.TrimSpace(parts[0])
-			fileConfig[key] = strings.TrimSpace(parts[1])
-		}
-	})
-
-	return fileConfig[name]
+	return bazelisk.fileConfig[name]
 }
 
-func (bazelisk *Bazelisk) getBazelVersion() (string, error) {
+// getBazelVersion returns the version of Bazel the workspace asks for, and
+// what decided it.
+func (bazelisk *Bazelisk) getBazelVersion() (string, string, error) {
 	// Check in this order:
 	// - env var "USE_BAZEL_VERSION" is set to a specific version.
 	// - env var "USE_NIGHTLY_BAZEL" or "USE_BAZEL_NIGHTLY" is set -> latest
-	//   nightly. (TODO)
+	//   nightly, i.e. the last green commit.
 	// - env var "USE_CANARY_BAZEL" or "USE_BAZEL_CANARY" is set -> latest
-	//   rc. (TODO)
-	// - the file workspace_root/tools/bazel exists -> that version. (TODO)
+	//   rc.
+	// - the file workspace_root/tools/bazel exists and sets a
+	//   'USE_BAZEL_VERSION' or 'BAZEL_VERSION' variable -> that version.
 	// - workspace_root/.bazeliskrc exists and contains a 'USE_BAZEL_VERSION'
 	//   variable -> read contents, that version.
 	// - workspace_root/.bazelversion exists -> read contents, that version.
-	// - workspace_root/WORKSPACE contains a version -> that version. (TODO)
-	// - fallback: latest release
-	bazelVersion := bazelisk.GetEnvOrConfig("USE_BAZEL_VERSION")
-	if len(bazelVersion) != 0 {
-		return bazelVersion, nil
+	// - fallback: latest release, which satisfies the minimum_bazel_version
+	//   of a versions.check in workspace_root/WORKSPACE, a lower bound.
+	if bazelVersion := os.Getenv("USE_BAZEL_VERSION"); len(bazelVersion) != 0 {
+		return bazelVersion, "the USE_BAZEL_VERSION environment variable", nil
+	}
+	for _, name := range []string{"USE_NIGHTLY_BAZEL", "USE_BAZEL_NIGHTLY"} {
+		if len(os.Getenv(name)) != 0 {
+			return "last_green", fmt.Sprintf("the %s environment variable", name), nil
+		}
+	}
+	for _, name := range []string{"USE_CANARY_BAZEL", "USE_BAZEL_CANARY"} {
+		if len(os.Getenv(name)) != 0 {
+			return "last_rc", fmt.Sprintf("the %s environment variable", name), nil
+		}
 	}
 
-	if len(bazelisk.workspaceRoot) != 0 {
-		bazelVersionPath := filepath.Join(bazelisk.workspaceRoot, ".bazelversion")
-		if _, err := os.Stat(bazelVersionPath); err == nil {
-			f, err := os.Open(bazelVersionPath)
-			if err != nil {
-				return "", fmt.Errorf("could not read %s: %v", bazelVersionPath, err)
-			}
-			defer f.Close()
+	if len(bazelisk.workspaceRoot) == 0 {
+		return "latest", "no version is set, so the latest release", nil
+	}
 
-			scanner := bufio.NewScanner(f)
-			scanner.Scan()
-			bazelVersion := scanner.Text()
-			if err := scanner.Err(); err != nil {
-				return "", fmt.Errorf("could not read version from file %s: %v", bazelVersion, err)
-			}
+	wrapper := filepath.Join(bazelisk.workspaceRoot, wrapperPath)
+	if contents, err := ioutil.ReadFile(wrapper); err == nil {
+		if m := wrapperVersionRegex.FindSubmatch(contents); m != nil {
+			return string(m[2]), fmt.Sprintf("%s in %s", m[1], wrapper), nil
+		}
+	}
 
-			if len(bazelVersion) != 0 {
-				return bazelVersion, nil
-			}
+	if bazelVersion := bazelisk.fileConfig["USE_BAZEL_VERSION"]; len(bazelVersion) != 0 {
+		return bazelVersion, fmt.Sprintf("USE_BAZEL_VERSION in %s", filepath.Join(bazelisk.workspaceRoot, ".bazeliskrc")), nil
+	}
+
+	bazelVersionPath := filepath.Join(bazelisk.workspaceRoot, ".bazelversion")
+	if _, err := os.Stat(bazelVersionPath); err == nil {
+		f, err := os.Open(bazelVersionPath)
+		if err != nil {
+			return "", "", fmt.Errorf("could not read %s: %v", bazelVersionPath, err)
+		}
+		defer f.Close()
+
+		scanner := bufio.NewScanner(f)
+		scanner.Scan()
+		bazelVersion := scanner.Text()
+		if err := scanner.Err(); err != nil {
+			return "", "", fmt.Errorf("could not read version from file %s: %v", bazelVersion, err)
+		}
+
+		if len(bazelVersion) != 0 {
+			return bazelVersion, bazelVersionPath, nil
+		}
+	}
+
+	for _, name := range []string{"WORKSPACE.bazel", "WORKSPACE"} {
+		workspacePath := filepath.Join(bazelisk.workspaceRoot, name)
+		contents, err := ioutil.ReadFile(workspacePath)
+		if err != nil {
+			continue
+		}
+		if m := minimumBazelVersionRegex.FindSubmatch(contents); m != nil {
+			return "latest", fmt.Sprintf("no version is set, so the latest release, which is at least the minimum_bazel_version %s in %s", m[1], workspacePath), nil
 		}
+		break
 	}
 
-	return "latest", nil
+	return "latest", "no version is set, so the latest release", nil
 }
 
 func (bazelisk *Bazelisk) parseBazelForkAndVersion(bazelForkAndVersion string) (string, string, error) {
@@ -278,14 +454,22 @@ func (bazelisk *Bazelisk) parseBazelForkAndVersion(bazelForkAndVersion string) (
 	return bazelFork, bazelVersion, nil
 }
 
-func (bazelisk *Bazelisk) downloadBazel(fork string, version string, baseDirectory string, repos *core.Repositories, downloader core.DownloadFunc) (string, error) {
+// downloadPath returns the path that downloadBazel downloads the given
+// version to.
+func (bazelisk *Bazelisk) downloadPath(version string, baseDirectory string) (string, error) {
 	pathSegment, err := platforms.DetermineBazelFilename(version, false)
 	if err != nil {
 		return "", fmt.Errorf("could not determine path segment to use for Bazel binary: %v", err)
 	}
+	return filepath.Join(baseDirectory, pathSegment, "bin", "bazel"+platforms.DetermineExecutableFilenameSuffix()), nil
+}
 
-	destFile := "bazel" + platforms.DetermineExecutableFilenameSuffix()
-	destinationDir := filepath.Join(baseDirectory, pathSegment, "bin")
+func (bazelisk *Bazelisk) downloadBazel(fork string, version string, baseDirectory string, repos *core.Repositories, downloader core.DownloadFunc) (string, error) {
+	path, err := bazelisk.downloadPath(version, baseDirectory)
+	if err != nil {
+		return "", err
+	}
+	destinationDir, destFile := filepath.Dir(path), filepath.Base(path)
 
 	if url := bazelisk.GetEnvOrConfig(core.BaseURLEnv); url != "" {
 		return repos.DownloadFromBaseURL(url, version, destinationDir, destFile)
@@ -365,40 +549,66 @@ func (bazelisk *Bazelisk) prependDirToPathList(cmd *exec.Cmd, dir string) {
 	}
 }
 
-func (bazelisk *Bazelisk) makeBazelCmd(bazel string, args []string, out io.Writer) *exec.Cmd {
+// withDefaults sets the options that aren't set to their defaults.
+func withDefaults(options RunOptions) RunOptions {
+	if options.Context == nil {
+		options.Context = context.Background()
+	}
+	if options.Streams.Stdin == nil {
+		options.Streams.Stdin = os.Stdin
+	}
+	if options.Streams.Stdout == nil {
+		options.Streams.Stdout = os.Stdout
+	}
+	if options.Streams.Stderr == nil {
+		options.Streams.Stderr = os.Stderr
+	}
+	return options
+}
+
+func (bazelisk *Bazelisk) makeBazelCmd(bazel string, args []string, options RunOptions) *exec.Cmd {
 	execPath := bazelisk.maybeDelegateToWrapper(bazel)
 
-	cmd := exec.Command(execPath, args...)
+	cmdArgs := make([]string, 0, len(bazelisk.startupOptions)+len(args))
+	cmdArgs = append(cmdArgs, bazelisk.startupOptions...)
+	cmdArgs = append(cmdArgs, args...)
+	cmd := exec.Command(execPath, cmdArgs...)
 	cmd.Env = append(os.Environ(), skipWrapperEnv+"=true")
 	if execPath != bazel {
 		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", bazelReal, bazel))
 	}
 	bazelisk.prependDirToPathList(cmd, filepath.Dir(execPath))
-	cmd.Stdin = os.Stdin
-	if out == nil {
-		cmd.Stdout = os.Stdout
-	} else {
-		cmd.Stdout = out
-	}
-	cmd.Stderr = os.Stderr
+	// The variables of the invocation come last, so that they override the
+	// ones of the process.
+	cmd.Env = append(cmd.Env, options.Env...)
+	cmd.Dir = options.Dir
+	cmd.Stdin = options.Streams.Stdin
+	cmd.Stdout = options.Streams.Stdout
+	cmd.Stderr = options.Streams.Stderr
 	return cmd
 }
 
-func (bazelisk *Bazelisk) runBazel(bazel string, args []string, out io.Writer) (int, error) {
-	cmd := bazelisk.makeBazelCmd(bazel, args, out)
+func (bazelisk *Bazelisk) runBazel(bazel string, args []string, options RunOptions) (int, error) {
+	options = withDefaults(options)
+	cmd := bazelisk.makeBazelCmd(bazel, args, options)
 	err := cmd.Start()
 	if err != nil {
 		return 1, fmt.Errorf("could not start Bazel: %v", err)
 	}
 
-	c := make(chan os.Signal)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	// The context is cancelled on Ctrl-C, and Bazel stops gracefully on an
+	// interrupt.
+	done := make(chan struct{})
+	defer close(done)
 	go func() {
-		s := <-c
-		if runtime.GOOS != "windows" {
-			cmd.Process.Signal(s)
-		} else {
-			cmd.Process.Kill()
+		select {
+		case <-options.Context.Done():
+			if runtime.GOOS != "windows" {
+				cmd.Process.Signal(os.Interrupt)
+			} else {
+				cmd.Process.Kill()
+			}
+		case <-done:
 		}
 	}()
 
@@ -416,7 +626,7 @@ func (bazelisk *Bazelisk) runBazel(bazel string, args []string, out io.Writer) (
 // getIncompatibleFlags returns all incompatible flags for the current Bazel command in alphabetical order.
 func (bazelisk *Bazelisk) getIncompatibleFlags(bazelPath, cmd string) ([]string, error) {
 	out := strings.Builder{}
-	if _, err := bazelisk.runBazel(bazelPath, []string{"help", cmd, "--short"}, &out); err != nil {
+	if _, err := bazelisk.runBazel(bazelPath, []string{"help", cmd, "--short"}, RunOptions{Streams: ioutils.Streams{Stdout: &out}}); err != nil {
 		return nil, fmt.Errorf("unable to determine incompatible flags with binary %s: %v", bazelPath, err)
 	}
 
@@ -449,72 +659,86 @@ func (bazelisk *Bazelisk) insertArgs(baseArgs []string, newArgs []string) []stri
 	return result
 }
 
-func (bazelisk *Bazelisk) shutdownIfNeeded(bazelPath string) {
+func (bazelisk *Bazelisk) shutdownIfNeeded(bazelPath string, options RunOptions) (int, error) {
 	bazeliskClean := bazelisk.GetEnvOrConfig("BAZELISK_SHUTDOWN")
 	if len(bazeliskClean) == 0 {
-		return
+		return 0, nil
 	}
 
-	fmt.Printf("bazel shutdown\n")
-	exitCode, err := bazelisk.runBazel(bazelPath, []string{"shutdown"}, nil)
-	fmt.Printf("\n")
+	fmt.Fprintf(options.Streams.Stdout, "bazel shutdown\n")
+	exitCode, err := bazelisk.runBazel(bazelPath, []string{"shutdown"}, options)
+	fmt.Fprintf(options.Streams.Stdout, "\n")
 	if err != nil {
-		log.Fatalf("failed to run bazel shutdown: %v", err)
+		return -1, fmt.Errorf("failed to run bazel shutdown: %v", err)
 	}
 	if exitCode != 0 {
-		fmt.Printf("Failure: shutdown command failed.\n")
-		os.Exit(exitCode)
+		fmt.Fprintf(options.Streams.Stdout, "Failure: shutdown command failed.\n")
 	}
+	return exitCode, nil
 }
 
-func (bazelisk *Bazelisk) cleanIfNeeded(bazelPath string) {
+func (bazelisk *Bazelisk) cleanIfNeeded(bazelPath string, options RunOptions) (int, error) {
 	bazeliskClean := bazelisk.GetEnvOrConfig("BAZELISK_CLEAN")
 	if len(bazeliskClean) == 0 {
-		return
+		return 0, nil
 	}
 
-	fmt.Printf("bazel clean --expunge\n")
-	exitCode, err := bazelisk.runBazel(bazelPath, []string{"clean", "--expunge"}, nil)
-	fmt.Printf("\n")
+	fmt.Fprintf(options.Streams.Stdout, "bazel clean --expunge\n")
+	exitCode, err := bazelisk.runBazel(bazelPath, []string{"clean", "--expunge"}, options)
+	fmt.Fprintf(options.Streams.Stdout, "\n")
 	if err != nil {
-		log.Fatalf("failed to run clean: %v", err)
+		return -1, fmt.Errorf("failed to run clean: %v", err)
 	}
 	if exitCode != 0 {
-		fmt.Printf("Failure: clean command failed.\n")
-		os.Exit(exitCode)
+		fmt.Fprintf(options.Streams.Stdout, "Failure: clean command failed.\n")
+	}
+	return exitCode, nil
+}
+
+// shutdownAndCleanIfNeeded shuts Bazel down and cleans the outputs before
+// each run of migrate, when BAZELISK_SHUTDOWN and BAZELISK_CLEAN ask for it.
+// It returns the non-zero exit code of the command that failed.
+func (bazelisk *Bazelisk) shutdownAndCleanIfNeeded(bazelPath string, options RunOptions) (int, error) {
+	if exitCode, err := bazelisk.shutdownIfNeeded(bazelPath, options); exitCode != 0 || err != nil {
+		return exitCode, err
 	}
+	return bazelisk.cleanIfNeeded(bazelPath, options)
 }
 
 // migrate will run Bazel with each flag separately and report which ones are failing.
-func (bazelisk *Bazelisk) migrate(bazelPath string, baseArgs []string, flags []string) {
+// It returns the exit code of the migration, which is 0 when no migration is
+// needed.
+func (bazelisk *Bazelisk) migrate(bazelPath string, baseArgs []string, flags []string, options RunOptions) (int, error) {
 	// 1. Try with all the flags.
 	args := bazelisk.insertArgs(baseArgs, flags)
-	fmt.Printf("\n\n--- Running Bazel with all incompatible flags\n\n")
-	bazelisk.shutdownIfNeeded(bazelPath)
-	bazelisk.cleanIfNeeded(bazelPath)
-	fmt.Printf("bazel %s\n", strings.Join(args, " "))
-	exitCode, err := bazelisk.runBazel(bazelPath, args, nil)
+	fmt.Fprintf(options.Streams.Stdout, "\n\n--- Running Bazel with all incompatible flags\n\n")
+	if exitCode, err := bazelisk.shutdownAndCleanIfNeeded(bazelPath, options); exitCode != 0 || err != nil {
+		return exitCode, err
+	}
+	fmt.Fprintf(options.Streams.Stdout, "bazel %s\n", strings.Join(args, " "))
+	exitCode, err := bazelisk.runBazel(bazelPath, args, options)
 	if err != nil {
-		log.Fatalf("could not run Bazel: %v", err)
+		return -1, fmt.Errorf("could not run Bazel: %v", err)
 	}
 	if exitCode == 0 {
-		fmt.Printf("Success: No migration needed.\n")
-		os.Exit(0)
+		fmt.Fprintf(options.Streams.Stdout, "Success: No migration needed.\n")
+		return 0, nil
 	}
 
 	// 2. Try with no flags, as a sanity check.
 	args = baseArgs
-	fmt.Printf("\n\n--- Running Bazel with no incompatible flags\n\n")
-	bazelisk.shutdownIfNeeded(bazelPath)
-	bazelisk.cleanIfNeeded(bazelPath)
-	fmt.Printf("bazel %s\n", strings.Join(args, " "))
-	exitCode, err = bazelisk.runBazel(bazelPath, args, nil)
+	fmt.Fprintf(options.Streams.Stdout, "\n\n--- Running Bazel with no incompatible flags\n\n")
+	if exitCode, err := bazelisk.shutdownAndCleanIfNeeded(bazelPath, options); exitCode != 0 || err != nil {
+		return exitCode, err
+	}
+	fmt.Fprintf(options.Streams.Stdout, "bazel %s\n", strings.Join(args, " "))
+	exitCode, err = bazelisk.runBazel(bazelPath, args, options)
 	if err != nil {
-		log.Fatalf("could not run Bazel: %v", err)
+		return -1, fmt.Errorf("could not run Bazel: %v", err)
 	}
 	if exitCode != 0 {
-		fmt.Printf("Failure: Command failed, even without incompatible flags.\n")
-		os.Exit(exitCode)
+		fmt.Fprintf(options.Streams.Stdout, "Failure: Command failed, even without incompatible flags.\n")
+		return exitCode, nil
 	}
 
 	// 3. Try with each flag separately.
@@ -522,13 +746,14 @@ func (bazelisk *Bazelisk) migrate(bazelPath string, baseArgs []string, flags []s
 	var failList []string
 	for _, arg := range flags {
 		args = bazelisk.insertArgs(baseArgs, []string{arg})
-		fmt.Printf("\n\n--- Running Bazel with %s\n\n", arg)
-		bazelisk.shutdownIfNeeded(bazelPath)
-		bazelisk.cleanIfNeeded(bazelPath)
-		fmt.Printf("bazel %s\n", strings.Join(args, " "))
-		exitCode, err = bazelisk.runBazel(bazelPath, args, nil)
+		fmt.Fprintf(options.Streams.Stdout, "\n\n--- Running Bazel with %s\n\n", arg)
+		if exitCode, err := bazelisk.shutdownAndCleanIfNeeded(bazelPath, options); exitCode != 0 || err != nil {
+			return exitCode, err
+		}
+		fmt.Fprintf(options.Streams.Stdout, "bazel %s\n", strings.Join(args, " "))
+		exitCode, err = bazelisk.runBazel(bazelPath, args, options)
 		if err != nil {
-			log.Fatalf("could not run Bazel: %v", err)
+			return -1, fmt.Errorf("could not run Bazel: %v", err)
 		}
 		if exitCode == 0 {
 			passList = append(passList, arg)
@@ -539,19 +764,19 @@ func (bazelisk *Bazelisk) migrate(bazelPath string, baseArgs []string, flags []s
 
 	print := func(l []string) {
 		for _, arg := range l {
-			fmt.Printf("  %s\n", arg)
+			fmt.Fprintf(options.Streams.Stdout, "  %s\n", arg)
 		}
 	}
 
 	// 4. Print report
-	fmt.Printf("\n\n+++ Result\n\n")
-	fmt.Printf("Command was successful with the following flags:\n")
+	fmt.Fprintf(options.Streams.Stdout, "\n\n+++ Result\n\n")
+	fmt.Fprintf(options.Streams.Stdout, "Command was successful with the following flags:\n")
 	print(passList)
-	fmt.Printf("\n")
-	fmt.Printf("Migration is needed for the following flags:\n")
+	fmt.Fprintf(options.Streams.Stdout, "\n")
+	fmt.Fprintf(options.Streams.Stdout, "Migration is needed for the following flags:\n")
 	print(failList)
 
-	os.Exit(1)
+	return 1, nil
 }
 
 func dirForURL(url string) string {
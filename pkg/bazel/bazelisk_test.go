@@ -0,0 +1,240 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package bazel_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bazelbuild/bazelisk/httputil"
+	. "github.com/onsi/gomega"
+
+	"aspect.build/cli/pkg/bazel"
+	"aspect.build/cli/pkg/ioutils"
+)
+
+func TestBazelisk(t *testing.T) {
+	t.Run("each workspace has its own .bazeliskrc", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		t.Setenv("BAZELISK_HOME", "")
+
+		dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "bazelisk")
+		g.Expect(err).To(BeNil())
+		defer os.RemoveAll(dir)
+		workspaces := []string{filepath.Join(dir, "a"), filepath.Join(dir, "b")}
+		for _, workspace := range workspaces {
+			g.Expect(os.MkdirAll(workspace, 0755)).To(Succeed())
+			rc := "# comment\nBAZELISK_HOME=" + filepath.Join(workspace, "home") + "\n"
+			g.Expect(ioutil.WriteFile(filepath.Join(workspace, ".bazeliskrc"), []byte(rc), 0644)).To(Succeed())
+		}
+
+		homes := make([]string, len(workspaces))
+		errs := make([]error, len(workspaces))
+		var wg sync.WaitGroup
+		for i, workspace := range workspaces {
+			wg.Add(1)
+			go func(i int, workspace string) {
+				defer wg.Done()
+				bazelisk, err := bazel.NewBazelisk(workspace)
+				if err != nil {
+					errs[i] = err
+					return
+				}
+				homes[i], errs[i] = bazelisk.Home()
+			}(i, workspace)
+		}
+		wg.Wait()
+		g.Expect(errs).To(Equal([]error{nil, nil}))
+		g.Expect(homes).To(Equal([]string{
+			filepath.Join(workspaces[0], "home"),
+			filepath.Join(workspaces[1], "home"),
+		}))
+	})
+
+	t.Run("the user agent is set once for the process", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		workspace := fakeBazel(t, g, "#!/bin/sh\n")
+		workspaces := []string{workspace, workspace + "-b", workspace + "-c"}
+		for i, workspace := range workspaces {
+			g.Expect(os.MkdirAll(workspace, 0755)).To(Succeed())
+			rc := fmt.Sprintf("BAZELISK_USER_AGENT=agent-%d\n", i)
+			g.Expect(ioutil.WriteFile(filepath.Join(workspace, ".bazeliskrc"), []byte(rc), 0644)).To(Succeed())
+		}
+
+		errs := make([]error, 2)
+		var wg sync.WaitGroup
+		for i, workspace := range workspaces[:2] {
+			wg.Add(1)
+			go func(i int, workspace string) {
+				defer wg.Done()
+				bzl := bazel.New()
+				bzl.SetWorkspaceRoot(workspace)
+				_, errs[i] = bzl.ResolveVersion()
+			}(i, workspace)
+		}
+		wg.Wait()
+		g.Expect(errs).To(Equal([]error{nil, nil}))
+		userAgent := httputil.UserAgent
+
+		bzl := bazel.New()
+		bzl.SetWorkspaceRoot(workspaces[2])
+		_, err := bzl.ResolveVersion()
+		g.Expect(err).To(BeNil())
+		g.Expect(httputil.UserAgent).To(Equal(userAgent))
+	})
+
+	t.Run("the version of a workspace is resolved once per process", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		workspace := fakeBazel(t, g, "#!/bin/sh\n")
+		bazelPath := os.Getenv("USE_BAZEL_VERSION")
+		bzl := bazel.New()
+		bzl.SetWorkspaceRoot(workspace)
+		g.Expect(bzl.RunCommand([]string{"info"}, ioutil.Discard)).To(Equal(0))
+
+		t.Setenv("USE_BAZEL_VERSION", filepath.Join(workspace, "other-bazel"))
+		resolution, err := bzl.ResolveVersion()
+		g.Expect(err).To(BeNil())
+		g.Expect(resolution.Version).To(Equal(bazelPath))
+	})
+
+	t.Run("resolving the version doesn't download Bazel", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "bazelisk")
+		g.Expect(err).To(BeNil())
+		defer os.RemoveAll(dir)
+		t.Setenv("BAZELISK_HOME", filepath.Join(dir, "bazelisk"))
+		t.Setenv("USE_BAZEL_VERSION", "5.0.0")
+
+		bzl := bazel.New()
+		bzl.SetWorkspaceRoot(dir)
+		resolution, err := bzl.ResolveVersion()
+		g.Expect(err).To(BeNil())
+		g.Expect(resolution.ResolvedVersion).To(Equal("5.0.0"))
+		g.Expect(resolution.NotDownloaded).To(BeTrue())
+		g.Expect(resolution.BinaryPath).To(HavePrefix(filepath.Join(dir, "bazelisk", "downloads", "bazelbuild", "bazel-5.0.0-")))
+		g.Expect(resolution.BinaryPath).NotTo(BeAnExistingFile())
+	})
+
+	t.Run("an unreadable .bazeliskrc is an error", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "bazelisk")
+		g.Expect(err).To(BeNil())
+		defer os.RemoveAll(dir)
+		g.Expect(os.Mkdir(filepath.Join(dir, ".bazeliskrc"), 0755)).To(Succeed())
+
+		_, err = bazel.NewBazelisk(dir)
+		g.Expect(err).To(MatchError(ContainSubstring("could not read " + filepath.Join(dir, ".bazeliskrc"))))
+	})
+
+	t.Run("--migrate returns the flags that need a migration", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		workspace := fakeBazel(t, g, `#!/bin/sh
+if [ "$1" = help ]; then
+  echo "  --[no]incompatible_bar"
+  echo "  --[no]incompatible_foo"
+  exit 0
+fi
+for arg in "$@"; do
+  if [ "$arg" = --incompatible_foo ]; then
+    exit 1
+  fi
+done
+`)
+
+		bzl := bazel.New()
+		bzl.SetWorkspaceRoot(workspace)
+		var stdout strings.Builder
+		exitCode, err := bzl.Run([]string{"--migrate", "build", "//..."}, bazel.RunOptions{
+			Streams: ioutils.Streams{Stdout: &stdout},
+		})
+		g.Expect(err).To(BeNil())
+		g.Expect(exitCode).To(Equal(1))
+		g.Expect(stdout.String()).To(HaveSuffix(`Command was successful with the following flags:
+  --incompatible_bar
+
+Migration is needed for the following flags:
+  --incompatible_foo
+`))
+	})
+
+	t.Run("the Bazel version is resolved from the first source that sets it", func(t *testing.T) {
+		for _, name := range []string{"USE_BAZEL_VERSION", "USE_NIGHTLY_BAZEL", "USE_BAZEL_NIGHTLY", "USE_CANARY_BAZEL", "USE_BAZEL_CANARY"} {
+			t.Setenv(name, "")
+		}
+		// The sources, from the lowest priority to the highest one. Each one
+		// sets the path of a different local binary, so that resolving it
+		// doesn't download Bazel.
+		sources := []struct {
+			name   string
+			file   string
+			format string
+			source string
+		}{
+			{".bazelversion", ".bazelversion", "%s\n", "%s"},
+			{".bazeliskrc", ".bazeliskrc", "USE_BAZEL_VERSION=%s\n", "USE_BAZEL_VERSION in %s"},
+			{"tools/bazel", "tools/bazel", "#!/bin/sh\nexport USE_BAZEL_VERSION=%s\nexec \"$BAZEL_REAL\" \"$@\"\n", "USE_BAZEL_VERSION in %s"},
+		}
+		for i, source := range sources {
+			t.Run(source.name, func(t *testing.T) {
+				g := NewGomegaWithT(t)
+
+				dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "bazelisk")
+				g.Expect(err).To(BeNil())
+				defer os.RemoveAll(dir)
+				t.Setenv("BAZELISK_HOME", filepath.Join(dir, "bazelisk"))
+				workspace := filepath.Join(dir, "ws")
+				g.Expect(os.MkdirAll(filepath.Join(workspace, "tools"), 0755)).To(Succeed())
+				for j, lower := range sources[:i+1] {
+					binary := filepath.Join(dir, fmt.Sprintf("bazel-%d", j))
+					g.Expect(ioutil.WriteFile(binary, []byte("#!/bin/sh\n"), 0755)).To(Succeed())
+					content := fmt.Sprintf(lower.format, binary)
+					g.Expect(ioutil.WriteFile(filepath.Join(workspace, lower.file), []byte(content), 0755)).To(Succeed())
+				}
+
+				bzl := bazel.New()
+				bzl.SetWorkspaceRoot(workspace)
+				resolution, err := bzl.ResolveVersion()
+				g.Expect(err).To(BeNil())
+				g.Expect(resolution.Version).To(Equal(filepath.Join(dir, fmt.Sprintf("bazel-%d", i))))
+				g.Expect(resolution.Source).To(Equal(fmt.Sprintf(source.source, filepath.Join(workspace, source.file))))
+				g.Expect(resolution.ResolvedVersion).To(Equal("unknown"))
+				g.Expect(resolution.BinaryPath).To(HavePrefix(filepath.Join(dir, "bazelisk", "local")))
+				if source.file == "tools/bazel" {
+					g.Expect(resolution.Wrapper).To(Equal(filepath.Join(workspace, "tools", "bazel")))
+				} else {
+					g.Expect(resolution.Wrapper).To(BeEmpty())
+				}
+			})
+		}
+
+		t.Run("USE_BAZEL_VERSION comes before the other variables and files", func(t *testing.T) {
+			g := NewGomegaWithT(t)
+
+			workspace := fakeBazel(t, g, "#!/bin/sh\n")
+			t.Setenv("USE_BAZEL_NIGHTLY", "1")
+			g.Expect(ioutil.WriteFile(filepath.Join(workspace, ".bazelversion"), []byte("5.0.0\n"), 0644)).To(Succeed())
+
+			bzl := bazel.New()
+			bzl.SetWorkspaceRoot(workspace)
+			resolution, err := bzl.ResolveVersion()
+			g.Expect(err).To(BeNil())
+			g.Expect(resolution.Version).To(Equal(os.Getenv("USE_BAZEL_VERSION")))
+			g.Expect(resolution.Source).To(Equal("the USE_BAZEL_VERSION environment variable"))
+			g.Expect(resolution.Wrapper).To(BeEmpty())
+		})
+	})
+}
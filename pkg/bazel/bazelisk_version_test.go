@@ -0,0 +1,87 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package bazel
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+// The version is looked up without resolving it, since resolving last_green
+// or last_rc downloads Bazel.
+func TestBazelVersionFromEnvironment(t *testing.T) {
+	names := []string{"USE_BAZEL_VERSION", "USE_NIGHTLY_BAZEL", "USE_BAZEL_NIGHTLY", "USE_CANARY_BAZEL", "USE_BAZEL_CANARY"}
+	workspace := func(t *testing.T, g *WithT) string {
+		dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "bazelisk")
+		g.Expect(err).To(BeNil())
+		t.Cleanup(func() { os.RemoveAll(dir) })
+		g.Expect(ioutil.WriteFile(filepath.Join(dir, ".bazelversion"), []byte("5.0.0\n"), 0644)).To(Succeed())
+		return dir
+	}
+
+	for _, test := range []struct {
+		name    string
+		version string
+	}{
+		{"USE_NIGHTLY_BAZEL", "last_green"},
+		{"USE_BAZEL_NIGHTLY", "last_green"},
+		{"USE_CANARY_BAZEL", "last_rc"},
+		{"USE_BAZEL_CANARY", "last_rc"},
+	} {
+		t.Run(test.name+" asks for "+test.version, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			for _, name := range names {
+				t.Setenv(name, "")
+			}
+			t.Setenv(test.name, "1")
+
+			bazelisk, err := NewBazelisk(workspace(t, g))
+			g.Expect(err).To(BeNil())
+			version, source, err := bazelisk.getBazelVersion()
+			g.Expect(err).To(BeNil())
+			g.Expect(version).To(Equal(test.version))
+			g.Expect(source).To(Equal("the " + test.name + " environment variable"))
+		})
+	}
+
+	t.Run("nightly comes before canary", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		for _, name := range names {
+			t.Setenv(name, "")
+		}
+		t.Setenv("USE_BAZEL_CANARY", "1")
+		t.Setenv("USE_BAZEL_NIGHTLY", "1")
+
+		bazelisk, err := NewBazelisk(workspace(t, g))
+		g.Expect(err).To(BeNil())
+		version, source, err := bazelisk.getBazelVersion()
+		g.Expect(err).To(BeNil())
+		g.Expect(version).To(Equal("last_green"))
+		g.Expect(source).To(Equal("the USE_BAZEL_NIGHTLY environment variable"))
+	})
+
+	t.Run("the minimum_bazel_version of the WORKSPACE is a lower bound", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		for _, name := range names {
+			t.Setenv(name, "")
+		}
+		dir := workspace(t, g)
+		g.Expect(os.Remove(filepath.Join(dir, ".bazelversion"))).To(Succeed())
+		g.Expect(ioutil.WriteFile(filepath.Join(dir, "WORKSPACE"), []byte("versions.check(minimum_bazel_version = \"4.2.1\")\n"), 0644)).To(Succeed())
+
+		bazelisk, err := NewBazelisk(dir)
+		g.Expect(err).To(BeNil())
+		version, source, err := bazelisk.getBazelVersion()
+		g.Expect(err).To(BeNil())
+		g.Expect(version).To(Equal("latest"))
+		g.Expect(source).To(Equal("no version is set, so the latest release, which is at least the minimum_bazel_version 4.2.1 in " + filepath.Join(dir, "WORKSPACE")))
+	})
+}
@@ -0,0 +1,229 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package bazel
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/mitchellh/go-homedir"
+	"google.golang.org/protobuf/proto"
+
+	"aspect.build/cli/pkg/ioutils"
+)
+
+// StartupCommand is the pseudo-command used by `bazel help flags-as-proto` to
+// mark the flags that are startup options.
+const StartupCommand = "startup"
+
+// Commands are the commands of Bazel.
+var Commands = map[string]bool{
+	"analyze-profile":    true,
+	"aquery":             true,
+	"build":              true,
+	"canonicalize-flags": true,
+	"clean":              true,
+	"config":             true,
+	"coverage":           true,
+	"cquery":             true,
+	"dump":               true,
+	"fetch":              true,
+	"help":               true,
+	"info":               true,
+	"license":            true,
+	"mobile-install":     true,
+	"mod":                true,
+	"print_action":       true,
+	"query":              true,
+	"run":                true,
+	"shutdown":           true,
+	"sync":               true,
+	"test":               true,
+	"version":            true,
+}
+
+// Only versions that always resolve to the same Bazel binary can have their
+// flags cached by version. The flags of the binaries of the other versions,
+// e.g. "latest", "last_green" or a local binary, are cached by binary.
+var cacheableVersionRegex = regexp.MustCompile(`^(\d+\.\d+\.\d+(rc\d+)?(-.+)?|[a-f0-9]{40})$`)
+
+// Flags returns the flags supported by the Bazel version used in the
+// workspace, keyed by flag name. The flags are cached per Bazel version, or
+// per Bazel binary for the versions without a version number, so that only the
+// first call for a given version has to ask Bazel for them.
+func (b *bazel) Flags() (map[string]*FlagInfo, error) {
+	cachePath, err := b.flagsCachePath(true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Bazel flags: %w", err)
+	}
+
+	if flags := readCachedFlags(cachePath); flags != nil {
+		return flags, nil
+	}
+
+	helpProtoBytes, err := b.helpFlagsAsProto()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Bazel flags: %w", err)
+	}
+
+	flags, err := decodeFlags(helpProtoBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Bazel flags: %w", err)
+	}
+
+	if cachePath != "" {
+		// The cache is only an optimization, so failing to write it is not an
+		// error.
+		_ = ioutils.WriteFileAtomic(cachePath, helpProtoBytes)
+	}
+
+	return flags, nil
+}
+
+// CachedFlags is like Flags, but it never invokes Bazel. It returns nil if the
+// flags for the Bazel version used in the workspace were not cached yet, or if
+// the version has to be looked up first, e.g. latest.
+func (b *bazel) CachedFlags() (map[string]*FlagInfo, error) {
+	cachePath, err := b.flagsCachePath(false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cached Bazel flags: %w", err)
+	}
+	return readCachedFlags(cachePath), nil
+}
+
+// flagsCachePath returns the path of the cached flags of the Bazel used in the
+// workspace. The version is resolved, which may download Bazel, when it has no
+// version number and isn't a local binary, unless resolve is false.
+func (b *bazel) flagsCachePath(resolve bool) (string, error) {
+	bazelisk, err := NewBazelisk(b.workspaceRoot)
+	if err != nil {
+		return "", err
+	}
+	version, _, err := bazelisk.getBazelVersion()
+	if err != nil {
+		return "", err
+	}
+	if cachePath := flagsCachePath(version); cachePath != "" {
+		return cachePath, nil
+	}
+	bazelPath, err := homedir.Expand(version)
+	if err != nil || !filepath.IsAbs(bazelPath) {
+		if !resolve {
+			return "", nil
+		}
+		resolution, err := bazelisk.resolve(bazelisk.createRepositories())
+		if err != nil {
+			return "", err
+		}
+		bazelPath = resolution.BinaryPath
+	}
+	return binaryFlagsCachePath(bazelPath), nil
+}
+
+func readCachedFlags(cachePath string) map[string]*FlagInfo {
+	if cachePath == "" {
+		return nil
+	}
+	helpProtoBytes, err := ioutil.ReadFile(cachePath)
+	if err != nil {
+		return nil
+	}
+	flags, err := decodeFlags(helpProtoBytes)
+	if err != nil {
+		return nil
+	}
+	return flags
+}
+
+func (b *bazel) helpFlagsAsProto() ([]byte, error) {
+	r, w := io.Pipe()
+	decoder := base64.NewDecoder(base64.StdEncoding, r)
+	bazelErrs := make(chan error, 1)
+	defer close(bazelErrs)
+	go func() {
+		defer w.Close()
+		_, err := b.RunCommand([]string{"help", "flags-as-proto"}, w)
+		bazelErrs <- err
+	}()
+
+	helpProtoBytes, err := ioutil.ReadAll(decoder)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := <-bazelErrs; err != nil {
+		return nil, err
+	}
+
+	return helpProtoBytes, nil
+}
+
+func decodeFlags(helpProtoBytes []byte) (map[string]*FlagInfo, error) {
+	flagCollection := &FlagCollection{}
+	if err := proto.Unmarshal(helpProtoBytes, flagCollection); err != nil {
+		return nil, err
+	}
+
+	flags := make(map[string]*FlagInfo)
+	for i := range flagCollection.FlagInfos {
+		flags[*flagCollection.FlagInfos[i].Name] = flagCollection.FlagInfos[i]
+	}
+
+	return flags, nil
+}
+
+// flagsCachePath returns the path of the cached flags for the given Bazel
+// version, or an empty string if the version can't be cached.
+func flagsCachePath(version string) string {
+	bazelVersion := version
+	if i := strings.LastIndex(version, "/"); i >= 0 {
+		bazelVersion = version[i+1:]
+	}
+	if !cacheableVersionRegex.MatchString(bazelVersion) {
+		return ""
+	}
+	cacheDir, err := cacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(cacheDir, "flags", dirForURL(version)+".pb")
+}
+
+// binaryFlagsCachePath returns the path of the cached flags for the given Bazel
+// binary, keyed by its path, size and modification time, so that replacing the
+// binary invalidates them. It returns an empty string if the binary can't be
+// read.
+func binaryFlagsCachePath(bazelPath string) string {
+	info, err := os.Stat(bazelPath)
+	if err != nil {
+		return ""
+	}
+	cacheDir, err := cacheDir()
+	if err != nil {
+		return ""
+	}
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%d\x00%d", bazelPath, info.Size(), info.ModTime().UnixNano())))
+	return filepath.Join(cacheDir, "flags", "binaries", hex.EncodeToString(hash[:])+".pb")
+}
+
+// cacheDir returns the directory used by the aspect CLI to cache data across
+// invocations.
+func cacheDir() (string, error) {
+	userCacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("could not get the user's cache directory: %w", err)
+	}
+	return filepath.Join(userCacheDir, "aspect"), nil
+}
@@ -0,0 +1,104 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package bazel
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"aspect.build/cli/pkg/aspecterrors"
+)
+
+// Info is the output of bazel info, which describes the Bazel server of a
+// workspace and where it keeps its outputs.
+type Info struct {
+	BazelBin      string
+	BazelGenfiles string
+	BazelTestlogs string
+	CommandLog    string
+	ExecutionRoot string
+	InstallBase   string
+	OutputBase    string
+	OutputPath    string
+	Release       string
+	ServerLog     string
+	ServerPID     int
+	Workspace     string
+
+	// Keys are the keys of the output, in the order Bazel prints them.
+	Keys []string
+	// Values are the values of all the keys, including the ones without a
+	// field.
+	Values map[string]string
+}
+
+// ParseInfo parses the "key: value" lines output by bazel info.
+func ParseInfo(output []byte) *Info {
+	info := &Info{Values: make(map[string]string)}
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ": ", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key, value := parts[0], strings.TrimSpace(parts[1])
+		if _, ok := info.Values[key]; !ok {
+			info.Keys = append(info.Keys, key)
+		}
+		info.Values[key] = value
+	}
+
+	info.BazelBin = info.Values["bazel-bin"]
+	info.BazelGenfiles = info.Values["bazel-genfiles"]
+	info.BazelTestlogs = info.Values["bazel-testlogs"]
+	info.CommandLog = info.Values["command_log"]
+	info.ExecutionRoot = info.Values["execution_root"]
+	info.InstallBase = info.Values["install_base"]
+	info.OutputBase = info.Values["output_base"]
+	info.OutputPath = info.Values["output_path"]
+	info.Release = info.Values["release"]
+	info.ServerLog = info.Values["server_log"]
+	info.ServerPID, _ = strconv.Atoi(info.Values["server_pid"])
+	info.Workspace = info.Values["workspace"]
+	return info
+}
+
+// String formats the info like bazel info does.
+func (i *Info) String() string {
+	var s strings.Builder
+	for _, key := range i.Keys {
+		fmt.Fprintf(&s, "%s: %s\n", key, i.Values[key])
+	}
+	return s.String()
+}
+
+// Info runs bazel info in the workspace. The info is cached per workspace and
+// startup options, so that only the first call runs Bazel.
+func (b *bazel) Info() (*Info, error) {
+	key := b.workspaceRoot + "\x00" + strings.Join(b.startupOptions, "\x00")
+	if info, ok := b.infos[key]; ok {
+		return info, nil
+	}
+
+	var out bytes.Buffer
+	if exitCode, err := b.RunCommand([]string{"info"}, &out); exitCode != 0 {
+		err = &aspecterrors.ExitError{
+			Err:      err,
+			ExitCode: exitCode,
+		}
+		return nil, fmt.Errorf("failed to run bazel info: %w", err)
+	}
+	info := ParseInfo(out.Bytes())
+	if b.infos == nil {
+		b.infos = make(map[string]*Info)
+	}
+	b.infos[key] = info
+	return info, nil
+}
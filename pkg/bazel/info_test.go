@@ -0,0 +1,43 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package bazel_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"aspect.build/cli/pkg/bazel"
+)
+
+func TestParseInfo(t *testing.T) {
+	output := `bazel-bin: /ob/execroot/ws/bazel-out/k8-fastbuild/bin
+execution_root: /ob/execroot/ws
+java-runtime: Runtime info: OpenJDK 11
+output_base: /ob
+release: release 5.0.0
+server_pid: 4242
+`
+
+	t.Run("the keys are parsed into the fields", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		info := bazel.ParseInfo([]byte(output))
+		g.Expect(info.BazelBin).To(Equal("/ob/execroot/ws/bazel-out/k8-fastbuild/bin"))
+		g.Expect(info.ExecutionRoot).To(Equal("/ob/execroot/ws"))
+		g.Expect(info.OutputBase).To(Equal("/ob"))
+		g.Expect(info.Release).To(Equal("release 5.0.0"))
+		g.Expect(info.ServerPID).To(Equal(4242))
+		g.Expect(info.Values["java-runtime"]).To(Equal("Runtime info: OpenJDK 11"))
+	})
+
+	t.Run("the info is formatted like bazel info", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		g.Expect(bazel.ParseInfo([]byte(output)).String()).To(Equal(output))
+	})
+}
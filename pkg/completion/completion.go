@@ -0,0 +1,197 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package completion
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"aspect.build/cli/pkg/bazel"
+	"aspect.build/cli/pkg/pathutils"
+)
+
+// knownFlagValues are the values of the common Bazel flags that take one of a
+// fixed set of values. The flags reported by Bazel don't include them.
+var knownFlagValues = map[string][]string{
+	"color":                   {"yes", "no", "auto"},
+	"compilation_mode":        {"fastbuild", "dbg", "opt"},
+	"curses":                  {"yes", "no", "auto"},
+	"output":                  {"label", "label_kind", "build", "minrank", "maxrank", "package", "location", "graph", "xml", "proto", "jsonproto", "textproto"},
+	"remote_download_outputs": {"all", "minimal", "toplevel"},
+	"test_output":             {"summary", "errors", "all", "streamed"},
+	"test_size_filters":       {"small", "medium", "large", "enormous"},
+	"test_summary":            {"short", "terse", "detailed", "none", "testcase"},
+	"test_timeout_filters":    {"short", "moderate", "long", "eternal"},
+}
+
+// ValidArgsFunction returns a cobra ValidArgsFunction that completes the flags
+// of the given Bazel command and the target labels of a command that wraps it. Flags are only
+// completed when they were cached by a previous invocation, so completion
+// never starts Bazel.
+func ValidArgsFunction(bzl bazel.Bazel, command string) func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		wd, err := os.Getwd()
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveError
+		}
+		workspacePath, err := pathutils.DefaultWorkspaceFinder.Find(wd)
+		if err != nil || workspacePath == "" {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		workspaceRoot := path.Dir(workspacePath)
+
+		for _, arg := range args {
+			if arg == "--" {
+				// Everything after the separator is opaque to Bazel.
+				return nil, cobra.ShellCompDirectiveDefault
+			}
+		}
+
+		completingFlag := strings.HasPrefix(toComplete, "-")
+		completingSeparateValue := len(args) > 0 && strings.HasPrefix(args[len(args)-1], "-") && !strings.Contains(args[len(args)-1], "=")
+		if completingFlag || completingSeparateValue {
+			bzl.SetWorkspaceRoot(workspaceRoot)
+			flags, err := bzl.CachedFlags()
+			if err != nil || flags == nil {
+				return nil, cobra.ShellCompDirectiveNoFileComp
+			}
+			if completingFlag {
+				return Flags(flags, workspaceRoot, command, toComplete)
+			}
+			previous := strings.TrimLeft(args[len(args)-1], "-")
+			if info, ok := flags[previous]; ok && !info.GetHasNegativeFlag() {
+				return FlagValues(info, workspaceRoot, "", toComplete), cobra.ShellCompDirectiveNoFileComp
+			}
+		}
+
+		return Labels(workspaceRoot, wd, toComplete), cobra.ShellCompDirectiveNoSpace | cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+// Flags returns the completions for a partial flag of the given command. A flag
+// followed by `=` completes to its values.
+func Flags(flags map[string]*bazel.FlagInfo, workspaceRoot, command, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if i := strings.Index(toComplete, "="); i >= 0 {
+		info, ok := flags[strings.TrimLeft(toComplete[:i], "-")]
+		if !ok {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		return FlagValues(info, workspaceRoot, toComplete[:i+1], toComplete[i+1:]), cobra.ShellCompDirectiveNoFileComp
+	}
+
+	name := strings.TrimLeft(toComplete, "-")
+	var completions []string
+	for flagName, info := range flags {
+		if !supportsCommand(info, command) {
+			continue
+		}
+		description := info.GetDocumentation()
+		if i := strings.IndexAny(description, ".\n"); i >= 0 {
+			description = description[:i]
+		}
+		if strings.HasPrefix(flagName, name) {
+			completions = append(completions, "--"+flagName+"\t"+description)
+		}
+		if info.GetHasNegativeFlag() && strings.HasPrefix("no"+flagName, name) && strings.HasPrefix(name, "no") {
+			completions = append(completions, "--no"+flagName+"\t"+description)
+		}
+	}
+	sort.Strings(completions)
+	return completions, cobra.ShellCompDirectiveNoFileComp
+}
+
+// FlagValues returns the completions for the value of the given flag, each
+// prefixed with prefix.
+func FlagValues(info *bazel.FlagInfo, workspaceRoot, prefix, toComplete string) []string {
+	var values []string
+	switch {
+	case info.GetName() == "config":
+		values = Configs(workspaceRoot)
+	case info.GetHasNegativeFlag():
+		values = []string{"true", "false"}
+	default:
+		values = knownFlagValues[info.GetName()]
+	}
+
+	var completions []string
+	for _, value := range values {
+		if strings.HasPrefix(value, toComplete) {
+			completions = append(completions, prefix+value)
+		}
+	}
+	return completions
+}
+
+// Configs returns the names of the configs defined in the .bazelrc files of the
+// workspace and the user, e.g. `ci` for a `build:ci` line.
+func Configs(workspaceRoot string) []string {
+	rcFiles := []string{filepath.Join(workspaceRoot, ".bazelrc")}
+	if home, err := os.UserHomeDir(); err == nil {
+		rcFiles = append(rcFiles, filepath.Join(home, ".bazelrc"))
+	}
+
+	seen := make(map[string]struct{})
+	scanned := make(map[string]bool)
+	for len(rcFiles) > 0 {
+		rcFile := rcFiles[0]
+		rcFiles = rcFiles[1:]
+		if scanned[rcFile] {
+			continue
+		}
+		scanned[rcFile] = true
+		rcFiles = append(rcFiles, scanBazelrc(rcFile, workspaceRoot, seen)...)
+	}
+
+	configs := make([]string, 0, len(seen))
+	for config := range seen {
+		configs = append(configs, config)
+	}
+	sort.Strings(configs)
+	return configs
+}
+
+// scanBazelrc adds the configs defined in the given rc file to configs and
+// returns the files it imports.
+func scanBazelrc(rcFile, workspaceRoot string, configs map[string]struct{}) []string {
+	f, err := os.Open(rcFile)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var imports []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if (fields[0] == "import" || fields[0] == "try-import") && len(fields) > 1 {
+			imports = append(imports, strings.ReplaceAll(fields[1], "%workspace%", workspaceRoot))
+			continue
+		}
+		if i := strings.Index(fields[0], ":"); i >= 0 && i < len(fields[0])-1 {
+			configs[fields[0][i+1:]] = struct{}{}
+		}
+	}
+	return imports
+}
+
+func supportsCommand(info *bazel.FlagInfo, command string) bool {
+	for _, c := range info.GetCommands() {
+		if c == command || c == bazel.StartupCommand {
+			return true
+		}
+	}
+	return false
+}
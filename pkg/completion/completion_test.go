@@ -0,0 +1,79 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package completion_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/proto"
+
+	"aspect.build/cli/pkg/bazel"
+	"aspect.build/cli/pkg/completion"
+)
+
+func TestFlags(t *testing.T) {
+	flags := map[string]*bazel.FlagInfo{
+		"compilation_mode": {
+			Name:          proto.String("compilation_mode"),
+			Documentation: proto.String("Specify the mode the binary will be built in. Values: 'fastbuild', 'dbg', 'opt'."),
+			Commands:      []string{"build"},
+		},
+		"config": {
+			Name:     proto.String("config"),
+			Commands: []string{"build"},
+		},
+		"keep_going": {
+			Name:            proto.String("keep_going"),
+			HasNegativeFlag: proto.Bool(true),
+			Commands:        []string{"build"},
+		},
+		"test_output": {
+			Name:     proto.String("test_output"),
+			Commands: []string{"test"},
+		},
+	}
+
+	t.Run("completes the flag names of the command", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		completions, directive := completion.Flags(flags, "", "build", "--co")
+		g.Expect(completions).To(Equal([]string{
+			"--compilation_mode\tSpecify the mode the binary will be built in",
+			"--config\t",
+		}))
+		g.Expect(directive).To(Equal(cobra.ShellCompDirectiveNoFileComp))
+
+		completions, _ = completion.Flags(flags, "", "build", "--nok")
+		g.Expect(completions).To(Equal([]string{"--nokeep_going\t"}))
+	})
+
+	t.Run("completes the flag values", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		completions, _ := completion.Flags(flags, "", "build", "--compilation_mode=")
+		g.Expect(completions).To(Equal([]string{
+			"--compilation_mode=fastbuild",
+			"--compilation_mode=dbg",
+			"--compilation_mode=opt",
+		}))
+	})
+
+	t.Run("completes configs from the .bazelrc files", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		workspaceRoot := t.TempDir()
+		writeFile(t, filepath.Join(workspaceRoot, ".bazelrc"), `
+# build:commented --foo
+build:ci --keep_going
+try-import %workspace%/user.bazelrc
+`)
+		writeFile(t, filepath.Join(workspaceRoot, "user.bazelrc"), "test:debug --test_output=streamed\n")
+		completions, _ := completion.Flags(flags, workspaceRoot, "build", "--config=")
+		g.Expect(completions).To(ContainElements("--config=ci", "--config=debug"))
+		g.Expect(completions).NotTo(ContainElement("--config=commented"))
+	})
+}
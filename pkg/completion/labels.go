@@ -0,0 +1,136 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package completion
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bazelbuild/buildtools/build"
+)
+
+// https://github.com/bazelbuild/bazel/blob/8346ea4c/src/main/java/com/google/devtools/build/lib/packages/BuildFileName.java
+var buildFilenames = []string{"BUILD.bazel", "BUILD"}
+
+// Labels returns the labels that complete the given partial label by scanning
+// the BUILD files in the workspace, without invoking Bazel. Labels starting
+// with `//` are resolved against the workspace root, and the others against
+// the package of the working directory wd.
+//
+// Partial package paths complete to `//pkg:` for packages and `//pkg/` for
+// directories, so the caller should not add a space after a completion.
+func Labels(workspaceRoot, wd, toComplete string) []string {
+	// The package of the working directory, used to resolve relative labels.
+	base := ""
+	if rel, err := filepath.Rel(workspaceRoot, wd); err == nil && rel != "." && !strings.HasPrefix(rel, "..") {
+		base = filepath.ToSlash(rel)
+	}
+	absolute := strings.HasPrefix(toComplete, "//")
+	label := strings.TrimPrefix(toComplete, "//")
+	if !absolute && base != "" {
+		if strings.HasPrefix(label, ":") {
+			label = base + label
+		} else {
+			label = base + "/" + label
+		}
+	}
+	// format turns a workspace-relative label back into the form it was typed.
+	format := func(l string) string {
+		if absolute {
+			return "//" + l
+		}
+		return strings.TrimPrefix(strings.TrimPrefix(l, base), "/")
+	}
+
+	var completions []string
+	if i := strings.Index(label, ":"); i >= 0 {
+		pkg, name := label[:i], label[i+1:]
+		for _, target := range Targets(workspaceRoot, pkg) {
+			if strings.HasPrefix(target, name) {
+				completions = append(completions, format(pkg+":"+target))
+			}
+		}
+		return completions
+	}
+
+	dir, name := "", label
+	if i := strings.LastIndex(label, "/"); i >= 0 {
+		dir, name = label[:i], label[i+1:]
+	}
+	if dir == "" && name == "" && IsPackage(workspaceRoot, "") {
+		completions = append(completions, format(":"))
+	}
+	entries, err := ioutil.ReadDir(filepath.Join(workspaceRoot, dir))
+	if err != nil {
+		return completions
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), name) || isIgnoredDir(entry.Name()) {
+			continue
+		}
+		pkg := entry.Name()
+		if dir != "" {
+			pkg = dir + "/" + pkg
+		}
+		if IsPackage(workspaceRoot, pkg) {
+			completions = append(completions, format(pkg+":"))
+		}
+		completions = append(completions, format(pkg+"/"))
+	}
+	return completions
+}
+
+// IsPackage returns whether the given workspace-relative directory contains a
+// BUILD file.
+func IsPackage(workspaceRoot, pkg string) bool {
+	return buildFilePath(workspaceRoot, pkg) != ""
+}
+
+// Targets returns the sorted names of the rules declared in the BUILD file of
+// the given package, plus the `all` wildcard. Rules created by macros that
+// don't take a name attribute can't be found without Bazel.
+func Targets(workspaceRoot, pkg string) []string {
+	path := buildFilePath(workspaceRoot, pkg)
+	if path == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	f, err := build.ParseBuild(path, data)
+	if err != nil {
+		return nil
+	}
+	targets := []string{"all"}
+	for _, rule := range f.Rules("") {
+		if name := rule.Name(); name != "" {
+			targets = append(targets, name)
+		}
+	}
+	sort.Strings(targets)
+	return targets
+}
+
+func buildFilePath(workspaceRoot, pkg string) string {
+	for _, filename := range buildFilenames {
+		path := filepath.Join(workspaceRoot, pkg, filename)
+		if stat, err := os.Stat(path); err == nil && !stat.IsDir() {
+			return path
+		}
+	}
+	return ""
+}
+
+// isIgnoredDir returns whether a directory can't contain packages, such as the
+// convenience symlinks created by Bazel.
+func isIgnoredDir(name string) bool {
+	return strings.HasPrefix(name, ".") || strings.HasPrefix(name, "bazel-")
+}
@@ -0,0 +1,68 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package completion_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"aspect.build/cli/pkg/completion"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLabels(t *testing.T) {
+	workspaceRoot := t.TempDir()
+	writeFile(t, filepath.Join(workspaceRoot, "WORKSPACE"), "")
+	writeFile(t, filepath.Join(workspaceRoot, "BUILD.bazel"), `exports_files(["README.md"])`)
+	writeFile(t, filepath.Join(workspaceRoot, "foo", "BUILD"), `
+go_library(name = "lib")
+go_test(name = "lib_test")
+`)
+	writeFile(t, filepath.Join(workspaceRoot, "foo", "bar", "BUILD.bazel"), `sh_binary(name = "bin")`)
+	writeFile(t, filepath.Join(workspaceRoot, "docs", "index.md"), "")
+	if err := os.MkdirAll(filepath.Join(workspaceRoot, "bazel-out"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("completes packages and directories from the workspace root", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		g.Expect(completion.Labels(workspaceRoot, workspaceRoot, "//")).To(Equal([]string{
+			"//:", "//docs/", "//foo:", "//foo/",
+		}))
+		g.Expect(completion.Labels(workspaceRoot, workspaceRoot, "//foo/")).To(Equal([]string{
+			"//foo/bar:", "//foo/bar/",
+		}))
+	})
+
+	t.Run("completes the targets of a package", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		g.Expect(completion.Labels(workspaceRoot, workspaceRoot, "//foo:")).To(Equal([]string{
+			"//foo:all", "//foo:lib", "//foo:lib_test",
+		}))
+		g.Expect(completion.Labels(workspaceRoot, workspaceRoot, "//foo:lib_")).To(Equal([]string{
+			"//foo:lib_test",
+		}))
+	})
+
+	t.Run("completes labels relative to the working directory", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		wd := filepath.Join(workspaceRoot, "foo")
+		g.Expect(completion.Labels(workspaceRoot, wd, ":l")).To(Equal([]string{":lib", ":lib_test"}))
+		g.Expect(completion.Labels(workspaceRoot, wd, "b")).To(Equal([]string{"bar:", "bar/"}))
+	})
+}
@@ -0,0 +1,50 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package gitutils
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Run runs git with the given arguments in dir, and returns its output.
+func Run(dir string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("failed to run git %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// TopLevel returns the root of the git repository that contains dir.
+func TopLevel(dir string) (string, error) {
+	out, err := Run(dir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(strings.TrimSpace(out))
+}
+
+// AddWorktree checks out the given revision of the repository that contains
+// repoDir in a new worktree at dir, which is detached from any branch.
+func AddWorktree(repoDir string, rev string, dir string) error {
+	_, err := Run(repoDir, "worktree", "add", "--detach", dir, rev)
+	return err
+}
+
+// RemoveWorktree removes the worktree at dir, and any change made in it.
+func RemoveWorktree(repoDir string, dir string) error {
+	_, err := Run(repoDir, "worktree", "remove", "--force", dir)
+	return err
+}
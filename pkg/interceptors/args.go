@@ -0,0 +1,99 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package interceptors
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"aspect.build/cli/pkg/aspect/root/flags"
+	"aspect.build/cli/pkg/bazel"
+)
+
+// ParsedArgsKeyType is a type for the ParsedArgsKey that avoids collisions.
+type ParsedArgsKeyType bool
+
+// ParsedArgsKey is the key for the injected *bazel.ParsedArgs into the context.
+// The interceptors that run after the ParseArgsInterceptor, like the plugin
+// hooks ones, and the command itself can read it. The plugins themselves don't
+// receive the parsed arguments: the hooks of the v1alpha2 plugin protocol have
+// no field for them, so it takes a new version of the protocol.
+const ParsedArgsKey ParsedArgsKeyType = true
+
+// ParseArgsInterceptor validates the command arguments against the flags that
+// the given Bazel command has in the Bazel version used in the workspace and
+// injects the parsed arguments into the context. The Bazel command is the name
+// of the aspect command, unless the aspect command runs another one, e.g. build
+// for explain-cache. The next functions in the chain receive the command flags and
+// targets as args. It must run after the WorkspaceRootInterceptor and the
+// StartupOptionsInterceptor, and the command must set DisableFlagParsing so
+// that cobra hands the Bazel flags over.
+//
+// The flags defined by the command itself are set on the command and removed
+// from the arguments before they are validated, so a command can have its own
+// flags besides the Bazel ones.
+func ParseArgsInterceptor(bzl bazel.Bazel, command string) Interceptor {
+	return func(ctx context.Context, cmd *cobra.Command, args []string, next RunEContextFn) error {
+		for _, arg := range args {
+			if arg == "--" {
+				break
+			}
+			if arg == "--help" || arg == "-h" {
+				return cmd.Help()
+			}
+		}
+
+		workspaceRoot := ctx.Value(WorkspaceRootKey).(string)
+		bzl.SetWorkspaceRoot(workspaceRoot)
+		bazelFlags, err := bzl.Flags()
+		if err != nil {
+			// Bazel versions without `help flags-as-proto` still get the
+			// arguments, just without validation.
+			bazelFlags = nil
+		}
+
+		args, err = parseLocalFlags(cmd, args, bazelFlags)
+		if err != nil {
+			return fmt.Errorf("failed to run command %q: %w", cmd.Use, err)
+		}
+
+		parsed, err := bazel.ParseArgs(bazelFlags, command, args)
+		if err != nil {
+			return fmt.Errorf("failed to run command %q: %w", cmd.Use, err)
+		}
+		if len(parsed.StartupOptions) > 0 {
+			// Like Bazel, startup options are only accepted before the command,
+			// e.g. `aspect --output_base=/tmp/foo build`.
+			err = fmt.Errorf("startup option %q must be placed before the command", parsed.StartupOptions[0])
+			return fmt.Errorf("failed to run command %q: %w", cmd.Use, err)
+		}
+
+		ctx = context.WithValue(ctx, ParsedArgsKey, parsed)
+		return next(ctx, cmd, parsed.Args())
+	}
+}
+
+// parseLocalFlags sets the flags defined by cmd that appear in args before any
+// `--` separator and returns the remaining arguments. The flags cmd inherits
+// from the root command are set too, unless Bazel has a flag of the same name,
+// e.g. --config, which is Bazel's after the command. They are only set when the
+// Bazel flags are known.
+func parseLocalFlags(cmd *cobra.Command, args []string, bazelFlags map[string]*bazel.FlagInfo) ([]string, error) {
+	flagSet := pflag.NewFlagSet(cmd.Name(), pflag.ContinueOnError)
+	flagSet.AddFlagSet(cmd.LocalNonPersistentFlags())
+	if bazelFlags != nil {
+		cmd.InheritedFlags().VisitAll(func(flag *pflag.Flag) {
+			if _, ok := bazelFlags[flag.Name]; !ok {
+				flagSet.AddFlag(flag)
+			}
+		})
+	}
+	return flags.ParseFlags(flagSet, args)
+}
@@ -0,0 +1,122 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package interceptors
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/proto"
+
+	"aspect.build/cli/pkg/bazel"
+	bazel_mock "aspect.build/cli/pkg/bazel/mock"
+)
+
+func TestParseArgsInterceptor(t *testing.T) {
+	t.Run("the flags are checked against the Bazel command the aspect command runs", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		bzl := bazel_mock.NewMockBazel(ctrl)
+		bzl.EXPECT().SetWorkspaceRoot("/ws")
+		bzl.
+			EXPECT().
+			Flags().
+			Return(map[string]*bazel.FlagInfo{
+				"disk_cache": {Name: proto.String("disk_cache"), Commands: []string{"build", "test"}},
+			}, nil)
+
+		var nextArgs []string
+		cmd := &cobra.Command{Use: "explain-cache"}
+		ctx := context.WithValue(context.Background(), WorkspaceRootKey, "/ws")
+		err := ParseArgsInterceptor(bzl, "build")(ctx, cmd, []string{"--disk_cache=/tmp/cache", "//..."}, func(ctx context.Context, cmd *cobra.Command, args []string) error {
+			nextArgs = args
+			return nil
+		})
+		g.Expect(err).To(BeNil())
+		g.Expect(nextArgs).To(Equal([]string{"--disk_cache=/tmp/cache", "//..."}))
+	})
+
+	t.Run("a flag the Bazel command doesn't have fails", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		bzl := bazel_mock.NewMockBazel(ctrl)
+		bzl.EXPECT().SetWorkspaceRoot("/ws")
+		bzl.
+			EXPECT().
+			Flags().
+			Return(map[string]*bazel.FlagInfo{
+				"output": {Name: proto.String("output"), Commands: []string{"query"}},
+			}, nil)
+
+		cmd := &cobra.Command{Use: "explain-cache"}
+		ctx := context.WithValue(context.Background(), WorkspaceRootKey, "/ws")
+		err := ParseArgsInterceptor(bzl, "build")(ctx, cmd, []string{"--output=label"}, func(ctx context.Context, cmd *cobra.Command, args []string) error {
+			return nil
+		})
+		g.Expect(err).To(MatchError(`failed to run command "explain-cache": flag "--output=label" is not supported by command "build"`))
+	})
+}
+
+func TestParseLocalFlags(t *testing.T) {
+	newCmd := func() (*cobra.Command, *float64, *bool) {
+		cmd := &cobra.Command{Use: "fake"}
+		threshold := cmd.Flags().Float64("threshold", 0, "")
+		verbose := cmd.Flags().Bool("verbose", false, "")
+		return cmd, threshold, verbose
+	}
+
+	t.Run("the command flags are set and removed from the arguments", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		cmd, threshold, verbose := newCmd()
+
+		args, err := parseLocalFlags(cmd, []string{"--threshold", "80", "--config=ci", "--verbose", "//...", "--", "--threshold=1"}, nil)
+		g.Expect(err).To(BeNil())
+		g.Expect(args).To(Equal([]string{"--config=ci", "//...", "--", "--threshold=1"}))
+		g.Expect(*threshold).To(Equal(80.0))
+		g.Expect(*verbose).To(BeTrue())
+	})
+
+	t.Run("an invalid value fails", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		cmd, _, _ := newCmd()
+
+		_, err := parseLocalFlags(cmd, []string{"--threshold=high"}, nil)
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(err.Error()).To(HavePrefix(`invalid argument "high" for "--threshold=high" flag`))
+	})
+
+	t.Run("a missing value fails", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		cmd, _, _ := newCmd()
+
+		_, err := parseLocalFlags(cmd, []string{"//...", "--threshold"}, nil)
+		g.Expect(err).To(MatchError("flag needs an argument: --threshold"))
+	})
+
+	t.Run("the root flags are set unless Bazel has them", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		root := &cobra.Command{Use: "aspect"}
+		config := root.PersistentFlags().String("config", "", "")
+		interactive := root.PersistentFlags().Bool("interactive", false, "")
+		cmd, _, _ := newCmd()
+		root.AddCommand(cmd)
+
+		bazelFlags := map[string]*bazel.FlagInfo{"config": {}}
+		args, err := parseLocalFlags(cmd, []string{"--config=ci", "--interactive", "//..."}, bazelFlags)
+		g.Expect(err).To(BeNil())
+		g.Expect(args).To(Equal([]string{"--config=ci", "//..."}))
+		g.Expect(*config).To(BeEmpty())
+		g.Expect(*interactive).To(BeTrue())
+	})
+}
@@ -0,0 +1,59 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package interceptors
+
+import (
+	"context"
+
+	"github.com/spf13/cobra"
+
+	"aspect.build/cli/pkg/aspect/root/config"
+	"aspect.build/cli/pkg/bazel"
+)
+
+// StartupOptionsKeyType is a type for the StartupOptionsKey that avoids
+// collisions.
+type StartupOptionsKeyType bool
+
+// StartupOptionsKey is the key for the Bazel startup options in the context.
+// The main function injects the startup options given on the command line
+// before the command, and the StartupOptionsInterceptor replaces them with the
+// complete list of startup options.
+const StartupOptionsKey StartupOptionsKeyType = true
+
+// StartupOptionsInterceptor collects the Bazel startup options from the config
+// and the command line and sets them on bzl, so that every Bazel invocation
+// made by the command uses the same ones. The config has startup options
+// either at the top level for all commands or under a command name for that
+// command only, e.g.:
+//
+//	startup_options:
+//	  - --output_user_root=/tmp/bazel
+//	build:
+//	  startup_options:
+//	    - --host_jvm_args=-Xmx4g
+//
+// The options from the config come first, so that the command line can
+// override them. It also sets the context of the command on bzl, so that
+// cancelling the command stops Bazel. It must run before any interceptor that
+// invokes Bazel.
+func StartupOptionsInterceptor(cfg *config.Config, bzl bazel.Bazel) Interceptor {
+	return func(ctx context.Context, cmd *cobra.Command, args []string, next RunEContextFn) error {
+		values := cfg.Values()
+		var startupOptions []string
+		startupOptions = append(startupOptions, values.StartupOptions...)
+		startupOptions = append(startupOptions, values.CommandStartupOptions[cmd.Name()]...)
+		if cliStartupOptions, ok := ctx.Value(StartupOptionsKey).([]string); ok {
+			startupOptions = append(startupOptions, cliStartupOptions...)
+		}
+
+		bzl.SetStartupOptions(startupOptions)
+		bzl.SetContext(ctx)
+		ctx = context.WithValue(ctx, StartupOptionsKey, startupOptions)
+		return next(ctx, cmd, args)
+	}
+}
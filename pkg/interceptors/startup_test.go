@@ -0,0 +1,92 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package interceptors
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/cobra"
+
+	"aspect.build/cli/pkg/aspect/root/config"
+	bazel_mock "aspect.build/cli/pkg/bazel/mock"
+)
+
+func TestStartupOptionsInterceptor(t *testing.T) {
+	t.Run("without startup options, none are set", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		ctx := context.Background()
+		bzl := bazel_mock.NewMockBazel(ctrl)
+		bzl.EXPECT().
+			SetStartupOptions(nil).
+			Times(1)
+		bzl.EXPECT().
+			SetContext(ctx).
+			Times(1)
+
+		cmd := &cobra.Command{Use: "fake"}
+		called := false
+		next := func(ctx context.Context, cmd *cobra.Command, args []string) error {
+			called = true
+			g.Expect(args).To(Equal([]string{"//..."}))
+			return nil
+		}
+
+		err := StartupOptionsInterceptor(&config.Config{}, bzl)(ctx, cmd, []string{"//..."}, next)
+		g.Expect(err).To(BeNil())
+		g.Expect(called).To(BeTrue())
+	})
+
+	t.Run("the config options come before the command line ones", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		cfgFile, err := ioutil.TempFile(os.Getenv("TEST_TMPDIR"), "cfg*.yaml")
+		g.Expect(err).To(BeNil())
+		defer os.Remove(cfgFile.Name())
+		_, err = cfgFile.WriteString(`startup_options: [--output_user_root=/tmp/bazel]
+build:
+  startup_options: [--host_jvm_args=-Xmx4g]
+test:
+  startup_options: [--batch]
+`)
+		g.Expect(err).To(BeNil())
+		cfg, err := config.Load(cfgFile.Name(), "", nil)
+		g.Expect(err).To(BeNil())
+
+		expected := []string{
+			"--output_user_root=/tmp/bazel",
+			"--host_jvm_args=-Xmx4g",
+			"--output_base=/tmp/base",
+		}
+		ctx := context.WithValue(context.Background(), StartupOptionsKey, []string{"--output_base=/tmp/base"})
+		bzl := bazel_mock.NewMockBazel(ctrl)
+		bzl.EXPECT().
+			SetStartupOptions(expected).
+			Times(1)
+		bzl.EXPECT().
+			SetContext(ctx).
+			Times(1)
+
+		cmd := &cobra.Command{Use: "build"}
+		next := func(ctx context.Context, cmd *cobra.Command, args []string) error {
+			g.Expect(ctx.Value(StartupOptionsKey)).To(Equal(expected))
+			return nil
+		}
+
+		err = StartupOptionsInterceptor(cfg, bzl)(ctx, cmd, nil, next)
+		g.Expect(err).To(BeNil())
+	})
+}
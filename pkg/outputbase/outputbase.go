@@ -0,0 +1,267 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package outputbase
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/mitchellh/go-homedir"
+)
+
+// markerFile is written by Bazel at the root of every output base, and holds
+// the path of the workspace the output base belongs to.
+const markerFile = "DO_NOT_BUILD_HERE"
+
+// OutputBase is the output base of a Bazel workspace, where Bazel keeps the
+// build outputs, the external repositories and the server state.
+type OutputBase struct {
+	Path string
+	// Workspace is the root of the workspace that the output base belongs to.
+	Workspace string
+	// Size is the disk space used by the output base, in bytes.
+	Size int64
+	// LastUsed is the last time a Bazel command ran in the output base.
+	LastUsed time.Time
+	// ServerRunning is whether a Bazel server is running in the output base.
+	ServerRunning bool
+}
+
+// WorkspaceExists returns whether the workspace of the output base still
+// exists.
+func (o *OutputBase) WorkspaceExists() bool {
+	_, err := os.Stat(o.Workspace)
+	return err == nil
+}
+
+// OutputUserRoot returns the output user root that Bazel uses with the given
+// startup options, i.e. the value of --output_user_root or Bazel's default.
+func OutputUserRoot(startupOptions []string) (string, error) {
+	for i, option := range startupOptions {
+		if strings.HasPrefix(option, "--output_user_root=") {
+			return strings.TrimPrefix(option, "--output_user_root="), nil
+		}
+		if option == "--output_user_root" && i+1 < len(startupOptions) {
+			return startupOptions[i+1], nil
+		}
+	}
+	return DefaultOutputUserRoot()
+}
+
+// DefaultOutputUserRoot returns the output user root that Bazel uses when
+// --output_user_root isn't set.
+// See https://docs.bazel.build/versions/main/output_directories.html
+func DefaultOutputUserRoot() (string, error) {
+	u, err := user.Current()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate the output user root: %w", err)
+	}
+	dirName := "_bazel_" + u.Username
+	if testTmpDir, ok := os.LookupEnv("TEST_TMPDIR"); ok {
+		return filepath.Join(testTmpDir, dirName), nil
+	}
+	if runtime.GOOS == "darwin" {
+		return filepath.Join("/private/var/tmp", dirName), nil
+	}
+	home, err := homedir.Dir()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate the output user root: %w", err)
+	}
+	return filepath.Join(home, ".cache", "bazel", dirName), nil
+}
+
+// ForWorkspace returns the output base that Bazel uses for the given workspace
+// with the given startup options, i.e. the value of --output_base or the
+// directory named after the hash of the workspace path under the output user
+// root. The output base may not exist yet.
+func ForWorkspace(workspaceRoot string, startupOptions []string) (string, error) {
+	for i, option := range startupOptions {
+		if strings.HasPrefix(option, "--output_base=") {
+			return strings.TrimPrefix(option, "--output_base="), nil
+		}
+		if option == "--output_base" && i+1 < len(startupOptions) {
+			return startupOptions[i+1], nil
+		}
+	}
+	outputUserRoot, err := OutputUserRoot(startupOptions)
+	if err != nil {
+		return "", err
+	}
+	// Bazel hashes the workspace path as the client sees it, with the symlinks
+	// resolved.
+	workspace, err := filepath.EvalSymlinks(workspaceRoot)
+	if err != nil {
+		return "", fmt.Errorf("failed to locate the output base: %w", err)
+	}
+	hash := md5.Sum([]byte(workspace))
+	return filepath.Join(outputUserRoot, hex.EncodeToString(hash[:])), nil
+}
+
+// List returns the output bases under the given output user root, the least
+// recently used first. Directories of the output user root that aren't output
+// bases, e.g. the install bases, are skipped.
+func List(outputUserRoot string) ([]*OutputBase, error) {
+	entries, err := ioutil.ReadDir(outputUserRoot)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the output bases: %w", err)
+	}
+
+	var outputBases []*OutputBase
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		path := filepath.Join(outputUserRoot, entry.Name())
+		workspace, err := ioutil.ReadFile(filepath.Join(path, markerFile))
+		if err != nil {
+			continue
+		}
+		size, err := DirSize(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list the output bases: %w", err)
+		}
+		outputBases = append(outputBases, &OutputBase{
+			Path:          path,
+			Workspace:     strings.TrimSpace(string(workspace)),
+			Size:          size,
+			LastUsed:      lastUsed(path, entry.ModTime()),
+			ServerRunning: IsServerRunning(path),
+		})
+	}
+	sort.SliceStable(outputBases, func(i, j int) bool {
+		return outputBases[i].LastUsed.Before(outputBases[j].LastUsed)
+	})
+	return outputBases, nil
+}
+
+// lastUsed returns the time of the last Bazel command in the output base,
+// from the command log that Bazel rewrites for every command.
+func lastUsed(path string, fallback time.Time) time.Time {
+	if info, err := os.Stat(filepath.Join(path, "command.log")); err == nil {
+		return info.ModTime()
+	}
+	return fallback
+}
+
+// IsServerRunning returns whether the process of the Bazel server of the
+// given output base is alive.
+func IsServerRunning(path string) bool {
+	data, err := ioutil.ReadFile(filepath.Join(path, "server", "server.pid.txt"))
+	if err != nil {
+		return false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return false
+	}
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	return process.Signal(syscall.Signal(0)) == nil
+}
+
+// DirSize returns the disk space used by the files under the given directory,
+// without following symlinks.
+func DirSize(path string) (int64, error) {
+	var size int64
+	err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) || os.IsPermission(err) {
+				return nil
+			}
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// Remove deletes the given directory. Bazel makes some of the files and
+// directories of an output base read-only, so they are made writable first.
+func Remove(path string) error {
+	if err := os.RemoveAll(path); err == nil {
+		return nil
+	}
+	err := filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() && info.Mode().Perm()&0200 == 0 {
+			return os.Chmod(p, info.Mode().Perm()|0700)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to remove %s: %w", path, err)
+	}
+	if err := os.RemoveAll(path); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", path, err)
+	}
+	return nil
+}
+
+// FormatSize returns the given number of bytes in a human readable form, e.g.
+// 1.5 GiB.
+func FormatSize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
+
+// ParseAge parses a duration that also accepts a number of days, e.g. 30d,
+// on top of the units of time.ParseDuration.
+func ParseAge(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil || days < 0 {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return d, nil
+}
+
+// FormatAge returns how long ago the given time is, in days.
+func FormatAge(t time.Time, now time.Time) string {
+	switch days := int(now.Sub(t).Hours() / 24); days {
+	case 0:
+		return "today"
+	case 1:
+		return "yesterday"
+	default:
+		return fmt.Sprintf("%d days ago", days)
+	}
+}
@@ -0,0 +1,159 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package outputbase_test
+
+import (
+	"crypto/md5"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+
+	"aspect.build/cli/pkg/outputbase"
+)
+
+// writeOutputBase creates an output base of the given workspace, last used at
+// the given time.
+func writeOutputBase(t *testing.T, root, name, workspace string, lastUsed time.Time) string {
+	path := filepath.Join(root, name)
+	if err := os.MkdirAll(filepath.Join(path, "execroot", "ws"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"DO_NOT_BUILD_HERE":     workspace,
+		"command.log":           "INFO: Build completed successfully",
+		"execroot/ws/output.js": "console.log(42)",
+	}
+	for file, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(path, file), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Chtimes(filepath.Join(path, "command.log"), lastUsed, lastUsed); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestList(t *testing.T) {
+	t.Run("the output bases are listed, least recently used first", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		root, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "output_user_root")
+		g.Expect(err).To(BeNil())
+		defer os.RemoveAll(root)
+
+		now := time.Now().Truncate(time.Second)
+		recent := writeOutputBase(t, root, "a1", "/home/me/recent", now.Add(-time.Hour))
+		old := writeOutputBase(t, root, "b2", "/home/me/old", now.Add(-40*24*time.Hour))
+		g.Expect(os.MkdirAll(filepath.Join(root, "install", "abc"), 0755)).To(Succeed())
+		g.Expect(os.MkdirAll(filepath.Join(root, "cache", "repos"), 0755)).To(Succeed())
+
+		outputBases, err := outputbase.List(root)
+		g.Expect(err).To(BeNil())
+		g.Expect(outputBases).To(Equal([]*outputbase.OutputBase{
+			{Path: old, Workspace: "/home/me/old", Size: 61, LastUsed: now.Add(-40 * 24 * time.Hour)},
+			{Path: recent, Workspace: "/home/me/recent", Size: 64, LastUsed: now.Add(-time.Hour)},
+		}))
+		g.Expect(outputBases[0].WorkspaceExists()).To(BeFalse())
+	})
+
+	t.Run("a missing output user root has no output bases", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		outputBases, err := outputbase.List(filepath.Join(os.TempDir(), "does", "not", "exist"))
+		g.Expect(err).To(BeNil())
+		g.Expect(outputBases).To(BeEmpty())
+	})
+}
+
+func TestRemove(t *testing.T) {
+	t.Run("read-only directories are removed", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		root, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "output_user_root")
+		g.Expect(err).To(BeNil())
+		defer os.RemoveAll(root)
+		path := writeOutputBase(t, root, "a1", "/home/me/ws", time.Now())
+		g.Expect(os.Chmod(filepath.Join(path, "execroot", "ws"), 0555)).To(Succeed())
+
+		g.Expect(outputbase.Remove(path)).To(Succeed())
+		_, err = os.Stat(path)
+		g.Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+}
+
+func TestOutputUserRoot(t *testing.T) {
+	t.Run("the startup option takes precedence", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		root, err := outputbase.OutputUserRoot([]string{"--batch", "--output_user_root=/tmp/bazel"})
+		g.Expect(err).To(BeNil())
+		g.Expect(root).To(Equal("/tmp/bazel"))
+
+		root, err = outputbase.OutputUserRoot([]string{"--output_user_root", "/tmp/other"})
+		g.Expect(err).To(BeNil())
+		g.Expect(root).To(Equal("/tmp/other"))
+	})
+}
+
+func TestForWorkspace(t *testing.T) {
+	t.Run("the output base is named after the hash of the workspace", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "workspace")
+		g.Expect(err).To(BeNil())
+		defer os.RemoveAll(dir)
+		workspace := filepath.Join(dir, "ws")
+		g.Expect(os.Mkdir(workspace, 0755)).To(Succeed())
+		link := filepath.Join(dir, "link")
+		g.Expect(os.Symlink(workspace, link)).To(Succeed())
+
+		path, err := outputbase.ForWorkspace(link, []string{"--output_user_root=/tmp/bazel"})
+		g.Expect(err).To(BeNil())
+		resolved, err := filepath.EvalSymlinks(workspace)
+		g.Expect(err).To(BeNil())
+		g.Expect(path).To(Equal(filepath.Join("/tmp/bazel", fmt.Sprintf("%x", md5.Sum([]byte(resolved))))))
+	})
+
+	t.Run("the startup option takes precedence", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		path, err := outputbase.ForWorkspace("/does/not/exist", []string{"--output_base", "/tmp/out"})
+		g.Expect(err).To(BeNil())
+		g.Expect(path).To(Equal("/tmp/out"))
+	})
+}
+
+func TestFormat(t *testing.T) {
+	t.Run("sizes", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		g.Expect(outputbase.FormatSize(512)).To(Equal("512 B"))
+		g.Expect(outputbase.FormatSize(1536)).To(Equal("1.5 KiB"))
+		g.Expect(outputbase.FormatSize(3 << 30)).To(Equal("3.0 GiB"))
+	})
+
+	t.Run("ages", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		now := time.Now()
+
+		g.Expect(outputbase.FormatAge(now.Add(-time.Hour), now)).To(Equal("today"))
+		g.Expect(outputbase.FormatAge(now.Add(-25*time.Hour), now)).To(Equal("yesterday"))
+		g.Expect(outputbase.FormatAge(now.Add(-30*24*time.Hour), now)).To(Equal("30 days ago"))
+	})
+
+	t.Run("durations", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		g.Expect(outputbase.ParseAge("30d")).To(Equal(30 * 24 * time.Hour))
+		g.Expect(outputbase.ParseAge("12h")).To(Equal(12 * time.Hour))
+		_, err := outputbase.ParseAge("a week")
+		g.Expect(err).To(MatchError(`invalid duration "a week"`))
+	})
+}
@@ -112,6 +112,14 @@ func (m *GRPCServer) PostRunHook(
 		m.Impl.PostRunHook(req.IsInteractiveMode, prompter)
 }
 
+// CancelHook translates the gRPC call to the Plugin CancelHook implementation.
+func (m *GRPCServer) CancelHook(
+	ctx context.Context,
+	req *proto.CancelHookReq,
+) (*proto.CancelHookRes, error) {
+	return &proto.CancelHookRes{}, m.Impl.CancelHook(req.Command)
+}
+
 // GRPCClient implements the gRPC client that is used by the Core to communicate
 // with the Plugin instances.
 type GRPCClient struct {
@@ -189,6 +197,12 @@ func (m *GRPCClient) PostRunHook(isInteractiveMode bool, promptRunner ioutils.Pr
 	return err
 }
 
+// CancelHook is called from the Core to execute the Plugin CancelHook.
+func (m *GRPCClient) CancelHook(command string) error {
+	_, err := m.client.CancelHook(context.Background(), &proto.CancelHookReq{Command: command})
+	return err
+}
+
 // PrompterGRPCServer implements the gRPC server that runs on the Core and is
 // passed to the Plugin to allow prompt actions to the CLI user.
 type PrompterGRPCServer struct {
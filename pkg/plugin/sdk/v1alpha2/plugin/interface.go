@@ -26,4 +26,7 @@ type Plugin interface {
 		isInteractiveMode bool,
 		promptRunner ioutils.PromptRunner,
 	) error
+	// CancelHook is called instead of the post hook when the given command,
+	// e.g. build, is cancelled, such as with Ctrl-C.
+	CancelHook(command string) error
 }
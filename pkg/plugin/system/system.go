@@ -128,7 +128,9 @@ type BESBackendInterceptorKeyType bool
 const BESBackendInterceptorKey BESBackendInterceptorKeyType = true
 
 // BESBackendInterceptor starts a BES backend and injects it into the context.
-// It gracefully stops the  server after the main command is executed.
+// It gracefully stops the server after the main command is executed, also when
+// it is cancelled, so that the plugins receive the events Bazel sends before
+// it stops, including the one telling them the build was interrupted.
 func (ps *pluginSystem) BESBackendInterceptor() interceptors.Interceptor {
 	return func(ctx context.Context, cmd *cobra.Command, args []string, next interceptors.RunEContextFn) error {
 		besBackend := bep.NewBESBackend()
@@ -138,9 +140,9 @@ func (ps *pluginSystem) BESBackendInterceptor() interceptors.Interceptor {
 		if err := besBackend.Setup(); err != nil {
 			return fmt.Errorf("failed to run BES backend: %w", err)
 		}
-		ctx, cancel := context.WithTimeout(ctx, time.Second)
+		serveCtx, cancel := context.WithTimeout(ctx, time.Second)
 		defer cancel()
-		if err := besBackend.ServeWait(ctx); err != nil {
+		if err := besBackend.ServeWait(serveCtx); err != nil {
 			return fmt.Errorf("failed to run BES backend: %w", err)
 		}
 		defer besBackend.GracefulStop()
@@ -174,20 +176,32 @@ func (ps *pluginSystem) commandHooksInterceptor(methodName string, streams iouti
 			return fmt.Errorf("failed to run 'aspect %s' command: %w", cmd.Use, err)
 		}
 
-		// TODO(f0rmiga): test this hook.
 		defer func() {
+			// The post hooks don't run when the command is cancelled, e.g. on
+			// Ctrl-C, since the user asked to stop. The plugins are told
+			// about it with their cancel hook instead.
+			isCancelled := ctx.Err() != nil
 			hasErrors := false
 			for node := ps.plugins.head; node != nil; node = node.next {
-				params := []reflect.Value{
-					reflect.ValueOf(isInteractiveMode),
-					reflect.ValueOf(ps.promptRunner),
+				var err error
+				if isCancelled {
+					err = node.plugin.CancelHook(cmd.Use)
+				} else {
+					params := []reflect.Value{
+						reflect.ValueOf(isInteractiveMode),
+						reflect.ValueOf(ps.promptRunner),
+					}
+					if res := reflect.ValueOf(node.plugin).MethodByName(methodName).Call(params)[0].Interface(); res != nil {
+						err = res.(error)
+					}
 				}
-				if err := reflect.ValueOf(node.plugin).MethodByName(methodName).Call(params)[0].Interface(); err != nil {
+				if err != nil {
 					fmt.Fprintf(streams.Stderr, "Error: failed to run 'aspect %s' command: %v\n", cmd.Use, err)
 					hasErrors = true
 				}
 			}
-			if hasErrors {
+			// A cancelled command keeps the exit code of the cancellation.
+			if hasErrors && !isCancelled {
 				var err *aspecterrors.ExitError
 				if errors.As(exitErr, &err) {
 					err.ExitCode = 1
@@ -0,0 +1,113 @@
+/*
+Copyright © 2021 Aspect Build Systems Inc
+
+Not licensed for re-use.
+*/
+
+package system
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/spf13/cobra"
+
+	buildeventstream "aspect.build/cli/bazel/buildeventstream/proto"
+	rootFlags "aspect.build/cli/pkg/aspect/root/flags"
+	"aspect.build/cli/pkg/aspecterrors"
+	"aspect.build/cli/pkg/ioutils"
+)
+
+// hookPlugin counts the calls to its post-build hook, which returns err, and
+// records the commands its cancel hook is called with.
+type hookPlugin struct {
+	postBuildHooks    int
+	cancelledCommands []string
+	err               error
+}
+
+func (p *hookPlugin) BEPEventCallback(event *buildeventstream.BuildEvent) error {
+	return nil
+}
+
+func (p *hookPlugin) PostBuildHook(isInteractiveMode bool, promptRunner ioutils.PromptRunner) error {
+	p.postBuildHooks++
+	return p.err
+}
+
+func (p *hookPlugin) PostTestHook(isInteractiveMode bool, promptRunner ioutils.PromptRunner) error {
+	return nil
+}
+
+func (p *hookPlugin) PostRunHook(isInteractiveMode bool, promptRunner ioutils.PromptRunner) error {
+	return nil
+}
+
+func (p *hookPlugin) CancelHook(command string) error {
+	p.cancelledCommands = append(p.cancelledCommands, command)
+	return p.err
+}
+
+func TestBuildHooksInterceptor(t *testing.T) {
+	run := func(ctx context.Context, p *hookPlugin, exitErr error) (string, error) {
+		ps := &pluginSystem{plugins: &PluginList{}, promptRunner: ioutils.NewPromptRunner()}
+		ps.plugins.insert(p)
+		cmd := &cobra.Command{Use: "build"}
+		cmd.PersistentFlags().Bool(rootFlags.InteractiveFlagName, false, "")
+		var stderr strings.Builder
+		interceptor := ps.BuildHooksInterceptor(ioutils.Streams{Stderr: &stderr})
+		err := interceptor(ctx, cmd, nil, func(ctx context.Context, cmd *cobra.Command, args []string) error {
+			return exitErr
+		})
+		return stderr.String(), err
+	}
+
+	t.Run("the post-build hooks run after the command", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		p := &hookPlugin{}
+
+		stderr, err := run(context.Background(), p, nil)
+		g.Expect(err).To(BeNil())
+		g.Expect(stderr).To(BeEmpty())
+		g.Expect(p.postBuildHooks).To(Equal(1))
+		g.Expect(p.cancelledCommands).To(BeEmpty())
+	})
+
+	t.Run("a failed post-build hook fails the command", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		p := &hookPlugin{err: fmt.Errorf("hook failed")}
+
+		stderr, err := run(context.Background(), p, &aspecterrors.ExitError{ExitCode: 0})
+		g.Expect(err).To(Equal(&aspecterrors.ExitError{ExitCode: 1}))
+		g.Expect(stderr).To(Equal("Error: failed to run 'aspect build' command: hook failed\n"))
+		g.Expect(p.postBuildHooks).To(Equal(1))
+	})
+
+	t.Run("the cancel hooks run instead of the post-build hooks when the command is cancelled", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		p := &hookPlugin{}
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		stderr, err := run(ctx, p, context.Canceled)
+		g.Expect(err).To(MatchError(context.Canceled))
+		g.Expect(stderr).To(BeEmpty())
+		g.Expect(p.postBuildHooks).To(Equal(0))
+		g.Expect(p.cancelledCommands).To(Equal([]string{"build"}))
+	})
+
+	t.Run("a failed cancel hook is reported without changing the exit code", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		p := &hookPlugin{err: fmt.Errorf("hook failed")}
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		stderr, err := run(ctx, p, &aspecterrors.ExitError{ExitCode: 130})
+		g.Expect(err).To(Equal(&aspecterrors.ExitError{ExitCode: 130}))
+		g.Expect(stderr).To(Equal("Error: failed to run 'aspect build' command: hook failed\n"))
+		g.Expect(p.cancelledCommands).To(Equal([]string{"build"}))
+	})
+}
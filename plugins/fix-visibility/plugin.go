@@ -172,6 +172,13 @@ func (plugin *FixVisibilityPlugin) PostRunHook(
 	return plugin.PostBuildHook(isInteractiveMode, promptRunner)
 }
 
+// CancelHook satisfies the Plugin interface. The cancelled command leaves no
+// visibility issues to fix, so the ones collected so far are dropped.
+func (plugin *FixVisibilityPlugin) CancelHook(command string) error {
+	plugin.targetsToFix = &fixOrderedSet{nodes: make(map[fixNode]struct{})}
+	return nil
+}
+
 func (plugin *FixVisibilityPlugin) hasPrivateVisibility(toFix string) (bool, error) {
 	visibility, err := plugin.buildozer.run("print visibility", toFix)
 	if err != nil {